@@ -23,6 +23,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/hrapovd1/pmetrics/internal/agents"
 	"github.com/hrapovd1/pmetrics/internal/config"
 	pb "github.com/hrapovd1/pmetrics/internal/proto"
 	"github.com/hrapovd1/pmetrics/internal/types"
@@ -46,10 +47,14 @@ type (
 	mmetrics struct {
 		mu          sync.Mutex
 		pollCounter counter
+		numGC       uint32 // количество GC на момент предыдущего опроса
 		mtrcs       map[string]interface{}
 	}
 )
 
+// Границы бакетов гистограммы пауз GC в наносекундах
+var gcPauseBounds = []float64{1e4, 5e4, 1e5, 5e5, 1e6, 5e6, 1e7, 5e7, 1e8}
+
 func main() {
 	logger := log.New(os.Stdout, "AGENT\t", log.Ldate|log.Ltime)
 	agentConf, err := config.NewAgentConf(config.GetAgentFlags())
@@ -74,9 +79,6 @@ func main() {
 
 	client := pb.NewMetricsClient(conn)
 
-	md := metadata.New(map[string]string{"X-Real-IP": localAddr})
-	ctx := metadata.NewOutgoingContext(nctx, md)
-
 	if buildVersion == "" {
 		buildVersion = "N/A"
 	}
@@ -87,6 +89,18 @@ func main() {
 		buildCommit = "N/A"
 	}
 
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Printf("when get hostname got error: %v\n", err)
+	}
+	md := metadata.New(map[string]string{
+		"X-Real-IP":          localAddr,
+		agents.IDHeader:      agents.LocalID(agentConf.AgentID),
+		agents.HostHeader:    hostname,
+		agents.VersionHeader: buildVersion,
+	})
+	ctx := metadata.NewOutgoingContext(nctx, md)
+
 	logger.Println("Agent has started")
 	logger.Printf("\tBuild version: %s\n", buildVersion)
 	logger.Printf("\tBuild date: %s\n", buildDate)
@@ -161,6 +175,7 @@ func reportMetrics(ctx context.Context, w *sync.WaitGroup, metrics *mmetrics, cf
 			}
 
 			// send metrics in stream
+			sent := true
 			metrics.mu.Lock()
 			for mKey, mVal := range metrics.mtrcs {
 				data, err := metricToJSON(mKey, mVal, cfg.Key)
@@ -171,6 +186,7 @@ func reportMetrics(ctx context.Context, w *sync.WaitGroup, metrics *mmetrics, cf
 					dataEnc, err = dataToEnc(encrypt.symmKey, data)
 					if err != nil {
 						logger.Println(err)
+						sent = false
 						break
 					}
 					req := pb.EncMetricRequest{
@@ -181,6 +197,7 @@ func reportMetrics(ctx context.Context, w *sync.WaitGroup, metrics *mmetrics, cf
 					}
 					if err := encrypt.stream.Send(&req); err != nil {
 						logger.Println(err)
+						sent = false
 						break
 					}
 				} else {
@@ -189,22 +206,35 @@ func reportMetrics(ctx context.Context, w *sync.WaitGroup, metrics *mmetrics, cf
 					}
 					if err := stream.Send(&req); err != nil {
 						logger.Println(err)
+						sent = false
 						break
 					}
 				}
 			}
-			metrics.mu.Unlock()
 
-			// close opened stream
+			// close opened stream, ответ сервера подтверждает прием метрик
 			if encrypt.enc {
-				if err := encrypt.stream.CloseSend(); err != nil {
+				if _, err := encrypt.stream.CloseAndRecv(); err != nil {
 					logger.Printf("when close encrypt stream got err: %v", err)
+					sent = false
 				}
 			} else {
-				if err := stream.CloseSend(); err != nil {
+				if _, err := stream.CloseAndRecv(); err != nil {
 					logger.Printf("when close stream got err: %v", err)
+					sent = false
+				}
+			}
+
+			// гистограммы отправляются приращением с прошлой успешной
+			// отправки, при ошибке наблюдения остаются до следующей
+			if sent {
+				for _, mVal := range metrics.mtrcs {
+					if hist, ok := mVal.(*types.Histogram); ok {
+						*hist = types.NewHistogram(hist.Bounds)
+					}
 				}
 			}
+			metrics.mu.Unlock()
 		}
 	}
 }
@@ -252,12 +282,34 @@ func pollMetrics(ctx context.Context, w *sync.WaitGroup, metrics *mmetrics, poll
 			metrics.mtrcs["NumForcedGC"] = gauge(rtm.NumForcedGC)
 			metrics.mtrcs["GCCPUFraction"] = gauge(rtm.GCCPUFraction)
 			metrics.mtrcs["RandomValue"] = gauge(rand.Float64())
+			metrics.observeGCPauses(&rtm)
 
 			metrics.mu.Unlock()
 		}
 	}
 }
 
+// observeGCPauses добавляет в гистограмму GCPauseNs паузы GC,
+// произошедшие после предыдущего опроса, вызывается под блокировкой
+func (m *mmetrics) observeGCPauses(rtm *runtime.MemStats) {
+	hist, ok := m.mtrcs["GCPauseNs"].(*types.Histogram)
+	if !ok {
+		h := types.NewHistogram(gcPauseBounds)
+		hist = &h
+		m.mtrcs["GCPauseNs"] = hist
+	}
+	// PauseNs хранит только последние 256 пауз
+	ring := uint32(len(rtm.PauseNs))
+	from := m.numGC
+	if rtm.NumGC-from > ring {
+		from = rtm.NumGC - ring
+	}
+	for i := from; i < rtm.NumGC; i++ {
+		hist.Observe(float64(rtm.PauseNs[i%ring]))
+	}
+	m.numGC = rtm.NumGC
+}
+
 func pollHwMetrics(ctx context.Context, w *sync.WaitGroup, metrics *mmetrics, pollIntvl time.Duration, logger *log.Logger) {
 	defer w.Done()
 	pollTick := time.NewTicker(pollIntvl)
@@ -309,6 +361,14 @@ func metricToJSON(mKey string, mValue interface{}, key string) ([]byte, error) {
 				return nil, err
 			}
 		}
+	case *types.Histogram:
+		data.MType = "histogram"
+		data.Histogram = val
+		if key != "" {
+			if err := usecase.SignData(&data, key); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return json.Marshal(data)
 }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
@@ -17,11 +18,14 @@ import (
 	"github.com/hrapovd1/pmetrics/internal/mygrpc"
 	pb "github.com/hrapovd1/pmetrics/internal/proto"
 	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/types"
 	"github.com/hrapovd1/pmetrics/internal/usecase"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func Test_pollHwMetrics(t *testing.T) {
@@ -101,6 +105,31 @@ func Test_pollMetrics(t *testing.T) {
 	})
 }
 
+func Test_observeGCPauses(t *testing.T) {
+	metrics := mmetrics{mtrcs: make(map[string]interface{})}
+	var rtm runtime.MemStats
+	rtm.PauseNs[0] = 20000
+	rtm.PauseNs[1] = 2000000
+	rtm.NumGC = 2
+	metrics.observeGCPauses(&rtm)
+	hist, ok := metrics.mtrcs["GCPauseNs"].(*types.Histogram)
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), hist.Count)
+	assert.NoError(t, hist.Validate())
+
+	// учитываются только новые паузы
+	rtm.PauseNs[2] = 30000
+	rtm.NumGC = 3
+	metrics.observeGCPauses(&rtm)
+	assert.Equal(t, uint64(3), hist.Count)
+	assert.Equal(t, float64(2050000), hist.Sum)
+
+	// при переполнении кольцевого буфера учитываются последние 256 пауз
+	rtm.NumGC = 1000
+	metrics.observeGCPauses(&rtm)
+	assert.Equal(t, uint64(259), hist.Count)
+}
+
 func Test_metricToJSON(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -129,6 +158,12 @@ func Test_metricToJSON(t *testing.T) {
 			signKey: "1234rewq",
 			want:    []byte(`{"id":"M3","type":"gauge","value":3.45,"hash":"151bf36a64705782ced1f2e7fc9f2feda8a11b6e669b90ca4f24d3f3218e2e9b"}`),
 		},
+		{
+			name:  "Check histogram",
+			key:   "M4",
+			value: &types.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1},
+			want:  []byte(`{"id":"M4","type":"histogram","histogram":{"bounds":[1],"counts":[1,0],"sum":0.5,"count":1}}`),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -521,3 +556,61 @@ func Test_getLocalAddr(t *testing.T) {
 
 	})
 }
+
+// failStream поток отправки метрик, Send которого возвращает ошибку
+// sendErr, а CloseAndRecv - ответ сервера recvErr
+type failStream struct {
+	pb.Metrics_ReportMetricsClient
+	sendErr error
+	recvErr error
+}
+
+func (fs failStream) Send(*pb.MetricRequest) error { return fs.sendErr }
+
+func (fs failStream) CloseAndRecv() (*pb.MetricResponse, error) {
+	if fs.recvErr != nil {
+		return nil, fs.recvErr
+	}
+	return &pb.MetricResponse{}, nil
+}
+
+// streamClient клиент, открывающий поток stream
+type streamClient struct {
+	pb.MetricsClient
+	stream pb.Metrics_ReportMetricsClient
+}
+
+func (sc streamClient) ReportMetrics(ctx context.Context, opts ...grpc.CallOption) (pb.Metrics_ReportMetricsClient, error) {
+	return sc.stream, nil
+}
+
+func Test_reportMetrics_histogram(t *testing.T) {
+	tests := []struct {
+		name    string
+		sendErr error
+		recvErr error
+		want    uint64
+	}{
+		// при ошибке отправки наблюдения гистограммы сохраняются
+		{name: "send failed", sendErr: fmt.Errorf("connection reset"), want: 1},
+		// сервер отклонил поток
+		{name: "rejected", recvErr: status.Error(codes.ResourceExhausted, "throttled"), want: 1},
+		{name: "sent", want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hist := types.NewHistogram([]float64{1})
+			hist.Observe(0.5)
+			metrics := mmetrics{mtrcs: map[string]interface{}{"Latency": &hist}}
+			client := streamClient{stream: failStream{sendErr: test.sendErr, recvErr: test.recvErr}}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+			defer cancel()
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go reportMetrics(ctx, &wg, &metrics, config.Config{ReportInterval: 5 * time.Millisecond}, client, log.Default())
+			wg.Wait()
+			assert.Equal(t, test.want, hist.Count)
+		})
+	}
+}
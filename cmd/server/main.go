@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
 	"net/http"
@@ -9,14 +10,27 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/hrapovd1/pmetrics/internal/alerts"
+	"github.com/hrapovd1/pmetrics/internal/anomaly"
 	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/graphite"
+	"github.com/hrapovd1/pmetrics/internal/handlers"
+	"github.com/hrapovd1/pmetrics/internal/migrations"
 	"github.com/hrapovd1/pmetrics/internal/mygrpc"
 	pb "github.com/hrapovd1/pmetrics/internal/proto"
+	"github.com/hrapovd1/pmetrics/internal/retention"
+	"github.com/hrapovd1/pmetrics/internal/sqlitestorage"
+	"github.com/hrapovd1/pmetrics/internal/statsd"
 	"github.com/hrapovd1/pmetrics/internal/types"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
 )
 
+// Время ожидания завершения обработки http запросов при остановке
+const httpShutdownTimeout = 5 * time.Second
+
 var (
 	buildVersion string
 	buildDate    string
@@ -44,6 +58,27 @@ func main() {
 	logger.Printf("\tBuild version: %s\n", buildVersion)
 	logger.Printf("\tBuild date: %s\n", buildDate)
 	logger.Printf("\tBuild commit: %s\n", buildCommit)
+
+	// С флагом -migrate сервер только выполняет миграцию схемы базы
+	if serverConf.Migrate != "" {
+		if err := migrateDB(context.Background(), *serverConf, logger); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+	// Версия схемы базы проверяется до создания хранилища, устаревшая
+	// схема обновляется, со схемой новее сервер не запускается; при
+	// недоступной базе хранилище повторяет проверку перед записью
+	// значений; схему базы SQLite создает хранилище
+	if serverConf.DatabaseDSN != "" && !sqlitestorage.IsDSN(serverConf.DatabaseDSN) {
+		err := checkDB(context.Background(), *serverConf, logger)
+		if errors.Is(err, migrations.ErrSchemaNewer) {
+			logger.Fatal(err)
+		}
+		if err != nil {
+			logger.Printf("database schema isn't checked: %v\n", err)
+		}
+	}
 	logger.Println("Server start on ", serverConf.ServerAddress)
 
 	grpcServer := mygrpc.NewMetricsServer(*serverConf, logger)
@@ -62,24 +97,124 @@ func main() {
 	wg.Add(1)
 	go srvStorage.Storing(ctx, &wg, logger, serverConf.StoreInterval, serverConf.IsRestore)
 
+	// Производные ряды аномалий записываются в общее хранилище
+	if serverConf.AnomalyZScore > 0 {
+		detector, err := anomaly.NewDetector(grpcServer.Storage, serverConf.AnomalyZScore, serverConf.Anomaly)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		wg.Add(1)
+		go detector.Run(ctx, &wg)
+	}
+	// История хранилища сжимается по политикам хранения, память
+	// хранит историю ограниченной глубины и не сжимается
+	if len(serverConf.Retention) > 0 {
+		policies, err := retention.NewPolicies(serverConf.Retention)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		compactor, err := retention.NewCompactor(grpcServer.Storage, policies, serverConf.RetentionInt, logger)
+		if err != nil {
+			logger.Printf("retention policies aren't applied: %v\n", err)
+		} else {
+			wg.Add(1)
+			go compactor.Run(ctx, &wg)
+		}
+	}
+	// Тишина оповещений общая для http и grpc серверов
+	silences, err := alerts.NewSilenceStore(serverConf.AlertSilences)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	grpcServer.Silences = silences
+	// Уведомления об оповещениях отправляются, если заданы webhook
+	alertOpts := []alerts.Option{alerts.WithSilences(silences)}
+	if len(serverConf.AlertWebhooks) > 0 {
+		dispatcher, err := alerts.NewDispatcher(serverConf.AlertWebhooks, serverConf.AlertOutbox, serverConf.AlertGroupWait, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		alertOpts = append(alertOpts, alerts.WithNotifier(dispatcher))
+		wg.Add(1)
+		go dispatcher.Run(ctx, &wg)
+	}
+	// Правила оповещений проверяются по общему хранилищу
+	alertManager, err := alerts.NewManager(serverConf.AlertRules, grpcServer.Storage, logger, alertOpts...)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if len(serverConf.AlertRules) > 0 {
+		wg.Add(1)
+		go alertManager.Run(ctx, &wg, serverConf.AlertInterval)
+	}
+
 	listen, err := net.Listen("tcp", serverConf.ServerAddress)
 	if err != nil {
 		log.Fatalf("when open port got error: %v\n", err)
 	}
 
-	srv := grpc.NewServer(grpc.StreamInterceptor(grpcServer.StreamInterceptor))
+	srv := grpc.NewServer(
+		grpc.StreamInterceptor(grpcServer.StreamInterceptor),
+		grpc.UnaryInterceptor(grpcServer.UnaryInterceptor),
+	)
 	pb.RegisterMetricsServer(srv, grpcServer)
+	colmetricspb.RegisterMetricsServiceServer(srv, mygrpc.NewOTLPServer(grpcServer.Storage, logger))
+
+	// http сервер использует общие с grpc сервером хранилище и реестр агентов
+	var httpServer *http.Server
+	if serverConf.HTTPAddress != "" {
+		httpServer = &http.Server{
+			Addr: serverConf.HTTPAddress,
+			Handler: handlers.NewRouter(
+				handlers.NewMetricsHandler(
+					*serverConf,
+					logger,
+					handlers.WithStorage(grpcServer.Storage),
+					handlers.WithAgents(grpcServer.Agents),
+					handlers.WithAlerts(alertManager),
+					handlers.WithSilences(silences),
+				),
+			),
+		}
+		logger.Println("HTTP server start on ", serverConf.HTTPAddress)
+		go func() {
+			if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+				logger.Fatal(err)
+			}
+		}()
+	}
+
+	if serverConf.StatsdAddress != "" {
+		wg.Add(1)
+		go statsd.NewServer(serverConf.StatsdAddress, grpcServer.Storage, logger, statsd.WithTrustedSubnet(serverConf.TrustedSubnet)).Run(ctx, &wg)
+	}
+
+	if serverConf.GraphiteAddr != "" {
+		mapper, err := graphite.NewMapper(serverConf.GraphiteRules)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		wg.Add(1)
+		go graphite.NewServer(serverConf.GraphiteAddr, grpcServer.Storage, logger, mapper).Run(ctx, &wg)
+	}
 
 	wg.Add(1)
-	go func(c context.Context, w *sync.WaitGroup, s *grpc.Server, l *log.Logger) {
+	go func(c context.Context, w *sync.WaitGroup, s *grpc.Server, hs *http.Server, l *log.Logger) {
 		defer wg.Done()
 		<-c.Done()
 		l.Println("got signal to stop")
+		if hs != nil {
+			ctxT, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+			defer cancel()
+			if err := hs.Shutdown(ctxT); err != nil {
+				l.Printf("when stop http server got error: %v\n", err)
+			}
+		}
 		s.GracefulStop()
 
-	}(ctx, &wg, srv, logger)
+	}(ctx, &wg, srv, httpServer, logger)
 
-	if err := srv.Serve(listen); err != http.ErrServerClosed {
+	if err := srv.Serve(listen); err != nil {
 		logger.Fatal(err)
 	}
 
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/migrations"
+	"github.com/hrapovd1/pmetrics/internal/sqlitestorage"
+)
+
+// checkDBTimeout наибольшее время проверки схемы базы при запуске
+const checkDBTimeout = 5 * time.Second
+
+// migrateDB выполняет миграцию схемы базы, заданную флагом -migrate
+func migrateDB(ctx context.Context, conf config.Config, logger *log.Logger) error {
+	if conf.DatabaseDSN == "" {
+		return errors.New("database isn't configured, set DATABASE_DSN or -d")
+	}
+	if sqlitestorage.IsDSN(conf.DatabaseDSN) {
+		return errors.New("migrations are applied to postgresql only, sqlite schema is created on server start")
+	}
+	db, err := sql.Open("pgx", conf.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Print(err)
+		}
+	}()
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	switch conf.Migrate {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	default:
+		var version int
+		if version, err = strconv.Atoi(conf.Migrate); err == nil {
+			err = migrator.To(ctx, version)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Printf("database schema version %d, latest %d\n", version, migrator.Latest())
+	return nil
+}
+
+// checkDB проверяет при запуске сервера версию схемы базы postgresql:
+// к схеме старее последней известной серверу миграции применяются
+// непримененные миграции, схема новее возвращает ErrSchemaNewer
+func checkDB(ctx context.Context, conf config.Config, logger *log.Logger) error {
+	db, err := sql.Open("pgx", conf.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Print(err)
+		}
+	}()
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, checkDBTimeout)
+	defer cancel()
+	err = migrator.Check(checkCtx)
+	if !errors.Is(err, migrations.ErrSchemaOlder) {
+		return err
+	}
+	logger.Printf("%v, applying migrations\n", err)
+	if err := migrator.Up(ctx); err != nil {
+		return err
+	}
+	logger.Printf("database schema version %d\n", migrator.Latest())
+	return nil
+}
@@ -0,0 +1,101 @@
+// Модуль agents содержит идентификацию агентов и реестр агентов,
+// отправлявших метрики на сервер.
+package agents
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Заголовки gRPC metadata с идентификацией агента
+const (
+	IDHeader      = "X-Agent-ID"
+	HostHeader    = "X-Agent-Host"
+	VersionHeader = "X-Agent-Version"
+)
+
+// Метки, которыми сервер помечает метрики агента
+const (
+	IDLabel   = "agent"
+	HostLabel = "host"
+)
+
+// Файл с постоянным идентификатором машины
+var machineIDFile = "/etc/machine-id"
+
+// Agent описание агента
+type Agent struct {
+	ID       string    `json:"id"`        // постоянный идентификатор агента
+	Host     string    `json:"host"`      // имя хоста агента
+	Version  string    `json:"version"`   // версия сборки агента
+	Address  string    `json:"address"`   // адрес агента
+	LastSeen time.Time `json:"last_seen"` // время последней отправки метрик
+}
+
+// Labels добавляет метки агента к меткам метрики, метки агента
+// перезаписывают одноименные метки, переданные в самой метрике
+func (a Agent) Labels(labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels)+2)
+	for k, v := range labels {
+		out[k] = v
+	}
+	if a.ID != "" {
+		out[IDLabel] = a.ID
+	}
+	if a.Host != "" {
+		out[HostLabel] = a.Host
+	}
+	return out
+}
+
+// Registry реестр агентов, безопасен для конкурентного использования
+type Registry struct {
+	mu     sync.RWMutex
+	agents map[string]Agent
+}
+
+// NewRegistry создает пустой реестр агентов
+func NewRegistry() *Registry {
+	return &Registry{agents: make(map[string]Agent)}
+}
+
+// Seen отмечает обращение агента, при пустом LastSeen
+// используется текущее время
+func (r *Registry) Seen(agent Agent) {
+	if agent.LastSeen.IsZero() {
+		agent.LastSeen = time.Now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.agents[agent.ID] = agent
+}
+
+// List возвращает агентов отсортированных по идентификатору
+func (r *Registry) List() []Agent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Agent, 0, len(r.agents))
+	for _, agent := range r.agents {
+		list = append(list, agent)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// LocalID возвращает постоянный идентификатор агента: id из
+// конфигурации, иначе machine-id, иначе имя хоста
+func LocalID(confID string) string {
+	if confID != "" {
+		return confID
+	}
+	if data, err := os.ReadFile(machineIDFile); err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id
+		}
+	}
+	host, _ := os.Hostname()
+	return host
+}
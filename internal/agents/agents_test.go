@@ -0,0 +1,54 @@
+package agents
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_Labels(t *testing.T) {
+	agent := Agent{ID: "a1", Host: "web01"}
+	assert.Equal(t, map[string]string{"agent": "a1", "host": "web01"}, agent.Labels(nil))
+	assert.Equal(t,
+		map[string]string{"agent": "a1", "host": "web01", "cpu": "1"},
+		agent.Labels(map[string]string{"agent": "a2", "host": "db", "cpu": "1"}),
+	)
+	assert.Equal(t, map[string]string{"agent": "a1"}, Agent{ID: "a1"}.Labels(nil))
+}
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	assert.Empty(t, reg.List())
+
+	seen := time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC)
+	reg.Seen(Agent{ID: "b", Host: "web02", LastSeen: seen})
+	reg.Seen(Agent{ID: "a", Host: "web01", Version: "v1"})
+	reg.Seen(Agent{ID: "a", Host: "web01", Version: "v2"})
+
+	list := reg.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, "a", list[0].ID)
+	assert.Equal(t, "v2", list[0].Version)
+	assert.False(t, list[0].LastSeen.IsZero())
+	assert.Equal(t, seen, list[1].LastSeen)
+}
+
+func TestLocalID(t *testing.T) {
+	defer func(name string) { machineIDFile = name }(machineIDFile)
+	dir := t.TempDir()
+
+	assert.Equal(t, "conf-id", LocalID("conf-id"))
+
+	machineIDFile = filepath.Join(dir, "machine-id")
+	require.NoError(t, os.WriteFile(machineIDFile, []byte("0123abcd\n"), 0600))
+	assert.Equal(t, "0123abcd", LocalID(""))
+
+	machineIDFile = filepath.Join(dir, "not-exists")
+	host, err := os.Hostname()
+	require.NoError(t, err)
+	assert.Equal(t, host, LocalID(""))
+}
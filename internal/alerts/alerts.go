@@ -0,0 +1,343 @@
+// Модуль alerts содержит проверку правил оповещений по значениям
+// метрик и отслеживание состояния оповещений.
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/query"
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// Состояния оповещения
+const (
+	StatePending  = "pending"  // условие выполняется меньше For правила
+	StateFiring   = "firing"   // условие выполняется дольше For правила
+	StateResolved = "resolved" // условие сработавшего оповещения перестало выполняться
+)
+
+// resolvedKeep время, в течение которого показываются разрешенные оповещения
+const resolvedKeep = 15 * time.Minute
+
+// Alert оповещение по временному ряду, удовлетворяющему условию правила
+type Alert struct {
+	Rule       string            `json:"rule"`
+	Metric     string            `json:"metric,omitempty"`
+	Severity   string            `json:"severity,omitempty"`
+	Summary    string            `json:"summary,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	State      string            `json:"state"`
+	Value      float64           `json:"value"`     // последнее значение, удовлетворившее условию
+	ActiveAt   time.Time         `json:"active_at"` // время начала выполнения условия
+	FiredAt    *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time        `json:"resolved_at,omitempty"`
+	SilencedBy []string          `json:"silenced_by,omitempty"` // тишина, подавляющая уведомления
+	notified   bool              // отправлено уведомление о срабатывании
+}
+
+// rule разобранное правило оповещения
+type rule struct {
+	config.AlertRule
+	expr    query.Expr
+	compare func(value, threshold float64) bool
+}
+
+// comparisons операторы сравнения значения с порогом
+var comparisons = map[string]func(value, threshold float64) bool{
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// Manager проверяет правила оповещений и хранит состояние оповещений,
+// безопасен для конкурентного использования
+type Manager struct {
+	repo     types.Repository
+	logger   *log.Logger
+	rules    []rule
+	mu       sync.RWMutex
+	alerts   map[string]*Alert
+	now      func() time.Time
+	notify   Notifier
+	silences *SilenceStore
+}
+
+// Notifier получает оповещения, которые сработали или разрешились
+type Notifier interface {
+	Notify(alerts []Alert)
+}
+
+// FiringNotifier Notifier, который помнит между перезапусками
+// оповещения, о срабатывании которых уведомил
+type FiringNotifier interface {
+	Notifier
+	Firing() []Alert
+}
+
+// Option тип для модификации Manager
+type Option func(m *Manager)
+
+// WithNotifier модифицирует Manager, передавая сработавшие
+// и разрешенные оповещения получателю n
+func WithNotifier(n Notifier) Option {
+	return func(m *Manager) {
+		m.notify = n
+	}
+}
+
+// WithSilences модифицирует Manager, подавляя уведомления
+// об оповещениях, подходящих под тишину из ss
+func WithSilences(ss *SilenceStore) Option {
+	return func(m *Manager) {
+		m.silences = ss
+	}
+}
+
+// NewManager создает Manager, возвращает ошибку для некорректных правил
+func NewManager(rules []config.AlertRule, repo types.Repository, logger *log.Logger, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		repo:   repo,
+		logger: logger,
+		rules:  make([]rule, 0, len(rules)),
+		alerts: make(map[string]*Alert),
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	names := make(map[string]struct{}, len(rules))
+	for _, r := range rules {
+		parsed, err := parseRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %q: %w", r.Name, err)
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("alert rule %q: duplicate name", r.Name)
+		}
+		names[r.Name] = struct{}{}
+		m.rules = append(m.rules, parsed)
+	}
+	m.restoreFiring(names)
+	return m, nil
+}
+
+// restoreFiring восстанавливает сработавшие оповещения, о которых
+// FiringNotifier уведомил до перезапуска: при следующей проверке они
+// разрешаются, если условие больше не выполняется; об оповещениях
+// правил, которых больше нет, сразу уведомляется как о разрешенных
+func (m *Manager) restoreFiring(names map[string]struct{}) {
+	notifier, ok := m.notify.(FiringNotifier)
+	if !ok {
+		return
+	}
+	now := m.now()
+	resolved := make([]Alert, 0)
+	for _, a := range notifier.Firing() {
+		if _, ok := names[a.Rule]; !ok {
+			resolvedAt := now
+			a.State = StateResolved
+			a.ResolvedAt = &resolvedAt
+			resolved = append(resolved, a)
+			continue
+		}
+		alert := a
+		alert.notified = true
+		m.alerts[alertKey(a.Rule, a.Metric, a.Labels)] = &alert
+	}
+	if len(resolved) > 0 {
+		notifier.Notify(resolved)
+	}
+}
+
+// parseRule проверяет правило и разбирает его условие
+func parseRule(r config.AlertRule) (rule, error) {
+	out := rule{AlertRule: r}
+	if r.Name == "" {
+		return out, errors.New("name is empty")
+	}
+	if (r.Expr == "") == (r.Metric == "") {
+		return out, errors.New("exactly one of expr or metric must be set")
+	}
+	var ok bool
+	if out.compare, ok = comparisons[r.Op]; !ok {
+		return out, fmt.Errorf("unknown comparison %q", r.Op)
+	}
+	if r.For < 0 {
+		return out, errors.New("for must not be negative")
+	}
+	input := r.Expr
+	if r.Metric != "" {
+		if _, _, err := types.ParseSeriesKey(r.Metric); err != nil {
+			return out, fmt.Errorf("wrong metric: %w", err)
+		}
+		input = r.Metric
+	}
+	var err error
+	if out.expr, err = query.Parse(input); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+// Run запускается в отдельной go routine, проверяет правила
+// с интервалом interval до отмены контекста
+func (m *Manager) Run(ctx context.Context, w *sync.WaitGroup, interval time.Duration) {
+	defer w.Done()
+	if len(m.rules) == 0 {
+		return
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			m.Evaluate(ctx)
+		}
+	}
+}
+
+// Evaluate проверяет все правила по текущим значениям метрик
+// и обновляет состояние оповещений, изменения передаются Notifier
+func (m *Manager) Evaluate(ctx context.Context) {
+	changed := make([]Alert, 0)
+	defer func() {
+		if m.notify != nil && len(changed) > 0 {
+			m.notify.Notify(changed)
+		}
+	}()
+	for _, r := range m.rules {
+		val, err := query.EvalExpr(ctx, m.repo, r.expr)
+		if err != nil {
+			if m.logger != nil {
+				m.logger.Printf("alerts: rule %q got error: %v\n", r.Name, err)
+			}
+			continue
+		}
+		changed = append(changed, m.update(r, matched(r, val))...)
+	}
+}
+
+// matched возвращает значения временных рядов, удовлетворяющие
+// условию правила, по ключам оповещений
+func matched(r rule, val query.Value) map[string]query.Series {
+	series := make(query.Vector, 0)
+	switch val := val.(type) {
+	case query.Scalar:
+		series = append(series, query.Series{Value: float64(val)})
+	case query.Vector:
+		series = val
+	}
+	out := make(map[string]query.Series)
+	for _, s := range series {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) || !r.compare(s.Value, r.Threshold) {
+			continue
+		}
+		out[alertKey(r.Name, s.Name, s.Labels)] = s
+	}
+	return out
+}
+
+// alertKey возвращает ключ оповещения правила rule по временному ряду
+// метрики metric с метками labels, ключи упорядочены по правилу
+func alertKey(rule, metric string, labels map[string]string) string {
+	return rule + "\x00" + types.SeriesKey(metric, labels)
+}
+
+// update переводит оповещения правила в новое состояние: новые
+// условия ожидают For, ожидающие дольше For срабатывают, сработавшие
+// без условия разрешаются, ожидающие без условия удаляются;
+// возвращает оповещения, о которых нужно уведомить: сработавшие
+// вне тишины и разрешенные после такого уведомления
+func (m *Manager) update(r rule, active map[string]query.Series) []Alert {
+	now := m.now()
+	changed := make([]Alert, 0)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, s := range active {
+		a, ok := m.alerts[key]
+		if !ok || a.State == StateResolved {
+			a = &Alert{
+				Rule:     r.Name,
+				Metric:   s.Name,
+				Severity: r.Severity,
+				Summary:  r.Summary,
+				Labels:   s.Labels,
+				State:    StatePending,
+				ActiveAt: now,
+			}
+			m.alerts[key] = a
+		}
+		a.Value = s.Value
+		if a.State == StatePending && now.Sub(a.ActiveAt) >= r.For {
+			firedAt := now
+			a.State = StateFiring
+			a.FiredAt = &firedAt
+		}
+	}
+	for key, a := range m.alerts {
+		if a.Rule != r.Name {
+			continue
+		}
+		if _, ok := active[key]; !ok {
+			switch a.State {
+			case StatePending:
+				delete(m.alerts, key)
+				continue
+			case StateFiring:
+				resolvedAt := now
+				a.State = StateResolved
+				a.ResolvedAt = &resolvedAt
+				if a.notified {
+					changed = append(changed, *a)
+				}
+			case StateResolved:
+				if now.Sub(*a.ResolvedAt) > resolvedKeep {
+					delete(m.alerts, key)
+					continue
+				}
+			}
+		}
+		a.SilencedBy = nil
+		if m.silences != nil && a.State != StateResolved {
+			if ids := m.silences.Silenced(*a); len(ids) > 0 {
+				a.SilencedBy = ids
+			}
+		}
+		// уведомление о срабатывании откладывается до окончания тишины
+		if a.State == StateFiring && !a.notified && len(a.SilencedBy) == 0 {
+			a.notified = true
+			changed = append(changed, *a)
+		}
+	}
+	return changed
+}
+
+// Alerts возвращает ожидающие, сработавшие и недавно разрешенные
+// оповещения, отсортированные по правилу и временному ряду
+func (m *Manager) Alerts() []Alert {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]string, 0, len(m.alerts))
+	for k := range m.alerts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]Alert, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *m.alerts[k])
+	}
+	return out
+}
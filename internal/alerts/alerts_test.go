@@ -0,0 +1,255 @@
+package alerts
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/query"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewManager(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []config.AlertRule
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			rules: []config.AlertRule{
+				{Name: "LowMemory", Metric: "FreeMemory", Op: "<", Threshold: 100},
+				{Name: "HighHeap", Expr: "HeapInuse / HeapSys", Op: ">=", Threshold: 0.9},
+			},
+		},
+		{name: "no name", rules: []config.AlertRule{{Metric: "FreeMemory", Op: "<"}}, wantErr: true},
+		{name: "no condition", rules: []config.AlertRule{{Name: "a", Op: "<"}}, wantErr: true},
+		{name: "both conditions", rules: []config.AlertRule{{Name: "a", Expr: "1", Metric: "FreeMemory", Op: "<"}}, wantErr: true},
+		{name: "wrong op", rules: []config.AlertRule{{Name: "a", Metric: "FreeMemory", Op: "=<"}}, wantErr: true},
+		{name: "wrong metric", rules: []config.AlertRule{{Name: "a", Metric: "FreeMemory{agent", Op: "<"}}, wantErr: true},
+		{name: "wrong expr", rules: []config.AlertRule{{Name: "a", Expr: "FreeMemory /", Op: "<"}}, wantErr: true},
+		{
+			name: "duplicate name",
+			rules: []config.AlertRule{
+				{Name: "a", Metric: "FreeMemory", Op: "<"},
+				{Name: "a", Metric: "Alloc", Op: ">"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewManager(tt.rules, storage.NewMemStorage(), nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// recorder сохраняет состояния полученных оповещений
+type recorder struct {
+	states []string
+}
+
+func (r *recorder) Notify(alerts []Alert) {
+	for _, a := range alerts {
+		r.states = append(r.states, a.State)
+	}
+}
+
+func TestManager_Evaluate(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+		`FreeMemory{agent="a1",host="web01"}`: float64(500),
+		`FreeMemory{agent="a2",host="db01"}`:  float64(50),
+	}))
+	rules := []config.AlertRule{{
+		Name:      "LowMemory",
+		Metric:    "FreeMemory",
+		Op:        "<",
+		Threshold: 100,
+		For:       time.Minute,
+		Severity:  "critical",
+	}}
+	notified := &recorder{}
+	manager, err := NewManager(rules, repo, log.New(os.Stdout, "", 0), WithNotifier(notified))
+	require.NoError(t, err)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return now }
+	db01 := map[string]string{"agent": "a2", "host": "db01"}
+
+	manager.Evaluate(ctx)
+	got := manager.Alerts()
+	require.Len(t, got, 1)
+	assert.Equal(t, Alert{
+		Rule:     "LowMemory",
+		Metric:   "FreeMemory",
+		Severity: "critical",
+		Labels:   db01,
+		State:    StatePending,
+		Value:    50,
+		ActiveAt: now,
+	}, got[0])
+
+	now = now.Add(time.Minute)
+	repo.Rewrite(ctx, `FreeMemory{agent="a2",host="db01"}`, 40)
+	manager.Evaluate(ctx)
+	got = manager.Alerts()
+	require.Len(t, got, 1)
+	assert.Equal(t, StateFiring, got[0].State)
+	assert.Equal(t, float64(40), got[0].Value)
+	assert.Equal(t, now, *got[0].FiredAt)
+
+	now = now.Add(time.Minute)
+	repo.Rewrite(ctx, `FreeMemory{agent="a2",host="db01"}`, 400)
+	manager.Evaluate(ctx)
+	got = manager.Alerts()
+	require.Len(t, got, 1)
+	assert.Equal(t, StateResolved, got[0].State)
+	assert.Equal(t, now, *got[0].ResolvedAt)
+
+	now = now.Add(time.Minute)
+	repo.Rewrite(ctx, `FreeMemory{agent="a2",host="db01"}`, 40)
+	manager.Evaluate(ctx)
+	got = manager.Alerts()
+	require.Len(t, got, 1)
+	assert.Equal(t, StatePending, got[0].State)
+	assert.Nil(t, got[0].FiredAt)
+
+	now = now.Add(time.Second)
+	repo.Rewrite(ctx, `FreeMemory{agent="a2",host="db01"}`, 400)
+	manager.Evaluate(ctx)
+	assert.Empty(t, manager.Alerts())
+	assert.Equal(t, []string{StateFiring, StateResolved}, notified.states)
+}
+
+func TestManager_restoreFiring(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	// до перезапуска отправлены уведомления о срабатывании
+	d, err := NewDispatcher([]string{"http://localhost"}, path, 0, nil)
+	require.NoError(t, err)
+	removed := testAlert("a3", StateFiring, now)
+	removed.Rule = "Removed"
+	d.Notify([]Alert{testAlert("a1", StateFiring, now), testAlert("a2", StateFiring, now), removed})
+
+	repo := storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+		`FreeMemory{agent="a1"}`: float64(50),
+		`FreeMemory{agent="a2"}`: float64(500),
+	}))
+	rules := []config.AlertRule{{Name: "LowMemory", Metric: "FreeMemory", Op: "<", Threshold: 100}}
+	restarted, err := NewDispatcher([]string{"http://localhost"}, path, 0, nil)
+	require.NoError(t, err)
+	// недоставленные уведомления о срабатывании не проверяются
+	restarted.box.Queue = nil
+	manager, err := NewManager(rules, repo, nil, WithNotifier(restarted))
+	require.NoError(t, err)
+	// оповещение удаленного правила разрешено сразу
+	require.Len(t, restarted.box.Queue, 1)
+	assert.Equal(t, "Removed", restarted.box.Queue[0].Rule)
+	assert.Equal(t, StateResolved, restarted.box.Queue[0].Status)
+
+	// оповещение, условие которого перестало выполняться, разрешено,
+	// а повторное срабатывание не отправляется
+	manager.Evaluate(ctx)
+	require.Len(t, restarted.box.Queue, 2)
+	assert.Equal(t, map[string]string{"agent": "a2"}, restarted.box.Queue[1].Labels)
+	assert.Equal(t, StateResolved, restarted.box.Queue[1].Status)
+	assert.Equal(t, []Alert{testAlert("a1", StateFiring, now)}, restarted.Firing())
+
+	got := manager.Alerts()
+	require.Len(t, got, 2)
+	assert.Equal(t, StateFiring, got[0].State)
+	assert.Equal(t, StateResolved, got[1].State)
+}
+
+func TestManager_Evaluate_expr(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+		`HeapInuse{agent="a1"}`: float64(95),
+		`HeapSys{agent="a1"}`:   float64(100),
+		`HeapInuse{agent="a2"}`: float64(10),
+		`HeapSys{agent="a2"}`:   float64(100),
+	}))
+	rules := []config.AlertRule{
+		{Name: "HighHeap", Expr: "HeapInuse / HeapSys", Op: ">", Threshold: 0.9},
+		{Name: "FleetHeap", Expr: "scalar(sum(HeapInuse))", Op: ">=", Threshold: 100},
+		{Name: "Broken", Expr: "rate(HeapInuse)", Op: ">", Threshold: 0},
+	}
+	manager, err := NewManager(rules, repo, log.New(os.Stdout, "", 0))
+	require.NoError(t, err)
+
+	manager.Evaluate(ctx)
+	got := manager.Alerts()
+	require.Len(t, got, 2)
+	assert.Equal(t, "FleetHeap", got[0].Rule)
+	assert.Equal(t, StateFiring, got[0].State)
+	assert.Nil(t, got[0].Labels)
+	assert.Equal(t, "HighHeap", got[1].Rule)
+	assert.Equal(t, map[string]string{"agent": "a1"}, got[1].Labels)
+	assert.Equal(t, StateFiring, got[1].State)
+}
+
+func Test_matched(t *testing.T) {
+	r, err := parseRule(config.AlertRule{Name: "High", Expr: "HeapInuse", Op: ">", Threshold: 0})
+	require.NoError(t, err)
+	labels := map[string]string{"agent": "a1"}
+	// ряды разных метрик с одинаковыми метками - разные оповещения
+	got := matched(r, query.Vector{
+		{Name: "HeapInuse", Labels: labels, Value: 1},
+		{Name: "HeapSys", Labels: labels, Value: 2},
+	})
+	assert.Len(t, got, 2)
+}
+
+func TestManager_Evaluate_forecast(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage(storage.WithHistory(100, time.Hour))
+	for _, v := range []float64{1000, 900, 800} {
+		repo.Rewrite(ctx, `FreeMemory{agent="a1"}`, v)
+		repo.Rewrite(ctx, `FreeMemory{agent="a2"}`, 2000-v)
+		time.Sleep(time.Millisecond)
+	}
+	rules := []config.AlertRule{{
+		Name:      "MemoryExhaustion",
+		Expr:      "predict_linear(FreeMemory[1h], 4 * 3600)",
+		Op:        "<=",
+		Threshold: 0,
+	}}
+	manager, err := NewManager(rules, repo, nil)
+	require.NoError(t, err)
+
+	manager.Evaluate(ctx)
+	got := manager.Alerts()
+	require.Len(t, got, 1, "free memory of a2 grows")
+	assert.Equal(t, map[string]string{"agent": "a1"}, got[0].Labels)
+	assert.Equal(t, StateFiring, got[0].State)
+}
+
+func TestManager_Run(t *testing.T) {
+	repo := storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+		"FreeMemory": float64(50),
+	}))
+	rules := []config.AlertRule{{Name: "LowMemory", Metric: "FreeMemory", Op: "<", Threshold: 100}}
+	manager, err := NewManager(rules, repo, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go manager.Run(ctx, &wg, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return len(manager.Alerts()) == 1 }, time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+}
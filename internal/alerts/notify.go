@@ -0,0 +1,363 @@
+// Часть модуля alerts содержит доставку уведомлений об оповещениях
+// в http webhook с группировкой, повторами и сохраняемой очередью.
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff    = time.Second      // пауза перед первым повтором доставки
+	maxBackoff    = 5 * time.Minute  // наибольшая пауза между повторами
+	maxAttempts   = 12               // число попыток, после которого уведомление отбрасывается
+	tickInterval  = time.Second      // интервал проверки очереди уведомлений
+	deliveryLimit = 10 * time.Second // таймаут одного запроса к webhook
+)
+
+// Notification уведомление об изменении состояния оповещения
+type Notification struct {
+	Rule     string            `json:"rule"`
+	Metric   string            `json:"metric,omitempty"`
+	Value    float64           `json:"value"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Status   string            `json:"status"`
+	Severity string            `json:"severity,omitempty"`
+	Summary  string            `json:"summary,omitempty"`
+	StartsAt time.Time         `json:"starts_at"`
+	EndsAt   *time.Time        `json:"ends_at,omitempty"`
+}
+
+// Payload тело запроса к webhook, содержит уведомления одного
+// правила, накопленные за окно группировки
+type Payload struct {
+	ID            string         `json:"id"` // по нему получатель отбрасывает повторы
+	Group         string         `json:"group"`
+	Status        string         `json:"status"` // firing, если есть сработавшие оповещения
+	Notifications []Notification `json:"alerts"`
+}
+
+// queued уведомление, ожидающее окончания окна группировки
+type queued struct {
+	Notification
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// delivery запрос к одному webhook, ожидающий доставки
+type delivery struct {
+	URL      string    `json:"url"`
+	Payload  Payload   `json:"payload"`
+	Attempts int       `json:"attempts"`
+	NextAt   time.Time `json:"next_at"`
+}
+
+// outbox сохраняемое между перезапусками состояние Dispatcher
+type outbox struct {
+	Queue      []queued                `json:"queue"`
+	Deliveries []delivery              `json:"deliveries"`
+	Firing     map[string]Notification `json:"firing"` // оповещения, о срабатывании которых уже уведомили
+	Seq        uint64                  `json:"seq"`
+}
+
+// Dispatcher доставляет уведомления об оповещениях в webhook,
+// реализует Notifier
+type Dispatcher struct {
+	urls      []string
+	path      string
+	groupWait time.Duration
+	client    *http.Client
+	logger    *log.Logger
+	mu        sync.Mutex
+	box       outbox
+	now       func() time.Time
+}
+
+// NewDispatcher создает Dispatcher и восстанавливает очередь
+// уведомлений из файла path, при пустом path очередь не сохраняется
+func NewDispatcher(urls []string, path string, groupWait time.Duration, logger *log.Logger) (*Dispatcher, error) {
+	d := &Dispatcher{
+		urls:      urls,
+		path:      path,
+		groupWait: groupWait,
+		client:    &http.Client{Timeout: deliveryLimit},
+		logger:    logger,
+		box:       outbox{Firing: make(map[string]Notification)},
+		now:       time.Now,
+	}
+	if path == "" {
+		return d, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return d, nil
+	}
+	if err = json.Unmarshal(data, &d.box); err != nil {
+		return nil, fmt.Errorf("wrong alerts outbox %s: %w", path, err)
+	}
+	// ключи оповещений очереди прежних версий не содержат метрику
+	firing := make(map[string]Notification, len(d.box.Firing))
+	for _, n := range d.box.Firing {
+		firing[alertKey(n.Rule, n.Metric, n.Labels)] = n
+	}
+	d.box.Firing = firing
+	return d, nil
+}
+
+// Firing возвращает оповещения, о срабатывании которых уже уведомили,
+// а о разрешении еще нет, реализует FiringNotifier. Состояние
+// сохраняется между перезапусками, Manager восстанавливает по нему
+// сработавшие оповещения, чтобы уведомить об их разрешении
+func (d *Dispatcher) Firing() []Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]string, 0, len(d.box.Firing))
+	for key := range d.box.Firing {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]Alert, 0, len(keys))
+	for _, key := range keys {
+		n := d.box.Firing[key]
+		out = append(out, Alert{
+			Rule:     n.Rule,
+			Metric:   n.Metric,
+			Severity: n.Severity,
+			Summary:  n.Summary,
+			Labels:   n.Labels,
+			State:    StateFiring,
+			Value:    n.Value,
+			ActiveAt: n.StartsAt,
+		})
+	}
+	return out
+}
+
+// Notify ставит в очередь уведомления о сработавших и разрешенных
+// оповещениях, повторные уведомления о том же состоянии отбрасываются
+func (d *Dispatcher) Notify(alerts []Alert) {
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	queueLen := len(d.box.Queue)
+	for _, a := range alerts {
+		key := alertKey(a.Rule, a.Metric, a.Labels)
+		n := Notification{
+			Rule:     a.Rule,
+			Metric:   a.Metric,
+			Value:    a.Value,
+			Labels:   a.Labels,
+			Status:   a.State,
+			Severity: a.Severity,
+			Summary:  a.Summary,
+			StartsAt: a.ActiveAt,
+		}
+		switch a.State {
+		case StateFiring:
+			if _, ok := d.box.Firing[key]; ok {
+				continue
+			}
+			d.box.Firing[key] = n
+		case StateResolved:
+			if _, ok := d.box.Firing[key]; !ok {
+				continue
+			}
+			delete(d.box.Firing, key)
+			n.EndsAt = a.ResolvedAt
+		default:
+			continue
+		}
+		d.box.Queue = append(d.box.Queue, queued{Notification: n, QueuedAt: now})
+	}
+	if len(d.box.Queue) > queueLen {
+		d.save()
+	}
+}
+
+// Run запускается в отдельной go routine, отправляет уведомления
+// до отмены контекста, неотправленные остаются в сохраняемой очереди
+func (d *Dispatcher) Run(ctx context.Context, w *sync.WaitGroup) {
+	defer w.Done()
+	tick := time.NewTicker(tickInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			d.Flush(ctx)
+		}
+	}
+}
+
+// Flush группирует уведомления с истекшим окном группировки
+// и отправляет запросы, для которых наступило время попытки;
+// очередь сохраняется только при изменении
+func (d *Dispatcher) Flush(ctx context.Context) {
+	now := d.now()
+	d.mu.Lock()
+	if d.group(now) {
+		d.save()
+	}
+	due := make([]delivery, 0)
+	for _, dl := range d.box.Deliveries {
+		if !dl.NextAt.After(now) {
+			due = append(due, dl)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, dl := range due {
+		err := d.send(ctx, dl)
+		d.mu.Lock()
+		d.done(dl, err, now)
+		d.save()
+		d.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// group переносит уведомления правил, окно группировки которых
+// истекло, в запросы к каждому webhook, возвращает был ли перенос
+func (d *Dispatcher) group(now time.Time) bool {
+	due := make(map[string]bool)
+	for _, q := range d.box.Queue {
+		if !q.QueuedAt.Add(d.groupWait).After(now) {
+			due[q.Rule] = true
+		}
+	}
+	if len(due) == 0 {
+		return false
+	}
+	payloads := make(map[string]*Payload)
+	order := make([]string, 0, len(due))
+	rest := make([]queued, 0, len(d.box.Queue))
+	for _, q := range d.box.Queue {
+		if !due[q.Rule] {
+			rest = append(rest, q)
+			continue
+		}
+		p, ok := payloads[q.Rule]
+		if !ok {
+			d.box.Seq++
+			p = &Payload{
+				ID:     fmt.Sprintf("%s-%d", q.Rule, d.box.Seq),
+				Group:  q.Rule,
+				Status: StateResolved,
+			}
+			payloads[q.Rule] = p
+			order = append(order, q.Rule)
+		}
+		if q.Status == StateFiring {
+			p.Status = StateFiring
+		}
+		p.Notifications = append(p.Notifications, q.Notification)
+	}
+	d.box.Queue = rest
+	for _, rule := range order {
+		for _, url := range d.urls {
+			d.box.Deliveries = append(d.box.Deliveries, delivery{URL: url, Payload: *payloads[rule], NextAt: now})
+		}
+	}
+	return true
+}
+
+// done удаляет доставленный запрос или назначает время следующей
+// попытки с экспоненциальной паузой
+func (d *Dispatcher) done(dl delivery, sendErr error, now time.Time) {
+	for i := range d.box.Deliveries {
+		cur := &d.box.Deliveries[i]
+		if cur.URL != dl.URL || cur.Payload.ID != dl.Payload.ID {
+			continue
+		}
+		if sendErr == nil {
+			d.box.Deliveries = append(d.box.Deliveries[:i], d.box.Deliveries[i+1:]...)
+			return
+		}
+		cur.Attempts++
+		if cur.Attempts >= maxAttempts {
+			d.logf("alerts: drop notification %s to %s after %d attempts: %v\n", cur.Payload.ID, cur.URL, cur.Attempts, sendErr)
+			d.box.Deliveries = append(d.box.Deliveries[:i], d.box.Deliveries[i+1:]...)
+			return
+		}
+		cur.NextAt = now.Add(backoff(cur.Attempts))
+		d.logf("alerts: notification %s to %s got error: %v\n", cur.Payload.ID, cur.URL, sendErr)
+		return
+	}
+}
+
+// send отправляет запрос к webhook, успехом считается ответ 2xx
+func (d *Dispatcher) send(ctx context.Context, dl delivery) error {
+	body, err := json.Marshal(dl.Payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// save записывает очередь в файл через временный файл,
+// чтобы при сбое не остался частично записанный файл
+func (d *Dispatcher) save() {
+	if d.path == "" {
+		return
+	}
+	data, err := json.Marshal(d.box)
+	if err != nil {
+		d.logf("alerts: when save outbox got error: %v\n", err)
+		return
+	}
+	tmp := d.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err == nil {
+		err = os.Rename(tmp, d.path)
+	}
+	if err != nil {
+		d.logf("alerts: when save outbox got error: %v\n", err)
+	}
+}
+
+func (d *Dispatcher) logf(format string, v ...interface{}) {
+	if d.logger != nil {
+		d.logger.Printf(format, v...)
+	}
+}
+
+// backoff возвращает паузу перед попыткой attempt+1
+func backoff(attempt int) time.Duration {
+	if attempt > 16 {
+		return maxBackoff
+	}
+	pause := minBackoff << (attempt - 1)
+	if pause > maxBackoff {
+		return maxBackoff
+	}
+	return pause
+}
@@ -0,0 +1,196 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver тестовый webhook, отвечает кодами из statuses,
+// после их окончания отвечает 200
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	calls    int
+	payloads []Payload
+}
+
+func (rc *receiver) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.calls++
+	if len(rc.statuses) > 0 {
+		status := rc.statuses[0]
+		rc.statuses = rc.statuses[1:]
+		rw.WriteHeader(status)
+		return
+	}
+	var p Payload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.payloads = append(rc.payloads, p)
+}
+
+func testAlert(agent, state string, at time.Time) Alert {
+	a := Alert{
+		Rule:     "LowMemory",
+		Metric:   "FreeMemory",
+		Labels:   map[string]string{"agent": agent},
+		State:    state,
+		Value:    50,
+		ActiveAt: at,
+	}
+	if state == StateResolved {
+		a.ResolvedAt = &at
+	}
+	return a
+}
+
+func TestDispatcher_Flush(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	d, err := NewDispatcher([]string{srv.URL}, "", 30*time.Second, nil)
+	require.NoError(t, err)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	d.Notify([]Alert{testAlert("a1", StateFiring, now)})
+	now = now.Add(10 * time.Second)
+	d.Notify([]Alert{testAlert("a2", StateFiring, now), testAlert("a1", StateFiring, now)})
+	d.Flush(ctx)
+	assert.Equal(t, 0, rc.calls, "group window is not over")
+
+	now = now.Add(20 * time.Second)
+	d.Flush(ctx)
+	require.Len(t, rc.payloads, 1)
+	assert.Equal(t, "LowMemory", rc.payloads[0].Group)
+	assert.Equal(t, StateFiring, rc.payloads[0].Status)
+	require.Len(t, rc.payloads[0].Notifications, 2)
+	assert.Equal(t, map[string]string{"agent": "a1"}, rc.payloads[0].Notifications[0].Labels)
+	assert.Equal(t, "FreeMemory", rc.payloads[0].Notifications[0].Metric)
+	assert.Equal(t, float64(50), rc.payloads[0].Notifications[0].Value)
+	assert.Equal(t, map[string]string{"agent": "a2"}, rc.payloads[0].Notifications[1].Labels)
+
+	d.Notify([]Alert{testAlert("a1", StateResolved, now), testAlert("a3", StateResolved, now)})
+	now = now.Add(30 * time.Second)
+	d.Flush(ctx)
+	require.Len(t, rc.payloads, 2)
+	assert.Equal(t, StateResolved, rc.payloads[1].Status)
+	require.Len(t, rc.payloads[1].Notifications, 1, "resolved without firing is skipped")
+	assert.NotNil(t, rc.payloads[1].Notifications[0].EndsAt)
+	assert.NotEqual(t, rc.payloads[0].ID, rc.payloads[1].ID)
+}
+
+func TestDispatcher_Flush_retry(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	d, err := NewDispatcher([]string{srv.URL}, "", 0, nil)
+	require.NoError(t, err)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	d.Notify([]Alert{testAlert("a1", StateFiring, now)})
+	d.Flush(ctx)
+	assert.Equal(t, 1, rc.calls)
+	d.Flush(ctx)
+	assert.Equal(t, 1, rc.calls, "backoff is not over")
+
+	now = now.Add(minBackoff)
+	d.Flush(ctx)
+	assert.Equal(t, 2, rc.calls)
+	now = now.Add(minBackoff)
+	d.Flush(ctx)
+	assert.Equal(t, 2, rc.calls, "second backoff is doubled")
+
+	now = now.Add(minBackoff)
+	d.Flush(ctx)
+	assert.Equal(t, 3, rc.calls)
+	assert.Len(t, rc.payloads, 1)
+	assert.Empty(t, d.box.Deliveries)
+}
+
+func TestDispatcher_outbox(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	rc := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	d, err := NewDispatcher([]string{srv.URL}, path, 0, nil)
+	require.NoError(t, err)
+	d.now = func() time.Time { return now }
+	d.Notify([]Alert{testAlert("a1", StateFiring, now)})
+	d.Flush(ctx)
+	assert.Equal(t, 1, rc.calls)
+
+	// после перезапуска недоставленное уведомление сохранилось,
+	// а повторное срабатывание того же оповещения отброшено
+	restarted, err := NewDispatcher([]string{srv.URL}, path, 0, nil)
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	restarted.now = func() time.Time { return now }
+	restarted.Notify([]Alert{testAlert("a1", StateFiring, now)})
+	restarted.Flush(ctx)
+	require.Len(t, rc.payloads, 1)
+	assert.Len(t, rc.payloads[0].Notifications, 1)
+
+	restarted, err = NewDispatcher([]string{srv.URL}, path, 0, nil)
+	require.NoError(t, err)
+	restarted.now = func() time.Time { return now }
+	restarted.Flush(ctx)
+	assert.Len(t, rc.payloads, 1, "delivered notification is not sent again")
+}
+
+func TestDispatcher_legacyOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	// очередь прежней версии с ключом оповещения без метрики
+	legacy := `{"firing":{"LowMemory{agent=\"a1\"}":{"rule":"LowMemory","metric":"FreeMemory","labels":{"agent":"a1"},"status":"firing"}}}`
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0600))
+
+	d, err := NewDispatcher(nil, path, 0, nil)
+	require.NoError(t, err)
+	d.Notify([]Alert{testAlert("a1", StateResolved, time.Now())})
+	assert.Empty(t, d.Firing())
+	assert.Len(t, d.box.Queue, 1)
+}
+
+func TestDispatcher_save(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	d, err := NewDispatcher(nil, path, time.Minute, nil)
+	require.NoError(t, err)
+
+	// без изменений очередь не сохраняется
+	d.Flush(ctx)
+	d.Notify([]Alert{testAlert("a1", StateResolved, time.Now())})
+	assert.NoFileExists(t, path)
+
+	d.Notify([]Alert{testAlert("a1", StateFiring, time.Now())})
+	assert.FileExists(t, path)
+	require.NoError(t, os.Remove(path))
+	d.Flush(ctx)
+	assert.NoFileExists(t, path)
+}
+
+func Test_backoff(t *testing.T) {
+	assert.Equal(t, time.Second, backoff(1))
+	assert.Equal(t, 8*time.Second, backoff(4))
+	assert.Equal(t, maxBackoff, backoff(10))
+	assert.Equal(t, maxBackoff, backoff(100))
+}
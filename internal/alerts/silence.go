@@ -0,0 +1,392 @@
+// Часть модуля alerts содержит хранилище тишины: временного или
+// повторяющегося по расписанию подавления уведомлений об оповещениях.
+package alerts
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Состояния тишины
+const (
+	SilencePending = "pending" // время начала еще не наступило
+	SilenceActive  = "active"
+	SilenceExpired = "expired"
+)
+
+const (
+	// silenceKeep время, в течение которого хранятся истекшие тишины
+	silenceKeep = 24 * time.Hour
+	// MetricLabel имя метки, по которой сравнивается имя метрики
+	MetricLabel = "__name__"
+	// RuleLabel имя метки, по которой сравнивается имя правила
+	RuleLabel = "alertname"
+)
+
+// ErrSilenceNotFound тишина с указанным идентификатором не найдена
+var ErrSilenceNotFound = errors.New("silence not found")
+
+// Matcher условие на метку оповещения, Op одно из = != =~ !~
+type Matcher struct {
+	Name  string `json:"name"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+	re    *regexp.Regexp
+}
+
+// Recurrence ежедневное окно обслуживания, начинается в Start
+// (время UTC в формате 15:04) в дни недели Weekdays и длится Duration,
+// пустой Weekdays означает каждый день
+type Recurrence struct {
+	Weekdays []string      `json:"weekdays,omitempty"`
+	Start    string        `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+// MarshalJSON записывает длительность окна в формате 2h30m
+func (r Recurrence) MarshalJSON() ([]byte, error) {
+	type RecurrenceAlias Recurrence
+	return json.Marshal(&struct {
+		RecurrenceAlias
+		Duration string `json:"duration"`
+	}{
+		RecurrenceAlias: RecurrenceAlias(r),
+		Duration:        r.Duration.String(),
+	})
+}
+
+// UnmarshalJSON разбирает длительность окна в формате 2h30m
+func (r *Recurrence) UnmarshalJSON(data []byte) error {
+	type RecurrenceAlias Recurrence
+	aliasValue := &struct {
+		*RecurrenceAlias
+		Duration string `json:"duration"`
+	}{
+		RecurrenceAlias: (*RecurrenceAlias)(r),
+	}
+	if err := json.Unmarshal(data, aliasValue); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(aliasValue.Duration)
+	if err != nil {
+		return fmt.Errorf("wrong recurrence duration: %w", err)
+	}
+	r.Duration = duration
+	return nil
+}
+
+// Silence подавляет уведомления об оповещениях, метки которых
+// удовлетворяют всем Matchers, с StartsAt до EndsAt, при заданном
+// Recurrence только в окна обслуживания, нулевой EndsAt не ограничивает
+// окна обслуживания по времени
+type Silence struct {
+	ID         string      `json:"id"`
+	Matchers   []Matcher   `json:"matchers"`
+	StartsAt   time.Time   `json:"starts_at"`
+	EndsAt     time.Time   `json:"ends_at"`
+	Recurrence *Recurrence `json:"recurrence,omitempty"`
+	Comment    string      `json:"comment,omitempty"`
+	CreatedBy  string      `json:"created_by,omitempty"`
+	Status     string      `json:"status,omitempty"`
+}
+
+// weekdays дни недели в формате окна обслуживания
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// compile проверяет тишину и подготавливает регулярные выражения
+func (s *Silence) compile() error {
+	if len(s.Matchers) == 0 {
+		return errors.New("silence must have at least one matcher")
+	}
+	for i := range s.Matchers {
+		m := &s.Matchers[i]
+		if m.Name == "" {
+			return errors.New("matcher name is empty")
+		}
+		switch m.Op {
+		case "", "=", "!=":
+		case "=~", "!~":
+			re, err := regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return fmt.Errorf("wrong matcher %s regexp: %w", m.Name, err)
+			}
+			m.re = re
+		default:
+			return fmt.Errorf("unknown matcher op %q", m.Op)
+		}
+		if m.Op == "" {
+			m.Op = "="
+		}
+	}
+	if s.Recurrence == nil {
+		if s.EndsAt.IsZero() || !s.EndsAt.After(s.StartsAt) {
+			return errors.New("ends_at must be after starts_at")
+		}
+		return nil
+	}
+	if !s.EndsAt.IsZero() && !s.EndsAt.After(s.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	if _, err := time.Parse("15:04", s.Recurrence.Start); err != nil {
+		return fmt.Errorf("wrong recurrence start %q", s.Recurrence.Start)
+	}
+	if s.Recurrence.Duration <= 0 || s.Recurrence.Duration > 7*24*time.Hour {
+		return errors.New("recurrence duration must be positive and at most a week")
+	}
+	for _, day := range s.Recurrence.Weekdays {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("wrong recurrence weekday %q", day)
+		}
+	}
+	return nil
+}
+
+// matches проверяет метки оповещения
+func (s *Silence) matches(labels map[string]string) bool {
+	for _, m := range s.Matchers {
+		value := labels[m.Name]
+		var ok bool
+		switch m.Op {
+		case "=":
+			ok = value == m.Value
+		case "!=":
+			ok = value != m.Value
+		case "=~":
+			ok = m.re.MatchString(value)
+		case "!~":
+			ok = !m.re.MatchString(value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// status возвращает состояние тишины на момент now
+func (s *Silence) status(now time.Time) string {
+	switch {
+	case now.Before(s.StartsAt):
+		return SilencePending
+	case !s.EndsAt.IsZero() && !now.Before(s.EndsAt):
+		return SilenceExpired
+	}
+	return SilenceActive
+}
+
+// active проверяет, подавляет ли тишина уведомления в момент now
+func (s *Silence) active(now time.Time) bool {
+	if s.status(now) != SilenceActive {
+		return false
+	}
+	if s.Recurrence == nil {
+		return true
+	}
+	start, _ := time.Parse("15:04", s.Recurrence.Start)
+	now = now.UTC()
+	// окно, начавшееся в один из предыдущих дней, может еще длиться
+	for back := 0; back <= 7; back++ {
+		day := now.AddDate(0, 0, -back)
+		from := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+		if from.After(now) || !s.onWeekday(from.Weekday()) {
+			continue
+		}
+		if now.Before(from.Add(s.Recurrence.Duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Silence) onWeekday(day time.Weekday) bool {
+	if len(s.Recurrence.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range s.Recurrence.Weekdays {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// SilenceStore хранилище тишины, сохраняется в файл,
+// безопасно для конкурентного использования
+type SilenceStore struct {
+	path     string
+	mu       sync.RWMutex
+	silences map[string]*Silence
+	now      func() time.Time
+}
+
+// NewSilenceStore создает SilenceStore и загружает тишину из файла
+// path, при пустом path тишина не сохраняется
+func NewSilenceStore(path string) (*SilenceStore, error) {
+	ss := &SilenceStore{
+		path:     path,
+		silences: make(map[string]*Silence),
+		now:      time.Now,
+	}
+	if path == "" {
+		return ss, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || err == nil && len(data) == 0 {
+		return ss, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Silence, 0)
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("wrong silences file %s: %w", path, err)
+	}
+	for i := range list {
+		s := list[i]
+		if err = s.compile(); err != nil {
+			return nil, fmt.Errorf("wrong silence %s: %w", s.ID, err)
+		}
+		ss.silences[s.ID] = &s
+	}
+	return ss, nil
+}
+
+// Add проверяет и сохраняет новую тишину, нулевой StartsAt
+// означает текущий момент
+func (ss *SilenceStore) Add(s Silence) (Silence, error) {
+	now := ss.now()
+	if s.StartsAt.IsZero() {
+		s.StartsAt = now
+	}
+	s.Status = ""
+	if err := s.compile(); err != nil {
+		return Silence{}, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Silence{}, err
+	}
+	s.ID = hex.EncodeToString(id)
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.silences[s.ID] = &s
+	if err := ss.save(now); err != nil {
+		delete(ss.silences, s.ID)
+		return Silence{}, err
+	}
+	out := s
+	out.Status = s.status(now)
+	return out, nil
+}
+
+// Expire завершает тишину с идентификатором id
+func (ss *SilenceStore) Expire(id string) error {
+	now := ss.now()
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, ok := ss.silences[id]
+	if !ok {
+		return ErrSilenceNotFound
+	}
+	if s.status(now) == SilenceExpired {
+		return nil
+	}
+	expired := *s
+	if now.Before(expired.StartsAt) {
+		expired.StartsAt = now
+	}
+	expired.EndsAt = now
+	// при ошибке записи тишина остается действующей
+	ss.silences[id] = &expired
+	if err := ss.save(now); err != nil {
+		ss.silences[id] = s
+		return err
+	}
+	return nil
+}
+
+// List возвращает тишину, отсортированную по времени начала
+func (ss *SilenceStore) List() []Silence {
+	now := ss.now()
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	out := make([]Silence, 0, len(ss.silences))
+	for _, s := range ss.silences {
+		item := *s
+		item.Status = s.status(now)
+		out = append(out, item)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].StartsAt.Equal(out[j].StartsAt) {
+			return out[i].StartsAt.Before(out[j].StartsAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Silenced возвращает идентификаторы тишины, подавляющей
+// уведомления об оповещении a
+func (ss *SilenceStore) Silenced(a Alert) []string {
+	now := ss.now()
+	labels := make(map[string]string, len(a.Labels)+2)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels[MetricLabel] = a.Metric
+	labels[RuleLabel] = a.Rule
+
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	ids := make([]string, 0)
+	for id, s := range ss.silences {
+		if s.active(now) && s.matches(labels) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// save удаляет давно истекшую тишину и записывает остальную в файл
+func (ss *SilenceStore) save(now time.Time) error {
+	for id, s := range ss.silences {
+		if !s.EndsAt.IsZero() && now.Sub(s.EndsAt) > silenceKeep {
+			delete(ss.silences, id)
+		}
+	}
+	if ss.path == "" {
+		return nil
+	}
+	list := make([]Silence, 0, len(ss.silences))
+	for _, s := range ss.silences {
+		list = append(list, *s)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tmp := ss.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ss.path)
+}
@@ -0,0 +1,194 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSilenceStore_Add(t *testing.T) {
+	now := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC) // понедельник
+	tests := []struct {
+		name    string
+		silence Silence
+		wantErr bool
+	}{
+		{
+			name:    "one time",
+			silence: Silence{Matchers: []Matcher{{Name: "agent", Value: "a1"}}, EndsAt: now.Add(time.Hour)},
+		},
+		{
+			name: "recurring",
+			silence: Silence{
+				Matchers:   []Matcher{{Name: "host", Op: "=~", Value: "db.*"}},
+				Recurrence: &Recurrence{Weekdays: []string{"Sun"}, Start: "02:00", Duration: 2 * time.Hour},
+			},
+		},
+		{name: "no matchers", silence: Silence{EndsAt: now.Add(time.Hour)}, wantErr: true},
+		{name: "no end", silence: Silence{Matchers: []Matcher{{Name: "agent", Value: "a1"}}}, wantErr: true},
+		{
+			name:    "wrong op",
+			silence: Silence{Matchers: []Matcher{{Name: "agent", Op: "==", Value: "a1"}}, EndsAt: now.Add(time.Hour)},
+			wantErr: true,
+		},
+		{
+			name:    "wrong regexp",
+			silence: Silence{Matchers: []Matcher{{Name: "agent", Op: "=~", Value: "a("}}, EndsAt: now.Add(time.Hour)},
+			wantErr: true,
+		},
+		{
+			name: "wrong weekday",
+			silence: Silence{
+				Matchers:   []Matcher{{Name: "agent", Value: "a1"}},
+				Recurrence: &Recurrence{Weekdays: []string{"funday"}, Start: "02:00", Duration: time.Hour},
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong start",
+			silence: Silence{
+				Matchers:   []Matcher{{Name: "agent", Value: "a1"}},
+				Recurrence: &Recurrence{Start: "25:00", Duration: time.Hour},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss, err := NewSilenceStore("")
+			require.NoError(t, err)
+			ss.now = func() time.Time { return now }
+			got, err := ss.Add(tt.silence)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Empty(t, ss.List())
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, got.ID)
+			assert.Equal(t, now, got.StartsAt)
+			assert.Equal(t, SilenceActive, got.Status)
+		})
+	}
+}
+
+func TestSilenceStore_Silenced(t *testing.T) {
+	now := time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC) // воскресенье
+	ss, err := NewSilenceStore("")
+	require.NoError(t, err)
+	ss.now = func() time.Time { return now }
+	reboot, err := ss.Add(Silence{
+		Matchers: []Matcher{{Name: "agent", Value: "a1"}, {Name: MetricLabel, Op: "=~", Value: "Free.*"}},
+		EndsAt:   now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+	maintenance, err := ss.Add(Silence{
+		Matchers:   []Matcher{{Name: "host", Value: "db01"}},
+		StartsAt:   now.Add(-30 * 24 * time.Hour),
+		Recurrence: &Recurrence{Weekdays: []string{"sat"}, Start: "23:00", Duration: 5 * time.Hour},
+	})
+	require.NoError(t, err)
+
+	a1 := Alert{Rule: "LowMemory", Metric: "FreeMemory", Labels: map[string]string{"agent": "a1", "host": "web01"}}
+	a2 := Alert{Rule: "LowMemory", Metric: "FreeMemory", Labels: map[string]string{"agent": "a2", "host": "db01"}}
+	assert.Equal(t, []string{reboot.ID}, ss.Silenced(a1))
+	assert.Equal(t, []string{maintenance.ID}, ss.Silenced(a2), "window started yesterday")
+	assert.Empty(t, ss.Silenced(Alert{Rule: "HighHeap", Metric: "HeapInuse", Labels: a1.Labels}))
+
+	now = now.Add(2 * time.Hour)
+	assert.Empty(t, ss.Silenced(a1), "silence is over")
+	assert.Empty(t, ss.Silenced(a2), "window is over")
+
+	now = now.Add(6*24*time.Hour + 19*time.Hour) // следующее воскресенье 00:00
+	assert.Equal(t, []string{maintenance.ID}, ss.Silenced(a2))
+	require.NoError(t, ss.Expire(maintenance.ID))
+	assert.Empty(t, ss.Silenced(a2))
+	assert.ErrorIs(t, ss.Expire("unknown"), ErrSilenceNotFound)
+}
+
+func TestSilenceStore_persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "silences.json")
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	ss, err := NewSilenceStore(path)
+	require.NoError(t, err)
+	ss.now = func() time.Time { return now }
+	added, err := ss.Add(Silence{
+		Matchers:   []Matcher{{Name: "agent", Op: "!~", Value: "a[12]"}},
+		Recurrence: &Recurrence{Start: "01:30", Duration: 90 * time.Minute},
+		Comment:    "nightly backup",
+	})
+	require.NoError(t, err)
+
+	loaded, err := NewSilenceStore(path)
+	require.NoError(t, err)
+	loaded.now = ss.now
+	got := loaded.List()
+	require.Len(t, got, 1)
+	assert.Equal(t, added.ID, got[0].ID)
+	assert.Equal(t, "nightly backup", got[0].Comment)
+	assert.Equal(t, 90*time.Minute, got[0].Recurrence.Duration)
+	now = now.Add(2 * time.Hour)
+	assert.Len(t, loaded.Silenced(Alert{Rule: "r", Labels: map[string]string{"agent": "a3"}}), 1)
+
+	data, err := json.Marshal(got[0].Recurrence)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"start":"01:30","duration":"1h30m0s"}`, string(data))
+}
+
+func TestSilenceStore_Expire_saveFailed(t *testing.T) {
+	dir := t.TempDir()
+	ss, err := NewSilenceStore(filepath.Join(dir, "silences.json"))
+	require.NoError(t, err)
+	endsAt := time.Now().Add(time.Hour)
+	added, err := ss.Add(Silence{Matchers: []Matcher{{Name: "agent", Op: "=", Value: "a1"}}, EndsAt: endsAt})
+	require.NoError(t, err)
+
+	// тишина, которую не удалось сохранить истекшей, продолжает действовать
+	ss.path = filepath.Join(dir, "missing", "silences.json")
+	assert.Error(t, ss.Expire(added.ID))
+	got := ss.List()
+	require.Len(t, got, 1)
+	assert.Equal(t, SilenceActive, got[0].Status)
+	assert.True(t, endsAt.Equal(got[0].EndsAt))
+}
+
+func TestManager_Evaluate_silenced(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+		`FreeMemory{agent="a1"}`: float64(50),
+	}))
+	ss, err := NewSilenceStore("")
+	require.NoError(t, err)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	ss.now = func() time.Time { return now }
+	silence, err := ss.Add(Silence{Matchers: []Matcher{{Name: "agent", Value: "a1"}}, EndsAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+
+	notified := &recorder{}
+	rules := []config.AlertRule{{Name: "LowMemory", Metric: "FreeMemory", Op: "<", Threshold: 100}}
+	manager, err := NewManager(rules, repo, nil, WithNotifier(notified), WithSilences(ss))
+	require.NoError(t, err)
+	manager.now = ss.now
+
+	manager.Evaluate(ctx)
+	got := manager.Alerts()
+	require.Len(t, got, 1)
+	assert.Equal(t, StateFiring, got[0].State, "state is tracked under silence")
+	assert.Equal(t, []string{silence.ID}, got[0].SilencedBy)
+	assert.Empty(t, notified.states)
+
+	require.NoError(t, ss.Expire(silence.ID))
+	manager.Evaluate(ctx)
+	assert.Empty(t, manager.Alerts()[0].SilencedBy)
+	assert.Equal(t, []string{StateFiring}, notified.states, "notified after silence end")
+
+	manager.Evaluate(ctx)
+	assert.Equal(t, []string{StateFiring}, notified.states)
+}
@@ -0,0 +1,289 @@
+// Модуль anomaly содержит поиск аномальных значений gauge метрик
+// по отклонению от скользящего среднего, в том числе сезонного.
+package anomaly
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// Имена производных временных рядов, метка SourceLabel содержит
+// имя исходной метрики, остальные метки совпадают с исходными
+const (
+	ScoreName    = "anomaly_zscore"   // отклонение значения в стандартных отклонениях
+	BaselineName = "anomaly_baseline" // ожидаемое значение
+	FlagName     = "anomaly"          // 1, если значение аномально, иначе 0
+	// SourceLabel метка с именем исходной метрики, метки с префиксом __
+	// зарезервированы, ряды с этой меткой не проверяются
+	SourceLabel = "__source__"
+)
+
+// Значения параметров по умолчанию
+const (
+	defaultAlpha      = 0.1
+	defaultBuckets    = 24
+	defaultMinSamples = 10
+	defaultInterval   = 10 * time.Second
+)
+
+// Score результат проверки последнего значения временного ряда
+type Score struct {
+	Name      string            `json:"id"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     float64           `json:"value"`
+	Baseline  float64           `json:"baseline"`
+	ZScore    float64           `json:"zscore"`
+	Anomaly   bool              `json:"anomaly"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// ewma экспоненциально взвешенные скользящие среднее и дисперсия
+type ewma struct {
+	mean     float64
+	variance float64
+	n        int
+}
+
+// zscore возвращает отклонение x от среднего в стандартных отклонениях
+func (e *ewma) zscore(x float64) float64 {
+	if e.n == 0 {
+		return 0
+	}
+	diff := x - e.mean
+	if e.variance <= 0 {
+		if diff == 0 {
+			return 0
+		}
+		return math.Copysign(math.Inf(1), diff)
+	}
+	return diff / math.Sqrt(e.variance)
+}
+
+// add учитывает значение x с коэффициентом сглаживания alpha
+func (e *ewma) add(x, alpha float64) {
+	e.n++
+	if e.n == 1 {
+		e.mean = x
+		return
+	}
+	diff := x - e.mean
+	incr := alpha * diff
+	e.mean += incr
+	e.variance = (1 - alpha) * (e.variance + diff*incr)
+}
+
+// series статистика временного ряда: общая и по интервалам сезона
+type series struct {
+	global    ewma
+	seasonal  []ewma
+	last      time.Time
+	written   time.Time // время значения, записанного в производные ряды
+	score     Score
+	noHistory bool // проверяется только текущее значение
+}
+
+// Detector ищет аномальные значения gauge метрик хранилища и
+// записывает в него производные временные ряды, безопасен для
+// конкурентного использования
+type Detector struct {
+	repo      types.Repository
+	threshold float64
+	opts      config.AnomalyOptions
+	mu        sync.RWMutex
+	series    map[string]*series
+	now       func() time.Time
+}
+
+// NewDetector создает Detector, значения с отклонением по модулю не
+// меньше threshold считаются аномальными, незаданные параметры opts
+// получают значения по умолчанию
+func NewDetector(repo types.Repository, threshold float64, opts config.AnomalyOptions) (*Detector, error) {
+	if threshold <= 0 {
+		return nil, errors.New("anomaly z-score threshold must be positive")
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = defaultAlpha
+	}
+	if opts.Alpha < 0 || opts.Alpha > 1 {
+		return nil, errors.New("anomaly alpha must be in (0, 1]")
+	}
+	if opts.Season < 0 {
+		return nil, errors.New("anomaly season must not be negative")
+	}
+	if opts.Season > 0 && opts.Buckets == 0 {
+		opts.Buckets = defaultBuckets
+	}
+	if opts.Buckets < 0 || opts.Season > 0 && opts.Season/time.Duration(opts.Buckets) < time.Second {
+		return nil, errors.New("anomaly season buckets must be at least one second long")
+	}
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = defaultMinSamples
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	return &Detector{
+		repo:      repo,
+		threshold: threshold,
+		opts:      opts,
+		series:    make(map[string]*series),
+		now:       time.Now,
+	}, nil
+}
+
+// Run запускается в отдельной go routine, проверяет значения
+// с интервалом из параметров до отмены контекста
+func (d *Detector) Run(ctx context.Context, w *sync.WaitGroup) {
+	defer w.Done()
+	tick := time.NewTicker(d.opts.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			d.Evaluate(ctx)
+		}
+	}
+}
+
+// Evaluate проверяет новые значения gauge метрик и обновляет
+// производные временные ряды; если хранилище хранит историю,
+// проверяются все значения после предыдущей проверки, иначе
+// только текущее значение, если оно изменилось
+func (d *Detector) Evaluate(ctx context.Context) {
+	now := d.now()
+	ranger, hasHistory := d.repo.(types.Ranger)
+	scores := make([]Score, 0)
+	for key, value := range d.repo.GetAll(ctx) {
+		gauge, ok := value.(float64)
+		if !ok || ctx.Err() != nil {
+			continue
+		}
+		name, labels, err := types.ParseSeriesKey(key)
+		if err != nil || name == ScoreName || name == BaselineName || name == FlagName {
+			continue
+		}
+		if _, ok := labels[SourceLabel]; ok {
+			continue
+		}
+		d.mu.Lock()
+		st, ok := d.series[key]
+		if !ok {
+			st = &series{}
+			if d.opts.Season > 0 {
+				st.seasonal = make([]ewma, d.opts.Buckets)
+			}
+			d.series[key] = st
+		}
+		last, useHistory := st.last, hasHistory && !st.noHistory
+		unchanged := !last.IsZero() && st.score.Value == gauge
+		d.mu.Unlock()
+
+		samples := []types.Sample{{Timestamp: now, Value: gauge}}
+		if !useHistory && unchanged {
+			// без истории время значения неизвестно, неизмененное
+			// значение уже учтено, а повторный учет сужает разброс
+			continue
+		}
+		if useHistory {
+			history := ranger.Range(ctx, key, last, now)
+			// история ряда пуста, если хранилище не хранит историю
+			if len(history) > 0 || !last.IsZero() {
+				samples = newSamples(history, last)
+			} else {
+				d.mu.Lock()
+				st.noHistory = true
+				d.mu.Unlock()
+			}
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		d.mu.Lock()
+		for _, sample := range samples {
+			st.score = d.observe(st, sample)
+		}
+		st.score.Name = name
+		st.score.Labels = labels
+		// производные ряды записываются только по новому значению
+		if st.score.Timestamp.After(st.written) {
+			st.written = st.score.Timestamp
+			scores = append(scores, st.score)
+		}
+		d.mu.Unlock()
+	}
+	for _, score := range scores {
+		d.write(ctx, score)
+	}
+}
+
+// newSamples возвращает значения строго после last
+func newSamples(samples []types.Sample, last time.Time) []types.Sample {
+	out := make([]types.Sample, 0, len(samples))
+	for _, s := range samples {
+		if s.Timestamp.After(last) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// observe проверяет значение по статистике ряда и учитывает его;
+// при сезонной статистике используется интервал сезона, если в нем
+// накоплено достаточно значений
+func (d *Detector) observe(st *series, sample types.Sample) Score {
+	stat := &st.global
+	var bucket *ewma
+	if len(st.seasonal) > 0 {
+		width := d.opts.Season / time.Duration(len(st.seasonal))
+		offset := time.Duration(sample.Timestamp.UnixNano()) % d.opts.Season
+		bucket = &st.seasonal[int(offset/width)%len(st.seasonal)]
+		if bucket.n >= d.opts.MinSamples {
+			stat = bucket
+		}
+	}
+	score := Score{
+		Value:     sample.Value,
+		Baseline:  stat.mean,
+		ZScore:    stat.zscore(sample.Value),
+		Timestamp: sample.Timestamp,
+	}
+	if stat.n == 0 {
+		score.Baseline = sample.Value
+	}
+	score.Anomaly = stat.n >= d.opts.MinSamples && math.Abs(score.ZScore) >= d.threshold
+	st.global.add(sample.Value, d.opts.Alpha)
+	if bucket != nil {
+		bucket.add(sample.Value, d.opts.Alpha)
+	}
+	st.last = sample.Timestamp
+	return score
+}
+
+// write записывает производные временные ряды результата,
+// бесконечное отклонение записывается как ±math.MaxFloat64
+func (d *Detector) write(ctx context.Context, score Score) {
+	labels := make(map[string]string, len(score.Labels)+1)
+	for k, v := range score.Labels {
+		labels[k] = v
+	}
+	labels[SourceLabel] = score.Name
+	zscore := score.ZScore
+	if math.IsInf(zscore, 0) {
+		zscore = math.Copysign(math.MaxFloat64, zscore)
+	}
+	flag := float64(0)
+	if score.Anomaly {
+		flag = 1
+	}
+	d.repo.Rewrite(ctx, types.SeriesKey(ScoreName, labels), zscore)
+	d.repo.Rewrite(ctx, types.SeriesKey(BaselineName, labels), score.Baseline)
+	d.repo.Rewrite(ctx, types.SeriesKey(FlagName, labels), flag)
+}
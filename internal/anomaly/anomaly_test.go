@@ -0,0 +1,176 @@
+package anomaly
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/alerts"
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ewma(t *testing.T) {
+	var e ewma
+	assert.Equal(t, float64(0), e.zscore(10))
+	e.add(10, 0.5)
+	assert.Equal(t, float64(10), e.mean)
+	e.add(20, 0.5)
+	assert.Equal(t, float64(15), e.mean)
+	assert.Equal(t, float64(25), e.variance)
+	assert.Equal(t, float64(2), e.zscore(25))
+	assert.Equal(t, float64(-1), e.zscore(10))
+}
+
+func TestNewDetector(t *testing.T) {
+	repo := storage.NewMemStorage()
+	tests := []struct {
+		name      string
+		threshold float64
+		opts      config.AnomalyOptions
+		wantErr   bool
+	}{
+		{name: "defaults", threshold: 3},
+		{name: "seasonal", threshold: 3, opts: config.AnomalyOptions{Season: 24 * time.Hour, Buckets: 24}},
+		{name: "no threshold", wantErr: true},
+		{name: "wrong alpha", threshold: 3, opts: config.AnomalyOptions{Alpha: 1.5}, wantErr: true},
+		{name: "short buckets", threshold: 3, opts: config.AnomalyOptions{Season: time.Minute, Buckets: 120}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDetector(repo, tt.threshold, tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, defaultInterval, d.opts.Interval)
+		})
+	}
+}
+
+func TestDetector_Evaluate(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage(storage.WithHistory(100, time.Hour))
+	key := `HeapAlloc{agent="a1"}`
+	for i := 0; i < 20; i++ {
+		repo.Rewrite(ctx, key, float64(99+2*(i%2)))
+	}
+	repo.Append(ctx, "PollCount", 5)
+	d, err := NewDetector(repo, 3, config.AnomalyOptions{})
+	require.NoError(t, err)
+	manager, err := alerts.NewManager([]config.AlertRule{{
+		Name:      "HeapAnomaly",
+		Metric:    `anomaly{__source__="HeapAlloc"}`,
+		Op:        "==",
+		Threshold: 1,
+	}}, repo, nil)
+	require.NoError(t, err)
+
+	d.Evaluate(ctx)
+	require.Len(t, d.series, 1, "counters are skipped")
+	score := d.series[key].score
+	assert.Equal(t, "HeapAlloc", score.Name)
+	assert.False(t, score.Anomaly)
+	assert.InDelta(t, 100, score.Baseline, 1)
+	derived := `{__source__="HeapAlloc",agent="a1"}`
+	assert.Equal(t, float64(0), repo.Get(ctx, "anomaly"+derived))
+	manager.Evaluate(ctx)
+	assert.Empty(t, manager.Alerts())
+
+	repo.Rewrite(ctx, key, 200)
+	d.Evaluate(ctx)
+	require.Len(t, d.series, 1, "derived series are skipped")
+	score = d.series[key].score
+	assert.True(t, score.Anomaly)
+	assert.Greater(t, score.ZScore, float64(3))
+	assert.Equal(t, float64(1), repo.Get(ctx, "anomaly"+derived))
+	assert.Equal(t, score.ZScore, repo.Get(ctx, "anomaly_zscore"+derived))
+	assert.Equal(t, score.Baseline, repo.Get(ctx, "anomaly_baseline"+derived))
+	manager.Evaluate(ctx)
+	got := manager.Alerts()
+	require.Len(t, got, 1, "anomaly is an alert source")
+	assert.Equal(t, alerts.StateFiring, got[0].State)
+
+	// без новых значений производные ряды не перезаписываются
+	repo.Rewrite(ctx, "anomaly"+derived, 0)
+	d.Evaluate(ctx)
+	assert.True(t, d.series[key].score.Anomaly, "no new samples, score is kept")
+	assert.Equal(t, float64(0), repo.Get(ctx, "anomaly"+derived))
+}
+
+func TestDetector_Evaluate_sourceLabel(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+		`HeapAlloc{metric="heap"}`:      float64(1),
+		`HeapAlloc{__source__="other"}`: float64(1),
+	}))
+	d, err := NewDetector(repo, 3, config.AnomalyOptions{})
+	require.NoError(t, err)
+	d.Evaluate(ctx)
+
+	// метка metric исходного ряда сохраняется в производных рядах
+	assert.Equal(t, float64(0), repo.Get(ctx, `anomaly{__source__="HeapAlloc",metric="heap"}`))
+	assert.Len(t, d.series, 1, "series with reserved label are skipped")
+}
+
+func TestDetector_Evaluate_noHistory(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage()
+	key := "HeapAlloc"
+	d, err := NewDetector(repo, 3, config.AnomalyOptions{})
+	require.NoError(t, err)
+	for _, value := range []float64{90, 110, 90, 110} {
+		repo.Rewrite(ctx, key, value)
+		d.Evaluate(ctx)
+	}
+	stat := d.series[key].global
+
+	// неизмененное значение не учитывается повторно
+	for i := 0; i < 10; i++ {
+		d.Evaluate(ctx)
+	}
+	assert.Equal(t, stat, d.series[key].global)
+	repo.Rewrite(ctx, key, 100)
+	d.Evaluate(ctx)
+	assert.Equal(t, stat.n+1, d.series[key].global.n)
+}
+
+func TestDetector_Evaluate_seasonal(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage()
+	key := `CPUutilization1{agent="a1"}`
+	d, err := NewDetector(repo, 3, config.AnomalyOptions{
+		Alpha:      0.5,
+		Season:     2 * time.Minute,
+		Buckets:    2,
+		MinSamples: 3,
+	})
+	require.NoError(t, err)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	d.now = func() time.Time { return now }
+
+	// в четные минуты нагрузка низкая, в нечетные высокая
+	for i := 0; i < 20; i++ {
+		now = start.Add(time.Duration(i) * time.Minute)
+		value := float64(10 + (i/2)%2)
+		if i%2 == 1 {
+			value += 80
+		}
+		repo.Rewrite(ctx, key, value)
+		d.Evaluate(ctx)
+		if i >= 8 {
+			require.False(t, d.series[key].score.Anomaly, "minute %d", i)
+		}
+	}
+
+	now = start.Add(20 * time.Minute)
+	repo.Rewrite(ctx, key, 90)
+	d.Evaluate(ctx)
+	score := d.series[key].score
+	assert.True(t, score.Anomaly, "high load in low season bucket")
+	assert.InDelta(t, 10.5, score.Baseline, 0.5)
+}
@@ -0,0 +1,69 @@
+// Модуль backend выбирает и создает хранилище метрик сервера по
+// конфигурации.
+package backend
+
+import (
+	"errors"
+	"log"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	dbstorage "github.com/hrapovd1/pmetrics/internal/dbstrorage"
+	"github.com/hrapovd1/pmetrics/internal/filestorage"
+	"github.com/hrapovd1/pmetrics/internal/sqlitestorage"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/tsdb"
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// ErrConflict возвращается, если задано больше одной базы хранения
+// истории: DATABASE_DSN и TSDB_DIR
+var ErrConflict = errors.New("more than one storage backend is configured: DATABASE_DSN and TSDB_DIR")
+
+// New создает хранилище метрик по конфигурации conf: значения
+// хранятся в памяти, при заданном STORE_FILE сбрасываются в файл, а
+// история сохраняется в одну базу - Postgres или SQLite по
+// DATABASE_DSN или встроенную базу в TSDB_DIR. SQLite заменяет файл
+func New(conf config.Config, logger *log.Logger) (types.Repository, error) {
+	if conf.DatabaseDSN != "" && conf.TSDBDir != "" {
+		return nil, ErrConflict
+	}
+	var (
+		repo types.Repository
+		err  error
+	)
+	switch {
+	case sqlitestorage.IsDSN(conf.DatabaseDSN):
+		repo, err = sqlitestorage.NewSQLiteStorage(conf.DatabaseDSN, logger, newMemStorage(conf))
+	case conf.DatabaseDSN != "":
+		repo, err = dbstorage.NewDBStorage(
+			conf.DatabaseDSN,
+			logger,
+			backStorage(conf),
+			dbstorage.WithBatch(conf.DBBatchSize, conf.DBFlushInterval),
+			dbstorage.WithQueue(conf.DBQueueSize),
+			dbstorage.WithPool(conf.DBMaxConns, conf.DBMaxIdleConns),
+		)
+	case conf.TSDBDir != "":
+		repo, err = tsdb.NewStorage(conf.TSDBDir, logger, backStorage(conf), tsdb.WithBlockDuration(conf.TSDBBlockDur), tsdb.WithSyncInterval(conf.TSDBSyncInt))
+	default:
+		repo = backStorage(conf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// backStorage создает промежуточное хранилище значений: файл при
+// заданном STORE_FILE, иначе память
+func backStorage(conf config.Config) types.Repository {
+	if conf.StoreFile != "" {
+		return filestorage.NewFileStorage(conf, make(map[string]interface{}))
+	}
+	return newMemStorage(conf)
+}
+
+// newMemStorage создает хранилище в памяти с историей по конфигурации
+func newMemStorage(conf config.Config) *storage.MemStorage {
+	return storage.NewMemStorage(storage.WithHistory(conf.HistoryDepth, conf.HistoryRetention))
+}
@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	dbstorage "github.com/hrapovd1/pmetrics/internal/dbstrorage"
+	"github.com/hrapovd1/pmetrics/internal/filestorage"
+	"github.com/hrapovd1/pmetrics/internal/sqlitestorage"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/tsdb"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	tmpDir := t.TempDir()
+	storeFile := filepath.Join(tmpDir, "metrics.json")
+	tests := []struct {
+		name string
+		conf config.Config
+		stor types.Repository
+	}{
+		{name: "mem only", conf: config.Config{}, stor: &storage.MemStorage{}},
+		{name: "file storage", conf: config.Config{StoreFile: storeFile}, stor: &filestorage.FileStorage{}},
+		{name: "db storage", conf: config.Config{StoreFile: storeFile, DatabaseDSN: "postgres"}, stor: &dbstorage.DBStorage{}},
+		{name: "tsdb storage", conf: config.Config{StoreFile: storeFile, TSDBDir: filepath.Join(tmpDir, "tsdb")}, stor: &tsdb.Storage{}},
+		{
+			name: "sqlite storage",
+			conf: config.Config{StoreFile: storeFile, DatabaseDSN: sqlitestorage.Scheme + filepath.Join(tmpDir, "metrics.db")},
+			stor: &sqlitestorage.SQLiteStorage{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, err := New(tt.conf, log.Default())
+			require.NoError(t, err)
+			assert.IsType(t, tt.stor, repo)
+			require.NoError(t, repo.(types.Storager).Close())
+		})
+	}
+}
+
+func TestNew_conflict(t *testing.T) {
+	for _, dsn := range []string{"postgres", sqlitestorage.Scheme + "metrics.db"} {
+		_, err := New(config.Config{DatabaseDSN: dsn, TSDBDir: t.TempDir()}, log.Default())
+		assert.ErrorIs(t, err, ErrConflict, dsn)
+	}
+}
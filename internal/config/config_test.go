@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -33,17 +34,37 @@ func TestNewAgentConf(t *testing.T) {
 				CryptoKey:      "",
 				Key:            "",
 				tagsDefault: map[string]bool{
-					"ADDRESS":         true,
-					"CONFIG":          true,
-					"CRYPTO_KEY":      true,
-					"KEY":             true,
-					"POLL_INTERVAL":   true,
-					"REPORT_INTERVAL": true,
-					"RESTORE":         true,
-					"STORE_FILE":      true,
-					"STORE_INTERVAL":  true,
-					"DATABASE_DSN":    true,
-					"TRUSTED_SUBNET":  true,
+					"ADDRESS":             true,
+					"CONFIG":              true,
+					"CRYPTO_KEY":          true,
+					"KEY":                 true,
+					"POLL_INTERVAL":       true,
+					"REPORT_INTERVAL":     true,
+					"RESTORE":             true,
+					"STORE_FILE":          true,
+					"STORE_INTERVAL":      true,
+					"DATABASE_DSN":        true,
+					"TRUSTED_SUBNET":      true,
+					"HTTP_ADDRESS":        true,
+					"STATSD_ADDRESS":      true,
+					"GRAPHITE_ADDRESS":    true,
+					"AGENT_ID":            true,
+					"HISTORY_DEPTH":       true,
+					"HISTORY_RETENTION":   true,
+					"ALERT_INTERVAL":      true,
+					"ALERT_OUTBOX":        true,
+					"ALERT_GROUP_WAIT":    true,
+					"ALERT_SILENCES":      true,
+					"ANOMALY_ZSCORE":      true,
+					"RETENTION_INTERVAL":  true,
+					"TSDB_DIR":            true,
+					"TSDB_BLOCK_DURATION": true,
+					"TSDB_SYNC_INTERVAL":  true,
+					"DB_BATCH_SIZE":       true,
+					"DB_FLUSH_INTERVAL":   true,
+					"DB_QUEUE_SIZE":       true,
+					"DB_MAX_CONNS":        true,
+					"DB_MAX_IDLE_CONNS":   true,
 				},
 			},
 		},
@@ -60,17 +81,37 @@ func TestNewAgentConf(t *testing.T) {
 				CryptoKey:      "",
 				Key:            "",
 				tagsDefault: map[string]bool{
-					"ADDRESS":         true,
-					"CONFIG":          true,
-					"CRYPTO_KEY":      true,
-					"KEY":             true,
-					"POLL_INTERVAL":   true,
-					"REPORT_INTERVAL": true,
-					"RESTORE":         true,
-					"STORE_FILE":      true,
-					"STORE_INTERVAL":  true,
-					"DATABASE_DSN":    true,
-					"TRUSTED_SUBNET":  true,
+					"ADDRESS":             true,
+					"CONFIG":              true,
+					"CRYPTO_KEY":          true,
+					"KEY":                 true,
+					"POLL_INTERVAL":       true,
+					"REPORT_INTERVAL":     true,
+					"RESTORE":             true,
+					"STORE_FILE":          true,
+					"STORE_INTERVAL":      true,
+					"DATABASE_DSN":        true,
+					"TRUSTED_SUBNET":      true,
+					"HTTP_ADDRESS":        true,
+					"STATSD_ADDRESS":      true,
+					"GRAPHITE_ADDRESS":    true,
+					"AGENT_ID":            true,
+					"HISTORY_DEPTH":       true,
+					"HISTORY_RETENTION":   true,
+					"ALERT_INTERVAL":      true,
+					"ALERT_OUTBOX":        true,
+					"ALERT_GROUP_WAIT":    true,
+					"ALERT_SILENCES":      true,
+					"ANOMALY_ZSCORE":      true,
+					"RETENTION_INTERVAL":  true,
+					"TSDB_DIR":            true,
+					"TSDB_BLOCK_DURATION": true,
+					"TSDB_SYNC_INTERVAL":  true,
+					"DB_BATCH_SIZE":       true,
+					"DB_FLUSH_INTERVAL":   true,
+					"DB_QUEUE_SIZE":       true,
+					"DB_MAX_CONNS":        true,
+					"DB_MAX_IDLE_CONNS":   true,
 				},
 			},
 		},
@@ -87,17 +128,37 @@ func TestNewAgentConf(t *testing.T) {
 				CryptoKey:      "",
 				Key:            "",
 				tagsDefault: map[string]bool{
-					"ADDRESS":         true,
-					"CONFIG":          false,
-					"CRYPTO_KEY":      true,
-					"KEY":             true,
-					"POLL_INTERVAL":   true,
-					"REPORT_INTERVAL": true,
-					"RESTORE":         true,
-					"STORE_FILE":      true,
-					"STORE_INTERVAL":  true,
-					"DATABASE_DSN":    true,
-					"TRUSTED_SUBNET":  true,
+					"ADDRESS":             true,
+					"CONFIG":              false,
+					"CRYPTO_KEY":          true,
+					"KEY":                 true,
+					"POLL_INTERVAL":       true,
+					"REPORT_INTERVAL":     true,
+					"RESTORE":             true,
+					"STORE_FILE":          true,
+					"STORE_INTERVAL":      true,
+					"DATABASE_DSN":        true,
+					"TRUSTED_SUBNET":      true,
+					"HTTP_ADDRESS":        true,
+					"STATSD_ADDRESS":      true,
+					"GRAPHITE_ADDRESS":    true,
+					"AGENT_ID":            true,
+					"HISTORY_DEPTH":       true,
+					"HISTORY_RETENTION":   true,
+					"ALERT_INTERVAL":      true,
+					"ALERT_OUTBOX":        true,
+					"ALERT_GROUP_WAIT":    true,
+					"ALERT_SILENCES":      true,
+					"ANOMALY_ZSCORE":      true,
+					"RETENTION_INTERVAL":  true,
+					"TSDB_DIR":            true,
+					"TSDB_BLOCK_DURATION": true,
+					"TSDB_SYNC_INTERVAL":  true,
+					"DB_BATCH_SIZE":       true,
+					"DB_FLUSH_INTERVAL":   true,
+					"DB_QUEUE_SIZE":       true,
+					"DB_MAX_CONNS":        true,
+					"DB_MAX_IDLE_CONNS":   true,
 				},
 			},
 		},
@@ -114,17 +175,37 @@ func TestNewAgentConf(t *testing.T) {
 				CryptoKey:      "",
 				Key:            "",
 				tagsDefault: map[string]bool{
-					"ADDRESS":         true,
-					"CONFIG":          true,
-					"CRYPTO_KEY":      true,
-					"KEY":             true,
-					"POLL_INTERVAL":   true,
-					"REPORT_INTERVAL": true,
-					"RESTORE":         true,
-					"STORE_FILE":      true,
-					"STORE_INTERVAL":  true,
-					"DATABASE_DSN":    true,
-					"TRUSTED_SUBNET":  true,
+					"ADDRESS":             true,
+					"CONFIG":              true,
+					"CRYPTO_KEY":          true,
+					"KEY":                 true,
+					"POLL_INTERVAL":       true,
+					"REPORT_INTERVAL":     true,
+					"RESTORE":             true,
+					"STORE_FILE":          true,
+					"STORE_INTERVAL":      true,
+					"DATABASE_DSN":        true,
+					"TRUSTED_SUBNET":      true,
+					"HTTP_ADDRESS":        true,
+					"STATSD_ADDRESS":      true,
+					"GRAPHITE_ADDRESS":    true,
+					"AGENT_ID":            true,
+					"HISTORY_DEPTH":       true,
+					"HISTORY_RETENTION":   true,
+					"ALERT_INTERVAL":      true,
+					"ALERT_OUTBOX":        true,
+					"ALERT_GROUP_WAIT":    true,
+					"ALERT_SILENCES":      true,
+					"ANOMALY_ZSCORE":      true,
+					"RETENTION_INTERVAL":  true,
+					"TSDB_DIR":            true,
+					"TSDB_BLOCK_DURATION": true,
+					"TSDB_SYNC_INTERVAL":  true,
+					"DB_BATCH_SIZE":       true,
+					"DB_FLUSH_INTERVAL":   true,
+					"DB_QUEUE_SIZE":       true,
+					"DB_MAX_CONNS":        true,
+					"DB_MAX_IDLE_CONNS":   true,
 				},
 			},
 		},
@@ -170,6 +251,42 @@ func TestNewAgentConf(t *testing.T) {
 	}
 }
 
+func TestNewAgentConf_agentID(t *testing.T) {
+	cfg, err := NewAgentConf(Flags{agentID: "flag-id"})
+	require.NoError(t, err)
+	assert.Equal(t, "flag-id", cfg.AgentID)
+
+	defer os.Unsetenv("AGENT_ID")
+	os.Setenv("AGENT_ID", "env-id")
+	cfg, err = NewAgentConf(Flags{agentID: "flag-id"})
+	require.NoError(t, err)
+	assert.Equal(t, "env-id", cfg.AgentID)
+}
+
+func TestNewServerConf_alertFiles(t *testing.T) {
+	cfg, err := NewServerConf(Flags{storeFile: "/var/lib/metrics/db.json"})
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/metrics/devops-metrics-alerts.json", cfg.AlertOutbox)
+	assert.Equal(t, "/var/lib/metrics/devops-metrics-silences.json", cfg.AlertSilences)
+
+	cfg, err = NewServerConf(Flags{tsdbDir: "/var/lib/tsdb", alertSilences: "/etc/silences.json"})
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/tsdb/devops-metrics-alerts.json", cfg.AlertOutbox)
+	assert.Equal(t, "/etc/silences.json", cfg.AlertSilences)
+}
+
+func TestNewServerConf_migrate(t *testing.T) {
+	for _, migrate := range []string{"", "up", "down", "0", "2"} {
+		cfg, err := NewServerConf(Flags{migrate: migrate})
+		require.NoError(t, err, migrate)
+		assert.Equal(t, migrate, cfg.Migrate)
+	}
+	for _, migrate := range []string{"sideways", "-1", "v2"} {
+		_, err := NewServerConf(Flags{migrate: migrate})
+		assert.Error(t, err, migrate)
+	}
+}
+
 func TestNewServerConf(t *testing.T) {
 	tmpFile, _ := os.CreateTemp("", "*conf.json")
 	defer os.Remove(tmpFile.Name())
@@ -182,26 +299,61 @@ func TestNewServerConf(t *testing.T) {
 		{
 			name: "Server config",
 			fields: Config{
-				ServerAddress:  "localhost:8080",
-				ReportInterval: 10 * time.Second,
-				StoreInterval:  300 * time.Second,
-				StoreFile:      "/tmp/devops-metrics-db.json",
-				IsRestore:      false,
-				Key:            "",
-				CryptoKey:      "",
-				DatabaseDSN:    "",
+				ServerAddress:    "localhost:8080",
+				HTTPAddress:      "localhost:8081",
+				ReportInterval:   10 * time.Second,
+				StoreInterval:    300 * time.Second,
+				StoreFile:        "/tmp/devops-metrics-db.json",
+				IsRestore:        false,
+				Key:              "",
+				CryptoKey:        "",
+				DatabaseDSN:      "",
+				HistoryDepth:     720,
+				HistoryRetention: time.Hour,
+				AlertInterval:    15 * time.Second,
+				AlertOutbox:      "/tmp/devops-metrics-alerts.json",
+				AlertGroupWait:   30 * time.Second,
+				AlertSilences:    "/tmp/devops-metrics-silences.json",
+				DBBatchSize:      500,
+				DBFlushInterval:  time.Second,
+				DBQueueSize:      10000,
+				DBMaxConns:       10,
+				DBMaxIdleConns:   5,
+				RetentionInt:     10 * time.Minute,
+				TSDBBlockDur:     2 * time.Hour,
+				TSDBSyncInt:      time.Second,
 				tagsDefault: map[string]bool{
-					"ADDRESS":         true,
-					"CONFIG":          true,
-					"KEY":             true,
-					"CRYPTO_KEY":      true,
-					"POLL_INTERVAL":   true,
-					"REPORT_INTERVAL": true,
-					"RESTORE":         true,
-					"STORE_FILE":      true,
-					"STORE_INTERVAL":  true,
-					"DATABASE_DSN":    true,
-					"TRUSTED_SUBNET":  true,
+					"ADDRESS":             true,
+					"CONFIG":              true,
+					"KEY":                 true,
+					"CRYPTO_KEY":          true,
+					"POLL_INTERVAL":       true,
+					"REPORT_INTERVAL":     true,
+					"RESTORE":             true,
+					"STORE_FILE":          true,
+					"STORE_INTERVAL":      true,
+					"DATABASE_DSN":        true,
+					"TRUSTED_SUBNET":      true,
+					"HTTP_ADDRESS":        true,
+					"STATSD_ADDRESS":      true,
+					"GRAPHITE_ADDRESS":    true,
+					"AGENT_ID":            true,
+					"HISTORY_DEPTH":       true,
+					"HISTORY_RETENTION":   true,
+					"ALERT_INTERVAL":      true,
+					"ALERT_OUTBOX":        true,
+					"ALERT_GROUP_WAIT":    true,
+					"ALERT_SILENCES":      true,
+					"ANOMALY_ZSCORE":      true,
+					"RETENTION_INTERVAL":  true,
+					"TSDB_DIR":            true,
+					"TSDB_BLOCK_DURATION": true,
+					"TSDB_SYNC_INTERVAL":  true,
+					"DB_BATCH_SIZE":       true,
+					"DB_FLUSH_INTERVAL":   true,
+					"DB_QUEUE_SIZE":       true,
+					"DB_MAX_CONNS":        true,
+					"DB_MAX_IDLE_CONNS":   true,
 				},
 			},
 		},
@@ -218,17 +370,37 @@ func TestNewServerConf(t *testing.T) {
 				CryptoKey:      "",
 				Key:            "",
 				tagsDefault: map[string]bool{
-					"ADDRESS":         true,
-					"CONFIG":          true,
-					"CRYPTO_KEY":      true,
-					"KEY":             true,
-					"POLL_INTERVAL":   true,
-					"REPORT_INTERVAL": true,
-					"RESTORE":         true,
-					"STORE_FILE":      true,
-					"STORE_INTERVAL":  true,
-					"DATABASE_DSN":    true,
-					"TRUSTED_SUBNET":  true,
+					"ADDRESS":             true,
+					"CONFIG":              true,
+					"CRYPTO_KEY":          true,
+					"KEY":                 true,
+					"POLL_INTERVAL":       true,
+					"REPORT_INTERVAL":     true,
+					"RESTORE":             true,
+					"STORE_FILE":          true,
+					"STORE_INTERVAL":      true,
+					"DATABASE_DSN":        true,
+					"TRUSTED_SUBNET":      true,
+					"HTTP_ADDRESS":        true,
+					"STATSD_ADDRESS":      true,
+					"GRAPHITE_ADDRESS":    true,
+					"AGENT_ID":            true,
+					"HISTORY_DEPTH":       true,
+					"HISTORY_RETENTION":   true,
+					"ALERT_INTERVAL":      true,
+					"ALERT_OUTBOX":        true,
+					"ALERT_GROUP_WAIT":    true,
+					"ALERT_SILENCES":      true,
+					"ANOMALY_ZSCORE":      true,
+					"RETENTION_INTERVAL":  true,
+					"TSDB_DIR":            true,
+					"TSDB_BLOCK_DURATION": true,
+					"TSDB_SYNC_INTERVAL":  true,
+					"DB_BATCH_SIZE":       true,
+					"DB_FLUSH_INTERVAL":   true,
+					"DB_QUEUE_SIZE":       true,
+					"DB_MAX_CONNS":        true,
+					"DB_MAX_IDLE_CONNS":   true,
 				},
 			},
 		},
@@ -245,17 +417,37 @@ func TestNewServerConf(t *testing.T) {
 				CryptoKey:      "",
 				Key:            "",
 				tagsDefault: map[string]bool{
-					"ADDRESS":         true,
-					"CONFIG":          false,
-					"CRYPTO_KEY":      true,
-					"KEY":             true,
-					"POLL_INTERVAL":   true,
-					"REPORT_INTERVAL": true,
-					"RESTORE":         true,
-					"STORE_FILE":      true,
-					"STORE_INTERVAL":  true,
-					"DATABASE_DSN":    true,
-					"TRUSTED_SUBNET":  true,
+					"ADDRESS":             true,
+					"CONFIG":              false,
+					"CRYPTO_KEY":          true,
+					"KEY":                 true,
+					"POLL_INTERVAL":       true,
+					"REPORT_INTERVAL":     true,
+					"RESTORE":             true,
+					"STORE_FILE":          true,
+					"STORE_INTERVAL":      true,
+					"DATABASE_DSN":        true,
+					"TRUSTED_SUBNET":      true,
+					"HTTP_ADDRESS":        true,
+					"STATSD_ADDRESS":      true,
+					"GRAPHITE_ADDRESS":    true,
+					"AGENT_ID":            true,
+					"HISTORY_DEPTH":       true,
+					"HISTORY_RETENTION":   true,
+					"ALERT_INTERVAL":      true,
+					"ALERT_OUTBOX":        true,
+					"ALERT_GROUP_WAIT":    true,
+					"ALERT_SILENCES":      true,
+					"ANOMALY_ZSCORE":      true,
+					"RETENTION_INTERVAL":  true,
+					"TSDB_DIR":            true,
+					"TSDB_BLOCK_DURATION": true,
+					"TSDB_SYNC_INTERVAL":  true,
+					"DB_BATCH_SIZE":       true,
+					"DB_FLUSH_INTERVAL":   true,
+					"DB_QUEUE_SIZE":       true,
+					"DB_MAX_CONNS":        true,
+					"DB_MAX_IDLE_CONNS":   true,
 				},
 			},
 		},
@@ -272,17 +464,37 @@ func TestNewServerConf(t *testing.T) {
 				CryptoKey:      "",
 				Key:            "",
 				tagsDefault: map[string]bool{
-					"ADDRESS":         true,
-					"CONFIG":          true,
-					"CRYPTO_KEY":      true,
-					"KEY":             true,
-					"POLL_INTERVAL":   true,
-					"REPORT_INTERVAL": true,
-					"RESTORE":         true,
-					"STORE_FILE":      true,
-					"STORE_INTERVAL":  true,
-					"DATABASE_DSN":    true,
-					"TRUSTED_SUBNET":  true,
+					"ADDRESS":             true,
+					"CONFIG":              true,
+					"CRYPTO_KEY":          true,
+					"KEY":                 true,
+					"POLL_INTERVAL":       true,
+					"REPORT_INTERVAL":     true,
+					"RESTORE":             true,
+					"STORE_FILE":          true,
+					"STORE_INTERVAL":      true,
+					"DATABASE_DSN":        true,
+					"TRUSTED_SUBNET":      true,
+					"HTTP_ADDRESS":        true,
+					"STATSD_ADDRESS":      true,
+					"GRAPHITE_ADDRESS":    true,
+					"AGENT_ID":            true,
+					"HISTORY_DEPTH":       true,
+					"HISTORY_RETENTION":   true,
+					"ALERT_INTERVAL":      true,
+					"ALERT_OUTBOX":        true,
+					"ALERT_GROUP_WAIT":    true,
+					"ALERT_SILENCES":      true,
+					"ANOMALY_ZSCORE":      true,
+					"RETENTION_INTERVAL":  true,
+					"TSDB_DIR":            true,
+					"TSDB_BLOCK_DURATION": true,
+					"TSDB_SYNC_INTERVAL":  true,
+					"DB_BATCH_SIZE":       true,
+					"DB_FLUSH_INTERVAL":   true,
+					"DB_QUEUE_SIZE":       true,
+					"DB_MAX_CONNS":        true,
+					"DB_MAX_IDLE_CONNS":   true,
 				},
 			},
 		},
@@ -356,8 +568,20 @@ func Test_parseInterval(t *testing.T) {
 			wantErr:  false,
 		},
 		{
-			name:     "wrong format",
+			name:     "test 2 hours",
 			interval: "2h",
+			want:     2 * time.Hour,
+			wantErr:  false,
+		},
+		{
+			name:     "test 7 days",
+			interval: "7d",
+			want:     7 * 24 * time.Hour,
+			wantErr:  false,
+		},
+		{
+			name:     "wrong format",
+			interval: "2w",
 			want:     2 * time.Minute,
 			wantErr:  true,
 		},
@@ -392,7 +616,7 @@ func Test_getRawJSONConfig(t *testing.T) {
 		dataSize int
 		positive bool
 	}{
-		{name: "small file", dataSize: 2000, positive: true},
+		{name: "small file", dataSize: maxConfigFileSize, positive: true},
 		{name: "big file", dataSize: 1, positive: false},
 	}
 
@@ -426,7 +650,18 @@ func TestConfig_setConfigFromFile(t *testing.T) {
     "address": "localhost:8080",
     "restore": true,
     "store_interval": "1s",
-    "store_file": "/path/to/file.db"
+    "store_file": "/path/to/file.db",
+    "graphite_mapping": [{"match": "servers.*.cpu", "name": "cpu", "labels": {"host": "$1"}}],
+    "alert_interval": "30s",
+    "alert_rules": [{"name": "LowMemory", "metric": "FreeMemory", "op": "<", "threshold": 1e8, "for": "5m", "severity": "critical"}],
+    "alert_webhooks": ["http://localhost:9093/hook"],
+    "alert_group_wait": "1m",
+    "anomaly_zscore": 3.5,
+    "anomaly": {"alpha": 0.2, "season": "1440m", "buckets": 24, "interval": "30s"},
+    "db_batch_size": 1000,
+    "db_flush_interval": "2s",
+    "retention": [{"match": "*", "raw": "7d", "rollups": [{"resolution": "5m", "keep": "90d"}, {"resolution": "1h", "keep": "730d"}]}],
+    "retention_interval": "1h"
 	}`)
 	require.NoError(t, err)
 
@@ -435,6 +670,36 @@ func TestConfig_setConfigFromFile(t *testing.T) {
 		IsRestore:     true,
 		StoreInterval: time.Second * 1,
 		StoreFile:     "/path/to/file.db",
+		GraphiteRules: []GraphiteRule{{Match: "servers.*.cpu", Name: "cpu", Labels: map[string]string{"host": "$1"}}},
+		AlertInterval: 30 * time.Second,
+		AlertRules: []AlertRule{{
+			Name:      "LowMemory",
+			Metric:    "FreeMemory",
+			Op:        "<",
+			Threshold: 1e8,
+			For:       5 * time.Minute,
+			Severity:  "critical",
+		}},
+		AlertWebhooks:  []string{"http://localhost:9093/hook"},
+		AlertGroupWait: time.Minute,
+		AnomalyZScore:  3.5,
+		Anomaly: AnomalyOptions{
+			Alpha:    0.2,
+			Season:   24 * time.Hour,
+			Buckets:  24,
+			Interval: 30 * time.Second,
+		},
+		DBBatchSize:     1000,
+		DBFlushInterval: 2 * time.Second,
+		Retention: []Retention{{
+			Match: "*",
+			Raw:   7 * 24 * time.Hour,
+			Rollups: []Rollup{
+				{Resolution: 5 * time.Minute, Keep: 90 * 24 * time.Hour},
+				{Resolution: time.Hour, Keep: 730 * 24 * time.Hour},
+			},
+		}},
+		RetentionInt: time.Hour,
 	}
 
 	t.Run("good", func(t *testing.T) {
@@ -446,6 +711,15 @@ func TestConfig_setConfigFromFile(t *testing.T) {
 		conf := Config{}
 		require.Error(t, conf.setConfigFromFile("/tmp/ke79685"))
 	})
+	t.Run("bad rule for", func(t *testing.T) {
+		var rule AlertRule
+		require.Error(t, json.Unmarshal([]byte(`{"name": "LowMemory", "for": "5"}`), &rule))
+	})
+	t.Run("bad retention", func(t *testing.T) {
+		var retention Retention
+		require.Error(t, json.Unmarshal([]byte(`{"match": "*", "raw": "7"}`), &retention))
+		require.Error(t, json.Unmarshal([]byte(`{"match": "*", "raw": "7d", "rollups": [{"resolution": "5", "keep": "1d"}]}`), &retention))
+	})
 }
 
 func TestConfig_UnmarshalJSON(t *testing.T) {
@@ -480,6 +754,26 @@ func TestConfig_UnmarshalJSON(t *testing.T) {
 			positive: false,
 			data:     []byte(`{"poll_interval": "2s", "report_interval": "5s", "store_interval": "7"}`),
 		},
+		{
+			name:     "wrong history_retention",
+			positive: false,
+			data:     []byte(`{"poll_interval": "2s", "report_interval": "5s", "store_interval": "7s", "history_retention": "1"}`),
+		},
+		{
+			name:     "wrong anomaly season",
+			positive: false,
+			data:     []byte(`{"poll_interval": "2s", "report_interval": "5s", "store_interval": "7s", "anomaly": {"season": "1w"}}`),
+		},
+		{
+			name:     "wrong alert_group_wait",
+			positive: false,
+			data:     []byte(`{"poll_interval": "2s", "report_interval": "5s", "store_interval": "7s", "alert_group_wait": "1"}`),
+		},
+		{
+			name:     "wrong db_flush_interval",
+			positive: false,
+			data:     []byte(`{"poll_interval": "2s", "report_interval": "5s", "store_interval": "7s", "db_flush_interval": "100ms"}`),
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
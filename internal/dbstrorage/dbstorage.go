@@ -5,52 +5,133 @@ package dbstorage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hrapovd1/pmetrics/internal/migrations"
 	"github.com/hrapovd1/pmetrics/internal/types"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// DBStorage тип для хранения метрик в базу
+// Значения параметров записи по умолчанию
+const (
+	defaultBatchSize     = 500
+	defaultFlushInterval = time.Second
+	defaultQueueSize     = 10000
+)
+
+// DBStorage тип для хранения метрик в базу, значения всех метрик
+// хранятся в таблицах временных рядов и их значений, таблицы
+// создаются миграциями модуля migrations до запуска сервера.
+// Значения записываются в базу асинхронно пакетами через очередь
+// после проверки версии схемы базы
 type DBStorage struct {
-	dbConnect  *sql.DB
-	logger     *log.Logger
-	backStor   types.Repository
-	tableNames map[string]struct{}
+	dbConnect     *sql.DB
+	logger        *log.Logger
+	backStor      types.Repository
+	mu            sync.Mutex
+	orm           *gorm.DB
+	schemaChecked bool             // версия схемы базы проверена
+	series        map[string]int64 // идентификаторы временных рядов по ключу
+	batchSize     int
+	flushInterval time.Duration
+	queue         chan record
+	queueMu       sync.RWMutex
+	dropped       atomic.Int64 // значения, отброшенные при заполненной очереди
+	backlog       atomic.Int64 // значения пакета, ожидающего повтора записи
+	closed        bool
+	stop          chan struct{} // закрывается вместе с очередью
+	flushed       chan struct{}
+}
+
+// Option тип функции для настройки DBStorage
+type Option func(ds *DBStorage)
+
+// WithBatch задает наибольшее число значений в одном INSERT и
+// интервал записи неполного пакета, нулевые значения не меняют
+// значений по умолчанию
+func WithBatch(size int, interval time.Duration) Option {
+	return func(ds *DBStorage) {
+		if size > 0 {
+			ds.batchSize = size
+		}
+		if interval > 0 {
+			ds.flushInterval = interval
+		}
+	}
+}
+
+// WithQueue задает размер очереди записи, при заполненной очереди
+// значения не записываются в базу, а Throttle возвращает ErrQueueFull
+func WithQueue(size int) Option {
+	return func(ds *DBStorage) {
+		if size > 0 {
+			ds.queue = make(chan record, size)
+		}
+	}
+}
+
+// WithPool задает наибольшее число открытых и простаивающих
+// подключений к базе
+func WithPool(maxConns, maxIdleConns int) Option {
+	return func(ds *DBStorage) {
+		if maxConns > 0 {
+			ds.dbConnect.SetMaxOpenConns(maxConns)
+		}
+		if maxIdleConns > 0 {
+			ds.dbConnect.SetMaxIdleConns(maxIdleConns)
+		}
+	}
 }
 
-// NewDBStorage возвращает тип DBStorage по полученному конфигу
-func NewDBStorage(dsn string, logger *log.Logger, backStor types.Repository) (*DBStorage, error) {
+// NewDBStorage возвращает тип DBStorage по полученному конфигу и
+// запускает запись очереди значений в базу, остановка записи - Close
+func NewDBStorage(dsn string, logger *log.Logger, backStor types.Repository, opts ...Option) (*DBStorage, error) {
 	db := DBStorage{
-		logger:     logger,
-		backStor:   backStor,
-		tableNames: make(map[string]struct{}),
+		logger:        logger,
+		backStor:      backStor,
+		series:        make(map[string]int64),
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		queue:         make(chan record, defaultQueueSize),
+		flushed:       make(chan struct{}),
+		stop:          make(chan struct{}),
 	}
 	dbConnect, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
 	db.dbConnect = dbConnect
-	return &db, err
+	for _, opt := range opts {
+		opt(&db)
+	}
+	go db.flushing()
+	return &db, nil
 }
 
 // Append сохраняет новое значение типа counter с дозаписью к старому
 func (ds *DBStorage) Append(ctx context.Context, key string, value int64) {
 	ds.backStor.Append(ctx, key, value)
-	metric := types.MetricModel{
-		ID:    key,
-		Mtype: "counter",
-		Delta: sql.NullInt64{
-			Int64: ds.backStor.Get(ctx, key).(int64),
-			Valid: true,
-		},
+	if rec, ok := ds.valueRecord(ctx, key, "counter"); ok {
+		ds.enqueue(rec)
 	}
-	if err := ds.store(ctx, &metric); err != nil {
-		if ds.logger != nil {
-			ds.logger.Println(err)
-		}
+}
+
+// AppendTotal дописывает к значению counter приращение до накопленного
+// значения total, см. storage.MemStorage.AppendTotal
+func (ds *DBStorage) AppendTotal(ctx context.Context, key string, total int64) int64 {
+	delta := ds.backStor.AppendTotal(ctx, key, total)
+	if rec, ok := ds.valueRecord(ctx, key, "counter"); ok {
+		ds.enqueue(rec)
 	}
+	return delta
 }
 
 // Get возвращает значение метрики переданной через key
@@ -66,50 +147,98 @@ func (ds *DBStorage) GetAll(ctx context.Context) map[string]interface{} {
 // Rewrite перезаписывает значение метрики типа gauge
 func (ds *DBStorage) Rewrite(ctx context.Context, key string, value float64) {
 	ds.backStor.Rewrite(ctx, key, value)
-	metric := types.MetricModel{
-		ID:    key,
-		Mtype: "gauge",
-		Value: sql.NullFloat64{Float64: value, Valid: true},
+	if rec, ok := ds.valueRecord(ctx, key, "gauge"); ok {
+		ds.enqueue(rec)
 	}
-	if err := ds.store(ctx, &metric); err != nil {
-		if ds.logger != nil {
-			ds.logger.Println(err)
-		}
+}
+
+// AppendHistogram сохраняет значение типа histogram со сложением с текущим
+func (ds *DBStorage) AppendHistogram(ctx context.Context, key string, value types.Histogram) {
+	ds.backStor.AppendHistogram(ctx, key, value)
+	if rec, ok := ds.valueRecord(ctx, key, "histogram"); ok {
+		ds.enqueue(rec)
 	}
 }
 
+// RewriteSummary перезаписывает значение метрики типа summary
+func (ds *DBStorage) RewriteSummary(ctx context.Context, key string, value types.Summary) {
+	ds.backStor.RewriteSummary(ctx, key, value)
+	if rec, ok := ds.valueRecord(ctx, key, "summary"); ok {
+		ds.enqueue(rec)
+	}
+}
+
+// Range возвращает историю значений counter и gauge временного ряда
+// из базы, если база недоступна, то историю
+// промежуточного хранилища, если оно ее хранит
+func (ds *DBStorage) Range(ctx context.Context, key string, from, to time.Time) []types.Sample {
+	samples, err := ds.rangeDB(ctx, key, from, to)
+	if err == nil {
+		return samples
+	}
+	if ds.logger != nil {
+		ds.logger.Println(err)
+	}
+	stor, ok := ds.backStor.(types.Ranger)
+	if !ok {
+		return nil
+	}
+	return stor.Range(ctx, key, from, to)
+}
+
+// Series возвращает ключи рядов метрики name с метками, см. types.SeriesLister
+func (ds *DBStorage) Series(ctx context.Context, name string) []string {
+	stor, ok := ds.backStor.(types.SeriesLister)
+	if !ok {
+		return nil
+	}
+	return stor.Series(ctx, name)
+}
+
 // StoreAll сохраняет все полученные метрики через слайс metrics
 func (ds *DBStorage) StoreAll(ctx context.Context, metrics *[]types.Metric) {
-	metricsDB := make([]types.MetricModel, 0)
+	records := make([]record, 0, len(*metrics))
 	for _, m := range *metrics {
-		metricDB := types.MetricModel{ID: m.ID, Mtype: m.MType}
+		key := m.Key()
 		switch m.MType {
 		case "counter":
-			ds.backStor.Append(ctx, m.ID, *m.Delta)
-			metricDB.Delta = sql.NullInt64{
-				Int64: ds.backStor.Get(ctx, m.ID).(int64),
-				Valid: true,
-			}
+			ds.backStor.Append(ctx, key, *m.Delta)
 		case "gauge":
-			ds.backStor.Rewrite(ctx, m.ID, *m.Value)
-			metricDB.Value = sql.NullFloat64{Float64: *m.Value, Valid: true}
+			ds.backStor.Rewrite(ctx, key, *m.Value)
+		case "histogram":
+			if m.Histogram == nil || m.Histogram.Validate() != nil {
+				continue
+			}
+			ds.backStor.AppendHistogram(ctx, key, *m.Histogram)
+		case "summary":
+			if m.Summary == nil || m.Summary.Validate() != nil {
+				continue
+			}
+			ds.backStor.RewriteSummary(ctx, key, *m.Summary)
+		default:
+			continue
 		}
-		metricsDB = append(metricsDB, metricDB)
-	}
-	if ds.dbConnect != nil {
-		if err := ds.storeBatch(ctx, &metricsDB); err != nil {
-			ds.logger.Print(err)
+		if rec, ok := ds.valueRecord(ctx, key, m.MType); ok {
+			records = append(records, rec)
 		}
 	}
+	ds.enqueue(records...)
 }
 
-// Storing запускается в отдельной go routine для сохранения метрик в файл
+// Storing запускается в отдельной go routine для сохранения метрик в файл,
+// при restore сначала восстанавливает значения метрик, см. Restore
 func (ds *DBStorage) Storing(ctx context.Context, w *sync.WaitGroup, logger *log.Logger, interval time.Duration, restore bool) {
+	if restore {
+		if err := ds.Restore(ctx); err != nil {
+			logger.Printf("ds.Restore err: %v", err)
+		}
+	}
 	stor := ds.backStor.(types.Storager)
-	stor.Storing(ctx, w, logger, interval, restore)
+	stor.Storing(ctx, w, logger, interval, false)
 }
 
-// Close закрывает подключение к БД, необходимо запускать в defer
+// Close записывает в базу значения из очереди и закрывает
+// подключение к БД, необходимо запускать в defer
 func (ds *DBStorage) Close() error {
 	stor := ds.backStor.(types.Storager)
 	defer func() {
@@ -118,6 +247,14 @@ func (ds *DBStorage) Close() error {
 		}
 	}()
 	ds.logger.Print("call DBStorage.Close()")
+	ds.queueMu.Lock()
+	if !ds.closed {
+		ds.closed = true
+		close(ds.queue)
+		close(ds.stop)
+	}
+	ds.queueMu.Unlock()
+	<-ds.flushed
 	return ds.dbConnect.Close()
 }
 
@@ -134,65 +271,243 @@ func (ds *DBStorage) Ping(ctx context.Context) bool {
 	return true
 }
 
-// Restore восстанавливае значение метрик при запуске из файла
-func (ds *DBStorage) Restore(ctx context.Context) error {
-	stor := ds.backStor.(types.Storager)
-	return stor.Restore(ctx)
+// record значение временного ряда для записи в базу
+type record struct {
+	key    string
+	mtype  string
+	sample types.SampleModel
 }
 
-// store внутренняя функция сохранения метрики в базу
-func (ds *DBStorage) store(ctx context.Context, metric *types.MetricModel) error {
-	db, err := gorm.Open(postgres.New(postgres.Config{Conn: ds.dbConnect}), &gorm.Config{})
-	if err != nil {
-		return err
+// newRecord возвращает запись значения временного ряда key
+// с текущим временем
+func newRecord(key, mType string) record {
+	return record{
+		key:    key,
+		mtype:  mType,
+		sample: types.SampleModel{Timestamp: time.Now().UnixNano()},
 	}
-	tableName := strings.ToLower(types.DBtablePrefix + metric.ID)
-	select {
-	case <-ctx.Done():
-		return nil
+}
+
+// valueRecord возвращает запись текущего значения ряда key из
+// промежуточного хранилища, если оно имеет тип mType: значение другого
+// типа промежуточное хранилище не перезаписывает
+func (ds *DBStorage) valueRecord(ctx context.Context, key, mType string) (record, bool) {
+	value := ds.backStor.Get(ctx, key)
+	if types.ValueType(value) != mType {
+		return record{}, false
+	}
+	rec := newRecord(key, mType)
+	switch value := value.(type) {
+	case int64:
+		rec.sample.Delta = sql.NullInt64{Int64: value, Valid: true}
+	case float64:
+		rec.sample.Value = sql.NullFloat64{Float64: value, Valid: true}
 	default:
-		if _, ok := ds.tableNames[tableName]; !ok {
-			if !db.Migrator().HasTable(tableName) {
-				if err := db.Table(tableName).Migrator().CreateTable(&types.MetricModel{}); err != nil {
-					return err
-				}
-			}
-			ds.tableNames[tableName] = struct{}{}
-		}
-		db.Table(tableName).Create(metric)
-		return nil
+		rec.sample.Data = jsonData(value)
 	}
+	return rec, true
 }
 
-// storeBatch внутренняя функция сохранения нескольких метрик в базу
-func (ds *DBStorage) storeBatch(ctx context.Context, metrics *[]types.MetricModel) error {
+// storeBatch внутренняя функция сохранения нескольких метрик в базу,
+// значения сохраняются многострочными INSERT не больше batchSize строк
+// в транзакции, повторная запись значения ряда с тем же временем
+// заменяет предыдущее
+func (ds *DBStorage) storeBatch(ctx context.Context, records []record) error {
 	select {
 	case <-ctx.Done():
 		return nil
 	default:
-		db, err := gorm.Open(postgres.New(postgres.Config{Conn: ds.dbConnect}), &gorm.Config{})
+		if len(records) == 0 {
+			return nil
+		}
+		if err := ds.checkSchema(ctx); err != nil {
+			return err
+		}
+		db, err := ds.gormDB(ctx)
 		if err != nil {
 			return err
 		}
 
-		if err := db.Transaction(func(tx *gorm.DB) error {
-			for _, metric := range *metrics {
-				tableName := strings.ToLower(types.DBtablePrefix + metric.ID)
-				if _, ok := ds.tableNames[tableName]; !ok {
-					if !db.Migrator().HasTable(tableName) {
-						if err := tx.Table(tableName).Migrator().CreateTable(&types.MetricModel{}); err != nil {
-							return err
-						}
-					}
-					ds.tableNames[tableName] = struct{}{}
-				}
-				tx.Table(tableName).Create(&metric)
+		// ряды создаются вне транзакции, чтобы откат не оставил
+		// в кэше идентификаторы несуществующих рядов
+		samples := make([]types.SampleModel, 0, len(records))
+		for _, rec := range records {
+			id, err := ds.seriesID(db, rec.key, rec.mtype)
+			if err != nil {
+				return err
 			}
-			return nil
+			rec.sample.SeriesID = id
+			samples = append(samples, rec.sample)
+		}
+		samples = uniqueSamples(samples)
+		return db.Transaction(func(tx *gorm.DB) error {
+			return tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&samples, ds.batchSize).Error
+		})
+	}
+}
 
-		}); err != nil {
-			return err
+// rangeDB внутренняя функция чтения истории временного ряда из базы
+func (ds *DBStorage) rangeDB(ctx context.Context, key string, from, to time.Time) ([]types.Sample, error) {
+	if ds.dbConnect == nil {
+		return nil, errors.New("database isn't connected")
+	}
+	db, err := ds.gormDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	series := newSeriesModel(key, "")
+	err = db.Where("name = ? AND labels = ?", series.Name, series.Labels).Take(&series).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return []types.Sample{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]types.SampleModel, 0)
+	if err := db.
+		Where("series_id = ? AND timestamp BETWEEN ? AND ?", series.ID, from.UnixNano(), to.UnixNano()).
+		Order("timestamp").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	samples := toSamples(rows)
+	// значения старше хранимых исходных берутся из агрегатов
+	end := to.UnixNano() + 1
+	if len(samples) > 0 {
+		end = samples[0].Timestamp.UnixNano()
+	}
+	older, err := rangeRollups(db, series, from.UnixNano(), end)
+	if err != nil {
+		return nil, err
+	}
+	return append(older, samples...), nil
+}
+
+// toSamples возвращает числовые значения строк таблицы значений,
+// значение counter - накопленная сумма
+func toSamples(rows []types.SampleModel) []types.Sample {
+	samples := make([]types.Sample, 0, len(rows))
+	for _, row := range rows {
+		sample := types.Sample{Timestamp: time.Unix(0, row.Timestamp)}
+		switch {
+		case row.Delta.Valid:
+			sample.Value = float64(row.Delta.Int64)
+		case row.Value.Valid:
+			sample.Value = row.Value.Float64
+		default:
+			continue
 		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// uniqueSamples оставляет последнее из значений ряда с одинаковым
+// временем, INSERT ... ON CONFLICT не может изменить строку дважды
+func uniqueSamples(samples []types.SampleModel) []types.SampleModel {
+	index := make(map[[2]int64]int, len(samples))
+	out := samples[:0]
+	for _, sample := range samples {
+		pk := [2]int64{sample.SeriesID, sample.Timestamp}
+		if i, ok := index[pk]; ok {
+			out[i] = sample
+			continue
+		}
+		index[pk] = len(out)
+		out = append(out, sample)
+	}
+	return out
+}
+
+// checkSchema проверяет версию схемы базы до первой записи: если база
+// была недоступна при запуске сервера, ее схема не проверена и таблицы
+// могут отсутствовать, проверка повторяется до успешной; к схеме
+// старее последней миграции применяются непримененные миграции
+func (ds *DBStorage) checkSchema(ctx context.Context) error {
+	if ds.dbConnect == nil {
+		return errors.New("database isn't connected")
+	}
+	ds.mu.Lock()
+	checked := ds.schemaChecked
+	ds.mu.Unlock()
+	if checked {
 		return nil
 	}
+	migrator, err := migrations.New(ds.dbConnect)
+	if err != nil {
+		return err
+	}
+	err = migrator.Check(ctx)
+	if errors.Is(err, migrations.ErrSchemaOlder) {
+		err = migrator.Up(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("database schema isn't checked: %w", err)
+	}
+	ds.mu.Lock()
+	ds.schemaChecked = true
+	ds.mu.Unlock()
+	return nil
+}
+
+// gormDB возвращает сессию gorm для подключения к базе, gorm
+// проверяет подключение при создании, поэтому при недоступной базе
+// создание повторяется при следующем вызове
+func (ds *DBStorage) gormDB(ctx context.Context) (*gorm.DB, error) {
+	if ds.dbConnect == nil {
+		return nil, errors.New("database isn't connected")
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.orm == nil {
+		orm, err := gorm.Open(postgres.New(postgres.Config{Conn: ds.dbConnect}), &gorm.Config{})
+		if err != nil {
+			return nil, err
+		}
+		ds.orm = orm
+	}
+	return ds.orm.WithContext(ctx), nil
+}
+
+// seriesID возвращает идентификатор временного ряда key,
+// создавая ряд при первой записи
+func (ds *DBStorage) seriesID(db *gorm.DB, key, mType string) (int64, error) {
+	ds.mu.Lock()
+	id, ok := ds.series[key]
+	ds.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	series := newSeriesModel(key, mType)
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&series).Error; err != nil {
+		return 0, err
+	}
+	if series.ID == 0 {
+		// ряд уже создан, например другим экземпляром сервера
+		if err := db.Where("name = ? AND labels = ?", series.Name, series.Labels).Take(&series).Error; err != nil {
+			return 0, err
+		}
+	}
+	ds.mu.Lock()
+	ds.series[key] = series.ID
+	ds.mu.Unlock()
+	return series.ID, nil
+}
+
+// newSeriesModel возвращает модель временного ряда для ключа key
+func newSeriesModel(key, mType string) types.SeriesModel {
+	name, labels, err := types.ParseSeriesKey(key)
+	if err != nil {
+		return types.SeriesModel{Name: key, Mtype: mType}
+	}
+	return types.SeriesModel{Name: name, Labels: types.LabelsString(labels), Mtype: mType}
+}
+
+// jsonData возвращает значение histogram или summary для колонки data
+func jsonData(value interface{}) sql.NullString {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
 }
@@ -0,0 +1,159 @@
+// Часть модуля dbstorage содержит очередь асинхронной записи значений
+// метрик в базу.
+package dbstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// flushTimeout наибольшее время записи одного пакета в базу
+const flushTimeout = 30 * time.Second
+
+// maxRetryBackoff наибольшая пауза между повторами записи пакета
+const maxRetryBackoff = time.Minute
+
+// ErrQueueFull очередь записи в базу заполнена
+var ErrQueueFull = errors.New("database write queue is full")
+
+// ErrBacklog пакет значений не записан в базу и ожидает повтора
+var ErrBacklog = errors.New("database write is failing, values wait for retry")
+
+// Throttle возвращает ErrBacklog, если пакет значений ожидает повтора
+// записи в базу, и ErrQueueFull, если очередь записи заполнена и новые
+// значения будут отброшены
+func (ds *DBStorage) Throttle() error {
+	if n := ds.backlog.Load(); n > 0 {
+		return fmt.Errorf("%w: %d values", ErrBacklog, n)
+	}
+	if ds.queue != nil && len(ds.queue) >= cap(ds.queue) {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// enqueue добавляет значения в очередь записи в базу без ожидания:
+// при заполненной очереди значения отбрасываются и учитываются в
+// dropped, текущие значения остаются в промежуточном хранилище; после
+// Close значения не записываются
+func (ds *DBStorage) enqueue(records ...record) {
+	ds.queueMu.RLock()
+	defer ds.queueMu.RUnlock()
+	if ds.closed {
+		ds.logger.Printf("database storage is closed, %d values dropped", len(records))
+		return
+	}
+	for i, rec := range records {
+		select {
+		case ds.queue <- rec:
+		default:
+			ds.dropped.Add(int64(len(records) - i))
+			return
+		}
+	}
+}
+
+// reportDropped пишет в журнал число значений, отброшенных при
+// заполненной очереди с прошлого вызова
+func (ds *DBStorage) reportDropped() {
+	if n := ds.dropped.Swap(0); n > 0 {
+		ds.logger.Printf("%d values dropped: %v", n, ErrQueueFull)
+	}
+}
+
+// nextBackoff возвращает паузу до следующего повтора записи пакета:
+// первая пауза равна interval, следующие удваиваются до maxRetryBackoff
+func nextBackoff(backoff, interval time.Duration) time.Duration {
+	if backoff == 0 {
+		return interval
+	}
+	backoff *= 2
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
+
+// flushing записывает значения из очереди в базу пакетами по batchSize
+// значений или по истечении flushInterval. Неудачно записанный пакет
+// не отбрасывается: запись повторяется с растущей паузой, пока очередь
+// не читается и Throttle сообщает об ожидающих значениях. Завершается
+// после закрытия очереди и последней попытки записи оставшихся значений
+func (ds *DBStorage) flushing() {
+	defer close(ds.flushed)
+	ticker := time.NewTicker(ds.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]record, 0, ds.batchSize)
+	var backoff time.Duration // пауза до повтора записи пакета
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+		if err := ds.storeBatch(ctx, batch); err != nil {
+			backoff = nextBackoff(backoff, ds.flushInterval)
+			ds.backlog.Store(int64(len(batch)))
+			ds.logger.Printf("%d values aren't stored, retry in %v: %v", len(batch), backoff, err)
+			return
+		}
+		backoff = 0
+		ds.backlog.Store(0)
+		batch = batch[:0]
+	}
+	// drain при закрытии записывает пакет и оставшиеся в очереди
+	// значения без повторов, незаписанные значения отбрасываются
+	drain := func() {
+		for {
+			flush()
+			if backoff > 0 {
+				ds.logger.Printf("%d values dropped: database storage is closed", len(batch))
+				batch = batch[:0]
+				backoff = 0
+				ds.backlog.Store(0)
+			}
+			for rec := range ds.queue {
+				batch = append(batch, rec)
+				if len(batch) >= ds.batchSize {
+					break
+				}
+			}
+			if len(batch) == 0 {
+				ds.reportDropped()
+				return
+			}
+		}
+	}
+	for {
+		if backoff > 0 {
+			retry := time.NewTimer(backoff)
+			select {
+			case <-retry.C:
+				flush()
+			case <-ds.stop:
+				retry.Stop()
+				drain()
+				return
+			}
+			ds.reportDropped()
+			continue
+		}
+		select {
+		case rec, ok := <-ds.queue:
+			if !ok {
+				drain()
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) >= ds.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			ds.reportDropped()
+		}
+	}
+}
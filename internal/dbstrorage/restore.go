@@ -0,0 +1,172 @@
+// Часть модуля dbstorage содержит восстановление значений метрик
+// из базы при запуске сервера.
+package dbstorage
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// maxLoggedKeys количество ключей расходящихся рядов в логе
+const maxLoggedKeys = 10
+
+// lastSample последнее значение временного ряда в базе
+type lastSample struct {
+	types.SampleModel
+	Name   string
+	Labels string
+	Mtype  string
+}
+
+// Restore восстанавливает значения метрик при запуске: сначала
+// промежуточное хранилище, например из файла, затем последние значения
+// рядов из базы. Если файл содержит значения, они сверяются с базой:
+// counter и histogram восстанавливаются по большему значению, gauge и
+// summary по значению базы, которое обновляется при каждой записи,
+// расходящиеся ряды записываются в лог
+func (ds *DBStorage) Restore(ctx context.Context) error {
+	stor := ds.backStor.(types.Storager)
+	if err := stor.Restore(ctx); err != nil && ds.logger != nil {
+		ds.logger.Printf("restore from backing storage: %v", err)
+	}
+	values, err := ds.lastValues(ctx)
+	if err != nil {
+		return err
+	}
+	diverged := restoreValues(ctx, ds.backStor, values)
+	if ds.logger == nil {
+		return nil
+	}
+	ds.logger.Printf("restored %d series from database", len(values))
+	if len(diverged) > 0 {
+		logged := diverged
+		if len(logged) > maxLoggedKeys {
+			logged = logged[:maxLoggedKeys]
+		}
+		ds.logger.Printf("%d series differ in database and file snapshot: %s", len(diverged), strings.Join(logged, ", "))
+	}
+	return nil
+}
+
+// lastValues возвращает последние значения всех рядов базы по ключу
+// временного ряда, значение counter в базе - накопленная сумма
+func (ds *DBStorage) lastValues(ctx context.Context) (map[string]interface{}, error) {
+	db, err := ds.gormDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]lastSample, 0)
+	if err := db.Raw(
+		`SELECT DISTINCT ON (sm.series_id) s.name, s.labels, s.mtype, sm.*
+		FROM ` + types.DBSamplesTable + ` sm JOIN ` + types.DBSeriesTable + ` s ON s.id = sm.series_id
+		ORDER BY sm.series_id, sm."timestamp" DESC`,
+	).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{}, len(rows))
+	for _, row := range rows {
+		key := row.Name
+		if row.Labels != "" {
+			key += "{" + row.Labels + "}"
+		}
+		if value, ok := sampleValue(row.Mtype, row.SampleModel); ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// sampleValue возвращает значение строки таблицы значений в виде,
+// в котором его хранит Repository
+func sampleValue(mType string, sample types.SampleModel) (interface{}, bool) {
+	switch mType {
+	case "counter":
+		return sample.Delta.Int64, sample.Delta.Valid
+	case "gauge":
+		return sample.Value.Float64, sample.Value.Valid
+	case "histogram":
+		var h types.Histogram
+		if !sample.Data.Valid || json.Unmarshal([]byte(sample.Data.String), &h) != nil || h.Validate() != nil {
+			return nil, false
+		}
+		return h, true
+	case "summary":
+		var s types.Summary
+		if !sample.Data.Valid || json.Unmarshal([]byte(sample.Data.String), &s) != nil || s.Validate() != nil {
+			return nil, false
+		}
+		return s, true
+	}
+	return nil, false
+}
+
+// restoreValues записывает значения базы values в хранилище repo,
+// если repo уже содержит значения, возвращает отсортированные ключи
+// рядов, значения которых в repo и базе различаются
+func restoreValues(ctx context.Context, repo types.Repository, values map[string]interface{}) []string {
+	current := repo.GetAll(ctx)
+	diverged := make([]string, 0)
+	for key, value := range values {
+		cur, ok := current[key]
+		if ok && reflect.DeepEqual(cur, value) {
+			continue
+		}
+		if ok {
+			diverged = append(diverged, key)
+			if reflect.TypeOf(cur) != reflect.TypeOf(value) {
+				continue
+			}
+		}
+		switch v := value.(type) {
+		case int64:
+			if c, _ := cur.(int64); v > c {
+				repo.Append(ctx, key, v-c)
+			}
+		case float64:
+			repo.Rewrite(ctx, key, v)
+		case types.Histogram:
+			h, _ := cur.(types.Histogram)
+			if increase, ok := histogramIncrease(h, v); ok {
+				repo.AppendHistogram(ctx, key, increase)
+			}
+		case types.Summary:
+			repo.RewriteSummary(ctx, key, v)
+		}
+	}
+	if len(current) == 0 {
+		return nil
+	}
+	for key := range current {
+		if _, ok := values[key]; !ok {
+			diverged = append(diverged, key)
+		}
+	}
+	sort.Strings(diverged)
+	return diverged
+}
+
+// histogramIncrease возвращает гистограмму, сложение с которой
+// переводит from в to; для гистограмм с различными границами это to,
+// false - to содержит не больше значений, чем from
+func histogramIncrease(from, to types.Histogram) (types.Histogram, bool) {
+	if to.Count <= from.Count {
+		return types.Histogram{}, false
+	}
+	if !reflect.DeepEqual(from.Bounds, to.Bounds) || len(from.Counts) != len(to.Counts) {
+		return to, true
+	}
+	out := types.NewHistogram(to.Bounds)
+	for i := range to.Counts {
+		if to.Counts[i] < from.Counts[i] {
+			return types.Histogram{}, false
+		}
+		out.Counts[i] = to.Counts[i] - from.Counts[i]
+	}
+	out.Sum, out.Count = to.Sum-from.Sum, to.Count-from.Count
+	return out, true
+}
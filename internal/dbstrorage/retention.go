@@ -0,0 +1,195 @@
+// Часть модуля dbstorage содержит сжатие истории рядов базы по
+// политикам хранения.
+package dbstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/retention"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Compact сжимает историю рядов базы по политикам хранения: значения
+// рядов с политикой агрегируются в завершенные к now интервалы, затем
+// удаляются значения, кроме последнего, и агрегаты старше их времени
+// хранения. Значения histogram и summary не агрегируются, только удаляются
+func (ds *DBStorage) Compact(ctx context.Context, policies retention.Policies, now time.Time) error {
+	db, err := ds.gormDB(ctx)
+	if err != nil {
+		return err
+	}
+	series := make([]types.SeriesModel, 0)
+	if err := db.Find(&series).Error; err != nil {
+		return err
+	}
+	compacted := 0
+	for _, s := range series {
+		policy, ok := policies.Match(s.Name)
+		if !ok {
+			continue
+		}
+		if err := ds.compactSeries(db, s, policy, now); err != nil {
+			return fmt.Errorf("series %d %s: %w", s.ID, s.Name, err)
+		}
+		compacted++
+	}
+	ds.logger.Printf("compacted history of %d series", compacted)
+	return nil
+}
+
+// compactSeries записывает агрегаты ряда series и удаляет устаревшие
+// значения, агрегаты записываются до удаления исходных значений;
+// последнее значение ряда не удаляется: по нему восстанавливается
+// значение ряда, который не обновлялся дольше времени хранения
+func (ds *DBStorage) compactSeries(db *gorm.DB, series types.SeriesModel, policy config.Retention, now time.Time) error {
+	// значения из очереди записи еще могут попасть в последний интервал
+	complete := now.Add(-ds.flushInterval)
+	for _, rollup := range policy.Rollups {
+		if err := ds.rollupSeries(db, series, rollup.Resolution, complete); err != nil {
+			return err
+		}
+	}
+	var latest sql.NullInt64
+	if err := db.Model(&types.SampleModel{}).
+		Select(`MAX("timestamp")`).
+		Where("series_id = ?", series.ID).
+		Row().Scan(&latest); err != nil {
+		return err
+	}
+	if err := db.
+		Where("series_id = ? AND timestamp < ?", series.ID, rawCutoff(now.Add(-policy.Raw).UnixNano(), latest)).
+		Delete(&types.SampleModel{}).Error; err != nil {
+		return err
+	}
+	for _, rollup := range policy.Rollups {
+		if err := db.
+			Where("series_id = ? AND resolution = ? AND timestamp < ?",
+				series.ID, int64(rollup.Resolution/time.Second), now.Add(-rollup.Keep).UnixNano()).
+			Delete(&types.RollupModel{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawCutoff возвращает время, значения ряда до которого удаляются:
+// cutoff, но не позже времени последнего значения ряда latest
+func rawCutoff(cutoff int64, latest sql.NullInt64) int64 {
+	if latest.Valid && latest.Int64 < cutoff {
+		return latest.Int64
+	}
+	return cutoff
+}
+
+// rollupSeries записывает агрегаты ряда series за интервалы resolution,
+// завершенные к complete и следующие за последним записанным
+func (ds *DBStorage) rollupSeries(db *gorm.DB, series types.SeriesModel, resolution time.Duration, complete time.Time) error {
+	seconds := int64(resolution / time.Second)
+	var last sql.NullInt64
+	if err := db.Model(&types.RollupModel{}).
+		Select(`MAX("timestamp")`).
+		Where("series_id = ? AND resolution = ?", series.ID, seconds).
+		Row().Scan(&last); err != nil {
+		return err
+	}
+	from := int64(0)
+	if last.Valid {
+		from = last.Int64 + int64(resolution)
+	}
+	to := retention.BucketStart(complete, resolution).UnixNano()
+	if from >= to {
+		return nil
+	}
+	rows := make([]types.SampleModel, 0)
+	if err := db.
+		Where("series_id = ? AND timestamp >= ? AND timestamp < ?", series.ID, from, to).
+		Order("timestamp").
+		Find(&rows).Error; err != nil {
+		return err
+	}
+	samples := toSamples(rows)
+	if len(samples) == 0 {
+		return nil
+	}
+	counter := series.Mtype == "counter"
+	// прирост первого интервала считается от предыдущего значения
+	var prev *types.Sample
+	if counter {
+		prevRows := make([]types.SampleModel, 0, 1)
+		if err := db.
+			Where("series_id = ? AND timestamp < ?", series.ID, from).
+			Order("timestamp DESC").
+			Limit(1).
+			Find(&prevRows).Error; err != nil {
+			return err
+		}
+		if p := toSamples(prevRows); len(p) > 0 {
+			prev = &p[0]
+		}
+	}
+	buckets := retention.Downsample(samples, prev, resolution)
+	models := make([]types.RollupModel, 0, len(buckets))
+	for _, b := range buckets {
+		models = append(models, types.RollupModel{
+			SeriesID:   series.ID,
+			Resolution: seconds,
+			Timestamp:  b.Start.UnixNano(),
+			Min:        b.Min,
+			Max:        b.Max,
+			Sum:        b.Sum,
+			Count:      b.Count,
+			Increase:   sql.NullFloat64{Float64: b.Increase, Valid: counter},
+		})
+	}
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&models, ds.batchSize).Error
+}
+
+// rangeRollups возвращает агрегаты ряда series в интервале [from, to)
+// наносекунд: сначала меньшего интервала, более старые - большего;
+// значение counter - наибольшая накопленная сумма, gauge - среднее
+func rangeRollups(db *gorm.DB, series types.SeriesModel, from, to int64) ([]types.Sample, error) {
+	resolutions := make([]int64, 0)
+	if err := db.Raw(
+		"SELECT DISTINCT resolution FROM "+types.DBRollupsTable+" WHERE series_id = ? ORDER BY resolution",
+		series.ID,
+	).Scan(&resolutions).Error; err != nil {
+		return nil, err
+	}
+	samples := make([]types.Sample, 0)
+	for _, resolution := range resolutions {
+		rows := make([]types.RollupModel, 0)
+		if err := db.
+			Where("series_id = ? AND resolution = ? AND timestamp >= ? AND timestamp < ?", series.ID, resolution, from, to).
+			Order("timestamp").
+			Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		samples = append(rollupSamples(rows, series.Mtype), samples...)
+		to = rows[0].Timestamp
+	}
+	return samples, nil
+}
+
+// rollupSamples возвращает значения агрегатов ряда типа mType
+func rollupSamples(rows []types.RollupModel, mType string) []types.Sample {
+	samples := make([]types.Sample, 0, len(rows))
+	for _, row := range rows {
+		sample := types.Sample{Timestamp: time.Unix(0, row.Timestamp)}
+		if mType == "counter" {
+			sample.Value = row.Max
+		} else {
+			sample.Value = row.Sum / float64(row.Count)
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
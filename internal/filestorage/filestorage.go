@@ -29,6 +29,7 @@ func NewFileStorage(conf config.Config, buff map[string]interface{}) *FileStorag
 	fs := &FileStorage{
 		ms: storage.NewMemStorage(
 			storage.WithBuffer(buff),
+			storage.WithHistory(conf.HistoryDepth, conf.HistoryRetention),
 		),
 	}
 	var err error
@@ -51,6 +52,12 @@ func (fs *FileStorage) Append(ctx context.Context, key string, value int64) {
 	fs.ms.Append(ctx, key, value)
 }
 
+// AppendTotal дописывает к значению counter приращение до накопленного
+// значения total, см. storage.MemStorage.AppendTotal
+func (fs *FileStorage) AppendTotal(ctx context.Context, key string, total int64) int64 {
+	return fs.ms.AppendTotal(ctx, key, total)
+}
+
 // Get возвращает значение метрики переданной через key
 func (fs *FileStorage) Get(ctx context.Context, key string) interface{} {
 	return fs.ms.Get(ctx, key)
@@ -66,6 +73,32 @@ func (fs *FileStorage) Rewrite(ctx context.Context, key string, value float64) {
 	fs.ms.Rewrite(ctx, key, value)
 }
 
+// AppendHistogram сохраняет значение типа histogram со сложением с текущим
+func (fs *FileStorage) AppendHistogram(ctx context.Context, key string, value types.Histogram) {
+	fs.ms.AppendHistogram(ctx, key, value)
+}
+
+// RewriteSummary перезаписывает значение метрики типа summary
+func (fs *FileStorage) RewriteSummary(ctx context.Context, key string, value types.Summary) {
+	fs.ms.RewriteSummary(ctx, key, value)
+}
+
+// Range возвращает историю значений временного ряда в интервале [from, to]
+func (fs *FileStorage) Range(ctx context.Context, key string, from, to time.Time) []types.Sample {
+	return fs.ms.Range(ctx, key, from, to)
+}
+
+// Series возвращает ключи рядов метрики name с метками
+func (fs *FileStorage) Series(ctx context.Context, name string) []string {
+	return fs.ms.Series(ctx, name)
+}
+
+// Rename переносит значение ряда from в еще не сохраненный ряд to,
+// см. storage.MemStorage.Rename
+func (fs *FileStorage) Rename(ctx context.Context, from, to string) bool {
+	return fs.ms.Rename(ctx, from, to)
+}
+
 // StoreAll сохраняет все полученные метрики через слайс metrics
 func (fs *FileStorage) StoreAll(ctx context.Context, metrics *[]types.Metric) {
 	fs.ms.StoreAll(ctx, metrics)
@@ -115,6 +148,9 @@ func (fs *FileStorage) Store(ctx context.Context) error {
 		buff := fs.ms.GetAll(ctx)
 		for k, v := range buff {
 			metric := types.Metric{ID: k}
+			if name, labels, err := types.ParseSeriesKey(k); err == nil {
+				metric.ID, metric.Labels = name, labels
+			}
 			switch val := v.(type) {
 			case int64:
 				metric.MType = "counter"
@@ -122,6 +158,12 @@ func (fs *FileStorage) Store(ctx context.Context) error {
 			case float64:
 				metric.MType = "gauge"
 				metric.Value = &val
+			case types.Histogram:
+				metric.MType = "histogram"
+				metric.Histogram = &val
+			case types.Summary:
+				metric.MType = "summary"
+				metric.Summary = &val
 			}
 			metrics = append(metrics, metric)
 		}
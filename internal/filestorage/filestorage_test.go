@@ -121,6 +121,38 @@ func TestFileStorage_Store(t *testing.T) {
 	assert.Equal(t, want, string(result[:n]))
 }
 
+func TestFileStorage_StoreLabels(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "devops*.json")
+	defer os.Remove(tmpFile.Name())
+	data := map[string]interface{}{
+		`M1{agent="a1"}`: int64(4),
+	}
+	fs := FileStorage{
+		file:   tmpFile,
+		writer: bufio.NewWriter(tmpFile),
+		ms:     storage.NewMemStorage(storage.WithBuffer(data)),
+	}
+	result := make([]byte, 300)
+	want := "[{\"id\":\"M1\",\"type\":\"counter\",\"delta\":4,\"labels\":{\"agent\":\"a1\"}}]\n"
+	ctx := context.Background()
+	require.NoError(t, fs.Store(ctx))
+	n, err := tmpFile.ReadAt(result, 0)
+	if err != io.EOF {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, want, string(result[:n]))
+
+	// восстановление возвращает метрику под тем же ключом
+	restored := FileStorage{
+		file: tmpFile,
+		ms:   storage.NewMemStorage(),
+	}
+	_, err = tmpFile.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(ctx))
+	assert.Equal(t, data, restored.GetAll(ctx))
+}
+
 func TestFileStorage_Get(t *testing.T) {
 	tmpFile, _ := os.CreateTemp("", "devops*.json")
 	defer os.Remove(tmpFile.Name())
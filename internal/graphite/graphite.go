@@ -0,0 +1,261 @@
+// Модуль graphite содержит сервер приема метрик по протоколу
+// Graphite carbon plaintext через TCP с сохранением в types.Repository.
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/hrapovd1/pmetrics/internal/usecase"
+)
+
+// reportInterval интервал записи в журнал числа отброшенных строк
+const reportInterval = 10 * time.Second
+
+// Server тип сервера приема метрик по протоколу Graphite plaintext
+type Server struct {
+	addr    string
+	repo    types.Repository
+	logger  *log.Logger
+	mapper  *Mapper
+	dropped atomic.Int64 // строки, отброшенные при перегрузке хранилища
+}
+
+// Line разобранная строка протокола: путь, метки, значение
+// и временная метка в секундах
+type Line struct {
+	Path      string
+	Tags      map[string]string
+	Value     float64
+	Timestamp int64
+}
+
+// NewServer создает сервер Graphite, слушающий addr по TCP
+func NewServer(addr string, repo types.Repository, logger *log.Logger, mapper *Mapper) *Server {
+	return &Server{
+		addr:   addr,
+		repo:   repo,
+		logger: logger,
+		mapper: mapper,
+	}
+}
+
+// Run запускается в отдельной go routine, принимает метрики до отмены контекста
+func (srv *Server) Run(ctx context.Context, w *sync.WaitGroup) {
+	defer w.Done()
+
+	listen, err := net.Listen("tcp", srv.addr)
+	if err != nil {
+		srv.logger.Printf("graphite: when listen tcp got error: %v\n", err)
+		return
+	}
+	srv.logger.Println("Graphite server start on ", srv.addr)
+
+	go func() {
+		tick := time.NewTicker(reportInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := listen.Close(); err != nil {
+					srv.logger.Println(err)
+				}
+				return
+			case <-tick.C:
+				srv.reportDropped()
+			}
+		}
+	}()
+
+	conns := sync.WaitGroup{}
+	defer srv.reportDropped()
+	defer conns.Wait()
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			srv.logger.Printf("graphite: when accept tcp got error: %v\n", err)
+			continue
+		}
+		conns.Add(1)
+		go func(c net.Conn) {
+			defer conns.Done()
+			done := make(chan struct{})
+			defer close(done)
+			// соединение закрывается при остановке сервера
+			go func() {
+				select {
+				case <-ctx.Done():
+				case <-done:
+				}
+				if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+					srv.logger.Println(err)
+				}
+			}()
+			scan := bufio.NewScanner(c)
+			for scan.Scan() {
+				srv.handleLine(ctx, scan.Text())
+			}
+		}(conn)
+	}
+}
+
+// handleLine разбирает строку и сохраняет значение как gauge, пока
+// хранилище не успевает сохранять значения, строки отбрасываются
+func (srv *Server) handleLine(ctx context.Context, raw string) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return
+	}
+	if usecase.Throttle(srv.repo) != nil {
+		srv.dropped.Add(1)
+		return
+	}
+	line, err := ParseLine(raw)
+	if err != nil {
+		srv.logger.Printf("graphite: %v\n", err)
+		return
+	}
+	if math.IsNaN(line.Value) {
+		return
+	}
+	srv.repo.Rewrite(ctx, srv.mapper.MetricID(line.Path, line.Tags), line.Value)
+}
+
+// reportDropped пишет в журнал число строк, отброшенных при
+// перегрузке хранилища с прошлого вызова
+func (srv *Server) reportDropped() {
+	if n := srv.dropped.Swap(0); n > 0 {
+		srv.logger.Printf("graphite: %d lines dropped: storage is overloaded\n", n)
+	}
+}
+
+// ParseLine разбирает строку формата "path value [timestamp]",
+// путь может содержать метки в формате path;tag1=value1;tag2=value2
+func ParseLine(raw string) (Line, error) {
+	line := Line{Timestamp: -1}
+	fields := strings.Fields(raw)
+	if len(fields) < 2 || len(fields) > 3 {
+		return line, fmt.Errorf("bad line %q: want path, value and timestamp", raw)
+	}
+	pathParts := strings.Split(fields[0], ";")
+	line.Path = pathParts[0]
+	if line.Path == "" {
+		return line, fmt.Errorf("bad line %q: empty path", raw)
+	}
+	for _, tag := range pathParts[1:] {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return line, fmt.Errorf("bad line %q: bad tag %q", raw, tag)
+		}
+		if line.Tags == nil {
+			line.Tags = make(map[string]string)
+		}
+		line.Tags[kv[0]] = kv[1]
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return line, fmt.Errorf("bad line %q: %w", raw, err)
+	}
+	line.Value = value
+	if len(fields) == 3 {
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return line, fmt.Errorf("bad line %q: %w", raw, err)
+		}
+		line.Timestamp = int64(ts)
+	}
+	return line, nil
+}
+
+// Mapper преобразует пути Graphite в идентификаторы метрик
+type Mapper struct {
+	rules []mappingRule
+}
+
+// mappingRule разобранное правило преобразования
+type mappingRule struct {
+	segments []string
+	name     string
+	labels   map[string]string
+}
+
+// NewMapper создает Mapper по правилам из конфигурации
+func NewMapper(rules []config.GraphiteRule) (*Mapper, error) {
+	mapper := &Mapper{rules: make([]mappingRule, 0, len(rules))}
+	for _, rule := range rules {
+		if rule.Match == "" || rule.Name == "" {
+			return nil, fmt.Errorf("graphite mapping %+v: match and name are required", rule)
+		}
+		mapper.rules = append(mapper.rules, mappingRule{
+			segments: strings.Split(rule.Match, "."),
+			name:     rule.Name,
+			labels:   rule.Labels,
+		})
+	}
+	return mapper, nil
+}
+
+// MetricID возвращает идентификатор метрики для пути и меток Graphite,
+// применяется первое совпавшее правило, без совпадений путь
+// используется как имя метрики
+func (m *Mapper) MetricID(path string, tags map[string]string) string {
+	segments := strings.Split(path, ".")
+	for _, rule := range m.rules {
+		captures, ok := rule.match(segments)
+		if !ok {
+			continue
+		}
+		labels := make(map[string]string, len(tags)+len(rule.labels))
+		for k, v := range tags {
+			labels[k] = v
+		}
+		for k, v := range rule.labels {
+			labels[k] = expand(v, captures)
+		}
+		return types.SeriesKey(expand(rule.name, captures), labels)
+	}
+	return types.SeriesKey(path, tags)
+}
+
+// match проверяет путь на соответствие правилу и возвращает
+// значения сегментов, совпавших с '*'
+func (r mappingRule) match(segments []string) ([]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	captures := make([]string, 0)
+	for i, pattern := range r.segments {
+		switch pattern {
+		case "*":
+			captures = append(captures, segments[i])
+		case segments[i]:
+		default:
+			return nil, false
+		}
+	}
+	return captures, true
+}
+
+// expand подставляет значения сегментов вместо $1, $2 и т.д.,
+// подстановка идет с конца чтобы $1 не заменял начало $10
+func expand(template string, captures []string) string {
+	for i := len(captures); i > 0; i-- {
+		template = strings.ReplaceAll(template, "$"+strconv.Itoa(i), captures[i-1])
+	}
+	return template
+}
@@ -0,0 +1,143 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    Line
+		wantErr bool
+	}{
+		{
+			name: "full line",
+			raw:  "servers.web01.cpu.load 0.75 1465839830",
+			want: Line{Path: "servers.web01.cpu.load", Value: 0.75, Timestamp: 1465839830},
+		},
+		{
+			name: "without timestamp",
+			raw:  "backup.duration 120",
+			want: Line{Path: "backup.duration", Value: 120, Timestamp: -1},
+		},
+		{
+			name: "with tags",
+			raw:  "disk.used;host=web01;mount=/var 42 1465839830",
+			want: Line{Path: "disk.used", Tags: map[string]string{"host": "web01", "mount": "/var"}, Value: 42, Timestamp: 1465839830},
+		},
+		{name: "no value", raw: "backup.duration", wantErr: true},
+		{name: "bad value", raw: "backup.duration abc 1", wantErr: true},
+		{name: "bad timestamp", raw: "backup.duration 1 abc", wantErr: true},
+		{name: "bad tag", raw: "backup.duration;host 1 1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := ParseLine(tt.raw)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, line)
+		})
+	}
+}
+
+func TestMapper_MetricID(t *testing.T) {
+	mapper, err := NewMapper([]config.GraphiteRule{
+		{Match: "servers.*.cpu.*", Name: "cpu_$2", Labels: map[string]string{"host": "$1"}},
+		{Match: "backup.duration", Name: "BackupDuration"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		path string
+		tags map[string]string
+		want string
+	}{
+		{path: "servers.web01.cpu.load", want: `cpu_load{host="web01"}`},
+		{path: "servers.web01.cpu.load", tags: map[string]string{"dc": "eu"}, want: `cpu_load{dc="eu",host="web01"}`},
+		{path: "backup.duration", want: "BackupDuration"},
+		{path: "servers.web01.mem", want: "servers.web01.mem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, mapper.MetricID(tt.path, tt.tags))
+		})
+	}
+
+	_, err = NewMapper([]config.GraphiteRule{{Match: "a.*"}})
+	require.Error(t, err)
+}
+
+func TestServer_Run(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listen.Addr().String()
+	require.NoError(t, listen.Close())
+
+	mapper, err := NewMapper([]config.GraphiteRule{{Match: "backup.duration", Name: "BackupDuration"}})
+	require.NoError(t, err)
+	locStorage := storage.NewMemStorage()
+	srv := NewServer(addr, locStorage, log.Default(), mapper)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go srv.Run(ctx, wg)
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		_, err = fmt.Fprint(conn, "backup.duration 120 1465839830\nbad line\nother.metric 1.5\n")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return locStorage.Get(context.Background(), "other.metric") != nil
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, float64(120), locStorage.Get(context.Background(), "BackupDuration"))
+	assert.Equal(t, 1.5, locStorage.Get(context.Background(), "other.metric"))
+}
+
+// throttledStorage хранилище, которое не успевает сохранять значения
+type throttledStorage struct {
+	types.Repository
+}
+
+func (ts throttledStorage) Throttle() error { return errors.New("queue is full") }
+
+func TestServer_handleLine_throttled(t *testing.T) {
+	ctx := context.Background()
+	stor := map[string]interface{}{}
+	logs := &bytes.Buffer{}
+	mapper, err := NewMapper(nil)
+	require.NoError(t, err)
+	srv := NewServer("", throttledStorage{storage.NewMemStorage(storage.WithBuffer(stor))}, log.New(logs, "", 0), mapper)
+
+	// при перегрузке хранилища строки отбрасываются без ожидания
+	srv.handleLine(ctx, "backup.duration 120")
+	assert.Empty(t, stor)
+	srv.reportDropped()
+	assert.Contains(t, logs.String(), "graphite: 1 lines dropped")
+}
@@ -5,16 +5,20 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/hrapovd1/pmetrics/internal/agents"
+	"github.com/hrapovd1/pmetrics/internal/alerts"
+	"github.com/hrapovd1/pmetrics/internal/backend"
 	"github.com/hrapovd1/pmetrics/internal/config"
-	dbstorage "github.com/hrapovd1/pmetrics/internal/dbstrorage"
-	"github.com/hrapovd1/pmetrics/internal/filestorage"
+	"github.com/hrapovd1/pmetrics/internal/query"
 	"github.com/hrapovd1/pmetrics/internal/storage"
 	"github.com/hrapovd1/pmetrics/internal/types"
 	"github.com/hrapovd1/pmetrics/internal/usecase"
@@ -31,52 +35,74 @@ const (
 // MetricsHandler тип обработчиков API
 // содержит конфигурацию и хранилище
 type MetricsHandler struct {
-	Storage types.Repository
-	Config  config.Config
-	logger  *log.Logger
+	Storage  types.Repository
+	Agents   *agents.Registry
+	Alerts   *alerts.Manager
+	Silences *alerts.SilenceStore
+	Config   config.Config
+	logger   *log.Logger
 }
 
-// NewMetricsHandler возвращает обработчик API
-func NewMetricsHandler(conf config.Config, logger *log.Logger) *MetricsHandler {
+// Option тип для модификации обработчика MetricsHandler
+type Option func(mh *MetricsHandler) *MetricsHandler
+
+// NewMetricsHandler возвращает обработчик API, если хранилище
+// не передано через опции, то оно создается по конфигурации
+func NewMetricsHandler(conf config.Config, logger *log.Logger, opts ...Option) *MetricsHandler {
 	mh := &MetricsHandler{Config: conf, logger: logger}
-	var fs *filestorage.FileStorage
-	// Have mem, fs and db storage
-	if mh.Config.StoreFile != "" && mh.Config.DatabaseDSN != "" {
-		db, err := dbstorage.NewDBStorage(
-			conf.DatabaseDSN,
-			logger,
-			filestorage.NewFileStorage(conf, make(map[string]interface{})),
-		)
-		if err != nil {
-			logger.Fatal(err)
-		}
-		mh.Storage = db
-	}
-	// Have mem and db storage
-	if mh.Config.DatabaseDSN != "" && mh.Config.StoreFile == "" {
-		db, err := dbstorage.NewDBStorage(
-			conf.DatabaseDSN,
-			logger,
-			storage.NewMemStorage(),
-		)
-		if err != nil {
-			logger.Fatal(err)
-		}
-		mh.Storage = db
+	for _, opt := range opts {
+		mh = opt(mh)
 	}
-	// Have mem and fs storage
-	if mh.Config.StoreFile != "" && mh.Config.DatabaseDSN == "" {
-		fs = filestorage.NewFileStorage(conf, make(map[string]interface{}))
-		mh.Storage = fs
+	if mh.Agents == nil {
+		mh.Agents = agents.NewRegistry()
 	}
-	// Have mem storage
-	if mh.Config.DatabaseDSN == "" && mh.Config.StoreFile == "" {
-		ms := storage.NewMemStorage()
-		mh.Storage = ms
+	if mh.Storage != nil {
+		return mh
 	}
+	repo, err := backend.New(conf, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	mh.Storage = repo
 	return mh
 }
 
+// WithStorage модифицирует MetricsHandler позволяя использовать
+// общее с grpc сервером хранилище
+func WithStorage(repo types.Repository) Option {
+	return func(mh *MetricsHandler) *MetricsHandler {
+		mh.Storage = repo
+		return mh
+	}
+}
+
+// WithAgents модифицирует MetricsHandler позволяя использовать
+// общий с grpc сервером реестр агентов
+func WithAgents(registry *agents.Registry) Option {
+	return func(mh *MetricsHandler) *MetricsHandler {
+		mh.Agents = registry
+		return mh
+	}
+}
+
+// WithAlerts модифицирует MetricsHandler позволяя показывать
+// оповещения менеджера правил
+func WithAlerts(manager *alerts.Manager) Option {
+	return func(mh *MetricsHandler) *MetricsHandler {
+		mh.Alerts = manager
+		return mh
+	}
+}
+
+// WithSilences модифицирует MetricsHandler позволяя управлять
+// тишиной оповещений
+func WithSilences(ss *alerts.SilenceStore) Option {
+	return func(mh *MetricsHandler) *MetricsHandler {
+		mh.Silences = ss
+		return mh
+	}
+}
+
 // UpdateHandler POST обработчик обновления одной метрики в JSON формате
 func (mh *MetricsHandler) UpdateHandler(rw http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
@@ -179,11 +205,13 @@ func (mh *MetricsHandler) UpdatesHandler(rw http.ResponseWriter, r *http.Request
 	}
 
 	// Write new metrics value
-	usecase.WriteJSONMetrics(
+	if err := usecase.WriteJSONMetrics(
 		ctx,
 		&data,
 		mh.Storage,
-	)
+	); err != nil {
+		mh.logger.Println(err)
+	}
 
 	rw.WriteHeader(http.StatusOK)
 	_, err = rw.Write([]byte(""))
@@ -216,7 +244,12 @@ func (mh *MetricsHandler) GetMetricJSONHandler(rw http.ResponseWriter, r *http.R
 	}
 
 	// Get metric value for response
-	if err = usecase.GetJSONMetric(ctx, mh.Storage, &data); err != nil {
+	err = usecase.GetJSONMetric(ctx, mh.Storage, &data)
+	if errors.Is(err, usecase.ErrAmbiguousKey) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
 		http.Error(rw, err.Error(), http.StatusNotFound)
 		return
 	}
@@ -340,6 +373,10 @@ func (mh *MetricsHandler) GetMetricHandler(rw http.ResponseWriter, r *http.Reque
 	}
 
 	metricVal, err := usecase.GetMetric(ctx, mh.Storage, splitedPath)
+	if errors.Is(err, usecase.ErrAmbiguousKey) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(rw, "Metric is't implemented yet.", http.StatusNotImplemented)
 		return
@@ -367,7 +404,15 @@ func (mh *MetricsHandler) GetAllHandler(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	outTable := usecase.GetTableMetrics(ctx, mh.Storage)
+	outTable := struct {
+		Metrics map[string]string
+		Alerts  []alerts.Alert
+	}{
+		Metrics: usecase.GetTableMetrics(ctx, mh.Storage),
+	}
+	if mh.Alerts != nil {
+		outTable.Alerts = mh.Alerts.Alerts()
+	}
 
 	indexTmplt, err := template.ParseFS(core.Index, "index.html")
 	if err != nil {
@@ -384,6 +429,413 @@ func (mh *MetricsHandler) GetAllHandler(rw http.ResponseWriter, r *http.Request)
 	}
 }
 
+// PrometheusHandler GET обработчик получения всех метрик в формате Prometheus
+func (mh *MetricsHandler) PrometheusHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var out strings.Builder
+	if err := usecase.WritePromMetrics(ctx, &out, mh.Storage); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", usecase.PromContentType)
+	rw.WriteHeader(http.StatusOK)
+	_, err := rw.Write([]byte(out.String()))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AgentsHandler GET обработчик получения списка агентов в JSON формате
+func (mh *MetricsHandler) AgentsHandler(rw http.ResponseWriter, r *http.Request) {
+	list := make([]agents.Agent, 0)
+	if mh.Agents != nil {
+		list = mh.Agents.List()
+	}
+	resp, err := json.Marshal(list)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AlertsHandler GET обработчик получения активных и недавно
+// разрешенных оповещений в JSON формате
+func (mh *MetricsHandler) AlertsHandler(rw http.ResponseWriter, r *http.Request) {
+	list := make([]alerts.Alert, 0)
+	if mh.Alerts != nil {
+		list = mh.Alerts.Alerts()
+	}
+	resp, err := json.Marshal(list)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// SilencesHandler GET обработчик получения списка тишины
+// оповещений в JSON формате
+func (mh *MetricsHandler) SilencesHandler(rw http.ResponseWriter, r *http.Request) {
+	list := make([]alerts.Silence, 0)
+	if mh.Silences != nil {
+		list = mh.Silences.List()
+	}
+	resp, err := json.Marshal(list)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AddSilenceHandler POST обработчик создания тишины оповещений,
+// возвращает созданную тишину в JSON формате
+func (mh *MetricsHandler) AddSilenceHandler(rw http.ResponseWriter, r *http.Request) {
+	if mh.Silences == nil {
+		http.Error(rw, "Silences are not supported.", http.StatusNotImplemented)
+		return
+	}
+	var silence alerts.Silence
+	if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	silence, err := mh.Silences.Add(silence)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := json.Marshal(silence)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ExpireSilenceHandler DELETE обработчик завершения тишины
+// оповещений по адресу /api/silences/<id>
+func (mh *MetricsHandler) ExpireSilenceHandler(rw http.ResponseWriter, r *http.Request) {
+	if mh.Silences == nil {
+		http.Error(rw, "Silences are not supported.", http.StatusNotImplemented)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/silences/")
+	err := mh.Silences.Expire(id)
+	if errors.Is(err, alerts.ErrSilenceNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// RangeHandler GET обработчик получения истории значений метрики
+// в JSON формате, параметр id задает ключ временного ряда, from и to
+// интервал в формате RFC3339 или unix времени в секундах
+func (mh *MetricsHandler) RangeHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	params := r.URL.Query()
+	name, labels, err := types.ParseSeriesKey(params.Get("id"))
+	if err != nil || name == "" {
+		http.Error(rw, "wrong metric id", http.StatusBadRequest)
+		return
+	}
+	from, err := usecase.ParseTime(params.Get("from"))
+	if err != nil {
+		http.Error(rw, "wrong from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := usecase.ParseTime(params.Get("to"))
+	if err != nil {
+		http.Error(rw, "wrong to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	samples, err := usecase.GetRange(ctx, mh.Storage, types.SeriesKey(name, labels), from, to)
+	if errors.Is(err, usecase.ErrNoHistory) {
+		http.Error(rw, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := json.Marshal(struct {
+		ID      string            `json:"id"`
+		Labels  map[string]string `json:"labels,omitempty"`
+		Samples []types.Sample    `json:"samples"`
+	}{ID: name, Labels: labels, Samples: samples})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AggregateHandler GET обработчик агрегации истории значений метрики
+// по окнам времени в JSON формате, параметр id задает ключ временного
+// ряда, fn функцию агрегации, step размер окна, например 30s,
+// p процентиль для percentile, from и to интервал как в RangeHandler
+func (mh *MetricsHandler) AggregateHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	params := r.URL.Query()
+	name, labels, err := types.ParseSeriesKey(params.Get("id"))
+	if err != nil || name == "" {
+		http.Error(rw, "wrong metric id", http.StatusBadRequest)
+		return
+	}
+	aq := usecase.AggregateQuery{Key: types.SeriesKey(name, labels), Func: params.Get("fn")}
+	if aq.From, err = usecase.ParseTime(params.Get("from")); err != nil {
+		http.Error(rw, "wrong from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if aq.To, err = usecase.ParseTime(params.Get("to")); err != nil {
+		http.Error(rw, "wrong to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if step := params.Get("step"); step != "" {
+		if aq.Step, err = time.ParseDuration(step); err != nil {
+			http.Error(rw, "wrong step: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if p := params.Get("p"); p != "" {
+		if aq.Percentile, err = storage.StrToFloat64(p); err != nil {
+			http.Error(rw, "wrong p: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	points, err := usecase.Aggregate(ctx, mh.Storage, aq)
+	if errors.Is(err, usecase.ErrNoHistory) {
+		http.Error(rw, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if aq.Step == 0 {
+		aq.Step = usecase.DefaultStep
+	}
+	resp, err := json.Marshal(struct {
+		ID     string            `json:"id"`
+		Labels map[string]string `json:"labels,omitempty"`
+		Func   string            `json:"fn"`
+		Step   float64           `json:"step"`
+		Points []types.Sample    `json:"points"`
+	}{ID: name, Labels: labels, Func: aq.Func, Step: aq.Step.Seconds(), Points: points})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ForecastHandler GET обработчик прогноза значений метрики по истории
+// в JSON формате, параметр id задает ключ временного ряда, method метод
+// linear или holt, horizon горизонт прогноза, например 24h, threshold
+// порог, момент достижения которого прогнозируется, alpha, beta и gamma
+// коэффициенты сглаживания для holt, season период сезонности для holt,
+// например 24h, from и to интервал как в RangeHandler
+func (mh *MetricsHandler) ForecastHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	params := r.URL.Query()
+	name, labels, err := types.ParseSeriesKey(params.Get("id"))
+	if err != nil || name == "" {
+		http.Error(rw, "wrong metric id", http.StatusBadRequest)
+		return
+	}
+	fq := usecase.ForecastQuery{Key: types.SeriesKey(name, labels), Method: params.Get("method")}
+	if fq.From, err = usecase.ParseTime(params.Get("from")); err != nil {
+		http.Error(rw, "wrong from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fq.To, err = usecase.ParseTime(params.Get("to")); err != nil {
+		http.Error(rw, "wrong to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if horizon := params.Get("horizon"); horizon != "" {
+		if fq.Horizon, err = time.ParseDuration(horizon); err != nil {
+			http.Error(rw, "wrong horizon: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if threshold := params.Get("threshold"); threshold != "" {
+		value, err := storage.StrToFloat64(threshold)
+		if err != nil {
+			http.Error(rw, "wrong threshold: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		fq.Threshold = &value
+	}
+	if alpha := params.Get("alpha"); alpha != "" {
+		if fq.Alpha, err = storage.StrToFloat64(alpha); err != nil {
+			http.Error(rw, "wrong alpha: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if beta := params.Get("beta"); beta != "" {
+		if fq.Beta, err = storage.StrToFloat64(beta); err != nil {
+			http.Error(rw, "wrong beta: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if gamma := params.Get("gamma"); gamma != "" {
+		if fq.Gamma, err = storage.StrToFloat64(gamma); err != nil {
+			http.Error(rw, "wrong gamma: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if season := params.Get("season"); season != "" {
+		if fq.Season, err = time.ParseDuration(season); err != nil {
+			http.Error(rw, "wrong season: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	forecast, err := usecase.GetForecast(ctx, mh.Storage, fq)
+	if errors.Is(err, usecase.ErrNoHistory) {
+		http.Error(rw, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fq.Method == "" {
+		fq.Method = usecase.ForecastLinear
+	}
+	resp, err := json.Marshal(struct {
+		ID       string            `json:"id"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Method   string            `json:"method"`
+		Level    float64           `json:"level"`
+		Slope    float64           `json:"slope"`
+		At       time.Time         `json:"at"`
+		Season   float64           `json:"season,omitempty"`
+		Seasonal []float64         `json:"seasonal,omitempty"`
+		Crossing *time.Time        `json:"crossing,omitempty"`
+		Points   []types.Sample    `json:"points"`
+	}{
+		ID:       name,
+		Labels:   labels,
+		Method:   fq.Method,
+		Level:    forecast.Level,
+		Slope:    forecast.Slope,
+		At:       forecast.At,
+		Season:   forecast.Season.Seconds(),
+		Seasonal: forecast.Seasonal,
+		Crossing: forecast.Crossing,
+		Points:   forecast.Points,
+	})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// QueryHandler GET обработчик вычисления выражения языка запросов,
+// параметр query задает выражение, например HeapInuse / HeapSys
+func (mh *MetricsHandler) QueryHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	expr := r.URL.Query().Get("query")
+	if expr == "" {
+		http.Error(rw, "query is empty", http.StatusBadRequest)
+		return
+	}
+
+	val, err := query.Eval(ctx, mh.Storage, expr)
+	if errors.Is(err, usecase.ErrNoHistory) {
+		http.Error(rw, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := json.Marshal(struct {
+		Type   string      `json:"type"`
+		Result query.Value `json:"result"`
+	}{Type: val.Type(), Result: val})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // PingDB GET обработчик проверки доступности базы
 func (mh *MetricsHandler) PingDB(rw http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
@@ -147,6 +147,7 @@ func TestMetricsHandler_GetMetricJSONHandler(t *testing.T) {
 	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
 	stor["PollCount"] = int64(4)
 	stor["Sys"] = float64(0.0)
+	stor[`Sys{agent="a1"}`] = float64(1.5)
 	ms := MetricsHandler{
 		Storage: locStorage,
 		logger:  log.New(os.Stderr, "test", log.Default().Flags()),
@@ -173,6 +174,13 @@ func TestMetricsHandler_GetMetricJSONHandler(t *testing.T) {
 			key:         "1234rewq",
 			statusCode:  http.StatusOK,
 		},
+		{
+			name:        "Sys with labels",
+			data:        `{"id":"Sys","type":"gauge","value":1.5,"hash":"62aeaf4c70d84bb26517742974bf74a6f13ab2e6c8f3a1ccd7b3ca5c7bdc0e2b","labels":{"agent":"a1"}}`,
+			contentType: "application/json",
+			key:         "1234rewq",
+			statusCode:  http.StatusOK,
+		},
 		{
 			name:        "Empty data",
 			data:        `{}`,
@@ -2,19 +2,27 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/hrapovd1/pmetrics/internal/agents"
+	"github.com/hrapovd1/pmetrics/internal/alerts"
 	"github.com/hrapovd1/pmetrics/internal/config"
 	dbstorage "github.com/hrapovd1/pmetrics/internal/dbstrorage"
 	"github.com/hrapovd1/pmetrics/internal/filestorage"
+	"github.com/hrapovd1/pmetrics/internal/sqlitestorage"
 	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/tsdb"
 	"github.com/hrapovd1/pmetrics/internal/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -23,6 +31,8 @@ import (
 func TestNewMetricsHandler(t *testing.T) {
 	tmpFile, _ := os.CreateTemp("", "*.json")
 	defer os.Remove(tmpFile.Name())
+	tmpDir, _ := os.MkdirTemp("", "tsdb")
+	defer os.RemoveAll(tmpDir)
 	tests := []struct {
 		name string
 		conf config.Config
@@ -48,6 +58,21 @@ func TestNewMetricsHandler(t *testing.T) {
 			conf: config.Config{StoreFile: tmpFile.Name(), DatabaseDSN: "postgres"},
 			stor: &dbstorage.DBStorage{},
 		},
+		{
+			name: "tsdb storage",
+			conf: config.Config{StoreFile: "", TSDBDir: tmpDir},
+			stor: &tsdb.Storage{},
+		},
+		{
+			name: "file and tsdb storage",
+			conf: config.Config{StoreFile: tmpFile.Name(), TSDBDir: tmpDir},
+			stor: &tsdb.Storage{},
+		},
+		{
+			name: "sqlite storage",
+			conf: config.Config{StoreFile: tmpFile.Name(), DatabaseDSN: sqlitestorage.Scheme + filepath.Join(tmpDir, "metrics.db")},
+			stor: &sqlitestorage.SQLiteStorage{},
+		},
 	}
 
 	for _, test := range tests {
@@ -287,6 +312,381 @@ func TestMetricsHandler_GetAllHandler(t *testing.T) {
 	}
 }
 
+func TestMetricsHandler_PrometheusHandler(t *testing.T) {
+	stor := make(map[string]interface{})
+	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
+	stor["Alloc"] = float64(3.5)
+	stor["PollCount"] = int64(7)
+	ms := MetricsHandler{
+		Storage: locStorage,
+	}
+
+	reqst := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	hndl := http.HandlerFunc(ms.PrometheusHandler)
+	hndl.ServeHTTP(rec, reqst)
+	result := rec.Result()
+	body, err := io.ReadAll(result.Body)
+	assert.Nil(t, err)
+	defer assert.Nil(t, result.Body.Close())
+
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", result.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "# TYPE Alloc gauge\nAlloc 3.5\n")
+	assert.Contains(t, string(body), "# TYPE PollCount counter\nPollCount 7\n")
+}
+
+func TestMetricsHandler_AgentsHandler(t *testing.T) {
+	registry := agents.NewRegistry()
+	registry.Seen(agents.Agent{
+		ID:       "a1",
+		Host:     "web01",
+		Version:  "v1.0.0",
+		Address:  "192.168.0.2",
+		LastSeen: time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC),
+	})
+	mh := NewMetricsHandler(config.Config{}, log.Default(), WithStorage(storage.NewMemStorage()), WithAgents(registry))
+
+	reqst := httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+	rec := httptest.NewRecorder()
+	hndl := http.HandlerFunc(mh.AgentsHandler)
+	hndl.ServeHTTP(rec, reqst)
+	result := rec.Result()
+	body, err := io.ReadAll(result.Body)
+	assert.Nil(t, err)
+	defer assert.Nil(t, result.Body.Close())
+
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+	assert.Equal(t,
+		`[{"id":"a1","host":"web01","version":"v1.0.0","address":"192.168.0.2","last_seen":"2023-04-01T10:00:00Z"}]`,
+		string(body),
+	)
+}
+
+func TestMetricsHandler_AlertsHandler(t *testing.T) {
+	locStorage := storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+		`FreeMemory{agent="a1",host="web01"}`: float64(50),
+		`FreeMemory{agent="a2",host="db01"}`:  float64(500),
+	}))
+	manager, err := alerts.NewManager([]config.AlertRule{{
+		Name:      "LowMemory",
+		Metric:    "FreeMemory",
+		Op:        "<",
+		Threshold: 100,
+		Severity:  "critical",
+		Summary:   "Free memory is too low",
+	}}, locStorage, log.Default())
+	require.NoError(t, err)
+	manager.Evaluate(context.Background())
+	mh := NewMetricsHandler(config.Config{}, log.Default(), WithStorage(locStorage), WithAlerts(manager))
+
+	t.Run("json", func(t *testing.T) {
+		reqst := httptest.NewRequest(http.MethodGet, "/api/alerts", nil)
+		rec := httptest.NewRecorder()
+		http.HandlerFunc(mh.AlertsHandler).ServeHTTP(rec, reqst)
+		result := rec.Result()
+		defer func() { assert.Nil(t, result.Body.Close()) }()
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+		var got []alerts.Alert
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&got))
+		require.Len(t, got, 1)
+		assert.Equal(t, "LowMemory", got[0].Rule)
+		assert.Equal(t, alerts.StateFiring, got[0].State)
+		assert.Equal(t, map[string]string{"agent": "a1", "host": "web01"}, got[0].Labels)
+		assert.Equal(t, float64(50), got[0].Value)
+	})
+
+	t.Run("index page", func(t *testing.T) {
+		reqst := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		http.HandlerFunc(mh.GetAllHandler).ServeHTTP(rec, reqst)
+		result := rec.Result()
+		defer func() { assert.Nil(t, result.Body.Close()) }()
+		body, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Contains(t, string(body), `<tr class="firing"><th>LowMemory</th><td>agent=a1 host=web01 </td>`)
+		assert.Contains(t, string(body), "Free memory is too low")
+	})
+}
+
+func TestMetricsHandler_SilencesHandler(t *testing.T) {
+	ss, err := alerts.NewSilenceStore("")
+	require.NoError(t, err)
+	mh := NewMetricsHandler(config.Config{}, log.Default(), WithStorage(storage.NewMemStorage()), WithSilences(ss))
+	srv := httptest.NewServer(NewRouter(mh))
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, []byte) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		defer func() { assert.Nil(t, resp.Body.Close()) }()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, data
+	}
+
+	code, body := do(http.MethodPost, "/api/silences", `{
+		"matchers": [{"name": "agent", "value": "a1"}],
+		"recurrence": {"weekdays": ["sun"], "start": "02:00", "duration": "2h"},
+		"comment": "weekly reboot"
+	}`)
+	require.Equal(t, http.StatusOK, code, string(body))
+	var added alerts.Silence
+	require.NoError(t, json.Unmarshal(body, &added))
+	assert.NotEmpty(t, added.ID)
+	assert.Equal(t, alerts.SilenceActive, added.Status)
+	assert.Equal(t, 2*time.Hour, added.Recurrence.Duration)
+
+	code, _ = do(http.MethodPost, "/api/silences", `{"matchers": []}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = do(http.MethodPost, "/api/silences", `{"matchers":`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = do(http.MethodGet, "/api/silences", "")
+	assert.Equal(t, http.StatusOK, code)
+	var list []alerts.Silence
+	require.NoError(t, json.Unmarshal(body, &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "weekly reboot", list[0].Comment)
+
+	code, _ = do(http.MethodDelete, "/api/silences/"+added.ID, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, alerts.SilenceExpired, ss.List()[0].Status)
+	code, _ = do(http.MethodDelete, "/api/silences/unknown", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestMetricsHandler_RangeHandler(t *testing.T) {
+	locStorage := storage.NewMemStorage(storage.WithHistory(10, time.Hour))
+	ctx := context.Background()
+	locStorage.Rewrite(ctx, `Alloc{agent="a1"}`, 1)
+	locStorage.Rewrite(ctx, `Alloc{agent="a1"}`, 2)
+	locStorage.Rewrite(ctx, "Alloc", 3)
+	mh := NewMetricsHandler(config.Config{}, log.Default(), WithStorage(locStorage))
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		values []float64
+	}{
+		{name: "labeled series", query: "id=" + url.QueryEscape(`Alloc{agent="a1"}`), status: http.StatusOK, values: []float64{1, 2}},
+		{name: "plain series", query: "id=Alloc&to=" + url.QueryEscape(time.Now().Add(time.Minute).Format(time.RFC3339)), status: http.StatusOK, values: []float64{3}},
+		{name: "unknown series", query: "id=Sys", status: http.StatusOK, values: []float64{}},
+		{name: "old range", query: "id=Alloc&from=0&to=60", status: http.StatusOK, values: []float64{}},
+		{name: "empty id", query: "", status: http.StatusBadRequest},
+		{name: "wrong from", query: "id=Alloc&from=yesterday", status: http.StatusBadRequest},
+		{name: "from after to", query: "id=Alloc&from=120&to=60", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqst := httptest.NewRequest(http.MethodGet, "/api/range?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hndl := http.HandlerFunc(mh.RangeHandler)
+			hndl.ServeHTTP(rec, reqst)
+			result := rec.Result()
+			defer func() { assert.Nil(t, result.Body.Close()) }()
+
+			assert.Equal(t, tt.status, result.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Samples []types.Sample `json:"samples"`
+			}
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+			values := make([]float64, 0)
+			for _, sample := range resp.Samples {
+				values = append(values, sample.Value)
+			}
+			assert.Equal(t, tt.values, values)
+		})
+	}
+}
+
+func TestMetricsHandler_AggregateHandler(t *testing.T) {
+	locStorage := storage.NewMemStorage(storage.WithHistory(10, time.Hour))
+	ctx := context.Background()
+	for _, v := range []float64{1, 5, 3} {
+		locStorage.Rewrite(ctx, `Alloc{agent="a1"}`, v)
+	}
+	locStorage.Append(ctx, "PollCount", 1)
+	mh := NewMetricsHandler(config.Config{}, log.Default(), WithStorage(locStorage))
+	alloc := "id=" + url.QueryEscape(`Alloc{agent="a1"}`)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		values []float64
+	}{
+		{name: "max", query: alloc + "&fn=max&step=2h", status: http.StatusOK, values: []float64{5}},
+		{name: "count", query: alloc + "&fn=count&step=2h", status: http.StatusOK, values: []float64{3}},
+		{name: "percentile", query: alloc + "&fn=percentile&p=50&step=2h", status: http.StatusOK, values: []float64{3}},
+		{name: "increase", query: "id=PollCount&fn=increase&step=2h", status: http.StatusOK, values: []float64{0}},
+		{name: "default step", query: "id=Sys&fn=avg", status: http.StatusOK, values: []float64{}},
+		{name: "empty id", query: "fn=avg", status: http.StatusBadRequest},
+		{name: "unknown fn", query: alloc + "&fn=median", status: http.StatusBadRequest},
+		{name: "rate of gauge", query: alloc + "&fn=rate", status: http.StatusBadRequest},
+		{name: "wrong step", query: alloc + "&fn=avg&step=1", status: http.StatusBadRequest},
+		{name: "wrong p", query: alloc + "&fn=percentile&p=high", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqst := httptest.NewRequest(http.MethodGet, "/api/aggregate?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hndl := http.HandlerFunc(mh.AggregateHandler)
+			hndl.ServeHTTP(rec, reqst)
+			result := rec.Result()
+			defer func() { assert.Nil(t, result.Body.Close()) }()
+
+			assert.Equal(t, tt.status, result.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Step   float64        `json:"step"`
+				Points []types.Sample `json:"points"`
+			}
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+			assert.NotZero(t, resp.Step)
+			values := make([]float64, 0)
+			for _, point := range resp.Points {
+				values = append(values, point.Value)
+			}
+			assert.Equal(t, tt.values, values)
+		})
+	}
+}
+
+func TestMetricsHandler_ForecastHandler(t *testing.T) {
+	locStorage := storage.NewMemStorage(storage.WithHistory(10, time.Hour))
+	ctx := context.Background()
+	for _, v := range []float64{1000, 900, 800} {
+		locStorage.Rewrite(ctx, `FreeMemory{agent="a1"}`, v)
+		locStorage.Rewrite(ctx, `HeapAlloc{agent="a1"}`, 2000-v)
+		time.Sleep(time.Millisecond)
+	}
+	mh := NewMetricsHandler(config.Config{}, log.Default(), WithStorage(locStorage))
+	free := "id=" + url.QueryEscape(`FreeMemory{agent="a1"}`)
+	heap := "id=" + url.QueryEscape(`HeapAlloc{agent="a1"}`)
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		method   string
+		crossing bool
+	}{
+		{name: "linear crossing", query: free + "&threshold=0", status: http.StatusOK, method: "linear", crossing: true},
+		{name: "holt crossing", query: free + "&method=holt&alpha=0.8&beta=0.2&threshold=0&horizon=1h", status: http.StatusOK, method: "holt", crossing: true},
+		{name: "growing", query: heap + "&threshold=0", status: http.StatusOK, method: "linear"},
+		{name: "no threshold", query: free, status: http.StatusOK, method: "linear"},
+		{name: "empty id", query: "threshold=0", status: http.StatusBadRequest},
+		{name: "unknown method", query: free + "&method=arima", status: http.StatusBadRequest},
+		{name: "wrong horizon", query: free + "&horizon=1", status: http.StatusBadRequest},
+		{name: "wrong threshold", query: free + "&threshold=zero", status: http.StatusBadRequest},
+		{name: "wrong alpha", query: free + "&method=holt&alpha=2", status: http.StatusBadRequest},
+		{name: "wrong season", query: free + "&method=holt&season=day", status: http.StatusBadRequest},
+		{name: "short season history", query: free + "&method=holt&season=24h", status: http.StatusBadRequest},
+		{name: "one sample", query: "id=Sys", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqst := httptest.NewRequest(http.MethodGet, "/api/forecast?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hndl := http.HandlerFunc(mh.ForecastHandler)
+			hndl.ServeHTTP(rec, reqst)
+			result := rec.Result()
+			defer func() { assert.Nil(t, result.Body.Close()) }()
+
+			assert.Equal(t, tt.status, result.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Method   string         `json:"method"`
+				Slope    float64        `json:"slope"`
+				At       time.Time      `json:"at"`
+				Crossing *time.Time     `json:"crossing"`
+				Points   []types.Sample `json:"points"`
+			}
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+			assert.Equal(t, tt.method, resp.Method)
+			assert.Len(t, resp.Points, 24)
+			assert.Equal(t, tt.crossing, resp.Crossing != nil)
+			if resp.Crossing != nil {
+				assert.Less(t, resp.Slope, float64(0))
+				assert.True(t, resp.Crossing.After(resp.At))
+			}
+		})
+	}
+}
+
+func TestMetricsHandler_QueryHandler(t *testing.T) {
+	stor := map[string]interface{}{
+		`HeapInuse{agent="a1"}`: float64(30),
+		`HeapSys{agent="a1"}`:   float64(60),
+		`HeapInuse{agent="a2"}`: float64(10),
+		`HeapSys{agent="a2"}`:   float64(0),
+	}
+	mh := NewMetricsHandler(config.Config{}, log.Default(), WithStorage(storage.NewMemStorage(storage.WithBuffer(stor))))
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   string
+	}{
+		{
+			name:   "ratio",
+			query:  "HeapInuse / HeapSys",
+			status: http.StatusOK,
+			want:   `{"type":"vector","result":[{"labels":{"agent":"a1"},"value":0.5},{"labels":{"agent":"a2"},"value":"+Inf"}]}`,
+		},
+		{
+			name:   "fleet sum",
+			query:  "sum(HeapInuse)",
+			status: http.StatusOK,
+			want:   `{"type":"vector","result":[{"value":40}]}`,
+		},
+		{
+			name:   "selector",
+			query:  `HeapSys{agent="a1"}`,
+			status: http.StatusOK,
+			want:   `{"type":"vector","result":[{"id":"HeapSys","labels":{"agent":"a1"},"value":60}]}`,
+		},
+		{name: "scalar", query: "1 + 2", status: http.StatusOK, want: `{"type":"scalar","result":3}`},
+		{name: "empty", query: "", status: http.StatusBadRequest},
+		{name: "syntax", query: "HeapInuse /", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqst := httptest.NewRequest(http.MethodGet, "/api/query?query="+url.QueryEscape(tt.query), nil)
+			rec := httptest.NewRecorder()
+			hndl := http.HandlerFunc(mh.QueryHandler)
+			hndl.ServeHTTP(rec, reqst)
+			result := rec.Result()
+			defer func() { assert.Nil(t, result.Body.Close()) }()
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, result.StatusCode)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+				assert.Equal(t, tt.want, string(body))
+			}
+		})
+	}
+}
+
 func TestMetricsHandler_GetMetricHandler(t *testing.T) {
 	stor := make(map[string]interface{})
 	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
@@ -0,0 +1,119 @@
+// Часть модуля handlers содержит обработчики приема метрик
+// по сторонним протоколам.
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/lineproto"
+	"github.com/hrapovd1/pmetrics/internal/remotewrite"
+	"github.com/hrapovd1/pmetrics/internal/usecase"
+)
+
+// HashHeader заголовок с подписью тела запроса для протоколов
+// без подписи отдельных метрик
+const HashHeader = "HashSHA256"
+
+// RemoteWriteHandler POST обработчик приема метрик по протоколу
+// Prometheus remote_write
+func (mh *MetricsHandler) RemoteWriteHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			mh.logger.Println(err)
+		}
+	}()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// check body hash.
+	if !mh.isBodySignEqual(r, body) {
+		http.Error(rw, "sign metric is bad", http.StatusBadRequest)
+		return
+	}
+
+	req, err := remotewrite.DecodeSnappy(body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Write new metrics value
+	if err := remotewrite.Store(ctx, req, mh.Storage); err != nil {
+		mh.logger.Println(err)
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// InfluxWriteHandler POST обработчик приема метрик в формате
+// InfluxDB line protocol
+func (mh *MetricsHandler) InfluxWriteHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			mh.logger.Println(err)
+		}
+	}()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// check body hash.
+	if !mh.isBodySignEqual(r, body) {
+		http.Error(rw, "sign metric is bad", http.StatusBadRequest)
+		return
+	}
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		if body, err = gunzip(body); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	points, err := lineproto.Parse(body, r.URL.Query().Get("precision"), time.Now())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Write new metrics value
+	metrics := lineproto.ToMetrics(points)
+	if err := usecase.WriteJSONMetrics(ctx, &metrics, mh.Storage); err != nil {
+		mh.logger.Println(err)
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// isBodySignEqual проверяет подпись тела запроса из заголовка HashHeader,
+// если ключ подписи не задан, то проверка не выполняется
+func (mh *MetricsHandler) isBodySignEqual(r *http.Request, body []byte) bool {
+	if mh.Config.Key == "" {
+		return true
+	}
+	return usecase.IsBodySignEqual(body, r.Header.Get(HashHeader), mh.Config.Key)
+}
+
+// gunzip распаковывает сжатое gzip тело запроса
+func gunzip(body []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/usecase"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteBody формирует тело remote_write запроса с одним gauge рядом
+func remoteWriteBody(name string, value float64) []byte {
+	var label, sample, series, req []byte
+	label = protowire.AppendTag(label, 1, protowire.BytesType)
+	label = protowire.AppendString(label, "__name__")
+	label = protowire.AppendTag(label, 2, protowire.BytesType)
+	label = protowire.AppendString(label, name)
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	series = protowire.AppendTag(series, 1, protowire.BytesType)
+	series = protowire.AppendBytes(series, label)
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample)
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, series)
+	return snappy.Encode(nil, req)
+}
+
+func TestMetricsHandler_RemoteWriteHandler(t *testing.T) {
+	body := remoteWriteBody("memory_free", 2.5)
+	tests := []struct {
+		name       string
+		key        string
+		body       []byte
+		sign       string
+		statusCode int
+	}{
+		{
+			name:       "without key",
+			body:       body,
+			statusCode: http.StatusNoContent,
+		},
+		{
+			name:       "good sign",
+			key:        "secret",
+			body:       body,
+			sign:       usecase.SignBody(body, "secret"),
+			statusCode: http.StatusNoContent,
+		},
+		{
+			name:       "bad sign",
+			key:        "secret",
+			body:       body,
+			sign:       usecase.SignBody(body, "other"),
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "bad body",
+			body:       []byte("bad body"),
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			locStorage := storage.NewMemStorage()
+			mh := MetricsHandler{Storage: locStorage, Config: config.Config{Key: test.key}}
+			reqst := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(test.body))
+			reqst.Header.Set(HashHeader, test.sign)
+			rec := httptest.NewRecorder()
+			http.HandlerFunc(mh.RemoteWriteHandler).ServeHTTP(rec, reqst)
+			result := rec.Result()
+			defer assert.Nil(t, result.Body.Close())
+			assert.Equal(t, test.statusCode, result.StatusCode)
+			if test.statusCode == http.StatusNoContent {
+				assert.Equal(t, 2.5, locStorage.Get(context.Background(), "memory_free"))
+			}
+		})
+	}
+}
+
+func TestMetricsHandler_InfluxWriteHandler(t *testing.T) {
+	body := []byte("mem free=2.5\ncpu,host=web01 usage=10i 1465839830\n")
+	var gzBody bytes.Buffer
+	gz := gzip.NewWriter(&gzBody)
+	_, err := gz.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	tests := []struct {
+		name       string
+		key        string
+		body       []byte
+		encoding   string
+		path       string
+		sign       string
+		statusCode int
+	}{
+		{
+			name:       "without key",
+			body:       body,
+			path:       "/write?precision=s",
+			statusCode: http.StatusNoContent,
+		},
+		{
+			name:       "gzip body with sign",
+			key:        "secret",
+			body:       gzBody.Bytes(),
+			encoding:   "gzip",
+			path:       "/write?precision=s",
+			sign:       usecase.SignBody(gzBody.Bytes(), "secret"),
+			statusCode: http.StatusNoContent,
+		},
+		{
+			name:       "bad sign",
+			key:        "secret",
+			body:       body,
+			path:       "/write",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "bad precision",
+			body:       body,
+			path:       "/write?precision=d",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "bad gzip",
+			body:       body,
+			encoding:   "gzip",
+			path:       "/write",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			locStorage := storage.NewMemStorage()
+			mh := MetricsHandler{Storage: locStorage, Config: config.Config{Key: test.key}}
+			reqst := httptest.NewRequest(http.MethodPost, test.path, bytes.NewReader(test.body))
+			reqst.Header.Set(HashHeader, test.sign)
+			reqst.Header.Set("Content-Encoding", test.encoding)
+			rec := httptest.NewRecorder()
+			http.HandlerFunc(mh.InfluxWriteHandler).ServeHTTP(rec, reqst)
+			result := rec.Result()
+			defer assert.Nil(t, result.Body.Close())
+			assert.Equal(t, test.statusCode, result.StatusCode)
+			if test.statusCode == http.StatusNoContent {
+				assert.Equal(t, 2.5, locStorage.Get(context.Background(), "mem_free"))
+				assert.Equal(t, float64(10), locStorage.Get(context.Background(), `cpu_usage{host="web01"}`))
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/hrapovd1/pmetrics/internal/types"
@@ -143,3 +144,23 @@ func (mh *MetricsHandler) CheckAgentNetMiddle(next http.Handler) http.Handler {
 		http.Error(w, "Unknown agent forbidden", http.StatusForbidden)
 	})
 }
+
+// throttleRetryAfter секунды до повторной отправки метрик при
+// перегруженном хранилище
+const throttleRetryAfter = 1
+
+// ThrottleMiddle промежуточный обработчик запросов записи метрик,
+// отвечает 503 с заголовком Retry-After, пока хранилище не успевает
+// сохранять значения
+func (mh *MetricsHandler) ThrottleMiddle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttler, ok := mh.Storage.(types.Throttler); ok {
+			if err := throttler.Throttle(); err != nil {
+				w.Header().Set("Retry-After", strconv.Itoa(throttleRetryAfter))
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
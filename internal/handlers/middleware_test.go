@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -405,3 +406,44 @@ func TestMetricsHandler_CheckAgentNetMiddle(t *testing.T) {
 		assert.NotEqual(t, clientReq, req)
 	})
 }
+
+// throttledStorage хранилище, которое не успевает сохранять значения
+type throttledStorage struct {
+	types.Repository
+	err error
+}
+
+func (ts throttledStorage) Throttle() error { return ts.err }
+
+func TestMetricsHandler_ThrottleMiddle(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name       string
+		stor       types.Repository
+		wantStatus int
+	}{
+		{name: "without throttler", stor: storage.NewMemStorage(), wantStatus: http.StatusOK},
+		{name: "not throttled", stor: throttledStorage{Repository: storage.NewMemStorage()}, wantStatus: http.StatusOK},
+		{
+			name:       "queue is full",
+			stor:       throttledStorage{Repository: storage.NewMemStorage(), err: errors.New("queue is full")},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mh := MetricsHandler{Storage: tt.stor, logger: log.Default()}
+			request := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			rec := httptest.NewRecorder()
+			mh.ThrottleMiddle(next).ServeHTTP(rec, request)
+			result := rec.Result()
+			defer assert.Nil(t, result.Body.Close())
+			assert.Equal(t, tt.wantStatus, result.StatusCode)
+			if tt.wantStatus == http.StatusServiceUnavailable {
+				assert.Equal(t, "1", result.Header.Get("Retry-After"))
+			}
+		})
+	}
+}
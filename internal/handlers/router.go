@@ -0,0 +1,57 @@
+// Часть модуля handlers содержит маршрутизацию http запросов
+// к обработчикам API.
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// NewRouter возвращает маршрутизатор http запросов к обработчикам API
+func NewRouter(mh *MetricsHandler) http.Handler {
+	router := chi.NewRouter()
+	router.Use(middleware.Recoverer)
+	router.Use(mh.GzipMiddle)
+
+	router.Get("/", mh.GetAllHandler)
+	router.Get("/ping", mh.PingDB)
+	router.Get("/metrics", mh.PrometheusHandler)
+	router.Get("/api/agents", mh.AgentsHandler)
+	router.Get("/api/alerts", mh.AlertsHandler)
+	router.Get("/api/silences", mh.SilencesHandler)
+	router.Get("/api/range", mh.RangeHandler)
+	router.Get("/api/aggregate", mh.AggregateHandler)
+	router.Get("/api/query", mh.QueryHandler)
+	router.Get("/api/forecast", mh.ForecastHandler)
+	router.Get("/value/*", mh.GetMetricHandler)
+	router.Post("/value/", mh.GetMetricJSONHandler)
+
+	// Запись метрик разрешена только доверенным агентам
+	router.Group(func(r chi.Router) {
+		r.Use(mh.CheckAgentNetMiddle)
+		r.Use(mh.ThrottleMiddle)
+		r.Use(mh.DecryptMiddle)
+		r.Post("/update/", mh.UpdateHandler)
+		r.Post("/updates/", mh.UpdatesHandler)
+		r.Post("/update/gauge/*", mh.GaugeHandler)
+		r.Post("/update/counter/*", mh.CounterHandler)
+		r.Post("/update/*", NotImplementedHandler)
+		r.Post("/write", mh.InfluxWriteHandler)
+		r.Post("/api/v2/write", mh.InfluxWriteHandler)
+	})
+	router.Group(func(r chi.Router) {
+		r.Use(mh.CheckAgentNetMiddle)
+		r.Use(mh.ThrottleMiddle)
+		r.Post("/api/v1/write", mh.RemoteWriteHandler)
+	})
+	// Тишина не пишет метрики и не ограничивается при перегрузке записи
+	router.Group(func(r chi.Router) {
+		r.Use(mh.CheckAgentNetMiddle)
+		r.Post("/api/silences", mh.AddSilenceHandler)
+		r.Delete("/api/silences/*", mh.ExpireSilenceHandler)
+	})
+
+	return router
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithStorage(t *testing.T) {
+	locStorage := storage.NewMemStorage()
+	mh := NewMetricsHandler(config.Config{}, log.Default(), WithStorage(locStorage))
+	assert.Same(t, locStorage, mh.Storage)
+}
+
+func TestNewRouter(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		statusCode int
+		want       string
+	}{
+		{
+			name:       "update gauge",
+			method:     http.MethodPost,
+			path:       "/update/gauge/Alloc/34.9",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "update counter",
+			method:     http.MethodPost,
+			path:       "/update/counter/PollCount/3",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "update unknown type",
+			method:     http.MethodPost,
+			path:       "/update/unknown/PollCount/3",
+			statusCode: http.StatusNotImplemented,
+		},
+		{
+			name:       "update json",
+			method:     http.MethodPost,
+			path:       "/update/",
+			body:       `{"id":"PollCount","type":"counter","delta":2}`,
+			statusCode: http.StatusOK,
+			want:       `{"id":"PollCount","type":"counter","delta":5}`,
+		},
+		{
+			name:       "updates json",
+			method:     http.MethodPost,
+			path:       "/updates/",
+			body:       `[{"id":"Sys","type":"gauge","value":1.5}]`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "value text",
+			method:     http.MethodGet,
+			path:       "/value/gauge/Alloc",
+			statusCode: http.StatusOK,
+			want:       "34.9",
+		},
+		{
+			name:       "value json",
+			method:     http.MethodPost,
+			path:       "/value/",
+			body:       `{"id":"Sys","type":"gauge"}`,
+			statusCode: http.StatusOK,
+			want:       `{"id":"Sys","type":"gauge","value":1.5}`,
+		},
+		{
+			name:       "value not found",
+			method:     http.MethodGet,
+			path:       "/value/gauge/Unknown",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "index page",
+			method:     http.MethodGet,
+			path:       "/",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "agents",
+			method:     http.MethodGet,
+			path:       "/api/agents",
+			statusCode: http.StatusOK,
+			want:       `[]`,
+		},
+		{
+			name:       "alerts",
+			method:     http.MethodGet,
+			path:       "/api/alerts",
+			statusCode: http.StatusOK,
+			want:       `[]`,
+		},
+		{
+			name:       "silences",
+			method:     http.MethodGet,
+			path:       "/api/silences",
+			statusCode: http.StatusOK,
+			want:       `[]`,
+		},
+		{
+			name:       "add silence without store",
+			method:     http.MethodPost,
+			path:       "/api/silences",
+			body:       `{"matchers":[{"name":"agent","value":"a1"}],"ends_at":"2030-01-01T00:00:00Z"}`,
+			statusCode: http.StatusNotImplemented,
+		},
+		{
+			name:       "range",
+			method:     http.MethodGet,
+			path:       "/api/range?id=Alloc",
+			statusCode: http.StatusOK,
+			want:       `{"id":"Alloc","samples":[]}`,
+		},
+		{
+			name:       "forecast without samples",
+			method:     http.MethodGet,
+			path:       "/api/forecast?id=Alloc&threshold=0",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	mh := NewMetricsHandler(config.Config{}, log.Default(), WithStorage(storage.NewMemStorage()))
+	srv := httptest.NewServer(NewRouter(mh))
+	defer srv.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), test.method, srv.URL+test.path, strings.NewReader(test.body))
+			require.NoError(t, err)
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer func() { assert.Nil(t, resp.Body.Close()) }()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, test.statusCode, resp.StatusCode)
+			if test.want != "" {
+				assert.Equal(t, test.want, string(body))
+			}
+		})
+	}
+}
+
+func TestNewRouter_untrustedAgent(t *testing.T) {
+	mh := NewMetricsHandler(
+		config.Config{TrustedSubnet: "192.168.0.0/24"},
+		log.Default(),
+		WithStorage(storage.NewMemStorage()),
+	)
+	srv := httptest.NewServer(NewRouter(mh))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/update/gauge/Alloc/1", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer func() { assert.Nil(t, resp.Body.Close()) }()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestNewRouter_throttled(t *testing.T) {
+	mh := NewMetricsHandler(
+		config.Config{},
+		log.Default(),
+		WithStorage(throttledStorage{Repository: storage.NewMemStorage(), err: errors.New("queue is full")}),
+	)
+	srv := httptest.NewServer(NewRouter(mh))
+	defer srv.Close()
+
+	// при перегрузке записи отклоняется только запись метрик,
+	// тишина оповещений не пишет метрики
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+	}{
+		{method: http.MethodPost, path: "/update/gauge/Alloc/1", statusCode: http.StatusServiceUnavailable},
+		{method: http.MethodPost, path: "/api/v1/write", statusCode: http.StatusServiceUnavailable},
+		{method: http.MethodPost, path: "/api/silences", statusCode: http.StatusNotImplemented},
+		{method: http.MethodDelete, path: "/api/silences/1", statusCode: http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader("{}"))
+			require.NoError(t, err)
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer func() { assert.Nil(t, resp.Body.Close()) }()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Модуль lineproto содержит разбор протокола InfluxDB line protocol
+// и преобразование точек в метрики pmetrics.
+package lineproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// Типы значений полей
+const (
+	FieldFloat = iota
+	FieldInteger
+	FieldUnsigned
+	FieldBoolean
+	FieldString
+)
+
+// Field значение поля точки
+type Field struct {
+	Type  int
+	Value float64
+	Str   string
+}
+
+// Point точка line protocol: measurement,tags fields timestamp
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]Field
+	Timestamp   time.Time
+}
+
+// Множители точности временной метки в наносекундах
+var precisions = map[string]int64{
+	"":   1,
+	"n":  1,
+	"ns": 1,
+	"u":  int64(time.Microsecond),
+	"us": int64(time.Microsecond),
+	"ms": int64(time.Millisecond),
+	"s":  int64(time.Second),
+}
+
+// Parse разбирает строки line protocol, точки без временной метки
+// получают время now
+func Parse(data []byte, precision string, now time.Time) ([]Point, error) {
+	multiplier, ok := precisions[precision]
+	if !ok {
+		return nil, fmt.Errorf("unknown precision %q", precision)
+	}
+	points := make([]Point, 0)
+	scan := bufio.NewScanner(bytes.NewReader(data))
+	scan.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	lineNum := 0
+	for scan.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		point, err := parseLine(line, multiplier, now)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		points = append(points, point)
+	}
+	return points, scan.Err()
+}
+
+// parseLine разбирает одну строку line protocol
+func parseLine(line string, multiplier int64, now time.Time) (Point, error) {
+	point := Point{Tags: make(map[string]string), Fields: make(map[string]Field), Timestamp: now}
+
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return point, errors.New("want measurement, fields and optional timestamp")
+	}
+
+	// measurement и теги
+	seriesParts := splitUnescaped(sections[0], ',', false)
+	point.Measurement = unescape(seriesParts[0])
+	if point.Measurement == "" {
+		return point, errors.New("empty measurement")
+	}
+	for _, tag := range seriesParts[1:] {
+		kv := splitUnescaped(tag, '=', false)
+		if len(kv) != 2 || kv[0] == "" {
+			return point, fmt.Errorf("bad tag %q", tag)
+		}
+		point.Tags[unescape(kv[0])] = unescape(kv[1])
+	}
+
+	// поля
+	for _, field := range splitUnescaped(sections[1], ',', true) {
+		sep := indexUnescaped(field, '=')
+		if sep <= 0 {
+			return point, fmt.Errorf("bad field %q", field)
+		}
+		value, err := parseFieldValue(field[sep+1:])
+		if err != nil {
+			return point, fmt.Errorf("bad field %q: %w", field, err)
+		}
+		point.Fields[unescape(field[:sep])] = value
+	}
+
+	// временная метка
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return point, fmt.Errorf("bad timestamp: %w", err)
+		}
+		point.Timestamp = time.Unix(0, ts*multiplier)
+	}
+	return point, nil
+}
+
+// parseFieldValue разбирает значение поля по его суффиксу
+func parseFieldValue(raw string) (Field, error) {
+	switch {
+	case raw == "":
+		return Field{}, errors.New("empty value")
+	case strings.HasPrefix(raw, `"`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return Field{}, errors.New("unterminated string")
+		}
+		str := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(raw[1 : len(raw)-1])
+		return Field{Type: FieldString, Str: str}, nil
+	case strings.HasSuffix(raw, "i"):
+		v, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		return Field{Type: FieldInteger, Value: float64(v)}, err
+	case strings.HasSuffix(raw, "u"):
+		v, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		return Field{Type: FieldUnsigned, Value: float64(v)}, err
+	}
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return Field{Type: FieldBoolean, Value: 1}, nil
+	case "f", "F", "false", "False", "FALSE":
+		return Field{Type: FieldBoolean, Value: 0}, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	return Field{Type: FieldFloat, Value: v}, err
+}
+
+// ToMetrics преобразует точки в gauge метрики, каждое числовое или
+// логическое поле становится метрикой measurement_field с тегами
+// точки в качестве меток, строковые поля пропускаются
+func ToMetrics(points []Point) []types.Metric {
+	metrics := make([]types.Metric, 0, len(points))
+	for _, point := range points {
+		for name, field := range point.Fields {
+			if field.Type == FieldString {
+				continue
+			}
+			value := field.Value
+			metrics = append(metrics, types.Metric{
+				ID:    types.SeriesKey(point.Measurement+"_"+name, point.Tags),
+				MType: "gauge",
+				Value: &value,
+			})
+		}
+	}
+	return metrics
+}
+
+// splitUnescaped делит строку по неэкранированному разделителю sep,
+// при quoted разделители внутри строк в двойных кавычках игнорируются
+func splitUnescaped(s string, sep byte, quoted bool) []string {
+	parts := make([]string, 0)
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// indexUnescaped возвращает позицию первого неэкранированного символа ch
+func indexUnescaped(s string, ch byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ch:
+			return i
+		}
+	}
+	return -1
+}
+
+// unescape убирает экранирование в именах и значениях тегов
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, `,`, `\=`, `=`, `\ `, ` `, `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,92 @@
+package lineproto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Unix(100, 0)
+	tests := []struct {
+		name      string
+		data      string
+		precision string
+		want      []Point
+		wantErr   bool
+	}{
+		{
+			name: "full line",
+			data: "cpu,host=web01,region=eu usage_idle=92.5,cores=4i 1465839830100400200",
+			want: []Point{{
+				Measurement: "cpu",
+				Tags:        map[string]string{"host": "web01", "region": "eu"},
+				Fields: map[string]Field{
+					"usage_idle": {Type: FieldFloat, Value: 92.5},
+					"cores":      {Type: FieldInteger, Value: 4},
+				},
+				Timestamp: time.Unix(0, 1465839830100400200),
+			}},
+		},
+		{
+			name:      "precision and escaping",
+			data:      "disk\\ io,path=/var\\,log written=10u,ok=t,msg=\"a \\\"b\\\" c\" 1465839830",
+			precision: "s",
+			want: []Point{{
+				Measurement: "disk io",
+				Tags:        map[string]string{"path": "/var,log"},
+				Fields: map[string]Field{
+					"written": {Type: FieldUnsigned, Value: 10},
+					"ok":      {Type: FieldBoolean, Value: 1},
+					"msg":     {Type: FieldString, Str: `a "b" c`},
+				},
+				Timestamp: time.Unix(1465839830, 0),
+			}},
+		},
+		{
+			name: "without timestamp and comments",
+			data: "# comment\n\nmem free=1.5\n",
+			want: []Point{{
+				Measurement: "mem",
+				Tags:        map[string]string{},
+				Fields:      map[string]Field{"free": {Type: FieldFloat, Value: 1.5}},
+				Timestamp:   now,
+			}},
+		},
+		{name: "no fields", data: "mem", wantErr: true},
+		{name: "bad field", data: "mem free", wantErr: true},
+		{name: "bad value", data: "mem free=abc", wantErr: true},
+		{name: "bad tag", data: "mem,host free=1", wantErr: true},
+		{name: "bad timestamp", data: "mem free=1 abc", wantErr: true},
+		{name: "bad precision", data: "mem free=1", precision: "h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := Parse([]byte(tt.data), tt.precision, now)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, points)
+		})
+	}
+}
+
+func TestToMetrics(t *testing.T) {
+	points, err := Parse([]byte(`cpu,host=web01 usage=12.5,up=true,name="x"`), "", time.Now())
+	require.NoError(t, err)
+	metrics := ToMetrics(points)
+	require.Len(t, metrics, 2)
+	got := make(map[string]float64)
+	for _, m := range metrics {
+		assert.Equal(t, "gauge", m.MType)
+		got[m.ID] = *m.Value
+	}
+	assert.Equal(t, map[string]float64{
+		`cpu_usage{host="web01"}`: 12.5,
+		`cpu_up{host="web01"}`:    1,
+	}, got)
+}
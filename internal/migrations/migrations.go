@@ -0,0 +1,275 @@
+// Модуль migrations содержит версионные миграции схемы базы postgresql.
+// Миграции хранятся в файлах sql/<версия>_<имя>.up.sql и
+// sql/<версия>_<имя>.down.sql, встроенных в бинарный файл, версии
+// начинаются с 1 и идут подряд. Примененные версии записываются
+// в таблицу schema_version. Миграция без down файла при откате
+// только удаляет запись о версии, например перенос данных.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	// драйвер pgx для database/sql
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// VersionTable таблица примененных версий схемы
+const VersionTable = "schema_version"
+
+// lockID ключ advisory блокировки, исключающей одновременное
+// применение миграций несколькими экземплярами сервера
+const lockID = 7_253_194_410
+
+var (
+	// ErrSchemaNewer схема базы новее последней известной серверу миграции
+	ErrSchemaNewer = errors.New("database schema is newer than supported by server")
+	// ErrSchemaOlder к схеме базы не применены известные серверу миграции
+	ErrSchemaOlder = errors.New("database schema is older than required by server")
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// fileName шаблон имени файла миграции
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration миграция схемы базы
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // пустая строка, если миграция не откатывается
+}
+
+// Load возвращает встроенные миграции, отсортированные по версии
+func Load() ([]Migration, error) {
+	return load(files, "sql")
+}
+
+// load читает миграции из каталога dir файловой системы fsys
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("wrong migration file name %s", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names %s and %s", version, m.Name, match[2])
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	for i, m := range out {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+	}
+	return out, nil
+}
+
+// Migrator применяет и откатывает миграции схемы базы
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New возвращает Migrator встроенных миграций для базы db
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest возвращает версию последней известной миграции
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Version возвращает текущую версию схемы базы, 0 - миграции
+// не применялись
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.createVersionTable(ctx); err != nil {
+		return 0, err
+	}
+	return currentVersion(ctx, m.db)
+}
+
+// Check возвращает ErrSchemaNewer, если схема базы новее последней
+// известной миграции, и ErrSchemaOlder, если к ней применены не все
+// миграции; сами миграции не применяются
+func (m *Migrator) Check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	return checkVersion(version, m.Latest())
+}
+
+// Up применяет все непримененные миграции
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down откатывает последнюю примененную миграцию
+func (m *Migrator) Down(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+	return m.To(ctx, version-1)
+}
+
+// To применяет или откатывает миграции до версии target, каждая
+// миграция выполняется в отдельной транзакции
+func (m *Migrator) To(ctx context.Context, target int) error {
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("unknown schema version %d, latest is %d", target, m.Latest())
+	}
+	if err := m.createVersionTable(ctx); err != nil {
+		return err
+	}
+	for {
+		done, err := m.step(ctx, target)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// step применяет или откатывает одну миграцию в направлении версии
+// target, возвращает true, если схема уже имеет версию target
+func (m *Migrator) step(ctx context.Context, target int) (bool, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		// после Commit откат возвращает sql.ErrTxDone
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", lockID); err != nil {
+		return false, err
+	}
+	// версия читается под блокировкой, ее мог изменить другой экземпляр
+	version, err := currentVersion(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	next, up, err := nextStep(version, target, m.Latest())
+	if err != nil || next == 0 {
+		return next == 0, err
+	}
+	migration := m.migrations[next-1]
+	query := migration.Up
+	if !up {
+		query = migration.Down
+	}
+	if query != "" {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return false, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	if up {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO "+VersionTable+" (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+VersionTable+" WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
+
+// checkVersion сравнивает версию схемы базы current с версией
+// последней известной миграции latest
+func checkVersion(current, latest int) error {
+	switch {
+	case current > latest:
+		return fmt.Errorf("%w: version %d, supported %d", ErrSchemaNewer, current, latest)
+	case current < latest:
+		return fmt.Errorf("%w: version %d, required %d", ErrSchemaOlder, current, latest)
+	}
+	return nil
+}
+
+// nextStep возвращает версию миграции, которую нужно применить (up)
+// или откатить для перехода от версии current к target, 0 - переход
+// не нужен
+func nextStep(current, target, latest int) (int, bool, error) {
+	if current > latest {
+		return 0, false, fmt.Errorf("%w: version %d, supported %d", ErrSchemaNewer, current, latest)
+	}
+	switch {
+	case current < target:
+		return current + 1, true, nil
+	case current > target:
+		return current, false, nil
+	}
+	return 0, false, nil
+}
+
+// createVersionTable создает таблицу версий схемы
+func (m *Migrator) createVersionTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+VersionTable+` (
+		version    integer PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	return err
+}
+
+// queryer общий интерфейс sql.DB и sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// currentVersion возвращает последнюю примененную версию схемы
+func currentVersion(ctx context.Context, q queryer) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM "+VersionTable).Scan(&version)
+	return version, err
+}
@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	got, err := Load()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(got), 3)
+	assert.Equal(t, "series", got[0].Name)
+	assert.Contains(t, got[0].Up, "CREATE TABLE IF NOT EXISTS metric_series")
+	assert.Contains(t, got[0].Down, "DROP TABLE IF EXISTS metric_samples")
+	assert.Equal(t, "legacy_tables", got[1].Name)
+	assert.Empty(t, got[1].Down, "moved data isn't moved back")
+	assert.Equal(t, "rollups", got[2].Name)
+	assert.Contains(t, got[2].Up, "CREATE TABLE IF NOT EXISTS metric_rollups")
+}
+
+func Test_load(t *testing.T) {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "sorted",
+			fsys: fstest.MapFS{
+				"sql/0002_b.up.sql":   file("up b"),
+				"sql/0001_a.up.sql":   file("up a"),
+				"sql/0001_a.down.sql": file("down a"),
+			},
+			want: []Migration{
+				{Version: 1, Name: "a", Up: "up a", Down: "down a"},
+				{Version: 2, Name: "b", Up: "up b"},
+			},
+		},
+		{name: "gap", fsys: fstest.MapFS{"sql/0001_a.up.sql": file("a"), "sql/0003_c.up.sql": file("c")}, wantErr: true},
+		{name: "no up", fsys: fstest.MapFS{"sql/0001_a.down.sql": file("a")}, wantErr: true},
+		{name: "wrong name", fsys: fstest.MapFS{"sql/a.sql": file("a")}, wantErr: true},
+		{name: "different names", fsys: fstest.MapFS{"sql/0001_a.up.sql": file("a"), "sql/0001_b.down.sql": file("b")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := load(tt.fsys, "sql")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_nextStep(t *testing.T) {
+	tests := []struct {
+		name                    string
+		current, target, latest int
+		next                    int
+		up                      bool
+		wantErr                 error
+	}{
+		{name: "empty database", current: 0, target: 3, latest: 3, next: 1, up: true},
+		{name: "pending", current: 2, target: 3, latest: 3, next: 3, up: true},
+		{name: "current", current: 3, target: 3, latest: 3},
+		{name: "rollback", current: 3, target: 1, latest: 3, next: 3},
+		{name: "newer schema", current: 4, target: 3, latest: 3, wantErr: ErrSchemaNewer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, up, err := nextStep(tt.current, tt.target, tt.latest)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, next)
+			assert.Equal(t, tt.up, up)
+		})
+	}
+}
+
+func Test_checkVersion(t *testing.T) {
+	assert.NoError(t, checkVersion(3, 3))
+	assert.ErrorIs(t, checkVersion(4, 3), ErrSchemaNewer)
+	assert.ErrorIs(t, checkVersion(0, 3), ErrSchemaOlder)
+	assert.ErrorIs(t, checkVersion(2, 3), ErrSchemaOlder)
+}
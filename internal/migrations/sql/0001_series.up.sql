@@ -0,0 +1,20 @@
+-- Таблицы временных рядов и их значений, IF NOT EXISTS позволяет
+-- применить миграцию к базе, в которой таблицы уже созданы сервером
+CREATE TABLE IF NOT EXISTS metric_series (
+    id     bigserial PRIMARY KEY,
+    name   text NOT NULL,
+    labels text NOT NULL,
+    mtype  text NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS metric_series_name_labels ON metric_series (name, labels);
+
+-- timestamp - наносекунды unix времени
+CREATE TABLE IF NOT EXISTS metric_samples (
+    series_id   bigint NOT NULL REFERENCES metric_series (id) ON DELETE CASCADE,
+    "timestamp" bigint NOT NULL,
+    value       double precision,
+    delta       bigint,
+    data        text,
+    PRIMARY KEY (series_id, "timestamp")
+);
+CREATE INDEX IF NOT EXISTS metric_samples_timestamp ON metric_samples ("timestamp");
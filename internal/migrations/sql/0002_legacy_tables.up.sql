@@ -0,0 +1,50 @@
+-- Перенос данных из таблиц прежней схемы, в которой каждая метрика
+-- хранилась в отдельной таблице pmetric_<имя метрики> со временем
+-- записи в секундах; колонки labels и data отсутствуют в таблицах,
+-- созданных до их появления. Перенесенные таблицы удаляются.
+DO $$
+DECLARE
+    tbl text;
+    lbl text;
+    dat text;
+BEGIN
+    FOR tbl IN
+        SELECT tablename FROM pg_tables
+        WHERE schemaname = current_schema() AND tablename LIKE 'pmetric\_%'
+        ORDER BY tablename
+    LOOP
+        -- приведение типа, так как ORDER BY не допускает строковую константу
+        lbl := '''''::text';
+        IF EXISTS (
+            SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = tbl AND column_name = 'labels'
+        ) THEN
+            lbl := 't.labels';
+        END IF;
+        dat := 'NULL';
+        IF EXISTS (
+            SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = tbl AND column_name = 'data'
+        ) THEN
+            dat := 't.data';
+        END IF;
+
+        -- тип ряда определяется по последнему значению
+        EXECUTE format(
+            'INSERT INTO metric_series (name, labels, mtype)
+            SELECT DISTINCT ON (t.id, %1$s) t.id, %1$s, t.mtype FROM %2$I t
+            ORDER BY t.id, %1$s, t."timestamp" DESC
+            ON CONFLICT (name, labels) DO NOTHING',
+            lbl, tbl
+        );
+        EXECUTE format(
+            'INSERT INTO metric_samples (series_id, "timestamp", value, delta, data)
+            SELECT s.id, t."timestamp" * 1000000000, t.value, t.delta, %1$s
+            FROM %2$I t JOIN metric_series s ON s.name = t.id AND s.labels = %3$s
+            ON CONFLICT (series_id, "timestamp") DO NOTHING',
+            dat, tbl, lbl
+        );
+        EXECUTE format('DROP TABLE %I', tbl);
+    END LOOP;
+END
+$$;
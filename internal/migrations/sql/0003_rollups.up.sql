@@ -0,0 +1,14 @@
+-- Агрегаты значений временных рядов за интервалы resolution секунд,
+-- "timestamp" - начало интервала в наносекундах unix времени;
+-- increase - прирост counter за интервал, для gauge NULL
+CREATE TABLE IF NOT EXISTS metric_rollups (
+    series_id   bigint NOT NULL REFERENCES metric_series (id) ON DELETE CASCADE,
+    resolution  bigint NOT NULL,
+    "timestamp" bigint NOT NULL,
+    min         double precision NOT NULL,
+    max         double precision NOT NULL,
+    sum         double precision NOT NULL,
+    count       bigint NOT NULL,
+    increase    double precision,
+    PRIMARY KEY (series_id, resolution, "timestamp")
+);
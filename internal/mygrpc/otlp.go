@@ -0,0 +1,191 @@
+package mygrpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/hrapovd1/pmetrics/internal/usecase"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// Атрибуты ресурса OTLP, которые становятся метками метрик
+var otlpResourceLabels = map[string]string{
+	"service.name":        "job",
+	"service.instance.id": "instance",
+}
+
+// OTLPServer - grpc OpenTelemetry MetricsService server
+type OTLPServer struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	Storage types.Repository
+	logger  *log.Logger
+}
+
+// NewOTLPServer - grpc OTLPServer constructor, uses storage of MetricsServer
+func NewOTLPServer(repo types.Repository, logger *log.Logger) *OTLPServer {
+	return &OTLPServer{Storage: repo, logger: logger}
+}
+
+// Export - unary server method, writes OTLP metrics in storage.
+// Monotonic Sum is written as counter, Gauge and non monotonic Sum as gauge,
+// Histogram and Summary are split into _count, _sum, _bucket series.
+func (ots *OTLPServer) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	for _, rm := range req.GetResourceMetrics() {
+		resLabels := make(map[string]string)
+		for _, kv := range rm.GetResource().GetAttributes() {
+			if name, ok := otlpResourceLabels[kv.GetKey()]; ok {
+				resLabels[name] = anyValueString(kv.GetValue())
+			}
+		}
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				ots.writeMetric(ctx, m, resLabels)
+			}
+		}
+	}
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+// writeMetric - write all data points of OTLP metric
+func (ots *OTLPServer) writeMetric(ctx context.Context, m *metricspb.Metric, resLabels map[string]string) {
+	name := m.GetName()
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range data.Gauge.GetDataPoints() {
+			ots.gauge(ctx, name, pointLabels(resLabels, dp.GetAttributes()), numberValue(dp))
+		}
+	case *metricspb.Metric_Sum:
+		cumulative := data.Sum.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+		for _, dp := range data.Sum.GetDataPoints() {
+			labels := pointLabels(resLabels, dp.GetAttributes())
+			if data.Sum.GetIsMonotonic() {
+				ots.counter(ctx, name, labels, numberValue(dp), cumulative)
+			} else {
+				ots.gauge(ctx, name, labels, numberValue(dp))
+			}
+		}
+	case *metricspb.Metric_Histogram:
+		cumulative := data.Histogram.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+		for _, dp := range data.Histogram.GetDataPoints() {
+			labels := pointLabels(resLabels, dp.GetAttributes())
+			ots.counter(ctx, name+"_count", labels, float64(dp.GetCount()), cumulative)
+			ots.gauge(ctx, name+"_sum", labels, dp.GetSum())
+			// границы бакетов OTLP не накопительные, привожу к le формату
+			var bucketTotal uint64
+			for i, count := range dp.GetBucketCounts() {
+				bucketTotal += count
+				le := "+Inf"
+				if i < len(dp.GetExplicitBounds()) {
+					le = strconv.FormatFloat(dp.GetExplicitBounds()[i], 'g', -1, 64)
+				}
+				ots.counter(ctx, name+"_bucket", withLabel(labels, "le", le), float64(bucketTotal), cumulative)
+			}
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		cumulative := data.ExponentialHistogram.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+		for _, dp := range data.ExponentialHistogram.GetDataPoints() {
+			labels := pointLabels(resLabels, dp.GetAttributes())
+			ots.counter(ctx, name+"_count", labels, float64(dp.GetCount()), cumulative)
+			ots.gauge(ctx, name+"_sum", labels, dp.GetSum())
+		}
+	case *metricspb.Metric_Summary:
+		for _, dp := range data.Summary.GetDataPoints() {
+			labels := pointLabels(resLabels, dp.GetAttributes())
+			ots.counter(ctx, name+"_count", labels, float64(dp.GetCount()), true)
+			ots.gauge(ctx, name+"_sum", labels, dp.GetSum())
+			for _, q := range dp.GetQuantileValues() {
+				quantile := strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)
+				ots.gauge(ctx, name, withLabel(labels, "quantile", quantile), q.GetValue())
+			}
+		}
+	default:
+		ots.logger.Printf("otlp: metric %s has unsupported data type %T\n", name, data)
+	}
+}
+
+// gauge - write gauge value
+func (ots *OTLPServer) gauge(ctx context.Context, name string, labels map[string]string, value float64) {
+	id := types.SeriesKey(name, labels)
+	if !ots.checkType(ctx, id, "gauge") {
+		return
+	}
+	ots.Storage.Rewrite(ctx, id, value)
+}
+
+// counter - write counter value, cumulative value is written
+// atomically as total, see usecase.WriteCounterTotal
+func (ots *OTLPServer) counter(ctx context.Context, name string, labels map[string]string, value float64, cumulative bool) {
+	id := types.SeriesKey(name, labels)
+	if cumulative {
+		usecase.WriteCounterTotal(ctx, ots.Storage, id, value)
+		return
+	}
+	if !ots.checkType(ctx, id, "counter") {
+		return
+	}
+	ots.Storage.Append(ctx, id, int64(math.Round(value)))
+}
+
+// checkType - check that series id doesn't keep value of other type,
+// data point of other type is skipped
+func (ots *OTLPServer) checkType(ctx context.Context, id, mType string) bool {
+	if err := usecase.CheckType(ctx, ots.Storage, id, mType); err != nil {
+		ots.logger.Printf("otlp: skip data point: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// numberValue - return data point value as float64
+func numberValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+// pointLabels - merge resource labels and data point attributes
+func pointLabels(resLabels map[string]string, attrs []*commonpb.KeyValue) map[string]string {
+	labels := make(map[string]string, len(resLabels)+len(attrs))
+	for k, v := range resLabels {
+		labels[k] = v
+	}
+	for _, kv := range attrs {
+		labels[kv.GetKey()] = anyValueString(kv.GetValue())
+	}
+	return labels
+}
+
+// withLabel - return copy of labels with additional label
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[name] = value
+	return out
+}
+
+// anyValueString - convert OTLP attribute value to string
+func anyValueString(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'g', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(val)
+	}
+}
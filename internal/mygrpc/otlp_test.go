@@ -0,0 +1,117 @@
+package mygrpc
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func strAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func otlpRequest(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				strAttr("service.name", "api"),
+				strAttr("host.arch", "amd64"),
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+		}},
+	}
+}
+
+func TestOTLPServer_Export(t *testing.T) {
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	ctx := context.Background()
+	ots := NewOTLPServer(storage.NewMemStorage(), log.Default())
+
+	req := otlpRequest(
+		&metricspb.Metric{Name: "temp", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{
+				Attributes: []*commonpb.KeyValue{strAttr("room", "a")},
+				Value:      &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5},
+			}},
+		}}},
+		&metricspb.Metric{Name: "requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			IsMonotonic:            true,
+			AggregationTemporality: cumulative,
+			DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 10}}},
+		}}},
+		&metricspb.Metric{Name: "errors", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			IsMonotonic:            true,
+			AggregationTemporality: delta,
+			DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 2}}},
+		}}},
+		&metricspb.Metric{Name: "queue", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: cumulative,
+			DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: -3}}},
+		}}},
+		&metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: cumulative,
+			DataPoints: []*metricspb.HistogramDataPoint{{
+				Count:          5,
+				Sum:            func() *float64 { v := 1.5; return &v }(),
+				ExplicitBounds: []float64{0.1, 1},
+				BucketCounts:   []uint64{2, 2, 1},
+			}},
+		}}},
+		&metricspb.Metric{Name: "rpc", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+			DataPoints: []*metricspb.SummaryDataPoint{{
+				Count:          4,
+				Sum:            8,
+				QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{{Quantile: 0.5, Value: 1.5}},
+			}},
+		}}},
+	)
+
+	// повторная отправка накопительных значений не меняет counter
+	for i := 0; i < 2; i++ {
+		resp, err := ots.Export(ctx, req)
+		require.NoError(t, err)
+		assert.NotNil(t, resp)
+	}
+
+	assert.Equal(t, 21.5, ots.Storage.Get(ctx, `temp{job="api",room="a"}`))
+	assert.Equal(t, int64(10), ots.Storage.Get(ctx, `requests{job="api"}`))
+	assert.Equal(t, int64(4), ots.Storage.Get(ctx, `errors{job="api"}`))
+	assert.Equal(t, float64(-3), ots.Storage.Get(ctx, `queue{job="api"}`))
+	assert.Equal(t, int64(5), ots.Storage.Get(ctx, `latency_count{job="api"}`))
+	assert.Equal(t, 1.5, ots.Storage.Get(ctx, `latency_sum{job="api"}`))
+	assert.Equal(t, int64(2), ots.Storage.Get(ctx, `latency_bucket{job="api",le="0.1"}`))
+	assert.Equal(t, int64(4), ots.Storage.Get(ctx, `latency_bucket{job="api",le="1"}`))
+	assert.Equal(t, int64(5), ots.Storage.Get(ctx, `latency_bucket{job="api",le="+Inf"}`))
+	assert.Equal(t, int64(4), ots.Storage.Get(ctx, `rpc_count{job="api"}`))
+	assert.Equal(t, float64(8), ots.Storage.Get(ctx, `rpc_sum{job="api"}`))
+	assert.Equal(t, 1.5, ots.Storage.Get(ctx, `rpc{job="api",quantile="0.5"}`))
+}
+
+func TestOTLPServer_Export_typeMismatch(t *testing.T) {
+	ctx := context.Background()
+	ots := NewOTLPServer(storage.NewMemStorage(), log.Default())
+
+	// Sum с именем сохраненного gauge пропускается
+	req := otlpRequest(
+		&metricspb.Metric{Name: "requests", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1}}},
+		}}},
+		&metricspb.Metric{Name: "requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			IsMonotonic:            true,
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+			DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 1}}},
+		}}},
+	)
+	_, err := ots.Export(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), ots.Storage.Get(ctx, `requests{job="api"}`))
+}
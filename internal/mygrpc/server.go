@@ -8,65 +8,55 @@ import (
 	"io"
 	"log"
 	"net"
+	"strings"
+	"time"
 
+	"github.com/hrapovd1/pmetrics/internal/agents"
+	"github.com/hrapovd1/pmetrics/internal/alerts"
+	"github.com/hrapovd1/pmetrics/internal/backend"
 	"github.com/hrapovd1/pmetrics/internal/config"
-	dbstorage "github.com/hrapovd1/pmetrics/internal/dbstrorage"
-	"github.com/hrapovd1/pmetrics/internal/filestorage"
 	pb "github.com/hrapovd1/pmetrics/internal/proto"
-	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/query"
 	"github.com/hrapovd1/pmetrics/internal/types"
 	"github.com/hrapovd1/pmetrics/internal/usecase"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
 type MetricsServer struct {
 	pb.UnimplementedMetricsServer
-	Storage types.Repository
-	conf    config.Config
-	logger  *log.Logger
+	Storage  types.Repository
+	Agents   *agents.Registry
+	Silences *alerts.SilenceStore
+	conf     config.Config
+	logger   *log.Logger
+}
+
+// trustedMethods - unary methods allowed only from TrustedSubnet
+var trustedMethods = map[string]bool{
+	pb.Metrics_AddSilence_FullMethodName:    true,
+	pb.Metrics_ExpireSilence_FullMethodName: true,
+}
+
+// writeMethods - unary methods writing metrics in storage, all streams
+// write metrics too
+var writeMethods = map[string]bool{
+	pb.Metrics_ReportMetric_FullMethodName:    true,
+	pb.Metrics_ReportEncMetric_FullMethodName: true,
 }
 
 // NewMetricsServer - grpc MetricsServer constructor
 func NewMetricsServer(conf config.Config, logger *log.Logger) *MetricsServer {
-	ms := MetricsServer{conf: conf, logger: logger}
-	var fs *filestorage.FileStorage
-	// Have mem, fs and db storage
-	if ms.conf.StoreFile != "" && ms.conf.DatabaseDSN != "" {
-		db, err := dbstorage.NewDBStorage(
-			conf.DatabaseDSN,
-			logger,
-			filestorage.NewFileStorage(conf, make(map[string]interface{})),
-		)
-		if err != nil {
-			logger.Fatal(err)
-		}
-		ms.Storage = db
-	}
-	// Have mem and db storage
-	if ms.conf.DatabaseDSN != "" && ms.conf.StoreFile == "" {
-		db, err := dbstorage.NewDBStorage(
-			conf.DatabaseDSN,
-			logger,
-			storage.NewMemStorage(),
-		)
-		if err != nil {
-			logger.Fatal(err)
-		}
-		ms.Storage = db
-	}
-	// Have mem and fs storage
-	if ms.conf.StoreFile != "" && ms.conf.DatabaseDSN == "" {
-		fs = filestorage.NewFileStorage(conf, make(map[string]interface{}))
-		ms.Storage = fs
-	}
-	// Have mem storage
-	if ms.conf.DatabaseDSN == "" && ms.conf.StoreFile == "" {
-		mms := storage.NewMemStorage()
-		ms.Storage = mms
+	ms := MetricsServer{conf: conf, logger: logger, Agents: agents.NewRegistry()}
+	repo, err := backend.New(conf, logger)
+	if err != nil {
+		logger.Fatal(err)
 	}
+	ms.Storage = repo
 	return &ms
 }
 
@@ -174,14 +164,219 @@ func (ms *MetricsServer) ReportEncMetrics(strm pb.Metrics_ReportEncMetricsServer
 	}
 }
 
+// ListAgents - unary server method, returns agents which have sent metrics
+func (ms *MetricsServer) ListAgents(c context.Context, r *pb.ListAgentsRequest) (*pb.ListAgentsResponse, error) {
+	list := ms.Agents.List()
+	resp := &pb.ListAgentsResponse{Agents: make([]*pb.Agent, 0, len(list))}
+	for _, agent := range list {
+		resp.Agents = append(resp.Agents, &pb.Agent{
+			Id:       agent.ID,
+			Host:     agent.Host,
+			Version:  agent.Version,
+			Address:  agent.Address,
+			LastSeen: agent.LastSeen.Unix(),
+		})
+	}
+	return resp, nil
+}
+
+// GetRange - unary server method, returns memory history of the series
+func (ms *MetricsServer) GetRange(c context.Context, r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	name, labels, err := types.ParseSeriesKey(r.Id)
+	if err != nil || name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "wrong metric id")
+	}
+	var from, to time.Time
+	if r.From != 0 {
+		from = time.UnixMilli(r.From)
+	}
+	if r.To != 0 {
+		to = time.UnixMilli(r.To)
+	}
+	samples, err := usecase.GetRange(c, ms.Storage, types.SeriesKey(name, labels), from, to)
+	if errors.Is(err, usecase.ErrNoHistory) {
+		return nil, status.Errorf(codes.Unimplemented, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	resp := &pb.RangeResponse{Samples: make([]*pb.Sample, 0, len(samples))}
+	for _, sample := range samples {
+		resp.Samples = append(resp.Samples, &pb.Sample{
+			Timestamp: sample.Timestamp.UnixMilli(),
+			Value:     sample.Value,
+		})
+	}
+	return resp, nil
+}
+
+// Query - unary server method, evaluates query language expression
+func (ms *MetricsServer) Query(c context.Context, r *pb.QueryRequest) (*pb.QueryResponse, error) {
+	val, err := query.Eval(c, ms.Storage, r.Query)
+	if errors.Is(err, usecase.ErrNoHistory) {
+		return nil, status.Errorf(codes.Unimplemented, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	resp := &pb.QueryResponse{Type: val.Type()}
+	switch val := val.(type) {
+	case query.Scalar:
+		resp.Scalar = float64(val)
+	case query.Vector:
+		resp.Series = make([]*pb.QuerySeries, 0, len(val))
+		for _, s := range val {
+			resp.Series = append(resp.Series, &pb.QuerySeries{Id: s.Name, Labels: s.Labels, Value: s.Value})
+		}
+	}
+	return resp, nil
+}
+
+// ListSilences - unary server method, returns alert silences
+func (ms *MetricsServer) ListSilences(c context.Context, r *pb.ListSilencesRequest) (*pb.ListSilencesResponse, error) {
+	resp := &pb.ListSilencesResponse{Silences: make([]*pb.Silence, 0)}
+	if ms.Silences == nil {
+		return resp, nil
+	}
+	for _, s := range ms.Silences.List() {
+		resp.Silences = append(resp.Silences, silenceToPb(s))
+	}
+	return resp, nil
+}
+
+// AddSilence - unary server method, creates alert silence
+func (ms *MetricsServer) AddSilence(c context.Context, r *pb.Silence) (*pb.Silence, error) {
+	if ms.Silences == nil {
+		return nil, status.Errorf(codes.Unimplemented, "silences are not supported")
+	}
+	silence, err := ms.Silences.Add(silenceFromPb(r))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	return silenceToPb(silence), nil
+}
+
+// ExpireSilence - unary server method, expires alert silence by id
+func (ms *MetricsServer) ExpireSilence(c context.Context, r *pb.ExpireSilenceRequest) (*pb.ExpireSilenceResponse, error) {
+	if ms.Silences == nil {
+		return nil, status.Errorf(codes.Unimplemented, "silences are not supported")
+	}
+	err := ms.Silences.Expire(r.Id)
+	if errors.Is(err, alerts.ErrSilenceNotFound) {
+		return nil, status.Errorf(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	return &pb.ExpireSilenceResponse{}, nil
+}
+
+// silenceToPb - convert silence to protobuf message
+func silenceToPb(s alerts.Silence) *pb.Silence {
+	out := &pb.Silence{
+		Id:        s.ID,
+		Matchers:  make([]*pb.SilenceMatcher, 0, len(s.Matchers)),
+		StartsAt:  s.StartsAt.UnixMilli(),
+		Comment:   s.Comment,
+		CreatedBy: s.CreatedBy,
+		Status:    s.Status,
+	}
+	if !s.EndsAt.IsZero() {
+		out.EndsAt = s.EndsAt.UnixMilli()
+	}
+	for _, m := range s.Matchers {
+		out.Matchers = append(out.Matchers, &pb.SilenceMatcher{Name: m.Name, Op: m.Op, Value: m.Value})
+	}
+	if s.Recurrence != nil {
+		out.Recurrence = &pb.Recurrence{
+			Weekdays: s.Recurrence.Weekdays,
+			Start:    s.Recurrence.Start,
+			Duration: int64(s.Recurrence.Duration / time.Second),
+		}
+	}
+	return out
+}
+
+// silenceFromPb - convert protobuf message to silence
+func silenceFromPb(r *pb.Silence) alerts.Silence {
+	out := alerts.Silence{
+		Matchers:  make([]alerts.Matcher, 0, len(r.Matchers)),
+		Comment:   r.Comment,
+		CreatedBy: r.CreatedBy,
+	}
+	if r.StartsAt != 0 {
+		out.StartsAt = time.UnixMilli(r.StartsAt)
+	}
+	if r.EndsAt != 0 {
+		out.EndsAt = time.UnixMilli(r.EndsAt)
+	}
+	for _, m := range r.Matchers {
+		out.Matchers = append(out.Matchers, alerts.Matcher{Name: m.Name, Op: m.Op, Value: m.Value})
+	}
+	if r.Recurrence != nil {
+		out.Recurrence = &alerts.Recurrence{
+			Weekdays: r.Recurrence.Weekdays,
+			Start:    r.Recurrence.Start,
+			Duration: time.Duration(r.Recurrence.Duration) * time.Second,
+		}
+	}
+	return out
+}
+
 // StreamInterceptor - check metadata value X-Real-IP from agent
+// and storage overload
 func (ms *MetricsServer) StreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	md, ok := metadata.FromIncomingContext(stream.Context())
+	if err := ms.checkAgent(stream.Context()); err != nil {
+		return err
+	}
+	if err := ms.checkThrottle(); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// UnaryInterceptor - check metadata value X-Real-IP for OTLP requests
+// and silences changes, storage overload for metrics writing
+func (ms *MetricsServer) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	otlp := strings.HasPrefix(info.FullMethod, "/"+colmetricspb.MetricsService_ServiceDesc.ServiceName+"/")
+	if otlp {
+		if err := ms.checkExporter(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if trustedMethods[info.FullMethod] {
+		if err := ms.checkAgent(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if otlp || writeMethods[info.FullMethod] {
+		if err := ms.checkThrottle(); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// checkThrottle - return ResourceExhausted while storage can't keep up
+// with writing values
+func (ms *MetricsServer) checkThrottle() error {
+	if throttler, ok := ms.Storage.(types.Throttler); ok {
+		if err := throttler.Throttle(); err != nil {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+	}
+	return nil
+}
+
+// checkAgent - check agent address from metadata value X-Real-IP,
+// request without X-Real-IP is rejected
+func (ms *MetricsServer) checkAgent(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
 	if ok {
 		values := md.Get("X-Real-IP")
 		if len(values) > 0 {
 			if ms.isTrustedAddr(values[0]) {
-				return handler(srv, stream)
+				return nil
 			}
 			ms.logger.Printf("got untrusted request from: %v\n", values)
 			return status.Error(codes.PermissionDenied, "untrusted agent")
@@ -190,6 +385,16 @@ func (ms *MetricsServer) StreamInterceptor(srv interface{}, stream grpc.ServerSt
 	return status.Error(codes.PermissionDenied, "untrusted agent")
 }
 
+// checkExporter - check OTLP exporter address like checkAgent, OTLP
+// exporters don't send X-Real-IP, so without TrustedSubnet all
+// exporters are allowed
+func (ms *MetricsServer) checkExporter(ctx context.Context) error {
+	if ms.conf.TrustedSubnet == "" {
+		return nil
+	}
+	return ms.checkAgent(ctx)
+}
+
 // isTrustedAddr - check agent address value
 func (ms *MetricsServer) isTrustedAddr(addr string) bool {
 	if ms.conf.TrustedSubnet == "" {
@@ -222,6 +427,17 @@ func (ms *MetricsServer) writeMetric(ctx context.Context, data []byte) error {
 		}
 	}
 
+	// metric is recorded under agent identity, the series which a file
+	// snapshot keeps without agent labels goes on under agent labels
+	if agent, ok := agentFromContext(ctx); ok {
+		unlabelled := metric.Key()
+		metric.Labels = agent.Labels(metric.Labels)
+		ms.Agents.Seen(agent)
+		if renamer, ok := ms.Storage.(types.Renamer); ok {
+			renamer.Rename(ctx, unlabelled, metric.Key())
+		}
+	}
+
 	// Write new metrics value
 	err := usecase.WriteJSONMetric(
 		ctx,
@@ -234,3 +450,33 @@ func (ms *MetricsServer) writeMetric(ctx context.Context, data []byte) error {
 	}
 	return nil
 }
+
+// agentFromContext - get agent identity from metadata values X-Agent-*,
+// agent address is X-Real-IP value or peer address
+func agentFromContext(ctx context.Context) (agents.Agent, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return agents.Agent{}, false
+	}
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	agent := agents.Agent{
+		ID:      first(agents.IDHeader),
+		Host:    first(agents.HostHeader),
+		Version: first(agents.VersionHeader),
+		Address: first("X-Real-IP"),
+	}
+	if agent.ID == "" {
+		return agent, false
+	}
+	if agent.Address == "" {
+		if p, ok := peer.FromContext(ctx); ok {
+			agent.Address = p.Addr.String()
+		}
+	}
+	return agent, true
+}
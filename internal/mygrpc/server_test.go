@@ -2,25 +2,37 @@ package mygrpc
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/hrapovd1/pmetrics/internal/agents"
+	"github.com/hrapovd1/pmetrics/internal/alerts"
 	"github.com/hrapovd1/pmetrics/internal/config"
 	dbstorage "github.com/hrapovd1/pmetrics/internal/dbstrorage"
 	"github.com/hrapovd1/pmetrics/internal/filestorage"
 	pb "github.com/hrapovd1/pmetrics/internal/proto"
+	"github.com/hrapovd1/pmetrics/internal/sqlitestorage"
 	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/tsdb"
 	"github.com/hrapovd1/pmetrics/internal/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestNewMetricsServer(t *testing.T) {
 	tmpFile, _ := os.CreateTemp("", "*.json")
 	defer os.Remove(tmpFile.Name())
+	tmpDir, _ := os.MkdirTemp("", "tsdb")
+	defer os.RemoveAll(tmpDir)
 	tests := []struct {
 		name string
 		conf config.Config
@@ -46,6 +58,21 @@ func TestNewMetricsServer(t *testing.T) {
 			conf: config.Config{StoreFile: tmpFile.Name(), DatabaseDSN: "postgres"},
 			stor: &dbstorage.DBStorage{},
 		},
+		{
+			name: "tsdb storage",
+			conf: config.Config{StoreFile: "", TSDBDir: tmpDir},
+			stor: &tsdb.Storage{},
+		},
+		{
+			name: "file and tsdb storage",
+			conf: config.Config{StoreFile: tmpFile.Name(), TSDBDir: tmpDir},
+			stor: &tsdb.Storage{},
+		},
+		{
+			name: "sqlite storage",
+			conf: config.Config{StoreFile: tmpFile.Name(), DatabaseDSN: sqlitestorage.Scheme + filepath.Join(tmpDir, "metrics.db")},
+			stor: &sqlitestorage.SQLiteStorage{},
+		},
 	}
 
 	for _, test := range tests {
@@ -75,6 +102,17 @@ func TestMetricsServer_ReportMetric(t *testing.T) {
 			data:    []byte(`{"id":"M1","type":"guge","value":45.1}`),
 			wantErr: true,
 		},
+		{
+			name:    "histogram",
+			data:    []byte(`{"id":"H1","type":"histogram","histogram":{"bounds":[1],"counts":[1,0],"sum":0.5,"count":1}}`),
+			wantErr: false,
+			resp:    &pb.MetricResponse{},
+		},
+		{
+			name:    "bad histogram",
+			data:    []byte(`{"id":"H1","type":"histogram","histogram":{"bounds":[1],"counts":[1],"count":1}}`),
+			wantErr: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -259,6 +297,130 @@ func TestMetricsServer_writeMetric(t *testing.T) {
 	}
 }
 
+func TestMetricsServer_writeMetric_agent(t *testing.T) {
+	ms := NewMetricsServer(config.Config{}, log.Default())
+	md := metadata.New(map[string]string{
+		"X-Real-IP":          "192.168.0.2",
+		agents.IDHeader:      "a1",
+		agents.HostHeader:    "web01",
+		agents.VersionHeader: "v1.0.0",
+	})
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	require.NoError(t, ms.writeMetric(ctx, []byte(`{"id":"CPUutilization1","type":"gauge","value":12.5}`)))
+	require.NoError(t, ms.writeMetric(context.Background(), []byte(`{"id":"CPUutilization1","type":"gauge","value":3}`)))
+
+	assert.Equal(t, 12.5, ms.Storage.Get(ctx, `CPUutilization1{agent="a1",host="web01"}`))
+	assert.Equal(t, float64(3), ms.Storage.Get(ctx, "CPUutilization1"))
+
+	resp, err := ms.ListAgents(ctx, &pb.ListAgentsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Agents, 1)
+	agent := resp.Agents[0]
+	assert.Equal(t, "a1", agent.Id)
+	assert.Equal(t, "web01", agent.Host)
+	assert.Equal(t, "v1.0.0", agent.Version)
+	assert.Equal(t, "192.168.0.2", agent.Address)
+	assert.NotZero(t, agent.LastSeen)
+}
+
+func TestMetricsServer_writeMetric_snapshot(t *testing.T) {
+	// снимок сервера без меток агентов хранит метрики под именами
+	buff := map[string]interface{}{"PollCount": int64(5)}
+	ms := NewMetricsServer(config.Config{}, log.Default())
+	ms.Storage = filestorage.NewFileStorage(config.Config{StoreFile: filepath.Join(t.TempDir(), "metrics.json")}, buff)
+	md := metadata.New(map[string]string{agents.IDHeader: "a1", agents.HostHeader: "web01"})
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	require.NoError(t, ms.writeMetric(ctx, []byte(`{"id":"PollCount","type":"counter","delta":2}`)))
+
+	assert.Equal(t, map[string]interface{}{`PollCount{agent="a1",host="web01"}`: int64(7)}, buff)
+}
+
+func TestMetricsServer_GetRange(t *testing.T) {
+	ms := NewMetricsServer(config.Config{HistoryDepth: 10, HistoryRetention: time.Hour}, log.Default())
+	ctx := context.Background()
+	ms.Storage.Rewrite(ctx, `Alloc{agent="a1"}`, 1.5)
+	ms.Storage.Append(ctx, "PollCount", 2)
+	ms.Storage.Append(ctx, "PollCount", 3)
+
+	resp, err := ms.GetRange(ctx, &pb.RangeRequest{Id: `Alloc{agent="a1"}`})
+	require.NoError(t, err)
+	require.Len(t, resp.Samples, 1)
+	assert.Equal(t, 1.5, resp.Samples[0].Value)
+	assert.NotZero(t, resp.Samples[0].Timestamp)
+
+	resp, err = ms.GetRange(ctx, &pb.RangeRequest{Id: "PollCount", To: time.Now().Add(time.Minute).UnixMilli()})
+	require.NoError(t, err)
+	require.Len(t, resp.Samples, 2)
+	assert.Equal(t, float64(5), resp.Samples[1].Value)
+
+	resp, err = ms.GetRange(ctx, &pb.RangeRequest{Id: "PollCount", From: 1000, To: 2000})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Samples)
+
+	_, err = ms.GetRange(ctx, &pb.RangeRequest{Id: ""})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = ms.GetRange(ctx, &pb.RangeRequest{Id: "PollCount", From: 2000, To: 1000})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestMetricsServer_Query(t *testing.T) {
+	ms := NewMetricsServer(config.Config{}, log.Default())
+	ctx := context.Background()
+	ms.Storage.Rewrite(ctx, `HeapInuse{agent="a1"}`, 30)
+	ms.Storage.Rewrite(ctx, `HeapSys{agent="a1"}`, 60)
+
+	resp, err := ms.Query(ctx, &pb.QueryRequest{Query: "HeapInuse / HeapSys"})
+	require.NoError(t, err)
+	assert.Equal(t, "vector", resp.Type)
+	require.Len(t, resp.Series, 1)
+	assert.Equal(t, map[string]string{"agent": "a1"}, resp.Series[0].Labels)
+	assert.Equal(t, 0.5, resp.Series[0].Value)
+
+	resp, err = ms.Query(ctx, &pb.QueryRequest{Query: `scalar(HeapSys{agent="a1"}) * 2`})
+	require.NoError(t, err)
+	assert.Equal(t, "scalar", resp.Type)
+	assert.Equal(t, float64(120), resp.Scalar)
+
+	_, err = ms.Query(ctx, &pb.QueryRequest{Query: "HeapInuse /"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestMetricsServer_Silences(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMetricsServer(config.Config{}, log.Default())
+	_, err := ms.AddSilence(ctx, &pb.Silence{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	ms.Silences, err = alerts.NewSilenceStore("")
+	require.NoError(t, err)
+	added, err := ms.AddSilence(ctx, &pb.Silence{
+		Matchers:   []*pb.SilenceMatcher{{Name: "host", Op: "=~", Value: "db.*"}},
+		Recurrence: &pb.Recurrence{Weekdays: []string{"sat", "sun"}, Start: "01:00", Duration: 3600},
+		Comment:    "backup",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, added.Id)
+	assert.Equal(t, alerts.SilenceActive, added.Status)
+	assert.Equal(t, int64(0), added.EndsAt)
+
+	_, err = ms.AddSilence(ctx, &pb.Silence{Matchers: []*pb.SilenceMatcher{{Name: "agent", Value: "a1"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resp, err := ms.ListSilences(ctx, &pb.ListSilencesRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Silences, 1)
+	assert.Equal(t, int64(3600), resp.Silences[0].Recurrence.Duration)
+	assert.Equal(t, "backup", resp.Silences[0].Comment)
+
+	_, err = ms.ExpireSilence(ctx, &pb.ExpireSilenceRequest{Id: added.Id})
+	require.NoError(t, err)
+	resp, err = ms.ListSilences(ctx, &pb.ListSilencesRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, alerts.SilenceExpired, resp.Silences[0].Status)
+	_, err = ms.ExpireSilence(ctx, &pb.ExpireSilenceRequest{Id: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
 func TestMetricsServer_isTrustedAddr(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -498,3 +660,114 @@ YwFAIVfOBFwuenyO1cI5dw5sW05PyeN3HxflJxV0Icg+jxnICfKyh3HSQXdL4Fwp
 		assert.Nil(t, err1)
 	})
 }
+
+func TestMetricsServer_UnaryInterceptor(t *testing.T) {
+	otlpInfo := &grpc.UnaryServerInfo{FullMethod: "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"}
+	tests := []struct {
+		name    string
+		info    *grpc.UnaryServerInfo
+		md      metadata.MD
+		conf    config.Config
+		wantErr bool
+	}{
+		{
+			name: "otlp trusted",
+			info: otlpInfo,
+			md:   metadata.Pairs("X-Real-IP", "192.168.0.1"),
+			conf: config.Config{TrustedSubnet: "192.168.0.0/24"},
+		},
+		{
+			name:    "otlp untrusted",
+			info:    otlpInfo,
+			md:      metadata.Pairs("X-Real-IP", "192.168.1.1"),
+			conf:    config.Config{TrustedSubnet: "192.168.0.0/24"},
+			wantErr: true,
+		},
+		{
+			name:    "otlp without header",
+			info:    otlpInfo,
+			md:      metadata.MD{},
+			conf:    config.Config{TrustedSubnet: "192.168.0.0/24"},
+			wantErr: true,
+		},
+		{
+			name: "otlp without subnet",
+			info: otlpInfo,
+			md:   metadata.MD{},
+			conf: config.Config{},
+		},
+		{
+			name:    "add silence untrusted",
+			info:    &grpc.UnaryServerInfo{FullMethod: pb.Metrics_AddSilence_FullMethodName},
+			md:      metadata.Pairs("X-Real-IP", "192.168.1.1"),
+			conf:    config.Config{TrustedSubnet: "192.168.0.0/24"},
+			wantErr: true,
+		},
+		{
+			name:    "add silence without header",
+			info:    &grpc.UnaryServerInfo{FullMethod: pb.Metrics_AddSilence_FullMethodName},
+			md:      metadata.MD{},
+			conf:    config.Config{},
+			wantErr: true,
+		},
+		{
+			name: "other method",
+			info: &grpc.UnaryServerInfo{FullMethod: "/pmetrics.Metrics/ReportMetric"},
+			md:   metadata.MD{},
+			conf: config.Config{TrustedSubnet: "192.168.0.0/24"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ms := NewMetricsServer(test.conf, log.Default())
+			ctx := metadata.NewIncomingContext(context.Background(), test.md)
+			called := false
+			_, err := ms.UnaryInterceptor(ctx, nil, test.info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			})
+			if test.wantErr {
+				assert.Equal(t, codes.PermissionDenied, status.Code(err))
+				assert.False(t, called)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, called)
+			}
+		})
+	}
+}
+
+// throttledStorage - storage which can't keep up with writing values
+type throttledStorage struct {
+	types.Repository
+}
+
+func (throttledStorage) Throttle() error { return errors.New("queue is full") }
+
+func TestMetricsServer_throttle(t *testing.T) {
+	ms := NewMetricsServer(config.Config{}, log.Default())
+	ms.Storage = throttledStorage{ms.Storage}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+
+	_, err := ms.UnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: pb.Metrics_ReportMetric_FullMethodName}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = ms.UnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = ms.UnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: pb.Metrics_GetRange_FullMethodName}, handler)
+	assert.NoError(t, err, "reading isn't throttled")
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("X-Real-IP", "192.168.0.1"))
+	err = ms.StreamInterceptor(nil, testStream{ctx: ctx}, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error { return nil })
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestMetricsServer_StreamInterceptor(t *testing.T) {
+	handler := func(srv interface{}, stream grpc.ServerStream) error { return nil }
+	ms := NewMetricsServer(config.Config{}, log.Default())
+	// stream without X-Real-IP is rejected even without TrustedSubnet
+	err := ms.StreamInterceptor(nil, testStream{ctx: context.Background()}, &grpc.StreamServerInfo{}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("X-Real-IP", "192.168.0.1"))
+	err = ms.StreamInterceptor(nil, testStream{ctx: ctx}, &grpc.StreamServerInfo{}, handler)
+	assert.NoError(t, err)
+}
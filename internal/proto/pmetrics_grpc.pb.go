@@ -23,6 +23,12 @@ const (
 	Metrics_ReportEncMetric_FullMethodName  = "/pmetrics.Metrics/ReportEncMetric"
 	Metrics_ReportMetrics_FullMethodName    = "/pmetrics.Metrics/ReportMetrics"
 	Metrics_ReportEncMetrics_FullMethodName = "/pmetrics.Metrics/ReportEncMetrics"
+	Metrics_ListAgents_FullMethodName       = "/pmetrics.Metrics/ListAgents"
+	Metrics_GetRange_FullMethodName         = "/pmetrics.Metrics/GetRange"
+	Metrics_Query_FullMethodName            = "/pmetrics.Metrics/Query"
+	Metrics_ListSilences_FullMethodName     = "/pmetrics.Metrics/ListSilences"
+	Metrics_AddSilence_FullMethodName       = "/pmetrics.Metrics/AddSilence"
+	Metrics_ExpireSilence_FullMethodName    = "/pmetrics.Metrics/ExpireSilence"
 )
 
 // MetricsClient is the client API for Metrics service.
@@ -33,6 +39,12 @@ type MetricsClient interface {
 	ReportEncMetric(ctx context.Context, in *EncMetricRequest, opts ...grpc.CallOption) (*MetricResponse, error)
 	ReportMetrics(ctx context.Context, opts ...grpc.CallOption) (Metrics_ReportMetricsClient, error)
 	ReportEncMetrics(ctx context.Context, opts ...grpc.CallOption) (Metrics_ReportEncMetricsClient, error)
+	ListAgents(ctx context.Context, in *ListAgentsRequest, opts ...grpc.CallOption) (*ListAgentsResponse, error)
+	GetRange(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*RangeResponse, error)
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+	ListSilences(ctx context.Context, in *ListSilencesRequest, opts ...grpc.CallOption) (*ListSilencesResponse, error)
+	AddSilence(ctx context.Context, in *Silence, opts ...grpc.CallOption) (*Silence, error)
+	ExpireSilence(ctx context.Context, in *ExpireSilenceRequest, opts ...grpc.CallOption) (*ExpireSilenceResponse, error)
 }
 
 type metricsClient struct {
@@ -129,6 +141,60 @@ func (x *metricsReportEncMetricsClient) CloseAndRecv() (*MetricResponse, error)
 	return m, nil
 }
 
+func (c *metricsClient) ListAgents(ctx context.Context, in *ListAgentsRequest, opts ...grpc.CallOption) (*ListAgentsResponse, error) {
+	out := new(ListAgentsResponse)
+	err := c.cc.Invoke(ctx, Metrics_ListAgents_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) GetRange(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*RangeResponse, error) {
+	out := new(RangeResponse)
+	err := c.cc.Invoke(ctx, Metrics_GetRange_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, Metrics_Query_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) ListSilences(ctx context.Context, in *ListSilencesRequest, opts ...grpc.CallOption) (*ListSilencesResponse, error) {
+	out := new(ListSilencesResponse)
+	err := c.cc.Invoke(ctx, Metrics_ListSilences_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) AddSilence(ctx context.Context, in *Silence, opts ...grpc.CallOption) (*Silence, error) {
+	out := new(Silence)
+	err := c.cc.Invoke(ctx, Metrics_AddSilence_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) ExpireSilence(ctx context.Context, in *ExpireSilenceRequest, opts ...grpc.CallOption) (*ExpireSilenceResponse, error) {
+	out := new(ExpireSilenceResponse)
+	err := c.cc.Invoke(ctx, Metrics_ExpireSilence_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsServer is the server API for Metrics service.
 // All implementations must embed UnimplementedMetricsServer
 // for forward compatibility
@@ -137,6 +203,12 @@ type MetricsServer interface {
 	ReportEncMetric(context.Context, *EncMetricRequest) (*MetricResponse, error)
 	ReportMetrics(Metrics_ReportMetricsServer) error
 	ReportEncMetrics(Metrics_ReportEncMetricsServer) error
+	ListAgents(context.Context, *ListAgentsRequest) (*ListAgentsResponse, error)
+	GetRange(context.Context, *RangeRequest) (*RangeResponse, error)
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+	ListSilences(context.Context, *ListSilencesRequest) (*ListSilencesResponse, error)
+	AddSilence(context.Context, *Silence) (*Silence, error)
+	ExpireSilence(context.Context, *ExpireSilenceRequest) (*ExpireSilenceResponse, error)
 	mustEmbedUnimplementedMetricsServer()
 }
 
@@ -156,6 +228,24 @@ func (UnimplementedMetricsServer) ReportMetrics(Metrics_ReportMetricsServer) err
 func (UnimplementedMetricsServer) ReportEncMetrics(Metrics_ReportEncMetricsServer) error {
 	return status.Errorf(codes.Unimplemented, "method ReportEncMetrics not implemented")
 }
+func (UnimplementedMetricsServer) ListAgents(context.Context, *ListAgentsRequest) (*ListAgentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAgents not implemented")
+}
+func (UnimplementedMetricsServer) GetRange(context.Context, *RangeRequest) (*RangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRange not implemented")
+}
+func (UnimplementedMetricsServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedMetricsServer) ListSilences(context.Context, *ListSilencesRequest) (*ListSilencesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSilences not implemented")
+}
+func (UnimplementedMetricsServer) AddSilence(context.Context, *Silence) (*Silence, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddSilence not implemented")
+}
+func (UnimplementedMetricsServer) ExpireSilence(context.Context, *ExpireSilenceRequest) (*ExpireSilenceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExpireSilence not implemented")
+}
 func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
 
 // UnsafeMetricsServer may be embedded to opt out of forward compatibility for this service.
@@ -257,6 +347,114 @@ func (x *metricsReportEncMetricsServer) Recv() (*EncMetricRequest, error) {
 	return m, nil
 }
 
+func _Metrics_ListAgents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAgentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).ListAgents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_ListAgents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).ListAgents(ctx, req.(*ListAgentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_GetRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_GetRange_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetRange(ctx, req.(*RangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_ListSilences_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSilencesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).ListSilences(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_ListSilences_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).ListSilences(ctx, req.(*ListSilencesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_AddSilence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Silence)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).AddSilence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_AddSilence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).AddSilence(ctx, req.(*Silence))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_ExpireSilence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpireSilenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).ExpireSilence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_ExpireSilence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).ExpireSilence(ctx, req.(*ExpireSilenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -272,6 +470,30 @@ var Metrics_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ReportEncMetric",
 			Handler:    _Metrics_ReportEncMetric_Handler,
 		},
+		{
+			MethodName: "ListAgents",
+			Handler:    _Metrics_ListAgents_Handler,
+		},
+		{
+			MethodName: "GetRange",
+			Handler:    _Metrics_GetRange_Handler,
+		},
+		{
+			MethodName: "Query",
+			Handler:    _Metrics_Query_Handler,
+		},
+		{
+			MethodName: "ListSilences",
+			Handler:    _Metrics_ListSilences_Handler,
+		},
+		{
+			MethodName: "AddSilence",
+			Handler:    _Metrics_AddSilence_Handler,
+		},
+		{
+			MethodName: "ExpireSilence",
+			Handler:    _Metrics_ExpireSilence_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
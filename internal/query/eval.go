@@ -0,0 +1,586 @@
+// Часть модуля query содержит вычисление выражения по текущим
+// значениям метрик хранилища и истории их значений.
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/hrapovd1/pmetrics/internal/usecase"
+)
+
+// Value результат вычисления выражения, Scalar или Vector
+type Value interface {
+	Type() string
+}
+
+// Scalar числовое значение
+type Scalar float64
+
+// Series значение временного ряда, после арифметических операций
+// и функций имя метрики не сохраняется
+type Series struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Vector набор значений временных рядов на момент вычисления
+type Vector []Series
+
+// matrix история значений временных рядов за интервал rng,
+// допустима только как аргумент функции
+type matrix struct {
+	rng    time.Duration
+	series []rangeSeries
+}
+
+// rangeSeries история значений одного временного ряда
+type rangeSeries struct {
+	name    string
+	labels  map[string]string
+	samples []types.Sample
+}
+
+func (Scalar) Type() string { return "scalar" }
+func (Vector) Type() string { return "vector" }
+func (matrix) Type() string { return "matrix" }
+
+// MarshalJSON кодирует значение, бесконечность и NaN передаются строкой
+func (s Scalar) MarshalJSON() ([]byte, error) {
+	return jsonFloat(float64(s)), nil
+}
+
+// MarshalJSON кодирует значение временного ряда в виде
+// {"id": имя, "labels": метки, "value": значение}
+func (s Series) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID     string            `json:"id,omitempty"`
+		Labels map[string]string `json:"labels,omitempty"`
+		Value  json.RawMessage   `json:"value"`
+	}{ID: s.Name, Labels: s.Labels, Value: jsonFloat(s.Value)})
+}
+
+// jsonFloat возвращает число JSON или строку для значений,
+// которые нельзя передать числом
+func jsonFloat(val float64) json.RawMessage {
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return json.RawMessage(strconv.Quote(strconv.FormatFloat(val, 'g', -1, 64)))
+	}
+	return json.RawMessage(strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+// Eval разбирает и вычисляет выражение на текущий момент, выборки
+// без интервала используют текущие значения counter и gauge метрик
+// repo, выборки с интервалом - историю значений
+func Eval(ctx context.Context, repo types.Repository, input string) (Value, error) {
+	expr, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	return EvalExpr(ctx, repo, expr)
+}
+
+// EvalExpr вычисляет разобранное выражение на текущий момент
+func EvalExpr(ctx context.Context, repo types.Repository, expr Expr) (Value, error) {
+	ev := &evaluator{ctx: ctx, repo: repo, now: time.Now()}
+	val, err := ev.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	switch val := val.(type) {
+	case matrix:
+		return nil, errors.New("range vector must be used as a function argument")
+	case Vector:
+		sort.Slice(val, func(i, j int) bool {
+			if val[i].Name != val[j].Name {
+				return val[i].Name < val[j].Name
+			}
+			return types.LabelsString(val[i].Labels) < types.LabelsString(val[j].Labels)
+		})
+	}
+	return val, nil
+}
+
+// evaluator состояние вычисления выражения
+type evaluator struct {
+	ctx  context.Context
+	repo types.Repository
+	now  time.Time
+	all  map[string]interface{}
+}
+
+func (ev *evaluator) eval(expr Expr) (Value, error) {
+	switch expr := expr.(type) {
+	case numberLit:
+		return Scalar(expr.val), nil
+	case unary:
+		val, err := ev.eval(expr.expr)
+		if err != nil {
+			return nil, err
+		}
+		return apply(val, func(v float64) float64 { return -v })
+	case selector:
+		return ev.evalSelector(expr)
+	case call:
+		return ev.evalCall(expr)
+	case aggregation:
+		return ev.evalAggregation(expr)
+	case binary:
+		return ev.evalBinary(expr)
+	}
+	return nil, fmt.Errorf("unsupported expression %s", expr)
+}
+
+// series возвращает имена, метки и значения counter и gauge
+// временных рядов, подходящих под выборку
+func (ev *evaluator) series(sel selector) []rangeSeries {
+	if ev.all == nil {
+		ev.all = ev.repo.GetAll(ev.ctx)
+	}
+	keys := make([]string, 0)
+	for k := range ev.all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]rangeSeries, 0)
+	for _, k := range keys {
+		var value float64
+		switch val := ev.all[k].(type) {
+		case int64:
+			value = float64(val)
+		case float64:
+			value = val
+		default:
+			continue
+		}
+		name, labels, err := types.ParseSeriesKey(k)
+		if err != nil || !sel.matches(name, labels) {
+			continue
+		}
+		out = append(out, rangeSeries{
+			name:    name,
+			labels:  labels,
+			samples: []types.Sample{{Timestamp: ev.now, Value: value}},
+		})
+	}
+	return out
+}
+
+func (ev *evaluator) evalSelector(sel selector) (Value, error) {
+	series := ev.series(sel)
+	if sel.rng == 0 {
+		out := make(Vector, 0, len(series))
+		for _, s := range series {
+			out = append(out, Series{Name: s.name, Labels: s.labels, Value: s.samples[0].Value})
+		}
+		return out, nil
+	}
+	ranger, ok := ev.repo.(types.Ranger)
+	if !ok {
+		return nil, usecase.ErrNoHistory
+	}
+	out := matrix{rng: sel.rng, series: make([]rangeSeries, 0, len(series))}
+	for _, s := range series {
+		s.samples = ranger.Range(ev.ctx, types.SeriesKey(s.name, s.labels), ev.now.Add(-sel.rng), ev.now)
+		if len(s.samples) > 0 {
+			out.series = append(out.series, s)
+		}
+	}
+	return out, nil
+}
+
+// matches проверяет имя и метки временного ряда,
+// имя метрики доступно и как метка __name__
+func (sel selector) matches(name string, labels map[string]string) bool {
+	if sel.name != "" && sel.name != name {
+		return false
+	}
+	for _, m := range sel.matchers {
+		value := labels[m.name]
+		if m.name == "__name__" {
+			value = name
+		}
+		var ok bool
+		switch m.op {
+		case "=":
+			ok = value == m.value
+		case "!=":
+			ok = value != m.value
+		case "=~":
+			ok = m.re.MatchString(value)
+		case "!~":
+			ok = !m.re.MatchString(value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// rangeFuncs функции истории значений временного ряда за интервал
+var rangeFuncs = map[string]func(samples []types.Sample, rng time.Duration) (float64, bool){
+	"rate": func(samples []types.Sample, rng time.Duration) (float64, bool) {
+		if len(samples) < 2 {
+			return 0, false
+		}
+		return usecase.Increase(samples[0].Value, values(samples[1:])) / rng.Seconds(), true
+	},
+	"increase": func(samples []types.Sample, _ time.Duration) (float64, bool) {
+		if len(samples) < 2 {
+			return 0, false
+		}
+		return usecase.Increase(samples[0].Value, values(samples[1:])), true
+	},
+	"avg_over_time": func(samples []types.Sample, _ time.Duration) (float64, bool) {
+		return sum(samples) / float64(len(samples)), true
+	},
+	"sum_over_time": func(samples []types.Sample, _ time.Duration) (float64, bool) {
+		return sum(samples), true
+	},
+	"min_over_time": func(samples []types.Sample, _ time.Duration) (float64, bool) {
+		out := samples[0].Value
+		for _, s := range samples[1:] {
+			out = math.Min(out, s.Value)
+		}
+		return out, true
+	},
+	"max_over_time": func(samples []types.Sample, _ time.Duration) (float64, bool) {
+		out := samples[0].Value
+		for _, s := range samples[1:] {
+			out = math.Max(out, s.Value)
+		}
+		return out, true
+	},
+	"count_over_time": func(samples []types.Sample, _ time.Duration) (float64, bool) {
+		return float64(len(samples)), true
+	},
+	"last_over_time": func(samples []types.Sample, _ time.Duration) (float64, bool) {
+		return samples[len(samples)-1].Value, true
+	},
+}
+
+// mathFuncs скалярные функции, применяемые к каждому значению
+var mathFuncs = map[string]func(float64) float64{
+	"abs":   math.Abs,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"round": math.Round,
+	"sqrt":  math.Sqrt,
+	"exp":   math.Exp,
+	"ln":    math.Log,
+	"log2":  math.Log2,
+	"log10": math.Log10,
+}
+
+func (ev *evaluator) evalCall(c call) (Value, error) {
+	args := make([]Value, 0, len(c.args))
+	for _, arg := range c.args {
+		val, err := ev.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, val)
+	}
+
+	if fn, ok := rangeFuncs[c.fn]; ok {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument", c.fn)
+		}
+		m, ok := args[0].(matrix)
+		if !ok {
+			return nil, fmt.Errorf("%s expects range vector argument", c.fn)
+		}
+		out := make(Vector, 0, len(m.series))
+		for _, s := range m.series {
+			if value, ok := fn(s.samples, m.rng); ok {
+				out = append(out, Series{Labels: s.labels, Value: value})
+			}
+		}
+		return out, nil
+	}
+	if fn, ok := mathFuncs[c.fn]; ok {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument", c.fn)
+		}
+		return apply(args[0], fn)
+	}
+
+	switch c.fn {
+	case "clamp_min", "clamp_max":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments", c.fn)
+		}
+		limit, ok := args[1].(Scalar)
+		if !ok {
+			return nil, fmt.Errorf("%s expects scalar second argument", c.fn)
+		}
+		clamp := math.Max
+		if c.fn == "clamp_max" {
+			clamp = math.Min
+		}
+		return apply(args[0], func(v float64) float64 { return clamp(v, float64(limit)) })
+	case "scalar":
+		if len(args) != 1 {
+			return nil, errors.New("scalar expects 1 argument")
+		}
+		vec, ok := args[0].(Vector)
+		if !ok {
+			return nil, errors.New("scalar expects instant vector argument")
+		}
+		if len(vec) != 1 {
+			return Scalar(math.NaN()), nil
+		}
+		return Scalar(vec[0].Value), nil
+	case "vector":
+		if len(args) != 1 {
+			return nil, errors.New("vector expects 1 argument")
+		}
+		s, ok := args[0].(Scalar)
+		if !ok {
+			return nil, errors.New("vector expects scalar argument")
+		}
+		return Vector{{Labels: map[string]string{}, Value: float64(s)}}, nil
+	case "predict_linear", "predict_holt":
+		// третий аргумент predict_holt - период сезонности в секундах
+		if c.fn == "predict_holt" && len(args) != 2 && len(args) != 3 {
+			return nil, errors.New("predict_holt expects 2 or 3 arguments")
+		}
+		if c.fn == "predict_linear" && len(args) != 2 {
+			return nil, errors.New("predict_linear expects 2 arguments")
+		}
+		m, ok := args[0].(matrix)
+		if !ok {
+			return nil, fmt.Errorf("%s expects range vector first argument", c.fn)
+		}
+		seconds, ok := args[1].(Scalar)
+		if !ok {
+			return nil, fmt.Errorf("%s expects scalar second argument", c.fn)
+		}
+		var season time.Duration
+		if len(args) == 3 {
+			period, ok := args[2].(Scalar)
+			if !ok || period <= 0 {
+				return nil, fmt.Errorf("%s expects positive scalar season", c.fn)
+			}
+			season = time.Duration(float64(period) * float64(time.Second))
+		}
+		at := ev.now.Add(time.Duration(float64(seconds) * float64(time.Second)))
+		out := make(Vector, 0, len(m.series))
+		for _, s := range m.series {
+			var tr usecase.Trend
+			var err error
+			switch {
+			case c.fn == "predict_linear":
+				tr, err = usecase.LinearTrend(s.samples)
+			case season > 0:
+				tr, err = usecase.HoltWintersTrend(s.samples, usecase.DefaultHoltAlpha, usecase.DefaultHoltBeta, usecase.DefaultHoltGamma, season)
+			default:
+				tr, err = usecase.HoltTrend(s.samples, usecase.DefaultHoltAlpha, usecase.DefaultHoltBeta)
+			}
+			// по ряду из одного значения или короче двух периодов
+			// сезонности тренд не строится
+			if err == nil {
+				out = append(out, Series{Labels: s.labels, Value: tr.ValueAt(at)})
+			}
+		}
+		return out, nil
+	case "time":
+		if len(args) != 0 {
+			return nil, errors.New("time expects no arguments")
+		}
+		return Scalar(float64(ev.now.UnixNano()) / float64(time.Second)), nil
+	}
+	return nil, fmt.Errorf("unknown function %s", c.fn)
+}
+
+func (ev *evaluator) evalAggregation(agg aggregation) (Value, error) {
+	val, err := ev.eval(agg.expr)
+	if err != nil {
+		return nil, err
+	}
+	vec, ok := val.(Vector)
+	if !ok {
+		return nil, fmt.Errorf("%s expects instant vector argument", agg.op)
+	}
+
+	type group struct {
+		labels map[string]string
+		values []float64
+	}
+	groups := make(map[string]*group)
+	order := make([]string, 0)
+	for _, s := range vec {
+		labels := groupLabels(s.Labels, agg.grouping, agg.without)
+		key := types.LabelsString(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.values = append(g.values, s.Value)
+	}
+
+	out := make(Vector, 0, len(groups))
+	for _, key := range order {
+		g := groups[key]
+		var value float64
+		switch agg.op {
+		case "sum":
+			for _, v := range g.values {
+				value += v
+			}
+		case "avg":
+			for _, v := range g.values {
+				value += v
+			}
+			value /= float64(len(g.values))
+		case "min":
+			value = g.values[0]
+			for _, v := range g.values[1:] {
+				value = math.Min(value, v)
+			}
+		case "max":
+			value = g.values[0]
+			for _, v := range g.values[1:] {
+				value = math.Max(value, v)
+			}
+		case "count":
+			value = float64(len(g.values))
+		}
+		out = append(out, Series{Labels: g.labels, Value: value})
+	}
+	return out, nil
+}
+
+// groupLabels возвращает метки группы: перечисленные в grouping
+// или, для without, все кроме перечисленных
+func groupLabels(labels map[string]string, grouping []string, without bool) map[string]string {
+	out := make(map[string]string)
+	if without {
+		for k, v := range labels {
+			out[k] = v
+		}
+		for _, name := range grouping {
+			delete(out, name)
+		}
+		return out
+	}
+	for _, name := range grouping {
+		if v, ok := labels[name]; ok {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// binaryOps арифметические операции
+var binaryOps = map[string]func(a, b float64) float64{
+	"+": func(a, b float64) float64 { return a + b },
+	"-": func(a, b float64) float64 { return a - b },
+	"*": func(a, b float64) float64 { return a * b },
+	"/": func(a, b float64) float64 { return a / b },
+	"%": math.Mod,
+	"^": math.Pow,
+}
+
+// evalBinary вычисляет операцию, значения двух векторов сопоставляются
+// по совпадающим меткам без учета имени метрики
+func (ev *evaluator) evalBinary(b binary) (Value, error) {
+	lhs, err := ev.eval(b.lhs)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := ev.eval(b.rhs)
+	if err != nil {
+		return nil, err
+	}
+	op := binaryOps[b.op]
+
+	switch l := lhs.(type) {
+	case Scalar:
+		switch r := rhs.(type) {
+		case Scalar:
+			return Scalar(op(float64(l), float64(r))), nil
+		case Vector:
+			return apply(r, func(v float64) float64 { return op(float64(l), v) })
+		}
+	case Vector:
+		switch r := rhs.(type) {
+		case Scalar:
+			return apply(l, func(v float64) float64 { return op(v, float64(r)) })
+		case Vector:
+			return matchVectors(l, r, op)
+		}
+	}
+	return nil, fmt.Errorf("operator %s expects scalar or instant vector operands", b.op)
+}
+
+// matchVectors выполняет операцию над значениями векторов с одинаковыми метками
+func matchVectors(lhs, rhs Vector, op func(a, b float64) float64) (Value, error) {
+	right := make(map[string]Series, len(rhs))
+	for _, s := range rhs {
+		key := types.LabelsString(s.Labels)
+		if _, ok := right[key]; ok {
+			return nil, fmt.Errorf("many series with labels {%s} on the right side", key)
+		}
+		right[key] = s
+	}
+	out := make(Vector, 0)
+	seen := make(map[string]struct{}, len(lhs))
+	for _, s := range lhs {
+		key := types.LabelsString(s.Labels)
+		if _, ok := seen[key]; ok {
+			return nil, fmt.Errorf("many series with labels {%s} on the left side", key)
+		}
+		seen[key] = struct{}{}
+		r, ok := right[key]
+		if !ok {
+			continue
+		}
+		out = append(out, Series{Labels: s.Labels, Value: op(s.Value, r.Value)})
+	}
+	return out, nil
+}
+
+// apply применяет функцию к скаляру или каждому значению вектора
+func apply(val Value, fn func(float64) float64) (Value, error) {
+	switch val := val.(type) {
+	case Scalar:
+		return Scalar(fn(float64(val))), nil
+	case Vector:
+		out := make(Vector, 0, len(val))
+		for _, s := range val {
+			out = append(out, Series{Labels: s.Labels, Value: fn(s.Value)})
+		}
+		return out, nil
+	}
+	return nil, errors.New("range vector must be used as a function argument")
+}
+
+// values возвращает значения истории
+func values(samples []types.Sample) []float64 {
+	out := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		out = append(out, s.Value)
+	}
+	return out
+}
+
+// sum возвращает сумму значений истории
+func sum(samples []types.Sample) float64 {
+	var out float64
+	for _, s := range samples {
+		out += s.Value
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/hrapovd1/pmetrics/internal/usecase"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// historyStub хранилище с заданной историей значений рядов
+type historyStub struct {
+	*storage.MemStorage
+	history map[string][]float64
+}
+
+func (hs historyStub) Range(ctx context.Context, key string, from, to time.Time) []types.Sample {
+	out := make([]types.Sample, 0)
+	for i, v := range hs.history[key] {
+		out = append(out, types.Sample{Timestamp: from.Add(time.Duration(i) * time.Second), Value: v})
+	}
+	return out
+}
+
+// noHistoryRepo хранилище без истории значений
+type noHistoryRepo struct {
+	types.Repository
+}
+
+func testRepo() historyStub {
+	return historyStub{
+		MemStorage: storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+			`HeapInuse{agent="a1",host="web01"}`: float64(30),
+			`HeapSys{agent="a1",host="web01"}`:   float64(60),
+			`HeapInuse{agent="a2",host="web01"}`: float64(10),
+			`HeapSys{agent="a2",host="web01"}`:   float64(40),
+			`HeapInuse{agent="a3",host="db01"}`:  float64(5),
+			`PollCount{agent="a1",host="web01"}`: int64(25),
+			`PollCount{agent="a2",host="web01"}`: int64(8),
+			"gc_pause":                           types.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1},
+		})),
+		history: map[string][]float64{
+			`PollCount{agent="a1",host="web01"}`: {10, 15, 25},
+			`PollCount{agent="a2",host="web01"}`: {6, 2, 8},
+			`HeapInuse{agent="a1",host="web01"}`: {20, 40, 30},
+		},
+	}
+}
+
+func TestEval(t *testing.T) {
+	a1 := map[string]string{"agent": "a1", "host": "web01"}
+	a2 := map[string]string{"agent": "a2", "host": "web01"}
+	a3 := map[string]string{"agent": "a3", "host": "db01"}
+	tests := []struct {
+		name  string
+		input string
+		want  Value
+	}{
+		{name: "scalar", input: "2 * (3 + 1) ^ 2 % 7", want: Scalar(4)},
+		{name: "unary minus", input: "-2 ^ 2", want: Scalar(-4)},
+		{name: "selector", input: `HeapInuse{host="web01"}`, want: Vector{
+			{Name: "HeapInuse", Labels: a1, Value: 30},
+			{Name: "HeapInuse", Labels: a2, Value: 10},
+		}},
+		{name: "regexp selector", input: `{__name__=~"Heap.*", agent!~"a[12]"}`, want: Vector{
+			{Name: "HeapInuse", Labels: a3, Value: 5},
+		}},
+		{name: "counter selector", input: `PollCount{agent="a2"}`, want: Vector{
+			{Name: "PollCount", Labels: a2, Value: 8},
+		}},
+		{name: "histogram skipped", input: "gc_pause", want: Vector{}},
+		{name: "ratio", input: "HeapInuse / HeapSys", want: Vector{
+			{Labels: a1, Value: 0.5},
+			{Labels: a2, Value: 0.25},
+		}},
+		{name: "vector and scalar", input: `100 * HeapInuse{agent="a1"} - 1`, want: Vector{
+			{Labels: a1, Value: 2999},
+		}},
+		{name: "sum", input: "sum(HeapInuse)", want: Vector{{Labels: map[string]string{}, Value: 45}}},
+		{name: "sum by", input: "sum by (host) (HeapInuse)", want: Vector{
+			{Labels: map[string]string{"host": "db01"}, Value: 5},
+			{Labels: map[string]string{"host": "web01"}, Value: 40},
+		}},
+		{name: "avg without", input: "avg without (agent) (HeapInuse)", want: Vector{
+			{Labels: map[string]string{"host": "db01"}, Value: 5},
+			{Labels: map[string]string{"host": "web01"}, Value: 20},
+		}},
+		{name: "count min max", input: "count(HeapInuse) + min(HeapInuse) * max(HeapInuse)", want: Vector{
+			{Labels: map[string]string{}, Value: 153},
+		}},
+		{name: "rate", input: "rate(PollCount[10s])", want: Vector{
+			{Labels: a1, Value: 1.5},
+			{Labels: a2, Value: 0.8},
+		}},
+		{name: "increase", input: `increase(PollCount{agent="a2"}[1m])`, want: Vector{
+			{Labels: a2, Value: 8},
+		}},
+		{name: "fleet rate", input: "sum(rate(PollCount[10s]))", want: Vector{
+			{Labels: map[string]string{}, Value: 2.3},
+		}},
+		{name: "over time", input: "avg_over_time(HeapInuse[1h]) + max_over_time(HeapInuse[1h]) - min_over_time(HeapInuse[1h])", want: Vector{
+			{Labels: a1, Value: 50},
+		}},
+		{name: "count and last over time", input: "count_over_time(HeapInuse[5m]) * last_over_time(HeapInuse[5m]) + sum_over_time(HeapInuse[5m])", want: Vector{
+			{Labels: a1, Value: 180},
+		}},
+		{name: "predict linear", input: "predict_linear(HeapInuse[1h], 2)", want: Vector{
+			{Labels: a1, Value: 18035},
+		}},
+		{name: "predict holt", input: "predict_holt(HeapInuse[1h], 2)", want: Vector{
+			{Labels: a1, Value: 66645},
+		}},
+		{name: "math", input: `sqrt(abs(-HeapSys{agent="a2"} * 10))`, want: Vector{
+			{Labels: a2, Value: 20},
+		}},
+		{name: "clamp", input: `clamp_max(HeapInuse{host="web01"}, 20)`, want: Vector{
+			{Labels: a1, Value: 20},
+			{Labels: a2, Value: 10},
+		}},
+		{name: "scalar function", input: `scalar(HeapSys{agent="a1"}) / 2`, want: Scalar(30)},
+		{name: "vector function", input: "vector(1) + sum(HeapSys)", want: Vector{
+			{Labels: map[string]string{}, Value: 101},
+		}},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Eval(ctx, testRepo(), tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEval_errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "syntax", input: "HeapInuse /"},
+		{name: "range result", input: "HeapInuse[5m]"},
+		{name: "range in arithmetic", input: "HeapInuse[5m] * 2"},
+		{name: "rate of instant vector", input: "rate(PollCount)"},
+		{name: "unknown function", input: "median(HeapInuse)"},
+		{name: "wrong arguments", input: "abs(HeapInuse, 2)"},
+		{name: "clamp by vector", input: "clamp_min(HeapInuse, HeapSys)"},
+		{name: "predict instant vector", input: "predict_linear(HeapInuse, 3600)"},
+		{name: "predict by vector", input: "predict_holt(HeapInuse[1h], HeapSys)"},
+		{name: "predict by zero season", input: "predict_holt(HeapInuse[1h], 2, 0)"},
+		{name: "predict linear season", input: "predict_linear(HeapInuse[1h], 2, 60)"},
+		{name: "aggregate scalar", input: "sum(1)"},
+		{name: "left duplicates", input: `{agent="a1"} / HeapSys`},
+		{name: "right duplicates", input: `HeapSys / {agent="a1"}`},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Eval(ctx, testRepo(), tt.input)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("no history", func(t *testing.T) {
+		_, err := Eval(ctx, noHistoryRepo{testRepo()}, "rate(PollCount[5m])")
+		assert.ErrorIs(t, err, usecase.ErrNoHistory)
+	})
+}
+
+func TestSeries_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Vector{
+		{Name: "Alloc", Labels: map[string]string{"agent": "a1"}, Value: 1.5},
+		{Value: math.Inf(1)},
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"id":"Alloc","labels":{"agent":"a1"},"value":1.5},{"value":"+Inf"}]`, string(data))
+
+	data, err = json.Marshal(Scalar(math.NaN()))
+	require.NoError(t, err)
+	assert.Equal(t, `"NaN"`, string(data))
+}
@@ -0,0 +1,154 @@
+// Модуль query содержит разбор и вычисление выражений
+// языка запросов метрик, упрощенного подмножества PromQL.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenType тип лексемы выражения
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDuration
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokOp      // + - * / % ^
+	tokMatchOp // = != =~ !~
+)
+
+// token лексема выражения
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+// lex разбивает выражение на лексемы, длительность в квадратных
+// скобках возвращается одной лексемой tokDuration
+func lex(input string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		ch := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+			continue
+		case len(tokens) > 0 && tokens[len(tokens)-1].typ == tokLBracket:
+			for i < len(runes) && runes[i] != ']' {
+				i++
+			}
+			tokens = append(tokens, token{typ: tokDuration, val: strings.TrimSpace(string(runes[start:i])), pos: start})
+			continue
+		case isIdentStart(ch):
+			for i < len(runes) && isIdentChar(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{typ: tokIdent, val: string(runes[start:i]), pos: start})
+			continue
+		case ch >= '0' && ch <= '9' || ch == '.':
+			i++
+			for i < len(runes) && isNumberChar(runes[i], runes[i-1]) {
+				i++
+			}
+			tokens = append(tokens, token{typ: tokNumber, val: string(runes[start:i]), pos: start})
+			continue
+		case ch == '"' || ch == '\'':
+			i++
+			for i < len(runes) && runes[i] != ch {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			val, err := unquote(string(runes[start:i]))
+			if err != nil {
+				return nil, fmt.Errorf("wrong string at %d: %w", start, err)
+			}
+			tokens = append(tokens, token{typ: tokString, val: val, pos: start})
+			continue
+		case ch == '=' || ch == '!':
+			i++
+			if i < len(runes) && (runes[i] == '=' || runes[i] == '~') {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" || op == "==" {
+				return nil, fmt.Errorf("unexpected %q at %d", op, start)
+			}
+			tokens = append(tokens, token{typ: tokMatchOp, val: op, pos: start})
+			continue
+		}
+		typ, ok := punctuation[ch]
+		if !ok {
+			return nil, fmt.Errorf("unexpected character %q at %d", ch, start)
+		}
+		tokens = append(tokens, token{typ: typ, val: string(ch), pos: start})
+		i++
+	}
+	return append(tokens, token{typ: tokEOF, pos: len(runes)}), nil
+}
+
+var punctuation = map[rune]tokenType{
+	'(': tokLParen,
+	')': tokRParen,
+	'{': tokLBrace,
+	'}': tokRBrace,
+	'[': tokLBracket,
+	']': tokRBracket,
+	',': tokComma,
+	'+': tokOp,
+	'-': tokOp,
+	'*': tokOp,
+	'/': tokOp,
+	'%': tokOp,
+	'^': tokOp,
+}
+
+// isIdentStart проверяет первый символ имени метрики, метки или функции
+func isIdentStart(ch rune) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' || ch == ':'
+}
+
+// isIdentChar проверяет символ имени, точка допустима для имен
+// метрик, полученных от StatsD и Graphite
+func isIdentChar(ch rune) bool {
+	return isIdentStart(ch) || ch >= '0' && ch <= '9' || ch == '.'
+}
+
+// isNumberChar проверяет символ числа с учетом знака порядка
+func isNumberChar(ch, prev rune) bool {
+	switch {
+	case ch >= '0' && ch <= '9', ch == '.', ch == 'e', ch == 'E':
+		return true
+	case ch == '+' || ch == '-':
+		return prev == 'e' || prev == 'E'
+	}
+	return false
+}
+
+// unquote снимает кавычки со строки в двойных или одинарных кавычках
+func unquote(s string) (string, error) {
+	if s[0] == '\'' {
+		s = `"` + strings.ReplaceAll(strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`), `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(s)
+}
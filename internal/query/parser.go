@@ -0,0 +1,418 @@
+// Часть модуля query содержит разбор выражения в дерево.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Expr узел дерева разобранного выражения
+type Expr interface {
+	String() string
+}
+
+// numberLit числовая константа
+type numberLit struct {
+	val float64
+}
+
+// selector выборка временных рядов по имени и меткам,
+// при rng > 0 выборка истории значений за rng
+type selector struct {
+	name     string
+	matchers []matcher
+	rng      time.Duration
+}
+
+// matcher условие на значение метки
+type matcher struct {
+	name  string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+// call вызов функции
+type call struct {
+	fn   string
+	args []Expr
+}
+
+// aggregation агрегация вектора с группировкой по меткам
+type aggregation struct {
+	op       string
+	grouping []string
+	without  bool
+	expr     Expr
+}
+
+// binary арифметическая операция
+type binary struct {
+	op       string
+	lhs, rhs Expr
+}
+
+// unary унарный минус
+type unary struct {
+	expr Expr
+}
+
+func (n numberLit) String() string { return strconv.FormatFloat(n.val, 'g', -1, 64) }
+
+func (s selector) String() string {
+	out := s.name
+	if len(s.matchers) > 0 {
+		out += "{"
+		for i, m := range s.matchers {
+			if i > 0 {
+				out += ","
+			}
+			out += m.name + m.op + strconv.Quote(m.value)
+		}
+		out += "}"
+	}
+	if s.rng > 0 {
+		out += "[" + s.rng.String() + "]"
+	}
+	return out
+}
+
+func (c call) String() string {
+	out := c.fn + "("
+	for i, arg := range c.args {
+		if i > 0 {
+			out += ", "
+		}
+		out += arg.String()
+	}
+	return out + ")"
+}
+
+func (a aggregation) String() string {
+	out := a.op
+	if a.grouping != nil {
+		kw := " by"
+		if a.without {
+			kw = " without"
+		}
+		out += kw + " ("
+		for i, l := range a.grouping {
+			if i > 0 {
+				out += ", "
+			}
+			out += l
+		}
+		out += ")"
+	}
+	return out + " (" + a.expr.String() + ")"
+}
+
+func (b binary) String() string {
+	return "(" + b.lhs.String() + " " + b.op + " " + b.rhs.String() + ")"
+}
+
+func (u unary) String() string { return "-" + u.expr.String() }
+
+// aggregationOps операторы агрегации вектора
+var aggregationOps = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+// precedence приоритет арифметических операторов
+var precedence = map[string]int{"+": 1, "-": 1, "*": 2, "/": 2, "%": 2, "^": 3}
+
+// parser разбор выражения методом рекурсивного спуска
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse разбирает выражение языка запросов
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.typ != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", tok.val, tok.pos)
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.typ != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// expect возвращает следующую лексему, если она типа typ
+func (p *parser) expect(typ tokenType, what string) (token, error) {
+	tok := p.next()
+	if tok.typ != typ {
+		if tok.typ == tokEOF {
+			return tok, fmt.Errorf("expected %s, got end of query", what)
+		}
+		return tok, fmt.Errorf("expected %s at %d, got %q", what, tok.pos, tok.val)
+	}
+	return tok, nil
+}
+
+// parseBinary разбирает операции с приоритетом не ниже minPrec,
+// возведение в степень правоассоциативно
+func (p *parser) parseBinary(minPrec int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		prec, ok := precedence[tok.val]
+		if tok.typ != tokOp || !ok || prec < minPrec {
+			return lhs, nil
+		}
+		p.next()
+		nextPrec := prec + 1
+		if tok.val == "^" {
+			nextPrec = prec
+		}
+		rhs, err := p.parseBinary(nextPrec)
+		if err != nil {
+			return nil, err
+		}
+		lhs = binary{op: tok.val, lhs: lhs, rhs: rhs}
+	}
+}
+
+// parseUnary разбирает унарные плюс и минус, они связывают
+// слабее возведения в степень: -2^2 = -4
+func (p *parser) parseUnary() (Expr, error) {
+	tok := p.peek()
+	if tok.typ == tokOp && (tok.val == "-" || tok.val == "+") {
+		p.next()
+		expr, err := p.parseBinary(precedence["^"])
+		if err != nil {
+			return nil, err
+		}
+		if tok.val == "-" {
+			return unary{expr: expr}, nil
+		}
+		return expr, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.next()
+	switch tok.typ {
+	case tokNumber:
+		val, err := strconv.ParseFloat(tok.val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("wrong number %q at %d", tok.val, tok.pos)
+		}
+		return numberLit{val: val}, nil
+	case tokLParen:
+		expr, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokLBrace:
+		p.pos--
+		return p.parseSelector("")
+	case tokIdent:
+		next := p.peek()
+		if aggregationOps[tok.val] && (next.typ == tokLParen || next.val == "by" || next.val == "without") {
+			return p.parseAggregation(tok.val)
+		}
+		if next.typ == tokLParen {
+			return p.parseCall(tok.val)
+		}
+		return p.parseSelector(tok.val)
+	case tokEOF:
+		return nil, errors.New("unexpected end of query")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", tok.val, tok.pos)
+}
+
+// parseSelector разбирает метки {name="value",...} и интервал [5m]
+func (p *parser) parseSelector(name string) (Expr, error) {
+	sel := selector{name: name}
+	if p.peek().typ == tokLBrace {
+		p.next()
+		for p.peek().typ != tokRBrace {
+			label, err := p.expect(tokIdent, "label name")
+			if err != nil {
+				return nil, err
+			}
+			op, err := p.expect(tokMatchOp, "label matcher")
+			if err != nil {
+				return nil, err
+			}
+			value, err := p.expect(tokString, "label value")
+			if err != nil {
+				return nil, err
+			}
+			m := matcher{name: label.val, op: op.val, value: value.val}
+			if op.val == "=~" || op.val == "!~" {
+				if m.re, err = regexp.Compile("^(?:" + value.val + ")$"); err != nil {
+					return nil, fmt.Errorf("wrong regexp at %d: %w", value.pos, err)
+				}
+			}
+			sel.matchers = append(sel.matchers, m)
+			if p.peek().typ != tokComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokRBrace, "'}'"); err != nil {
+			return nil, err
+		}
+	}
+	if sel.name == "" && len(sel.matchers) == 0 {
+		return nil, errors.New("selector must have a name or at least one label matcher")
+	}
+	if p.peek().typ == tokLBracket {
+		p.next()
+		tok, err := p.expect(tokDuration, "range duration")
+		if err != nil {
+			return nil, err
+		}
+		if sel.rng, err = parseDuration(tok.val); err != nil {
+			return nil, fmt.Errorf("wrong range at %d: %w", tok.pos, err)
+		}
+		if _, err := p.expect(tokRBracket, "']'"); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// parseCall разбирает аргументы функции
+func (p *parser) parseCall(fn string) (Expr, error) {
+	p.next()
+	c := call{fn: fn}
+	for p.peek().typ != tokRParen {
+		arg, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		if p.peek().typ != tokComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseAggregation разбирает sum by (labels) (expr), группировка
+// может быть указана и после выражения
+func (p *parser) parseAggregation(op string) (Expr, error) {
+	agg := aggregation{op: op}
+	var err error
+	if err = p.parseGrouping(&agg); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+	if agg.expr, err = p.parseBinary(1); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	if agg.grouping == nil {
+		if err = p.parseGrouping(&agg); err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
+
+// parseGrouping разбирает by (labels) или without (labels)
+func (p *parser) parseGrouping(agg *aggregation) error {
+	tok := p.peek()
+	if tok.typ != tokIdent || (tok.val != "by" && tok.val != "without") {
+		return nil
+	}
+	p.next()
+	agg.without = tok.val == "without"
+	agg.grouping = make([]string, 0)
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return err
+	}
+	for p.peek().typ != tokRParen {
+		label, err := p.expect(tokIdent, "label name")
+		if err != nil {
+			return err
+		}
+		agg.grouping = append(agg.grouping, label.val)
+		if p.peek().typ != tokComma {
+			break
+		}
+		p.next()
+	}
+	_, err := p.expect(tokRParen, "')'")
+	return err
+}
+
+// durationUnits единицы интервала выборки истории
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// parseDuration разбирает интервал вида 5m или 1h30m
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, errors.New("empty duration")
+	}
+	var out time.Duration
+	for len(s) > 0 {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		j := i
+		for j < len(s) && (s[j] < '0' || s[j] > '9') {
+			j++
+		}
+		unit, ok := durationUnits[s[i:j]]
+		if i == 0 || !ok {
+			return 0, fmt.Errorf("wrong duration %q", s)
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, err
+		}
+		out += time.Duration(n) * unit
+		s = s[j:]
+	}
+	if out <= 0 {
+		return 0, errors.New("duration must be positive")
+	}
+	return out, nil
+}
@@ -0,0 +1,90 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "HeapInuse / HeapSys", want: "(HeapInuse / HeapSys)"},
+		{input: `Alloc{agent="a1", host!='web01'}`, want: `Alloc{agent="a1",host!="web01"}`},
+		{input: `{__name__=~"Heap.*"}`, want: `{__name__=~"Heap.*"}`},
+		{input: "rate(PollCount[5m])", want: "rate(PollCount[5m0s])"},
+		{input: "avg_over_time(cpu.load[1h30m])", want: "avg_over_time(cpu.load[1h30m0s])"},
+		{input: "sum by (host) (Alloc)", want: "sum by (host) (Alloc)"},
+		{input: "sum(Alloc) without (agent)", want: "sum without (agent) (Alloc)"},
+		{input: "1 + 2 * 3", want: "(1 + (2 * 3))"},
+		{input: "(1 + 2) * 3", want: "((1 + 2) * 3)"},
+		{input: "2 ^ 3 ^ 2", want: "(2 ^ (3 ^ 2))"},
+		{input: "-2 ^ 2", want: "-(2 ^ 2)"},
+		{input: "10 - 2 - 3", want: "((10 - 2) - 3)"},
+		{input: "clamp_max(Alloc, 1e3)", want: "clamp_max(Alloc, 1000)"},
+		{input: "time()", want: "time()"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	tests := []string{
+		"",
+		"Alloc +",
+		"(Alloc",
+		"{}",
+		`Alloc{agent}`,
+		`Alloc{agent="a1"`,
+		`Alloc{agent=~"("}`,
+		`Alloc{agent="a1}`,
+		"Alloc[5x]",
+		"Alloc[]",
+		"sum by (host Alloc",
+		"Alloc Sys",
+		"Alloc == 1",
+		"Alloc # 1",
+	}
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := Parse(input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_parseDuration(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{input: "30s", want: 30 * time.Second},
+		{input: "1h30m", want: 90 * time.Minute},
+		{input: "1d", want: 24 * time.Hour},
+		{input: "500ms", want: 500 * time.Millisecond},
+		{input: "0s", wantErr: true},
+		{input: "5", wantErr: true},
+		{input: "m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseDuration(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
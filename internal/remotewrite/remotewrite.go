@@ -0,0 +1,260 @@
+// Модуль remotewrite содержит типы и функции для разбора запросов
+// Prometheus remote_write (сжатый snappy protobuf WriteRequest).
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/hrapovd1/pmetrics/internal/usecase"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Типы метрик из MetricMetadata.MetricType протокола remote_write
+const (
+	MetricTypeUnknown   = 0
+	MetricTypeCounter   = 1
+	MetricTypeGauge     = 2
+	MetricTypeHistogram = 3
+	MetricTypeSummary   = 5
+)
+
+// Label пара имя/значение метки временного ряда
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample значение временного ряда, Timestamp в миллисекундах
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries временной ряд с метками и значениями
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// MetricMetadata описание семейства метрик
+type MetricMetadata struct {
+	Type             int
+	MetricFamilyName string
+}
+
+// WriteRequest разобранный запрос remote_write
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+// Name возвращает имя метрики временного ряда из метки __name__
+func (ts TimeSeries) Name() string {
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// DecodeSnappy распаковывает тело запроса и разбирает WriteRequest
+func DecodeSnappy(body []byte) (*WriteRequest, error) {
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// Decode разбирает WriteRequest из protobuf представления
+func Decode(data []byte) (*WriteRequest, error) {
+	req := &WriteRequest{}
+	err := walkFields(data, func(num protowire.Number, num64 uint64, val []byte) error {
+		switch num {
+		case 1:
+			ts, err := decodeTimeSeries(val)
+			if err != nil {
+				return err
+			}
+			req.Timeseries = append(req.Timeseries, ts)
+		case 3:
+			md, err := decodeMetadata(val)
+			if err != nil {
+				return err
+			}
+			req.Metadata = append(req.Metadata, md)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// decodeTimeSeries разбирает сообщение TimeSeries
+func decodeTimeSeries(data []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	err := walkFields(data, func(num protowire.Number, num64 uint64, val []byte) error {
+		switch num {
+		case 1:
+			var l Label
+			if err := walkFields(val, func(n protowire.Number, n64 uint64, v []byte) error {
+				switch n {
+				case 1:
+					l.Name = string(v)
+				case 2:
+					l.Value = string(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, l)
+		case 2:
+			var s Sample
+			if err := walkFields(val, func(n protowire.Number, n64 uint64, v []byte) error {
+				switch n {
+				case 1:
+					s.Value = math.Float64frombits(n64)
+				case 2:
+					s.Timestamp = int64(n64)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+// decodeMetadata разбирает сообщение MetricMetadata
+func decodeMetadata(data []byte) (MetricMetadata, error) {
+	var md MetricMetadata
+	err := walkFields(data, func(num protowire.Number, num64 uint64, val []byte) error {
+		switch num {
+		case 1:
+			md.Type = int(num64)
+		case 2:
+			md.MetricFamilyName = string(val)
+		}
+		return nil
+	})
+	return md, err
+}
+
+// walkFields перебирает поля protobuf сообщения и передает их в fn,
+// значения varint и fixed полей передаются через num64,
+// значения length-delimited полей через val
+func walkFields(data []byte, fn func(num protowire.Number, num64 uint64, val []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var (
+			num64 uint64
+			val   []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			num64, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			num64, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			num64 = uint64(v)
+		case protowire.BytesType:
+			val, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := fn(num, num64, val); err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+	return nil
+}
+
+// Store сохраняет временные ряды запроса в Repository, для каждого
+// ряда используется последнее по времени значение. Накопительные
+// counter ряды Prometheus сохраняются атомарно через
+// usecase.WriteCounterTotal, остальные ряды - как gauge.
+func Store(ctx context.Context, req *WriteRequest, repo types.Repository) error {
+	familyTypes := make(map[string]int, len(req.Metadata))
+	for _, md := range req.Metadata {
+		familyTypes[md.MetricFamilyName] = md.Type
+	}
+
+	gauges := make([]types.Metric, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		name := ts.Name()
+		if name == "" || len(ts.Samples) == 0 {
+			continue
+		}
+		last := ts.Samples[0]
+		for _, s := range ts.Samples[1:] {
+			if s.Timestamp >= last.Timestamp {
+				last = s
+			}
+		}
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Name != "__name__" {
+				labels[l.Name] = l.Value
+			}
+		}
+		if isCounter(name, familyTypes) {
+			usecase.WriteCounterTotal(ctx, repo, types.SeriesKey(name, labels), last.Value)
+			continue
+		}
+		value := last.Value
+		gauges = append(gauges, types.Metric{ID: name, MType: "gauge", Value: &value, Labels: labels})
+	}
+	return usecase.WriteJSONMetrics(ctx, &gauges, repo)
+}
+
+// isCounter определяет является ли ряд накопительным счетчиком
+// по метаданным семейства или по суффиксу имени
+func isCounter(name string, familyTypes map[string]int) bool {
+	if mType, ok := familyTypes[name]; ok {
+		return mType == MetricTypeCounter
+	}
+	for _, suffix := range []string{"_total", "_count", "_bucket"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		mType, ok := familyTypes[strings.TrimSuffix(name, suffix)]
+		if !ok {
+			return true
+		}
+		switch mType {
+		case MetricTypeCounter:
+			return suffix == "_total"
+		case MetricTypeHistogram, MetricTypeSummary:
+			return suffix != "_total"
+		}
+		return false
+	}
+	return false
+}
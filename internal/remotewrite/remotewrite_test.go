@@ -0,0 +1,139 @@
+package remotewrite
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// encodeRequest кодирует WriteRequest в protobuf для тестов
+func encodeRequest(req WriteRequest) []byte {
+	var out []byte
+	for _, ts := range req.Timeseries {
+		var tsb []byte
+		for _, l := range ts.Labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Value)
+			tsb = protowire.AppendTag(tsb, 1, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, lb)
+		}
+		for _, s := range ts.Samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+			tsb = protowire.AppendTag(tsb, 2, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, sb)
+		}
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, tsb)
+	}
+	for _, md := range req.Metadata {
+		var mb []byte
+		mb = protowire.AppendTag(mb, 1, protowire.VarintType)
+		mb = protowire.AppendVarint(mb, uint64(md.Type))
+		mb = protowire.AppendTag(mb, 2, protowire.BytesType)
+		mb = protowire.AppendString(mb, md.MetricFamilyName)
+		out = protowire.AppendTag(out, 3, protowire.BytesType)
+		out = protowire.AppendBytes(out, mb)
+	}
+	return out
+}
+
+func testRequest() WriteRequest {
+	return WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "job", Value: "api"}},
+				Samples: []Sample{{Value: 10, Timestamp: 1000}, {Value: 12, Timestamp: 2000}},
+			},
+			{
+				Labels:  []Label{{Name: "__name__", Value: "memory_free"}},
+				Samples: []Sample{{Value: 1.5, Timestamp: 2000}},
+			},
+			{
+				Labels:  []Label{{Name: "__name__", Value: "queue_size"}},
+				Samples: []Sample{{Value: 3, Timestamp: 2000}},
+			},
+			{
+				Labels:  []Label{{Name: "job", Value: "no_name"}},
+				Samples: []Sample{{Value: 3, Timestamp: 2000}},
+			},
+		},
+		Metadata: []MetricMetadata{{Type: MetricTypeCounter, MetricFamilyName: "queue_size"}},
+	}
+}
+
+func TestDecodeSnappy(t *testing.T) {
+	want := testRequest()
+	got, err := DecodeSnappy(snappy.Encode(nil, encodeRequest(want)))
+	require.NoError(t, err)
+	assert.Equal(t, want, *got)
+
+	_, err = DecodeSnappy([]byte("not snappy"))
+	require.Error(t, err)
+
+	_, err = Decode([]byte{0x0a, 0xff})
+	require.Error(t, err)
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	req := testRequest()
+	req.Timeseries = append(req.Timeseries, TimeSeries{
+		Labels:  []Label{{Name: "__name__", Value: "cpu_seconds_total"}, {Name: "cpu", Value: "0"}},
+		Samples: []Sample{{Value: 12.75, Timestamp: 2000}},
+	})
+	stor := map[string]interface{}{`http_requests_total{job="api"}`: int64(5)}
+	repo := storage.NewMemStorage(storage.WithBuffer(stor))
+
+	require.NoError(t, Store(ctx, &req, repo))
+	require.Len(t, stor, 4)
+	// counter дописывается до последнего накопленного значения
+	assert.Equal(t, int64(12), stor[`http_requests_total{job="api"}`])
+	assert.Equal(t, 1.5, stor["memory_free"])
+	assert.Equal(t, int64(3), stor["queue_size"])
+	// дробный counter сохраняется без округления
+	assert.Equal(t, 12.75, stor[`cpu_seconds_total{cpu="0"}`])
+
+	// повторный запрос с теми же значениями не изменяет counter
+	require.NoError(t, Store(ctx, &req, repo))
+	assert.Equal(t, int64(12), stor[`http_requests_total{job="api"}`])
+	assert.Equal(t, int64(3), stor["queue_size"])
+}
+
+func Test_isCounter(t *testing.T) {
+	familyTypes := map[string]int{
+		"req_duration": MetricTypeHistogram,
+		"temperature":  MetricTypeGauge,
+		"errors":       MetricTypeCounter,
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "errors", want: true},
+		{name: "errors_total", want: true},
+		{name: "temperature", want: false},
+		{name: "req_duration_bucket", want: true},
+		{name: "req_duration_count", want: true},
+		{name: "req_duration_sum", want: false},
+		{name: "unknown_total", want: true},
+		{name: "unknown", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isCounter(tt.name, familyTypes))
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Модуль retention содержит политики хранения истории метрик:
+// сжатие исходных значений в агрегаты за интервалы и удаление
+// устаревших значений и агрегатов в хранилищах истории.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// Storage интерфейс хранилища, история которого сжимается по
+// политикам хранения на момент now
+type Storage interface {
+	Compact(ctx context.Context, policies Policies, now time.Time) error
+}
+
+// Policies политики хранения истории, ряду соответствует первая
+// политика, шаблон которой совпадает с именем метрики; история
+// рядов без политики хранится без ограничений
+type Policies []config.Retention
+
+// NewPolicies проверяет политики хранения: агрегаты вычисляются по
+// исходным значениям, поэтому исходные значения должны храниться не
+// меньше интервала агрегатов, а интервал - быть целым числом секунд
+func NewPolicies(rules []config.Retention) (Policies, error) {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Match, ""); err != nil {
+			return nil, fmt.Errorf("retention %s: %w", rule.Match, err)
+		}
+		if rule.Raw <= 0 {
+			return nil, fmt.Errorf("retention %s: raw must be positive", rule.Match)
+		}
+		resolutions := make(map[time.Duration]bool, len(rule.Rollups))
+		for _, rollup := range rule.Rollups {
+			switch {
+			case rollup.Resolution <= 0 || rollup.Resolution%time.Second != 0:
+				return nil, fmt.Errorf("retention %s: rollup resolution must be whole number of seconds", rule.Match)
+			case rollup.Resolution > rule.Raw:
+				return nil, fmt.Errorf("retention %s: rollup resolution %v is longer than raw %v", rule.Match, rollup.Resolution, rule.Raw)
+			case rollup.Keep < rollup.Resolution:
+				return nil, fmt.Errorf("retention %s: rollup keep %v is shorter than resolution", rule.Match, rollup.Keep)
+			case resolutions[rollup.Resolution]:
+				return nil, fmt.Errorf("retention %s: duplicate rollup resolution %v", rule.Match, rollup.Resolution)
+			}
+			resolutions[rollup.Resolution] = true
+		}
+	}
+	return Policies(rules), nil
+}
+
+// Match возвращает политику хранения ряда метрики name
+func (p Policies) Match(name string) (config.Retention, bool) {
+	for _, rule := range p {
+		if ok, _ := path.Match(rule.Match, name); ok {
+			return rule, true
+		}
+	}
+	return config.Retention{}, false
+}
+
+// Bucket агрегат значений ряда за интервал, начинающийся в Start;
+// Increase - прирост значения counter за интервал с учетом сброса
+type Bucket struct {
+	Start    time.Time
+	Min      float64
+	Max      float64
+	Sum      float64
+	Count    int64
+	Increase float64
+}
+
+// Avg возвращает среднее значение за интервал
+func (b Bucket) Avg() float64 {
+	if b.Count == 0 {
+		return 0
+	}
+	return b.Sum / float64(b.Count)
+}
+
+// Downsample вычисляет агрегаты отсортированных по времени значений
+// samples за интервалы resolution, выровненные по unix времени;
+// prev - значение перед samples, от которого считается прирост
+// первого значения, nil - прирост считается от первого значения
+func Downsample(samples []types.Sample, prev *types.Sample, resolution time.Duration) []Bucket {
+	buckets := make([]Bucket, 0)
+	for i, sample := range samples {
+		start := BucketStart(sample.Timestamp, resolution)
+		if len(buckets) == 0 || !buckets[len(buckets)-1].Start.Equal(start) {
+			buckets = append(buckets, Bucket{Start: start, Min: sample.Value, Max: sample.Value})
+		}
+		b := &buckets[len(buckets)-1]
+		b.Min = math.Min(b.Min, sample.Value)
+		b.Max = math.Max(b.Max, sample.Value)
+		b.Sum += sample.Value
+		b.Count++
+		if prev != nil {
+			// меньшее значение counter - сброс, прирост с нуля
+			if sample.Value >= prev.Value {
+				b.Increase += sample.Value - prev.Value
+			} else {
+				b.Increase += sample.Value
+			}
+		}
+		prev = &samples[i]
+	}
+	return buckets
+}
+
+// BucketStart возвращает начало интервала resolution, содержащего ts
+func BucketStart(ts time.Time, resolution time.Duration) time.Time {
+	ns := ts.UnixNano()
+	return time.Unix(0, ns-ns%int64(resolution))
+}
+
+// Compactor периодически сжимает историю хранилища по политикам
+type Compactor struct {
+	stor     Storage
+	policies Policies
+	interval time.Duration
+	logger   *log.Logger
+}
+
+// NewCompactor создает Compactor истории хранилища repo, если repo
+// хранит историю с политиками хранения
+func NewCompactor(repo types.Repository, policies Policies, interval time.Duration, logger *log.Logger) (*Compactor, error) {
+	stor, ok := repo.(Storage)
+	if !ok {
+		return nil, errors.New("storage doesn't support retention policies")
+	}
+	if interval <= 0 {
+		return nil, errors.New("retention interval must be positive")
+	}
+	return &Compactor{stor: stor, policies: policies, interval: interval, logger: logger}, nil
+}
+
+// Run запускается в отдельной go routine, сжимает историю с
+// интервалом interval до отмены контекста
+func (c *Compactor) Run(ctx context.Context, w *sync.WaitGroup) {
+	defer w.Done()
+	tick := time.NewTicker(c.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			c.Compact(ctx)
+		}
+	}
+}
+
+// Compact сжимает историю хранилища на текущий момент
+func (c *Compactor) Compact(ctx context.Context) {
+	if err := c.stor.Compact(ctx, c.policies, time.Now()); err != nil {
+		c.logger.Printf("history compaction: %v", err)
+	}
+}
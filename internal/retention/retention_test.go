@@ -0,0 +1,175 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const day = 24 * time.Hour
+
+func TestNewPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []config.Retention
+		wantErr bool
+	}{
+		{
+			name: "raw and rollups",
+			rules: []config.Retention{{
+				Match: "*",
+				Raw:   7 * day,
+				Rollups: []config.Rollup{
+					{Resolution: 5 * time.Minute, Keep: 90 * day},
+					{Resolution: time.Hour, Keep: 730 * day},
+				},
+			}},
+		},
+		{name: "raw only", rules: []config.Retention{{Match: "Alloc", Raw: day}}},
+		{name: "bad pattern", rules: []config.Retention{{Match: "[", Raw: day}}, wantErr: true},
+		{name: "without raw", rules: []config.Retention{{Match: "*"}}, wantErr: true},
+		{
+			name:    "resolution longer than raw",
+			rules:   []config.Retention{{Match: "*", Raw: time.Hour, Rollups: []config.Rollup{{Resolution: day, Keep: 90 * day}}}},
+			wantErr: true,
+		},
+		{
+			name:    "fractional resolution",
+			rules:   []config.Retention{{Match: "*", Raw: day, Rollups: []config.Rollup{{Resolution: 1500 * time.Millisecond, Keep: day}}}},
+			wantErr: true,
+		},
+		{
+			name:    "keep shorter than resolution",
+			rules:   []config.Retention{{Match: "*", Raw: day, Rollups: []config.Rollup{{Resolution: time.Hour, Keep: time.Minute}}}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate resolution",
+			rules: []config.Retention{{Match: "*", Raw: day, Rollups: []config.Rollup{
+				{Resolution: time.Hour, Keep: day},
+				{Resolution: time.Hour, Keep: 2 * day},
+			}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPolicies(tt.rules)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPolicies_Match(t *testing.T) {
+	policies, err := NewPolicies([]config.Retention{
+		{Match: "anomaly*", Raw: time.Hour},
+		{Match: "*", Raw: 7 * day},
+	})
+	require.NoError(t, err)
+
+	got, ok := policies.Match("anomaly_zscore")
+	require.True(t, ok)
+	assert.Equal(t, time.Hour, got.Raw, "first matching policy")
+	got, ok = policies.Match("Alloc")
+	require.True(t, ok)
+	assert.Equal(t, 7*day, got.Raw)
+
+	_, ok = Policies{{Match: "Alloc", Raw: day}}.Match("Sys")
+	assert.False(t, ok)
+}
+
+func TestDownsample(t *testing.T) {
+	start := time.Unix(3600, 0)
+	at := func(d time.Duration, v float64) types.Sample { return types.Sample{Timestamp: start.Add(d), Value: v} }
+
+	t.Run("gauge", func(t *testing.T) {
+		samples := []types.Sample{
+			at(0, 4),
+			at(2*time.Minute, 2),
+			at(4*time.Minute, 6),
+			at(5*time.Minute, 1),
+		}
+		got := Downsample(samples, nil, 5*time.Minute)
+		require.Len(t, got, 2)
+		assert.Equal(t, start, got[0].Start)
+		assert.Equal(t, 2.0, got[0].Min)
+		assert.Equal(t, 6.0, got[0].Max)
+		assert.Equal(t, int64(3), got[0].Count)
+		assert.Equal(t, 4.0, got[0].Avg())
+		assert.Equal(t, start.Add(5*time.Minute), got[1].Start)
+		assert.Equal(t, 1.0, got[1].Avg())
+	})
+
+	t.Run("counter with reset", func(t *testing.T) {
+		prev := at(-time.Minute, 10)
+		samples := []types.Sample{
+			at(0, 15),
+			at(time.Minute, 20),
+			at(6*time.Minute, 3), // сброс counter
+			at(7*time.Minute, 8),
+		}
+		got := Downsample(samples, &prev, 5*time.Minute)
+		require.Len(t, got, 2)
+		assert.Equal(t, 10.0, got[0].Increase)
+		assert.Equal(t, 8.0, got[1].Increase)
+
+		got = Downsample(samples, nil, 5*time.Minute)
+		assert.Equal(t, 5.0, got[0].Increase, "without previous value")
+	})
+
+	assert.Empty(t, Downsample(nil, nil, time.Minute))
+}
+
+func TestBucketStart(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 10, 47, 31, 0, time.UTC)
+	assert.True(t, time.Date(2024, 3, 1, 10, 45, 0, 0, time.UTC).Equal(BucketStart(ts, 5*time.Minute)))
+	assert.True(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC).Equal(BucketStart(ts, time.Hour)))
+}
+
+// compactStorage хранилище, записывающее вызовы Compact
+type compactStorage struct {
+	types.Repository
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (cs *compactStorage) Compact(ctx context.Context, policies Policies, now time.Time) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.calls++
+	return cs.err
+}
+
+func TestNewCompactor(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	_, err := NewCompactor(storage.NewMemStorage(), nil, time.Minute, logger)
+	assert.Error(t, err, "memory storage")
+	_, err = NewCompactor(&compactStorage{}, nil, 0, logger)
+	assert.Error(t, err, "zero interval")
+
+	stor := &compactStorage{err: errors.New("database isn't available")}
+	compactor, err := NewCompactor(stor, nil, 10*time.Millisecond, logger)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	compactor.Run(ctx, &wg)
+	wg.Wait()
+	assert.Greater(t, stor.calls, 0)
+}
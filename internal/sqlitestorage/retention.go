@@ -0,0 +1,232 @@
+// Часть модуля sqlitestorage содержит сжатие истории рядов базы по
+// политикам хранения.
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/retention"
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// queryer общий интерфейс sql.DB и sql.Tx для чтения строк
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// seriesRow временной ряд таблицы рядов
+type seriesRow struct {
+	id    int64
+	name  string
+	mtype string
+}
+
+// Compact сжимает историю рядов базы по политикам хранения: значения
+// рядов с политикой агрегируются в завершенные к now интервалы, затем
+// удаляются значения, кроме последнего, и агрегаты старше их времени
+// хранения. Значения histogram и summary не агрегируются, только удаляются
+func (ss *SQLiteStorage) Compact(ctx context.Context, policies retention.Policies, now time.Time) error {
+	series, err := ss.allSeries(ctx)
+	if err != nil {
+		return err
+	}
+	compacted := 0
+	for _, s := range series {
+		policy, ok := policies.Match(s.name)
+		if !ok {
+			continue
+		}
+		if err := ss.compactSeries(ctx, s, policy, now); err != nil {
+			return fmt.Errorf("series %d %s: %w", s.id, s.name, err)
+		}
+		compacted++
+	}
+	ss.logger.Printf("compacted history of %d series", compacted)
+	return nil
+}
+
+// allSeries возвращает все временные ряды базы
+func (ss *SQLiteStorage) allSeries(ctx context.Context) ([]seriesRow, error) {
+	rows, err := ss.dbConnect.QueryContext(ctx, `SELECT id, name, mtype FROM `+types.DBSeriesTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	series := make([]seriesRow, 0)
+	for rows.Next() {
+		var s seriesRow
+		if err := rows.Scan(&s.id, &s.name, &s.mtype); err != nil {
+			return nil, err
+		}
+		series = append(series, s)
+	}
+	return series, rows.Err()
+}
+
+// compactSeries записывает агрегаты ряда series и удаляет устаревшие
+// значения в одной транзакции; последнее значение ряда не удаляется:
+// по нему восстанавливается значение ряда, который не обновлялся
+// дольше времени хранения
+func (ss *SQLiteStorage) compactSeries(ctx context.Context, series seriesRow, policy config.Retention, now time.Time) error {
+	tx, err := ss.dbConnect.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// значения записываются в базу сразу, интервал до now завершен
+	for _, rollup := range policy.Rollups {
+		if err := rollupSeries(ctx, tx, series, rollup.Resolution, now); err != nil {
+			return err
+		}
+	}
+	cutoff := now.Add(-policy.Raw).UnixNano()
+	var latest sql.NullInt64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT MAX("timestamp") FROM `+types.DBSamplesTable+` WHERE series_id = ?`, series.id,
+	).Scan(&latest); err != nil {
+		return err
+	}
+	if latest.Valid && latest.Int64 < cutoff {
+		cutoff = latest.Int64
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM `+types.DBSamplesTable+` WHERE series_id = ? AND "timestamp" < ?`, series.id, cutoff,
+	); err != nil {
+		return err
+	}
+	for _, rollup := range policy.Rollups {
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM `+types.DBRollupsTable+` WHERE series_id = ? AND resolution = ? AND "timestamp" < ?`,
+			series.id, int64(rollup.Resolution/time.Second), now.Add(-rollup.Keep).UnixNano(),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// rollupSeries записывает агрегаты ряда series за интервалы resolution,
+// завершенные к complete и следующие за последним записанным
+func rollupSeries(ctx context.Context, tx *sql.Tx, series seriesRow, resolution time.Duration, complete time.Time) error {
+	seconds := int64(resolution / time.Second)
+	var last sql.NullInt64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT MAX("timestamp") FROM `+types.DBRollupsTable+` WHERE series_id = ? AND resolution = ?`,
+		series.id, seconds,
+	).Scan(&last); err != nil {
+		return err
+	}
+	from := int64(0)
+	if last.Valid {
+		from = last.Int64 + int64(resolution)
+	}
+	to := retention.BucketStart(complete, resolution).UnixNano()
+	if from >= to {
+		return nil
+	}
+	samples, err := querySamples(ctx, tx, series.id, from, to)
+	if err != nil || len(samples) == 0 {
+		return err
+	}
+	counter := series.mtype == "counter"
+	// прирост первого интервала считается от предыдущего значения
+	var prev *types.Sample
+	if counter {
+		var delta sql.NullInt64
+		err := tx.QueryRowContext(ctx,
+			`SELECT delta FROM `+types.DBSamplesTable+` WHERE series_id = ? AND "timestamp" < ?
+			ORDER BY "timestamp" DESC LIMIT 1`,
+			series.id, from,
+		).Scan(&delta)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if delta.Valid {
+			prev = &types.Sample{Value: float64(delta.Int64)}
+		}
+	}
+	for _, b := range retention.Downsample(samples, prev, resolution) {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR REPLACE INTO `+types.DBRollupsTable+`
+			(series_id, resolution, "timestamp", min, max, sum, count, increase)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			series.id, seconds, b.Start.UnixNano(), b.Min, b.Max, b.Sum, b.Count,
+			sql.NullFloat64{Float64: b.Increase, Valid: counter},
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeRollups возвращает агрегаты ряда id типа mType в интервале
+// [from, to) наносекунд: сначала меньшего интервала, более старые -
+// большего; значение counter - наибольшая накопленная сумма, gauge -
+// среднее
+func rangeRollups(ctx context.Context, q queryer, id int64, mType string, from, to int64) ([]types.Sample, error) {
+	resolutions, err := rollupResolutions(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]types.Sample, 0)
+	for _, resolution := range resolutions {
+		rows, err := q.QueryContext(ctx,
+			`SELECT "timestamp", max, sum, count FROM `+types.DBRollupsTable+`
+			WHERE series_id = ? AND resolution = ? AND "timestamp" >= ? AND "timestamp" < ?
+			ORDER BY "timestamp"`,
+			id, resolution, from, to,
+		)
+		if err != nil {
+			return nil, err
+		}
+		older := make([]types.Sample, 0)
+		for rows.Next() {
+			var row types.RollupModel
+			if err := rows.Scan(&row.Timestamp, &row.Max, &row.Sum, &row.Count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			sample := types.Sample{Timestamp: time.Unix(0, row.Timestamp), Value: row.Max}
+			if mType != "counter" {
+				sample.Value = row.Sum / float64(row.Count)
+			}
+			older = append(older, sample)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if len(older) == 0 {
+			continue
+		}
+		samples = append(older, samples...)
+		to = older[0].Timestamp.UnixNano()
+	}
+	return samples, nil
+}
+
+// rollupResolutions возвращает интервалы агрегатов ряда id в секундах
+// по возрастанию
+func rollupResolutions(ctx context.Context, q queryer, id int64) ([]int64, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT DISTINCT resolution FROM `+types.DBRollupsTable+` WHERE series_id = ? ORDER BY resolution`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	resolutions := make([]int64, 0)
+	for rows.Next() {
+		var resolution int64
+		if err := rows.Scan(&resolution); err != nil {
+			return nil, err
+		}
+		resolutions = append(resolutions, resolution)
+	}
+	return resolutions, rows.Err()
+}
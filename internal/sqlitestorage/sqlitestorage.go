@@ -0,0 +1,526 @@
+// Модуль sqlitestorage содержит типы и методы для хранения метрик
+// в файле базы SQLite, драйвер SQLite написан на Go и не требует cgo.
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+	_ "modernc.org/sqlite"
+)
+
+// Scheme префикс DSN базы SQLite, за ним следует путь к файлу базы,
+// например sqlite:///var/lib/pmetrics/metrics.db
+const Scheme = "sqlite://"
+
+// pragmas параметры каждого подключения к базе: журнал WAL с
+// синхронизацией при контрольной точке и ожидание блокировки записи
+var pragmas = []string{
+	"busy_timeout(5000)",
+	"journal_mode(WAL)",
+	"synchronous(NORMAL)",
+	"foreign_keys(1)",
+}
+
+// schema схема базы, таблицы повторяют таблицы временных рядов, их
+// значений и агрегатов в postgresql, timestamp - наносекунды unix времени
+const schema = `
+CREATE TABLE IF NOT EXISTS ` + types.DBSeriesTable + ` (
+    id     INTEGER PRIMARY KEY,
+    name   TEXT NOT NULL,
+    labels TEXT NOT NULL,
+    mtype  TEXT NOT NULL,
+    UNIQUE (name, labels)
+);
+CREATE TABLE IF NOT EXISTS ` + types.DBSamplesTable + ` (
+    series_id   INTEGER NOT NULL REFERENCES ` + types.DBSeriesTable + ` (id) ON DELETE CASCADE,
+    "timestamp" INTEGER NOT NULL,
+    value       REAL,
+    delta       INTEGER,
+    data        TEXT,
+    PRIMARY KEY (series_id, "timestamp")
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS ` + types.DBRollupsTable + ` (
+    series_id   INTEGER NOT NULL REFERENCES ` + types.DBSeriesTable + ` (id) ON DELETE CASCADE,
+    resolution  INTEGER NOT NULL,
+    "timestamp" INTEGER NOT NULL,
+    min         REAL NOT NULL,
+    max         REAL NOT NULL,
+    sum         REAL NOT NULL,
+    count       INTEGER NOT NULL,
+    increase    REAL,
+    PRIMARY KEY (series_id, resolution, "timestamp")
+) WITHOUT ROWID;
+`
+
+// IsDSN проверяет, что dsn задает базу SQLite
+func IsDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, Scheme)
+}
+
+// SQLiteStorage тип для хранения метрик в базе SQLite, текущие
+// значения хранятся в промежуточном хранилище, все значения метрик
+// записываются в таблицы временных рядов и их значений
+type SQLiteStorage struct {
+	dbConnect *sql.DB
+	logger    *log.Logger
+	backStor  types.Repository
+	mu        sync.Mutex
+	series    map[string]int64 // идентификаторы временных рядов по ключу
+}
+
+// NewSQLiteStorage открывает базу SQLite по dsn вида sqlite://path,
+// создает таблицы, если их нет, и возвращает тип SQLiteStorage
+func NewSQLiteStorage(dsn string, logger *log.Logger, backStor types.Repository) (*SQLiteStorage, error) {
+	if !IsDSN(dsn) {
+		return nil, errors.New("wrong sqlite DSN, expected " + Scheme + "path: " + dsn)
+	}
+	dbConnect, err := sql.Open("sqlite", driverDSN(dsn))
+	if err != nil {
+		return nil, err
+	}
+	// SQLite допускает одну запись в момент времени
+	dbConnect.SetMaxOpenConns(1)
+	if _, err := dbConnect.Exec(schema); err != nil {
+		dbConnect.Close()
+		return nil, err
+	}
+	return &SQLiteStorage{
+		dbConnect: dbConnect,
+		logger:    logger,
+		backStor:  backStor,
+		series:    make(map[string]int64),
+	}, nil
+}
+
+// Append сохраняет новое значение типа counter с дозаписью к старому
+func (ss *SQLiteStorage) Append(ctx context.Context, key string, value int64) {
+	ss.backStor.Append(ctx, key, value)
+	if rec, ok := ss.valueRecord(ctx, key, "counter"); ok {
+		ss.store(ctx, rec)
+	}
+}
+
+// AppendTotal дописывает к значению counter приращение до накопленного
+// значения total, см. storage.MemStorage.AppendTotal
+func (ss *SQLiteStorage) AppendTotal(ctx context.Context, key string, total int64) int64 {
+	delta := ss.backStor.AppendTotal(ctx, key, total)
+	if rec, ok := ss.valueRecord(ctx, key, "counter"); ok {
+		ss.store(ctx, rec)
+	}
+	return delta
+}
+
+// Get возвращает значение метрики переданной через key
+func (ss *SQLiteStorage) Get(ctx context.Context, key string) interface{} {
+	return ss.backStor.Get(ctx, key)
+}
+
+// GetAll возвращает все метрики
+func (ss *SQLiteStorage) GetAll(ctx context.Context) map[string]interface{} {
+	return ss.backStor.GetAll(ctx)
+}
+
+// Rewrite перезаписывает значение метрики типа gauge
+func (ss *SQLiteStorage) Rewrite(ctx context.Context, key string, value float64) {
+	ss.backStor.Rewrite(ctx, key, value)
+	if rec, ok := ss.valueRecord(ctx, key, "gauge"); ok {
+		ss.store(ctx, rec)
+	}
+}
+
+// AppendHistogram сохраняет значение типа histogram со сложением с текущим
+func (ss *SQLiteStorage) AppendHistogram(ctx context.Context, key string, value types.Histogram) {
+	ss.backStor.AppendHistogram(ctx, key, value)
+	if rec, ok := ss.valueRecord(ctx, key, "histogram"); ok {
+		ss.store(ctx, rec)
+	}
+}
+
+// RewriteSummary перезаписывает значение метрики типа summary
+func (ss *SQLiteStorage) RewriteSummary(ctx context.Context, key string, value types.Summary) {
+	ss.backStor.RewriteSummary(ctx, key, value)
+	if rec, ok := ss.valueRecord(ctx, key, "summary"); ok {
+		ss.store(ctx, rec)
+	}
+}
+
+// StoreAll сохраняет все полученные метрики через слайс metrics
+// в одной транзакции
+func (ss *SQLiteStorage) StoreAll(ctx context.Context, metrics *[]types.Metric) {
+	records := make([]record, 0, len(*metrics))
+	for _, m := range *metrics {
+		key := m.Key()
+		switch m.MType {
+		case "counter":
+			ss.backStor.Append(ctx, key, *m.Delta)
+		case "gauge":
+			ss.backStor.Rewrite(ctx, key, *m.Value)
+		case "histogram":
+			if m.Histogram == nil || m.Histogram.Validate() != nil {
+				continue
+			}
+			ss.backStor.AppendHistogram(ctx, key, *m.Histogram)
+		case "summary":
+			if m.Summary == nil || m.Summary.Validate() != nil {
+				continue
+			}
+			ss.backStor.RewriteSummary(ctx, key, *m.Summary)
+		default:
+			continue
+		}
+		if rec, ok := ss.valueRecord(ctx, key, m.MType); ok {
+			records = append(records, rec)
+		}
+	}
+	ss.store(ctx, records...)
+}
+
+// Range возвращает историю значений counter и gauge временного ряда
+// из базы, при ошибке чтения - историю промежуточного хранилища,
+// если оно ее хранит
+func (ss *SQLiteStorage) Range(ctx context.Context, key string, from, to time.Time) []types.Sample {
+	samples, err := ss.rangeDB(ctx, key, from, to)
+	if err == nil {
+		return samples
+	}
+	ss.logger.Println(err)
+	stor, ok := ss.backStor.(types.Ranger)
+	if !ok {
+		return nil
+	}
+	return stor.Range(ctx, key, from, to)
+}
+
+// Series возвращает ключи рядов метрики name с метками, см. types.SeriesLister
+func (ss *SQLiteStorage) Series(ctx context.Context, name string) []string {
+	stor, ok := ss.backStor.(types.SeriesLister)
+	if !ok {
+		return nil
+	}
+	return stor.Series(ctx, name)
+}
+
+// Storing запускается в отдельной go routine для сохранения метрик,
+// при restore сначала восстанавливает значения метрик, см. Restore;
+// значения записываются в базу сразу, поэтому периодического
+// сохранения нет
+func (ss *SQLiteStorage) Storing(ctx context.Context, w *sync.WaitGroup, logger *log.Logger, interval time.Duration, restore bool) {
+	if restore {
+		if err := ss.Restore(ctx); err != nil {
+			logger.Printf("ss.Restore err: %v", err)
+		}
+	}
+	stor := ss.backStor.(types.Storager)
+	stor.Storing(ctx, w, logger, interval, false)
+}
+
+// Restore восстанавливает в промежуточное хранилище последние
+// значения всех временных рядов базы
+func (ss *SQLiteStorage) Restore(ctx context.Context) error {
+	rows, err := ss.dbConnect.QueryContext(ctx,
+		`SELECT s.name, s.labels, s.mtype, sm.value, sm.delta, sm.data
+		FROM `+types.DBSeriesTable+` s JOIN `+types.DBSamplesTable+` sm ON sm.series_id = s.id
+		AND sm."timestamp" = (
+			SELECT MAX("timestamp") FROM `+types.DBSamplesTable+` WHERE series_id = s.id
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	restored := 0
+	for rows.Next() {
+		var name, labels, mType string
+		var sample types.SampleModel
+		if err := rows.Scan(&name, &labels, &mType, &sample.Value, &sample.Delta, &sample.Data); err != nil {
+			return err
+		}
+		key := name
+		if labels != "" {
+			key += "{" + labels + "}"
+		}
+		if restoreValue(ctx, ss.backStor, key, mType, sample) {
+			restored++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	ss.logger.Printf("restored %d series from sqlite", restored)
+	return nil
+}
+
+// Close закрывает промежуточное хранилище и базу, необходимо
+// запускать в defer
+func (ss *SQLiteStorage) Close() error {
+	stor := ss.backStor.(types.Storager)
+	defer func() {
+		if err := stor.Close(); err != nil {
+			ss.logger.Print(err)
+		}
+	}()
+	return ss.dbConnect.Close()
+}
+
+// Ping используется для проверки доступности базы
+func (ss *SQLiteStorage) Ping(ctx context.Context) bool {
+	ctxT, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+	return ss.dbConnect.PingContext(ctxT) == nil
+}
+
+// record значение временного ряда для записи в базу
+type record struct {
+	key    string
+	mtype  string
+	sample types.SampleModel
+}
+
+// newRecord возвращает запись значения временного ряда key
+// с текущим временем
+func newRecord(key, mType string) record {
+	return record{
+		key:    key,
+		mtype:  mType,
+		sample: types.SampleModel{Timestamp: time.Now().UnixNano()},
+	}
+}
+
+// valueRecord возвращает запись текущего значения ряда key из
+// промежуточного хранилища, если оно имеет тип mType: значение другого
+// типа промежуточное хранилище не перезаписывает
+func (ss *SQLiteStorage) valueRecord(ctx context.Context, key, mType string) (record, bool) {
+	value := ss.backStor.Get(ctx, key)
+	if types.ValueType(value) != mType {
+		return record{}, false
+	}
+	rec := newRecord(key, mType)
+	switch value := value.(type) {
+	case int64:
+		rec.sample.Delta = sql.NullInt64{Int64: value, Valid: true}
+	case float64:
+		rec.sample.Value = sql.NullFloat64{Float64: value, Valid: true}
+	default:
+		rec.sample.Data = jsonData(value)
+	}
+	return rec, true
+}
+
+// store записывает значения в базу в одной транзакции, повторная
+// запись значения ряда с тем же временем заменяет предыдущее; ошибка
+// записи пишется в лог, значения остаются в промежуточном хранилище
+func (ss *SQLiteStorage) store(ctx context.Context, records ...record) {
+	if len(records) == 0 {
+		return
+	}
+	if err := ss.storeTx(ctx, records); err != nil {
+		ss.logger.Printf("%d values aren't stored in sqlite: %v", len(records), err)
+	}
+}
+
+// storeTx внутренняя функция записи значений в транзакции
+func (ss *SQLiteStorage) storeTx(ctx context.Context, records []record) error {
+	tx, err := ss.dbConnect.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// идентификаторы созданных рядов кэшируются после фиксации,
+	// чтобы откат не оставил в кэше несуществующие ряды
+	created := make(map[string]int64)
+	for _, rec := range records {
+		id, err := ss.seriesID(ctx, tx, rec.key, rec.mtype, created)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR REPLACE INTO `+types.DBSamplesTable+` (series_id, "timestamp", value, delta, data)
+			VALUES (?, ?, ?, ?, ?)`,
+			id, rec.sample.Timestamp, rec.sample.Value, rec.sample.Delta, rec.sample.Data,
+		); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	for key, id := range created {
+		ss.series[key] = id
+	}
+	ss.mu.Unlock()
+	return nil
+}
+
+// seriesID возвращает идентификатор временного ряда key,
+// создавая ряд при первой записи
+func (ss *SQLiteStorage) seriesID(ctx context.Context, tx *sql.Tx, key, mType string, created map[string]int64) (int64, error) {
+	ss.mu.Lock()
+	id, ok := ss.series[key]
+	ss.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	if id, ok := created[key]; ok {
+		return id, nil
+	}
+	name, labels := seriesNameLabels(key)
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO `+types.DBSeriesTable+` (name, labels, mtype) VALUES (?, ?, ?)
+		ON CONFLICT (name, labels) DO NOTHING`,
+		name, labels, mType,
+	); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRowContext(ctx,
+		`SELECT id FROM `+types.DBSeriesTable+` WHERE name = ? AND labels = ?`, name, labels,
+	).Scan(&id); err != nil {
+		return 0, err
+	}
+	created[key] = id
+	return id, nil
+}
+
+// rangeDB внутренняя функция чтения истории временного ряда из базы,
+// значения старше хранимых исходных берутся из агрегатов
+func (ss *SQLiteStorage) rangeDB(ctx context.Context, key string, from, to time.Time) ([]types.Sample, error) {
+	name, labels := seriesNameLabels(key)
+	var id int64
+	var mType string
+	err := ss.dbConnect.QueryRowContext(ctx,
+		`SELECT id, mtype FROM `+types.DBSeriesTable+` WHERE name = ? AND labels = ?`, name, labels,
+	).Scan(&id, &mType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return []types.Sample{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	samples, err := querySamples(ctx, ss.dbConnect, id, from.UnixNano(), to.UnixNano()+1)
+	if err != nil {
+		return nil, err
+	}
+	end := to.UnixNano() + 1
+	if len(samples) > 0 {
+		end = samples[0].Timestamp.UnixNano()
+	}
+	older, err := rangeRollups(ctx, ss.dbConnect, id, mType, from.UnixNano(), end)
+	if err != nil {
+		return nil, err
+	}
+	return append(older, samples...), nil
+}
+
+// querySamples возвращает числовые значения ряда id в интервале
+// [from, to) наносекунд, значение counter - накопленная сумма
+func querySamples(ctx context.Context, q queryer, id, from, to int64) ([]types.Sample, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT "timestamp", value, delta FROM `+types.DBSamplesTable+`
+		WHERE series_id = ? AND "timestamp" >= ? AND "timestamp" < ?
+		ORDER BY "timestamp"`,
+		id, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	samples := make([]types.Sample, 0)
+	for rows.Next() {
+		var row types.SampleModel
+		if err := rows.Scan(&row.Timestamp, &row.Value, &row.Delta); err != nil {
+			return nil, err
+		}
+		sample := types.Sample{Timestamp: time.Unix(0, row.Timestamp)}
+		switch {
+		case row.Delta.Valid:
+			sample.Value = float64(row.Delta.Int64)
+		case row.Value.Valid:
+			sample.Value = row.Value.Float64
+		default:
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// restoreValue записывает значение строки таблицы значений в
+// хранилище repo, false - значение не восстановлено
+func restoreValue(ctx context.Context, repo types.Repository, key, mType string, sample types.SampleModel) bool {
+	switch mType {
+	case "counter":
+		if !sample.Delta.Valid {
+			return false
+		}
+		if current, _ := repo.Get(ctx, key).(int64); sample.Delta.Int64 > current {
+			repo.Append(ctx, key, sample.Delta.Int64-current)
+		}
+	case "gauge":
+		if !sample.Value.Valid {
+			return false
+		}
+		repo.Rewrite(ctx, key, sample.Value.Float64)
+	case "histogram":
+		var h types.Histogram
+		if !sample.Data.Valid || json.Unmarshal([]byte(sample.Data.String), &h) != nil || h.Validate() != nil {
+			return false
+		}
+		if _, ok := repo.Get(ctx, key).(types.Histogram); ok {
+			// histogram записывается сложением, повторное
+			// восстановление удвоило бы значение
+			return false
+		}
+		repo.AppendHistogram(ctx, key, h)
+	case "summary":
+		var s types.Summary
+		if !sample.Data.Valid || json.Unmarshal([]byte(sample.Data.String), &s) != nil || s.Validate() != nil {
+			return false
+		}
+		repo.RewriteSummary(ctx, key, s)
+	default:
+		return false
+	}
+	return true
+}
+
+// seriesNameLabels возвращает имя и метки в формате LabelsString
+// временного ряда key
+func seriesNameLabels(key string) (string, string) {
+	name, labels, err := types.ParseSeriesKey(key)
+	if err != nil {
+		return key, ""
+	}
+	return name, types.LabelsString(labels)
+}
+
+// driverDSN возвращает DSN драйвера SQLite: путь к файлу базы с
+// параметрами pragmas для каждого подключения
+func driverDSN(dsn string) string {
+	path := strings.TrimPrefix(dsn, Scheme)
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	for _, p := range pragmas {
+		path += sep + "_pragma=" + p
+		sep = "&"
+	}
+	return path
+}
+
+// jsonData возвращает значение histogram или summary для колонки data
+func jsonData(value interface{}) sql.NullString {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
@@ -0,0 +1,423 @@
+// Модуль statsd содержит сервер приема метрик по протоколу StatsD
+// через UDP и TCP с сохранением в types.Repository.
+package statsd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/hrapovd1/pmetrics/internal/usecase"
+)
+
+const (
+	// интервал агрегации таймеров по умолчанию
+	defaultFlushInterval = 10 * time.Second
+	// максимальный размер UDP пакета
+	maxPacketSize = 65535
+	// наибольшее число значений таймера, хранимых между агрегациями
+	maxTimerValues = 10000
+)
+
+// Перцентили таймеров, сохраняемые при агрегации
+var timerPercentiles = []float64{50, 90, 95, 99}
+
+// Option тип для модификации сервера Server
+type Option func(srv *Server) *Server
+
+// Server тип сервера приема метрик по протоколу StatsD
+type Server struct {
+	addr          string
+	repo          types.Repository
+	logger        *log.Logger
+	flushInterval time.Duration
+	trustedSubnet string
+	dropped       atomic.Int64 // строки, отброшенные при перегрузке хранилища
+	mu            sync.Mutex
+	timers        map[string]*timer
+}
+
+// timer значения таймера между агрегациями: количество, минимум,
+// максимум и сумма точные, перцентили считаются по случайной выборке
+// не более maxTimerValues значений
+type timer struct {
+	values []float64
+	seen   int     // число полученных значений
+	count  float64 // число значений с учетом частоты выборки
+	min    float64
+	max    float64
+	sum    float64
+}
+
+// add добавляет значение таймера, при заполненной выборке значение
+// заменяет случайное с вероятностью, равной для всех значений
+func (t *timer) add(value, sampleRate float64) {
+	if t.seen == 0 || value < t.min {
+		t.min = value
+	}
+	if t.seen == 0 || value > t.max {
+		t.max = value
+	}
+	t.seen++
+	t.count += 1 / sampleRate
+	t.sum += value
+	if len(t.values) < maxTimerValues {
+		t.values = append(t.values, value)
+		return
+	}
+	if i := rand.Intn(t.seen); i < maxTimerValues {
+		t.values[i] = value
+	}
+}
+
+// Line разобранная строка протокола StatsD
+type Line struct {
+	Name       string
+	Value      float64
+	Type       string
+	SampleRate float64
+	Relative   bool // для gauge: значение со знаком меняет текущее
+}
+
+// NewServer создает сервер StatsD, слушающий addr по UDP и TCP
+func NewServer(addr string, repo types.Repository, logger *log.Logger, opts ...Option) *Server {
+	srv := &Server{
+		addr:          addr,
+		repo:          repo,
+		logger:        logger,
+		flushInterval: defaultFlushInterval,
+		timers:        make(map[string]*timer),
+	}
+	for _, opt := range opts {
+		srv = opt(srv)
+	}
+	return srv
+}
+
+// WithFlushInterval задает интервал агрегации таймеров
+func WithFlushInterval(interval time.Duration) Option {
+	return func(srv *Server) *Server {
+		srv.flushInterval = interval
+		return srv
+	}
+}
+
+// WithTrustedSubnet ограничивает прием метрик адресами доверенной
+// подсети subnet в формате CIDR, метрики других адресов отбрасываются
+func WithTrustedSubnet(subnet string) Option {
+	return func(srv *Server) *Server {
+		srv.trustedSubnet = subnet
+		return srv
+	}
+}
+
+// Run запускается в отдельной go routine, принимает метрики до
+// отмены контекста, после чего сохраняет накопленные таймеры
+func (srv *Server) Run(ctx context.Context, w *sync.WaitGroup) {
+	defer w.Done()
+
+	udpConn, err := net.ListenPacket("udp", srv.addr)
+	if err != nil {
+		srv.logger.Printf("statsd: when listen udp got error: %v\n", err)
+		return
+	}
+	tcpListen, err := net.Listen("tcp", srv.addr)
+	if err != nil {
+		srv.logger.Printf("statsd: when listen tcp got error: %v\n", err)
+		if err := udpConn.Close(); err != nil {
+			srv.logger.Println(err)
+		}
+		return
+	}
+	srv.logger.Println("StatsD server start on ", srv.addr)
+
+	handlers := sync.WaitGroup{}
+	handlers.Add(2)
+	go srv.serveUDP(ctx, &handlers, udpConn)
+	go srv.serveTCP(ctx, &handlers, tcpListen)
+
+	flushTick := time.NewTicker(srv.flushInterval)
+	defer flushTick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := udpConn.Close(); err != nil {
+				srv.logger.Println(err)
+			}
+			if err := tcpListen.Close(); err != nil {
+				srv.logger.Println(err)
+			}
+			handlers.Wait()
+			srv.Flush(context.Background())
+			srv.reportDropped()
+			return
+		case <-flushTick.C:
+			srv.Flush(ctx)
+			srv.reportDropped()
+		}
+	}
+}
+
+// serveUDP принимает пакеты StatsD по UDP
+func (srv *Server) serveUDP(ctx context.Context, w *sync.WaitGroup, conn net.PacketConn) {
+	defer w.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			srv.logger.Printf("statsd: when read udp got error: %v\n", err)
+			continue
+		}
+		if !srv.trusted(addr) {
+			continue
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			srv.handleLine(ctx, line)
+		}
+	}
+}
+
+// serveTCP принимает соединения StatsD по TCP
+func (srv *Server) serveTCP(ctx context.Context, w *sync.WaitGroup, listen net.Listener) {
+	defer w.Done()
+	conns := sync.WaitGroup{}
+	defer conns.Wait()
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			srv.logger.Printf("statsd: when accept tcp got error: %v\n", err)
+			continue
+		}
+		if !srv.trusted(conn.RemoteAddr()) {
+			if err := conn.Close(); err != nil {
+				srv.logger.Println(err)
+			}
+			continue
+		}
+		conns.Add(1)
+		go func(c net.Conn) {
+			defer conns.Done()
+			done := make(chan struct{})
+			defer close(done)
+			// соединение закрывается при остановке сервера
+			go func() {
+				select {
+				case <-ctx.Done():
+				case <-done:
+				}
+				if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+					srv.logger.Println(err)
+				}
+			}()
+			scan := bufio.NewScanner(c)
+			for scan.Scan() {
+				srv.handleLine(ctx, scan.Text())
+			}
+		}(conn)
+	}
+}
+
+// trusted проверяет, что адрес отправителя addr входит в доверенную
+// подсеть, если она задана
+func (srv *Server) trusted(addr net.Addr) bool {
+	if srv.trustedSubnet == "" {
+		return true
+	}
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	ok, err := usecase.CheckAddr(ip, srv.trustedSubnet)
+	if err != nil {
+		srv.logger.Printf("statsd: when check address %v got error: %v\n", addr, err)
+		return false
+	}
+	if !ok {
+		srv.logger.Printf("statsd: drop metrics from untrusted address %v\n", addr)
+	}
+	return ok
+}
+
+// handleLine разбирает и сохраняет одну строку протокола, пока
+// хранилище не успевает сохранять значения, строки отбрасываются
+func (srv *Server) handleLine(ctx context.Context, raw string) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return
+	}
+	if usecase.Throttle(srv.repo) != nil {
+		srv.dropped.Add(1)
+		return
+	}
+	line, err := ParseLine(raw)
+	if err != nil {
+		srv.logger.Printf("statsd: %v\n", err)
+		return
+	}
+	srv.Store(ctx, line)
+}
+
+// Store сохраняет метрику: counter дописывается к текущему значению,
+// gauge перезаписывается, таймеры накапливаются до Flush. Метрика,
+// под именем которой сохранено значение другого типа, отбрасывается
+func (srv *Server) Store(ctx context.Context, line Line) {
+	switch line.Type {
+	case "c":
+		delta := math.Round(line.Value / line.SampleRate)
+		if math.Abs(delta) >= math.MaxInt64 {
+			srv.logger.Printf("statsd: skip counter %s: value %v is out of range\n", line.Name, delta)
+			return
+		}
+		if !srv.checkType(ctx, line.Name, "counter") {
+			return
+		}
+		srv.repo.Append(ctx, line.Name, int64(delta))
+	case "g":
+		if !srv.checkType(ctx, line.Name, "gauge") {
+			return
+		}
+		value := line.Value
+		if line.Relative {
+			if current, ok := srv.repo.Get(ctx, line.Name).(float64); ok {
+				value += current
+			}
+		}
+		srv.repo.Rewrite(ctx, line.Name, value)
+	case "ms", "h":
+		srv.mu.Lock()
+		t, ok := srv.timers[line.Name]
+		if !ok {
+			t = &timer{}
+			srv.timers[line.Name] = t
+		}
+		t.add(line.Value, line.SampleRate)
+		srv.mu.Unlock()
+	}
+}
+
+// Flush сохраняет агрегаты накопленных таймеров: количество как counter,
+// минимум, максимум, среднее и перцентили как gauge
+func (srv *Server) Flush(ctx context.Context) {
+	srv.mu.Lock()
+	timers := srv.timers
+	srv.timers = make(map[string]*timer)
+	srv.mu.Unlock()
+
+	metrics := make([]types.Metric, 0, len(timers)*(4+len(timerPercentiles)))
+	for name, t := range timers {
+		sort.Float64s(t.values)
+		count := int64(math.Round(t.count))
+		metrics = append(metrics,
+			types.Metric{ID: name + ".count", MType: "counter", Delta: &count},
+			gaugeMetric(name+".min", t.min),
+			gaugeMetric(name+".max", t.max),
+			gaugeMetric(name+".mean", t.sum/float64(t.seen)),
+		)
+		for _, p := range timerPercentiles {
+			metrics = append(metrics, gaugeMetric(fmt.Sprintf("%s.p%v", name, p), percentile(t.values, p)))
+		}
+	}
+	checked := metrics[:0]
+	for _, m := range metrics {
+		if srv.checkType(ctx, m.ID, m.MType) {
+			checked = append(checked, m)
+		}
+	}
+	if len(checked) > 0 {
+		srv.repo.StoreAll(ctx, &checked)
+	}
+}
+
+// reportDropped пишет в журнал число строк, отброшенных при
+// перегрузке хранилища с прошлого вызова
+func (srv *Server) reportDropped() {
+	if n := srv.dropped.Swap(0); n > 0 {
+		srv.logger.Printf("statsd: %d lines dropped: storage is overloaded\n", n)
+	}
+}
+
+// checkType проверяет, что под ключом key не сохранено значение другого
+// типа, чем mType, при несовпадении пишет в журнал
+func (srv *Server) checkType(ctx context.Context, key, mType string) bool {
+	if err := usecase.CheckType(ctx, srv.repo, key, mType); err != nil {
+		srv.logger.Printf("statsd: skip metric: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// ParseLine разбирает строку формата name:value|type[|@rate]
+func ParseLine(raw string) (Line, error) {
+	line := Line{SampleRate: 1}
+	sep := strings.Index(raw, ":")
+	if sep <= 0 {
+		return line, fmt.Errorf("bad line %q: no name", raw)
+	}
+	line.Name = raw[:sep]
+	parts := strings.Split(raw[sep+1:], "|")
+	if len(parts) < 2 {
+		return line, fmt.Errorf("bad line %q: no type", raw)
+	}
+	line.Type = parts[1]
+	switch line.Type {
+	case "c", "g", "ms", "h":
+	default:
+		return line, fmt.Errorf("bad line %q: unsupported type %s", raw, line.Type)
+	}
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return line, fmt.Errorf("bad line %q: %w", raw, err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return line, fmt.Errorf("bad line %q: value isn't finite", raw)
+	}
+	line.Value = value
+	line.Relative = line.Type == "g" && (strings.HasPrefix(parts[0], "+") || strings.HasPrefix(parts[0], "-"))
+	for _, part := range parts[2:] {
+		if !strings.HasPrefix(part, "@") {
+			continue
+		}
+		rate, err := strconv.ParseFloat(part[1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return line, fmt.Errorf("bad line %q: wrong sample rate", raw)
+		}
+		line.SampleRate = rate
+	}
+	return line, nil
+}
+
+// gaugeMetric возвращает gauge метрику
+func gaugeMetric(id string, value float64) types.Metric {
+	return types.Metric{ID: id, MType: "gauge", Value: &value}
+}
+
+// percentile возвращает перцентиль p отсортированных значений
+// методом ближайшего ранга
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,218 @@
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    Line
+		wantErr bool
+	}{
+		{
+			name: "counter",
+			raw:  "requests:3|c",
+			want: Line{Name: "requests", Value: 3, Type: "c", SampleRate: 1},
+		},
+		{
+			name: "counter with rate",
+			raw:  "requests:1|c|@0.1",
+			want: Line{Name: "requests", Value: 1, Type: "c", SampleRate: 0.1},
+		},
+		{
+			name: "gauge",
+			raw:  "temperature:36.6|g",
+			want: Line{Name: "temperature", Value: 36.6, Type: "g", SampleRate: 1},
+		},
+		{
+			name: "relative gauge",
+			raw:  "queue:-2|g",
+			want: Line{Name: "queue", Value: -2, Type: "g", SampleRate: 1, Relative: true},
+		},
+		{
+			name: "timer",
+			raw:  "db.query:320|ms",
+			want: Line{Name: "db.query", Value: 320, Type: "ms", SampleRate: 1},
+		},
+		{
+			name: "histogram with tags",
+			raw:  "size:12|h|#env:prod",
+			want: Line{Name: "size", Value: 12, Type: "h", SampleRate: 1},
+		},
+		{name: "no name", raw: ":1|c", wantErr: true},
+		{name: "no type", raw: "requests:1", wantErr: true},
+		{name: "set type", raw: "users:5|s", wantErr: true},
+		{name: "bad value", raw: "requests:a|c", wantErr: true},
+		{name: "NaN", raw: "requests:NaN|c", wantErr: true},
+		{name: "infinity", raw: "temperature:+Inf|g", wantErr: true},
+		{name: "bad rate", raw: "requests:1|c|@2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := ParseLine(tt.raw)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, line)
+		})
+	}
+}
+
+func TestServer_StoreFlush(t *testing.T) {
+	ctx := context.Background()
+	stor := make(map[string]interface{})
+	srv := NewServer("", storage.NewMemStorage(storage.WithBuffer(stor)), log.Default())
+
+	for _, raw := range []string{
+		"requests:2|c",
+		"requests:1|c|@0.5",
+		"queue:10|g",
+		"queue:-3|g",
+		"query:10|ms",
+		"query:30|ms",
+		"query:20|ms|@0.5",
+	} {
+		srv.handleLine(ctx, raw)
+	}
+	srv.Flush(ctx)
+
+	assert.Equal(t, int64(4), stor["requests"])
+	assert.Equal(t, float64(7), stor["queue"])
+	assert.Equal(t, int64(4), stor["query.count"])
+	assert.Equal(t, float64(10), stor["query.min"])
+	assert.Equal(t, float64(30), stor["query.max"])
+	assert.Equal(t, float64(20), stor["query.mean"])
+	assert.Equal(t, float64(20), stor["query.p50"])
+	assert.Equal(t, float64(30), stor["query.p99"])
+
+	// после Flush накопленные таймеры сбрасываются
+	srv.Flush(ctx)
+	assert.Equal(t, int64(4), stor["query.count"])
+}
+
+func Test_timer_add(t *testing.T) {
+	tm := &timer{}
+	for i := 1; i <= 3*maxTimerValues; i++ {
+		tm.add(float64(i), 0.5)
+	}
+	// выборка ограничена, агрегаты точные
+	assert.Len(t, tm.values, maxTimerValues)
+	assert.Equal(t, 3*maxTimerValues, tm.seen)
+	assert.Equal(t, float64(6*maxTimerValues), tm.count)
+	assert.Equal(t, 1.0, tm.min)
+	assert.Equal(t, float64(3*maxTimerValues), tm.max)
+	assert.Equal(t, float64(3*maxTimerValues)*float64(3*maxTimerValues+1)/2, tm.sum)
+}
+
+func TestServer_Store_typeMismatch(t *testing.T) {
+	ctx := context.Background()
+	stor := map[string]interface{}{"query.max": int64(1)}
+	srv := NewServer("", storage.NewMemStorage(storage.WithBuffer(stor)), log.Default())
+
+	// строка с типом, отличным от сохраненного, пропускается
+	srv.handleLine(ctx, "x:1|g")
+	srv.handleLine(ctx, "x:1|c")
+	srv.handleLine(ctx, "y:1|c")
+	srv.handleLine(ctx, "y:1|g")
+	srv.handleLine(ctx, "query:10|ms")
+	srv.Flush(ctx)
+
+	assert.Equal(t, float64(1), stor["x"])
+	assert.Equal(t, int64(1), stor["y"])
+	assert.Equal(t, int64(1), stor["query.max"])
+	assert.Equal(t, float64(10), stor["query.min"])
+}
+
+// throttledStorage хранилище, которое не успевает сохранять значения
+type throttledStorage struct {
+	types.Repository
+}
+
+func (ts throttledStorage) Throttle() error { return errors.New("queue is full") }
+
+func TestServer_handleLine_throttled(t *testing.T) {
+	ctx := context.Background()
+	stor := map[string]interface{}{}
+	logs := &bytes.Buffer{}
+	srv := NewServer("", throttledStorage{storage.NewMemStorage(storage.WithBuffer(stor))}, log.New(logs, "", 0))
+
+	// при перегрузке хранилища строки отбрасываются без ожидания
+	srv.handleLine(ctx, "x:1|g")
+	srv.handleLine(ctx, "query:10|ms")
+	assert.Empty(t, stor)
+	srv.reportDropped()
+	assert.Contains(t, logs.String(), "statsd: 2 lines dropped")
+	srv.reportDropped()
+	assert.Equal(t, 1, strings.Count(logs.String(), "dropped"))
+}
+
+func TestServer_trusted(t *testing.T) {
+	srv := NewServer("", storage.NewMemStorage(), log.Default())
+	assert.True(t, srv.trusted(&net.UDPAddr{IP: net.ParseIP("192.168.0.1")}))
+
+	srv = NewServer("", storage.NewMemStorage(), log.Default(), WithTrustedSubnet("10.0.0.0/8"))
+	assert.True(t, srv.trusted(&net.UDPAddr{IP: net.ParseIP("10.1.2.3")}))
+	assert.True(t, srv.trusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+	assert.False(t, srv.trusted(&net.UDPAddr{IP: net.ParseIP("192.168.0.1")}))
+	assert.False(t, srv.trusted(&net.TCPAddr{IP: net.ParseIP("192.168.0.1")}))
+}
+
+func TestServer_Run(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listen.Addr().String()
+	require.NoError(t, listen.Close())
+
+	locStorage := storage.NewMemStorage()
+	srv := NewServer(addr, locStorage, log.Default(), WithFlushInterval(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go srv.Run(ctx, wg)
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		_, err = fmt.Fprint(conn, "tcp.counter:5|c\ntcp.timer:7|ms\n")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	udpConn, err := net.Dial("udp", addr)
+	require.NoError(t, err)
+	defer udpConn.Close()
+	require.Eventually(t, func() bool {
+		_, err = fmt.Fprint(udpConn, "udp.gauge:1.5|g")
+		require.NoError(t, err)
+		return locStorage.Get(context.Background(), "udp.gauge") != nil &&
+			locStorage.Get(context.Background(), "tcp.counter") != nil
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, int64(5), locStorage.Get(context.Background(), "tcp.counter"))
+	assert.Equal(t, 1.5, locStorage.Get(context.Background(), "udp.gauge"))
+	// при остановке сохраняются накопленные таймеры
+	assert.Equal(t, float64(7), locStorage.Get(context.Background(), "tcp.timer.max"))
+}
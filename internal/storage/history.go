@@ -0,0 +1,128 @@
+// Часть модуля storage содержит историю значений временных рядов
+// в кольцевом буфере ограниченной глубины.
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// history кольцевой буфер значений одного временного ряда,
+// значения хранятся в порядке записи
+type history struct {
+	samples []types.Sample
+	start   int
+	size    int
+}
+
+// newHistory создает кольцевой буфер на depth значений
+func newHistory(depth int) *history {
+	return &history{samples: make([]types.Sample, depth)}
+}
+
+// add добавляет значение, при заполнении буфера вытесняется самое старое
+func (h *history) add(sample types.Sample) {
+	if h.size < len(h.samples) {
+		h.samples[(h.start+h.size)%len(h.samples)] = sample
+		h.size++
+		return
+	}
+	h.samples[h.start] = sample
+	h.start = (h.start + 1) % len(h.samples)
+}
+
+// prune удаляет значения, записанные раньше before
+func (h *history) prune(before time.Time) {
+	for h.size > 0 && h.samples[h.start].Timestamp.Before(before) {
+		h.samples[h.start] = types.Sample{}
+		h.start = (h.start + 1) % len(h.samples)
+		h.size--
+	}
+}
+
+// between возвращает значения, записанные в интервале [from, to]
+func (h *history) between(from, to time.Time) []types.Sample {
+	out := make([]types.Sample, 0)
+	for i := 0; i < h.size; i++ {
+		sample := h.samples[(h.start+i)%len(h.samples)]
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		out = append(out, sample)
+	}
+	return out
+}
+
+// Range возвращает историю значений временного ряда key в интервале
+// [from, to], значения старше срока хранения не возвращаются
+func (ms *MemStorage) Range(ctx context.Context, key string, from, to time.Time) []types.Sample {
+	select {
+	case <-ctx.Done():
+		return nil
+	default:
+		ms.mu.RLock()
+		defer ms.mu.RUnlock()
+		h, ok := ms.history[key]
+		if !ok {
+			return make([]types.Sample, 0)
+		}
+		if ms.retention > 0 {
+			if oldest := ms.now().Add(-ms.retention); from.Before(oldest) {
+				from = oldest
+			}
+		}
+		return h.between(from, to)
+	}
+}
+
+// record внутренняя функция записи значения в историю ряда,
+// вызывается под блокировкой
+func (ms *MemStorage) record(key string, value float64) {
+	if ms.depth <= 0 {
+		return
+	}
+	now := ms.now()
+	if ms.retention > 0 {
+		ms.evict(now)
+	}
+	h, ok := ms.history[key]
+	if !ok {
+		h = newHistory(ms.depth)
+		ms.history[key] = h
+	}
+	if ms.retention > 0 {
+		h.prune(now.Add(-ms.retention))
+	}
+	h.add(types.Sample{Timestamp: now, Value: value})
+}
+
+// evict удаляет историю рядов, не получавших значений дольше срока
+// хранения; ряды проверяются не чаще раза за срок хранения, вызывается
+// под блокировкой
+func (ms *MemStorage) evict(now time.Time) {
+	if now.Sub(ms.evicted) < ms.retention {
+		return
+	}
+	ms.evicted = now
+	before := now.Add(-ms.retention)
+	for key, h := range ms.history {
+		if h.prune(before); h.size == 0 {
+			delete(ms.history, key)
+		}
+	}
+}
+
+// WithHistory модифицирует MemStorage включая историю значений counter
+// и gauge метрик, depth ограничивает число значений одного ряда,
+// retention срок их хранения, история ряда без значений за срок
+// хранения удаляется. При retention == 0 срок не ограничен и история
+// рядов хранится до перезапуска
+func WithHistory(depth int, retention time.Duration) Option {
+	return func(mem *MemStorage) *MemStorage {
+		mem.depth = depth
+		mem.retention = retention
+		return mem
+	}
+}
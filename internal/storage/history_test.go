@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemStorage_Range(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	clock := start
+	ms := NewMemStorage(WithHistory(3, time.Hour))
+	ms.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		clock = start.Add(time.Duration(i) * time.Minute)
+		ms.Rewrite(ctx, "Alloc", float64(i))
+		ms.Append(ctx, "PollCount", 2)
+	}
+
+	t.Run("depth", func(t *testing.T) {
+		got := ms.Range(ctx, "Alloc", start, clock)
+		assert.Equal(t, []types.Sample{
+			{Timestamp: start.Add(time.Minute), Value: 1},
+			{Timestamp: start.Add(2 * time.Minute), Value: 2},
+			{Timestamp: start.Add(3 * time.Minute), Value: 3},
+		}, got)
+	})
+	t.Run("counter total", func(t *testing.T) {
+		got := ms.Range(ctx, "PollCount", start.Add(2*time.Minute), start.Add(2*time.Minute))
+		assert.Equal(t, []types.Sample{{Timestamp: start.Add(2 * time.Minute), Value: 6}}, got)
+	})
+	t.Run("unknown", func(t *testing.T) {
+		assert.Empty(t, ms.Range(ctx, "Unknown", start, clock))
+	})
+	t.Run("retention", func(t *testing.T) {
+		clock = start.Add(time.Hour + 150*time.Second)
+		got := ms.Range(ctx, "Alloc", start, clock)
+		assert.Equal(t, []types.Sample{{Timestamp: start.Add(3 * time.Minute), Value: 3}}, got)
+
+		ms.Rewrite(ctx, "Alloc", 4)
+		got = ms.Range(ctx, "Alloc", start, clock)
+		assert.Equal(t, []types.Sample{
+			{Timestamp: start.Add(3 * time.Minute), Value: 3},
+			{Timestamp: clock, Value: 4},
+		}, got)
+	})
+}
+
+func TestMemStorage_evict(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	clock := start
+	ms := NewMemStorage(WithHistory(3, time.Hour))
+	ms.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	ms.Rewrite(ctx, "Alloc", 1)
+	ms.Rewrite(ctx, "HeapSys", 1)
+	// ряд без значений за срок хранения удаляется из истории
+	clock = start.Add(time.Hour + time.Minute)
+	ms.Rewrite(ctx, "Alloc", 2)
+	assert.Len(t, ms.history, 1)
+	assert.Equal(t, []types.Sample{{Timestamp: clock, Value: 2}}, ms.Range(ctx, "Alloc", start, clock))
+	assert.Equal(t, float64(1), ms.Get(ctx, "HeapSys"))
+}
+
+func TestMemStorage_Range_disabled(t *testing.T) {
+	ms := NewMemStorage()
+	ctx := context.Background()
+	ms.Rewrite(ctx, "Alloc", 1)
+	metrics := []types.Metric{{ID: "Alloc", MType: "gauge", Value: new(float64)}}
+	ms.StoreAll(ctx, &metrics)
+	assert.Empty(t, ms.Range(ctx, "Alloc", time.Time{}, time.Now()))
+}
@@ -3,7 +3,9 @@ package storage
 import (
 	"context"
 	"log"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,31 +16,50 @@ import (
 // Option тип для модификации хранилища MemStorage
 type Option func(mem *MemStorage) *MemStorage
 
-// MemStorage тип реализации хранения в памяти
+// MemStorage тип реализации хранения в памяти,
+// безопасен для конкурентного использования
 type MemStorage struct {
-	buffer map[string]interface{}
+	mu        sync.RWMutex
+	buffer    map[string]interface{}
+	series    map[string]map[string]struct{} // ключи рядов с метками по имени метрики
+	history   map[string]*history
+	evicted   time.Time // время последнего удаления устаревшей истории
+	depth     int
+	retention time.Duration
+	now       func() time.Time
 }
 
 // NewMemStorage создает хранилище MemStorage
 func NewMemStorage(opts ...Option) *MemStorage {
 	buffer := make(map[string]interface{})
 	ms := &MemStorage{
-		buffer: buffer,
+		buffer:  buffer,
+		history: make(map[string]*history),
+		now:     time.Now,
 	}
 
 	for _, opt := range opts {
 		ms = opt(ms)
 	}
+	for key := range ms.buffer {
+		ms.index(key)
+	}
 
 	return ms
 }
 
-// Append сохраняет новое значение типа counter с дозаписью к старому
+// Append сохраняет новое значение типа counter с дозаписью к старому,
+// значение другого типа под ключом key не изменяется
 func (ms *MemStorage) Append(ctx context.Context, key string, value int64) {
 	select {
 	case <-ctx.Done():
 		return
 	default:
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+		if ms.mismatched(key, "counter") {
+			return
+		}
 		var val int64
 		_, ok := ms.buffer[key]
 		if ok {
@@ -46,7 +67,34 @@ func (ms *MemStorage) Append(ctx context.Context, key string, value int64) {
 		} else {
 			val = value
 		}
-		ms.buffer[key] = val
+		ms.set(key, val)
+		ms.record(key, float64(val))
+	}
+}
+
+// AppendTotal атомарно дописывает к значению counter приращение, после
+// которого значение равно накопленному значению источника total, и
+// возвращает это приращение; если total меньше текущего значения,
+// счетчик источника был сброшен и дописывается весь total. Значение
+// другого типа под ключом key не изменяется
+func (ms *MemStorage) AppendTotal(ctx context.Context, key string, total int64) int64 {
+	select {
+	case <-ctx.Done():
+		return 0
+	default:
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+		if ms.mismatched(key, "counter") {
+			return 0
+		}
+		current, _ := ms.buffer[key].(int64)
+		delta := total - current
+		if total < current {
+			delta = total
+		}
+		ms.set(key, current+delta)
+		ms.record(key, float64(current+delta))
+		return delta
 	}
 }
 
@@ -56,6 +104,8 @@ func (ms *MemStorage) Get(ctx context.Context, key string) interface{} {
 	case <-ctx.Done():
 		return nil
 	default:
+		ms.mu.RLock()
+		defer ms.mu.RUnlock()
 		val, ok := ms.buffer[key]
 		if ok {
 			return val
@@ -66,12 +116,56 @@ func (ms *MemStorage) Get(ctx context.Context, key string) interface{} {
 
 // GetAll возвращает все метрики
 func (ms *MemStorage) GetAll(ctx context.Context) map[string]interface{} {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	return maps.Clone(ms.buffer)
 }
 
-// Rewrite перезаписывает значение метрики типа gauge
+// Rewrite перезаписывает значение метрики типа gauge, значение другого
+// типа под ключом key не изменяется
 func (ms *MemStorage) Rewrite(ctx context.Context, key string, value float64) {
-	ms.buffer[key] = value
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.mismatched(key, "gauge") {
+		return
+	}
+	ms.set(key, value)
+	ms.record(key, value)
+}
+
+// AppendHistogram сохраняет значение типа histogram со сложением
+// с текущим, при изменении границ бакетов значение перезаписывается
+func (ms *MemStorage) AppendHistogram(ctx context.Context, key string, value types.Histogram) {
+	select {
+	case <-ctx.Done():
+		return
+	default:
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+		ms.appendHistogram(key, value)
+	}
+}
+
+// RewriteSummary перезаписывает значение метрики типа summary,
+// значение другого типа под ключом key не изменяется
+func (ms *MemStorage) RewriteSummary(ctx context.Context, key string, value types.Summary) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.mismatched(key, "summary") {
+		return
+	}
+	ms.set(key, value)
+}
+
+// appendHistogram внутренняя функция сложения гистограмм,
+// вызывается под блокировкой
+func (ms *MemStorage) appendHistogram(key string, value types.Histogram) {
+	if ms.mismatched(key, "histogram") {
+		return
+	}
+	current, _ := ms.buffer[key].(types.Histogram)
+	merged, _ := current.Merge(value)
+	ms.set(key, merged)
 }
 
 // StoreAll сохраняет все полученные метрики через слайс metrics
@@ -80,24 +174,119 @@ func (ms *MemStorage) StoreAll(ctx context.Context, metrics *[]types.Metric) {
 	case <-ctx.Done():
 		return
 	default:
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
 		for _, m := range *metrics {
+			key := m.Key()
+			if ms.mismatched(key, m.MType) {
+				continue
+			}
 			switch m.MType {
 			case "counter":
 				var val int64
-				_, ok := ms.buffer[m.ID]
+				_, ok := ms.buffer[key]
 				if ok {
-					val = ms.buffer[m.ID].(int64) + *m.Delta
+					val = ms.buffer[key].(int64) + *m.Delta
 				} else {
 					val = *m.Delta
 				}
-				ms.buffer[m.ID] = val
+				ms.set(key, val)
+				ms.record(key, float64(val))
 			case "gauge":
-				ms.buffer[m.ID] = *m.Value
+				ms.set(key, *m.Value)
+				ms.record(key, *m.Value)
+			case "histogram":
+				if m.Histogram != nil && m.Histogram.Validate() == nil {
+					ms.appendHistogram(key, *m.Histogram)
+				}
+			case "summary":
+				if m.Summary != nil && m.Summary.Validate() == nil {
+					ms.set(key, *m.Summary)
+				}
 			}
 		}
 	}
 }
 
+// Series возвращает ключи рядов метрики name с метками
+func (ms *MemStorage) Series(ctx context.Context, name string) []string {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	keys := make([]string, 0, len(ms.series[name]))
+	for key := range ms.series[name] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Rename переносит значение и историю ряда from в ряд to, если ряд to
+// еще не сохранен, и возвращает true при переносе
+func (ms *MemStorage) Rename(ctx context.Context, from, to string) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	value, ok := ms.buffer[from]
+	if !ok {
+		return false
+	}
+	if _, ok := ms.buffer[to]; ok {
+		return false
+	}
+	delete(ms.buffer, from)
+	ms.unindex(from)
+	ms.set(to, value)
+	if h, ok := ms.history[from]; ok {
+		delete(ms.history, from)
+		ms.history[to] = h
+	}
+	return true
+}
+
+// set сохраняет значение ряда key, вызывается под блокировкой
+func (ms *MemStorage) set(key string, value interface{}) {
+	if _, ok := ms.buffer[key]; !ok {
+		ms.index(key)
+	}
+	ms.buffer[key] = value
+}
+
+// index добавляет ряд key с метками в индекс рядов по имени метрики,
+// вызывается под блокировкой
+func (ms *MemStorage) index(key string) {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return
+	}
+	if ms.series == nil {
+		ms.series = make(map[string]map[string]struct{})
+	}
+	keys, ok := ms.series[key[:start]]
+	if !ok {
+		keys = make(map[string]struct{})
+		ms.series[key[:start]] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+// unindex удаляет ряд key из индекса рядов, вызывается под блокировкой
+func (ms *MemStorage) unindex(key string) {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return
+	}
+	delete(ms.series[key[:start]], key)
+	if len(ms.series[key[:start]]) == 0 {
+		delete(ms.series, key[:start])
+	}
+}
+
+// mismatched проверяет, что под ключом key сохранено значение другого
+// типа, чем mType, вызывается под блокировкой
+func (ms *MemStorage) mismatched(key, mType string) bool {
+	current, ok := ms.buffer[key]
+	return ok && types.ValueType(current) != mType
+}
+
 // Close для реализации интерфейса Storager
 func (ms *MemStorage) Close() error { return nil }
 
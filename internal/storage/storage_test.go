@@ -3,11 +3,13 @@ package storage
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/hrapovd1/pmetrics/internal/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/maps"
 )
 
 func TestMemStorage_Rewrite(t *testing.T) {
@@ -72,6 +74,50 @@ func TestMemStorage_Append(t *testing.T) {
 	})
 }
 
+func TestMemStorage_AppendTotal(t *testing.T) {
+	stor := map[string]interface{}{"Count1": int64(10), "Gauge1": 1.5}
+	ms := NewMemStorage(WithBuffer(stor))
+	ctx := context.Background()
+	assert.Equal(t, int64(5), ms.AppendTotal(ctx, "Count1", 15))
+	assert.Equal(t, int64(15), stor["Count1"])
+	// счетчик источника сброшен
+	assert.Equal(t, int64(3), ms.AppendTotal(ctx, "Count1", 3))
+	assert.Equal(t, int64(18), stor["Count1"])
+	assert.Equal(t, int64(7), ms.AppendTotal(ctx, "Count2", 7))
+	assert.Equal(t, int64(7), stor["Count2"])
+	// значение другого типа не изменяется
+	assert.Equal(t, int64(0), ms.AppendTotal(ctx, "Gauge1", 7))
+	assert.Equal(t, 1.5, stor["Gauge1"])
+}
+
+func TestMemStorage_AppendHistogram(t *testing.T) {
+	stor := make(map[string]interface{})
+	ms := NewMemStorage(WithBuffer(stor))
+	ctx := context.Background()
+	value := types.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 2}, Sum: 4, Count: 3}
+	ms.AppendHistogram(ctx, "H1", value)
+	ms.AppendHistogram(ctx, "H1", value)
+	assert.Equal(t, types.Histogram{Bounds: []float64{1}, Counts: []uint64{2, 4}, Sum: 8, Count: 6}, stor["H1"])
+	// сохраненное значение не зависит от переданного
+	assert.Equal(t, []uint64{1, 2}, value.Counts)
+
+	// новые границы бакетов заменяют значение
+	other := types.Histogram{Bounds: []float64{5}, Counts: []uint64{0, 1}, Sum: 6, Count: 1}
+	ms.AppendHistogram(ctx, "H1", other)
+	assert.Equal(t, other, stor["H1"])
+}
+
+func TestMemStorage_RewriteSummary(t *testing.T) {
+	stor := make(map[string]interface{})
+	ms := NewMemStorage(WithBuffer(stor))
+	ctx := context.Background()
+	for _, count := range []uint64{3, 5} {
+		value := types.Summary{Quantiles: []types.Quantile{{Quantile: 0.5, Value: 1}}, Count: count}
+		ms.RewriteSummary(ctx, "S1", value)
+		assert.Equal(t, value, stor["S1"])
+	}
+}
+
 func TestMemStorage_Get(t *testing.T) {
 	stor := make(map[string]interface{})
 	ctx := context.Background()
@@ -134,6 +180,49 @@ func TestMemStorage_Get(t *testing.T) {
 	}
 }
 
+func TestMemStorage_typeMismatch(t *testing.T) {
+	histogram := types.Histogram{Counts: []uint64{1}, Sum: 2, Count: 1}
+	summary := types.Summary{Sum: 1, Count: 1}
+	stor := map[string]interface{}{"C1": int64(1), "G1": 1.5, "H1": histogram, "S1": summary}
+	ms := NewMemStorage(WithBuffer(stor))
+	ctx := context.Background()
+	want := maps.Clone(stor)
+
+	// значение другого типа не изменяется
+	ms.Append(ctx, "G1", 1)
+	ms.Rewrite(ctx, "C1", 2)
+	ms.AppendHistogram(ctx, "S1", histogram)
+	ms.RewriteSummary(ctx, "H1", summary)
+	delta := int64(1)
+	ms.StoreAll(ctx, &[]types.Metric{{ID: "H1", MType: "counter", Delta: &delta}})
+	assert.Equal(t, want, stor)
+}
+
+func TestMemStorage_Series(t *testing.T) {
+	ms := NewMemStorage(WithBuffer(map[string]interface{}{
+		"Alloc": 1.5, `Alloc{agent="a2"}`: 2.5, `AllocX{agent="a1"}`: 3.5,
+	}))
+	ctx := context.Background()
+	ms.Rewrite(ctx, `Alloc{agent="a1"}`, 0.5)
+	assert.Equal(t, []string{`Alloc{agent="a1"}`, `Alloc{agent="a2"}`}, ms.Series(ctx, "Alloc"))
+	assert.Empty(t, ms.Series(ctx, "HeapSys"))
+}
+
+func TestMemStorage_Rename(t *testing.T) {
+	stor := map[string]interface{}{"PollCount": int64(5), "Alloc": 1.5, `Alloc{agent="a1"}`: 2.5}
+	ms := NewMemStorage(WithBuffer(stor), WithHistory(10, 0))
+	ctx := context.Background()
+	ms.Append(ctx, "PollCount", 1)
+
+	assert.True(t, ms.Rename(ctx, "PollCount", `PollCount{agent="a1"}`))
+	// ряд, значение которого уже сохранено, не перезаписывается
+	assert.False(t, ms.Rename(ctx, "Alloc", `Alloc{agent="a1"}`))
+	assert.False(t, ms.Rename(ctx, "HeapSys", `HeapSys{agent="a1"}`))
+	assert.Equal(t, map[string]interface{}{`PollCount{agent="a1"}`: int64(6), "Alloc": 1.5, `Alloc{agent="a1"}`: 2.5}, stor)
+	assert.Len(t, ms.Range(ctx, `PollCount{agent="a1"}`, time.Time{}, time.Now()), 1)
+	assert.Equal(t, []string{`PollCount{agent="a1"}`}, ms.Series(ctx, "PollCount"))
+}
+
 func TestMemStorage_GetAll(t *testing.T) {
 	stor := make(map[string]interface{})
 	ms := NewMemStorage(WithBuffer(stor))
@@ -157,6 +246,9 @@ func TestMemStorage_StoreAll(t *testing.T) {
 	metrics := []types.Metric{
 		{ID: "M1", MType: "counter", Delta: &m1},
 		{ID: "M2", MType: "gauge", Value: &m2},
+		{ID: "M1", MType: "counter", Delta: &m1, Labels: map[string]string{"agent": "a1"}},
+		{ID: "H1", MType: "histogram", Histogram: &types.Histogram{Counts: []uint64{1}, Sum: 2, Count: 1}},
+		{ID: "H2", MType: "histogram"},
 	}
 	buff := make(map[string]interface{})
 
@@ -171,7 +263,10 @@ func TestMemStorage_StoreAll(t *testing.T) {
 				ctx:     context.Background(),
 				metrics: &metrics,
 			},
-			map[string]interface{}{"M1": int64(4567), "M2": float64(45.67)},
+			map[string]interface{}{
+				"M1": int64(4567), "M2": float64(45.67), `M1{agent="a1"}`: int64(4567),
+				"H1": types.Histogram{Counts: []uint64{1}, Sum: 2, Count: 1},
+			},
 		},
 		{
 			"second test",
@@ -179,7 +274,10 @@ func TestMemStorage_StoreAll(t *testing.T) {
 				ctx:     context.Background(),
 				metrics: &metrics,
 			},
-			map[string]interface{}{"M1": int64(9134), "M2": float64(45.67)},
+			map[string]interface{}{
+				"M1": int64(9134), "M2": float64(45.67), `M1{agent="a1"}`: int64(9134),
+				"H1": types.Histogram{Counts: []uint64{2}, Sum: 4, Count: 2},
+			},
 		},
 	}
 	for _, tt := range tests {
@@ -0,0 +1,305 @@
+// Часть модуля tsdb содержит блоки - неизменяемые файлы значений
+// рядов за интервал времени. Файл блока содержит сжатые чанки
+// значений, затем индекс рядов по ключу и окончание: смещение
+// индекса, его CRC32 и сигнатуру формата.
+package tsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Формат файла блока
+const (
+	blockMagic      = "PMTSDB01"
+	blockExt        = ".block"
+	blockFooterSize = 8 + 4 + len(blockMagic)
+)
+
+// errCorrupted данные блока или журнала повреждены
+var errCorrupted = errors.New("tsdb: corrupted data")
+
+// chunkMeta положение чанка значений в файле блока
+type chunkMeta struct {
+	minT, maxT int64
+	offset     uint64
+	length     uint64
+	crc        uint32
+}
+
+// blockSeries ряд блока и его чанки, отсортированные по времени
+type blockSeries struct {
+	key    string
+	mtype  string
+	chunks []chunkMeta
+}
+
+// block открытый файл блока значений за интервал [minT, maxT)
+type block struct {
+	path   string
+	minT   int64
+	maxT   int64
+	file   *os.File
+	series map[string]*blockSeries
+}
+
+// seriesSamples значения ряда для записи в блок
+type seriesSamples struct {
+	key     string
+	mtype   string
+	samples []sample
+}
+
+// writeBlock записывает значения рядов за интервал [minT, maxT) в
+// новый файл блока каталога dir и открывает его; файл записывается
+// под временным именем и переименовывается после сброса на диск
+func writeBlock(dir string, minT, maxT int64, series []seriesSamples) (*block, error) {
+	sort.Slice(series, func(i, j int) bool { return series[i].key < series[j].key })
+	name := fmt.Sprintf("%d-%d-%d%s", minT, maxT, time.Now().UnixNano(), blockExt)
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	index := make([]byte, 0, 1024)
+	index = binary.AppendVarint(index, minT)
+	index = binary.AppendVarint(index, maxT)
+	index = binary.AppendUvarint(index, uint64(len(series)))
+	var offset uint64
+	for _, s := range series {
+		index = appendString(index, s.key)
+		index = appendString(index, s.mtype)
+		index = binary.AppendUvarint(index, uint64((len(s.samples)+samplesPerChunk-1)/samplesPerChunk))
+		for start := 0; start < len(s.samples); start += samplesPerChunk {
+			end := start + samplesPerChunk
+			if end > len(s.samples) {
+				end = len(s.samples)
+			}
+			part := s.samples[start:end]
+			data := encodeChunk(part)
+			if _, err := file.Write(data); err != nil {
+				file.Close()
+				return nil, err
+			}
+			index = binary.AppendVarint(index, part[0].t)
+			index = binary.AppendVarint(index, part[len(part)-1].t)
+			index = binary.AppendUvarint(index, offset)
+			index = binary.AppendUvarint(index, uint64(len(data)))
+			index = binary.BigEndian.AppendUint32(index, crc32.ChecksumIEEE(data))
+			offset += uint64(len(data))
+		}
+	}
+	footer := binary.BigEndian.AppendUint64(nil, offset)
+	footer = binary.BigEndian.AppendUint32(footer, crc32.ChecksumIEEE(index))
+	footer = append(footer, blockMagic...)
+	if _, err := file.Write(append(index, footer...)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return openBlock(path)
+}
+
+// openBlock открывает файл блока и читает его индекс
+func openBlock(path string) (*block, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readBlockIndex(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	b.path = path
+	return b, nil
+}
+
+// readBlockIndex читает окончание и индекс файла блока
+func readBlockIndex(file *os.File) (*block, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < int64(blockFooterSize) {
+		return nil, errCorrupted
+	}
+	footer := make([]byte, blockFooterSize)
+	if _, err := file.ReadAt(footer, size-int64(blockFooterSize)); err != nil {
+		return nil, err
+	}
+	if string(footer[12:]) != blockMagic {
+		return nil, errCorrupted
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(footer))
+	if indexOffset > size-int64(blockFooterSize) {
+		return nil, errCorrupted
+	}
+	index := make([]byte, size-int64(blockFooterSize)-indexOffset)
+	if _, err := file.ReadAt(index, indexOffset); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(index) != binary.BigEndian.Uint32(footer[8:12]) {
+		return nil, errCorrupted
+	}
+	d := decoder{buf: index}
+	b := &block{file: file, minT: d.varint(), maxT: d.varint(), series: make(map[string]*blockSeries)}
+	count := d.uvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		s := &blockSeries{key: d.string(), mtype: d.string()}
+		chunks := d.uvarint()
+		for j := uint64(0); j < chunks && d.err == nil; j++ {
+			s.chunks = append(s.chunks, chunkMeta{
+				minT:   d.varint(),
+				maxT:   d.varint(),
+				offset: d.uvarint(),
+				length: d.uvarint(),
+				crc:    d.uint32(),
+			})
+		}
+		b.series[s.key] = s
+	}
+	return b, d.err
+}
+
+// samples возвращает значения ряда key в интервале [from, to]
+func (b *block) samples(key string, from, to int64) ([]sample, error) {
+	s, ok := b.series[key]
+	if !ok || to < b.minT || from >= b.maxT {
+		return nil, nil
+	}
+	out := make([]sample, 0)
+	for _, c := range s.chunks {
+		if c.maxT < from || c.minT > to {
+			continue
+		}
+		data := make([]byte, c.length)
+		if _, err := b.file.ReadAt(data, int64(c.offset)); err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(data) != c.crc {
+			return nil, fmt.Errorf("%s: %w", b.path, errCorrupted)
+		}
+		decoded, err := decodeChunk(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.path, err)
+		}
+		for _, smp := range decoded {
+			if smp.t >= from && smp.t <= to {
+				out = append(out, smp)
+			}
+		}
+	}
+	return out, nil
+}
+
+// all возвращает все значения ряда key
+func (b *block) all(key string) ([]sample, error) {
+	return b.samples(key, math.MinInt64, math.MaxInt64)
+}
+
+// close закрывает файл блока
+func (b *block) close() error {
+	return b.file.Close()
+}
+
+// remove закрывает и удаляет файл блока
+func (b *block) remove() error {
+	b.file.Close()
+	return os.Remove(b.path)
+}
+
+// appendString добавляет строку с длиной в формате uvarint
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder читает значения из буфера, первая ошибка сохраняется в err
+// и последующие чтения возвращают нулевые значения
+type decoder struct {
+	buf []byte
+	err error
+}
+
+// uvarint читает число в формате uvarint
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errCorrupted
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// varint читает число в формате varint
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errCorrupted
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// uint32 читает 4 байта big endian
+func (d *decoder) uint32() uint32 {
+	if d.err != nil || len(d.buf) < 4 {
+		d.err = errCorrupted
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.buf)
+	d.buf = d.buf[4:]
+	return v
+}
+
+// uint64 читает 8 байт big endian
+func (d *decoder) uint64() uint64 {
+	if d.err != nil || len(d.buf) < 8 {
+		d.err = errCorrupted
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+// string читает строку с длиной в формате uvarint
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil || uint64(len(d.buf)) < n {
+		d.err = errCorrupted
+		return ""
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
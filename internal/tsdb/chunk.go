@@ -0,0 +1,273 @@
+// Часть модуля tsdb содержит сжатие значений временного ряда по
+// алгоритму Gorilla: время кодируется разностью разностей, значения -
+// XOR с предыдущим значением.
+package tsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// samplesPerChunk наибольшее число значений в одном чанке
+const samplesPerChunk = 120
+
+// errChunk чанк поврежден
+var errChunk = errors.New("tsdb: corrupted chunk")
+
+// sample значение временного ряда, время в миллисекундах unix времени
+type sample struct {
+	t int64
+	v float64
+}
+
+// bitWriter пишет последовательность битов
+type bitWriter struct {
+	buf  []byte
+	free uint8 // свободные биты последнего байта
+}
+
+// writeBit записывает один бит
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.buf = append(w.buf, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.free
+	}
+}
+
+// writeBits записывает n младших битов v, начиная со старшего
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(v>>uint(i)&1 == 1)
+	}
+}
+
+// bitReader читает последовательность битов
+type bitReader struct {
+	buf []byte
+	pos int // номер следующего бита
+}
+
+// readBit читает один бит
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf)*8 {
+		return false, io.ErrUnexpectedEOF
+	}
+	bit := r.buf[r.pos/8]>>(7-uint(r.pos%8))&1 == 1
+	r.pos++
+	return bit, nil
+}
+
+// readBits читает n битов как число
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
+
+// Группы разности разностей времени: префикс группы и число битов
+// значения, значения вне групп записываются 64 битами
+var dodBuckets = []struct {
+	prefix, prefixBits int
+	bits               int
+}{
+	{prefix: 0b10, prefixBits: 2, bits: 14},
+	{prefix: 0b110, prefixBits: 3, bits: 17},
+	{prefix: 0b1110, prefixBits: 4, bits: 20},
+}
+
+// fitsBits проверяет, что x помещается в n битов со знаком
+func fitsBits(x int64, n int) bool {
+	return -(1<<(n-1))+1 <= x && x <= 1<<(n-1)
+}
+
+// encodeChunk сжимает отсортированные по времени значения: число
+// значений, время и значение первого, затем для каждого следующего
+// разность разностей времени и XOR значения с предыдущим
+func encodeChunk(samples []sample) []byte {
+	w := &bitWriter{buf: make([]byte, 2, 2+len(samples)*2)}
+	binary.BigEndian.PutUint16(w.buf, uint16(len(samples)))
+	if len(samples) == 0 {
+		return w.buf
+	}
+	var prevDelta int64
+	leading, trailing := uint8(0xff), uint8(0)
+	for i, s := range samples {
+		if i == 0 {
+			w.writeBits(uint64(s.t), 64)
+			w.writeBits(math.Float64bits(s.v), 64)
+			continue
+		}
+		delta := s.t - samples[i-1].t
+		writeDod(w, delta-prevDelta)
+		prevDelta = delta
+		leading, trailing = writeXOR(w, math.Float64bits(samples[i-1].v)^math.Float64bits(s.v), leading, trailing)
+	}
+	return w.buf
+}
+
+// writeDod записывает разность разностей времени
+func writeDod(w *bitWriter, dod int64) {
+	if dod == 0 {
+		w.writeBit(false)
+		return
+	}
+	for _, b := range dodBuckets {
+		if fitsBits(dod, b.bits) {
+			w.writeBits(uint64(b.prefix), b.prefixBits)
+			w.writeBits(uint64(dod)&(1<<b.bits-1), b.bits)
+			return
+		}
+	}
+	w.writeBits(0b1111, 4)
+	w.writeBits(uint64(dod), 64)
+}
+
+// writeXOR записывает XOR значения с предыдущим: 0 - значение не
+// изменилось, 10 - значащие биты в окне предыдущего XOR, 11 - число
+// нулевых старших битов, число значащих битов и значащие биты;
+// возвращает окно значащих битов
+func writeXOR(w *bitWriter, xor uint64, leading, trailing uint8) (uint8, uint8) {
+	if xor == 0 {
+		w.writeBit(false)
+		return leading, trailing
+	}
+	w.writeBit(true)
+	newLeading, newTrailing := uint8(bits.LeadingZeros64(xor)), uint8(bits.TrailingZeros64(xor))
+	if newLeading > 31 {
+		newLeading = 31
+	}
+	if leading != 0xff && newLeading >= leading && newTrailing >= trailing {
+		w.writeBit(false)
+		w.writeBits(xor>>trailing, 64-int(leading)-int(trailing))
+		return leading, trailing
+	}
+	w.writeBit(true)
+	sigbits := 64 - newLeading - newTrailing
+	w.writeBits(uint64(newLeading), 5)
+	// 64 значащих бита не помещаются в 6 битов и записываются как 0
+	w.writeBits(uint64(sigbits)&0x3f, 6)
+	w.writeBits(xor>>newTrailing, int(sigbits))
+	return newLeading, newTrailing
+}
+
+// decodeChunk возвращает значения сжатого чанка
+func decodeChunk(data []byte) ([]sample, error) {
+	if len(data) < 2 {
+		return nil, errChunk
+	}
+	count := int(binary.BigEndian.Uint16(data))
+	samples := make([]sample, 0, count)
+	r := &bitReader{buf: data[2:]}
+	var prevDelta int64
+	var leading, trailing uint8
+	for i := 0; i < count; i++ {
+		if i == 0 {
+			t, err := r.readBits(64)
+			if err != nil {
+				return nil, errChunk
+			}
+			v, err := r.readBits(64)
+			if err != nil {
+				return nil, errChunk
+			}
+			samples = append(samples, sample{t: int64(t), v: math.Float64frombits(v)})
+			continue
+		}
+		dod, err := readDod(r)
+		if err != nil {
+			return nil, errChunk
+		}
+		prevDelta += dod
+		prev := samples[i-1]
+		var xor uint64
+		xor, leading, trailing, err = readXOR(r, leading, trailing)
+		if err != nil {
+			return nil, errChunk
+		}
+		samples = append(samples, sample{
+			t: prev.t + prevDelta,
+			v: math.Float64frombits(math.Float64bits(prev.v) ^ xor),
+		})
+	}
+	return samples, nil
+}
+
+// readDod читает разность разностей времени
+func readDod(r *bitReader) (int64, error) {
+	prefixBits := 0
+	for prefixBits < 4 {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		prefixBits++
+	}
+	if prefixBits == 0 {
+		return 0, nil
+	}
+	if prefixBits == 4 {
+		v, err := r.readBits(64)
+		return int64(v), err
+	}
+	n := dodBuckets[prefixBits-1].bits
+	v, err := r.readBits(n)
+	if err != nil {
+		return 0, err
+	}
+	// восстанавливаю знак
+	if v > 1<<(n-1) {
+		return int64(v) - 1<<n, nil
+	}
+	return int64(v), nil
+}
+
+// readXOR читает XOR значения с предыдущим, см. writeXOR
+func readXOR(r *bitReader, leading, trailing uint8) (uint64, uint8, uint8, error) {
+	changed, err := r.readBit()
+	if err != nil || !changed {
+		return 0, leading, trailing, err
+	}
+	newWindow, err := r.readBit()
+	if err != nil {
+		return 0, leading, trailing, err
+	}
+	if newWindow {
+		l, err := r.readBits(5)
+		if err != nil {
+			return 0, leading, trailing, err
+		}
+		sig, err := r.readBits(6)
+		if err != nil {
+			return 0, leading, trailing, err
+		}
+		if sig == 0 {
+			sig = 64
+		}
+		leading, trailing = uint8(l), uint8(64-l-sig)
+	}
+	sigbits := 64 - int(leading) - int(trailing)
+	v, err := r.readBits(sigbits)
+	if err != nil {
+		return 0, leading, trailing, err
+	}
+	return v << trailing, leading, trailing, nil
+}
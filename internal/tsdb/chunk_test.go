@@ -0,0 +1,93 @@
+package tsdb
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunk(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []sample
+	}{
+		{name: "single", samples: []sample{{t: 1000, v: 1.5}}},
+		{
+			name:    "regular interval equal values",
+			samples: []sample{{t: 10000, v: 7}, {t: 20000, v: 7}, {t: 30000, v: 7}, {t: 40000, v: 7}},
+		},
+		{
+			name: "irregular interval",
+			samples: []sample{
+				{t: -5000, v: 0}, {t: 1, v: -1}, {t: 2, v: 1e300},
+				{t: 70000, v: 0.1}, {t: 1 << 40, v: 3}, {t: 1<<40 + 5, v: math.Inf(-1)},
+			},
+		},
+		{
+			name:    "counter",
+			samples: []sample{{t: 0, v: 0}, {t: 10000, v: 15}, {t: 20010, v: 42}, {t: 29990, v: 42}, {t: 40000, v: 1e6}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeChunk(encodeChunk(tt.samples))
+			require.NoError(t, err)
+			assert.Equal(t, tt.samples, got)
+		})
+	}
+	t.Run("NaN", func(t *testing.T) {
+		got, err := decodeChunk(encodeChunk([]sample{{t: 1, v: 2}, {t: 2, v: math.NaN()}, {t: 3, v: 4}}))
+		require.NoError(t, err)
+		require.Len(t, got, 3)
+		assert.True(t, math.IsNaN(got[1].v))
+		assert.Equal(t, 4.0, got[2].v)
+	})
+	t.Run("compression", func(t *testing.T) {
+		samples := make([]sample, samplesPerChunk)
+		for i := range samples {
+			samples[i] = sample{t: int64(i) * 10000, v: 42}
+		}
+		// число значений, время и значение первого, первый интервал
+		// группой 17 битов, затем по 2 бита на значение
+		assert.LessOrEqual(t, len(encodeChunk(samples)), 2+16+3+samplesPerChunk/4)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		data := encodeChunk([]sample{{t: 1, v: 2}, {t: 2, v: 3}, {t: 5, v: 8}})
+		_, err := decodeChunk(data[:len(data)-2])
+		assert.Error(t, err)
+	})
+}
+
+func TestReplayWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), walName)
+	w, err := openWAL(path)
+	require.NoError(t, err)
+	require.NoError(t, w.logSeries(1, `Alloc{host="a"}`, "gauge"))
+	require.NoError(t, w.logSample(1, sample{t: 1000, v: 1.5}))
+	require.NoError(t, w.logSample(1, sample{t: 2000, v: 2.5}))
+	require.NoError(t, w.close())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	size := info.Size()
+	// недописанная запись после сбоя
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 20, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	var got []walRecord
+	require.NoError(t, replayWAL(path, func(rec walRecord) { got = append(got, rec) }))
+	require.Len(t, got, 3)
+	assert.Equal(t, walRecord{typ: walSeries, ref: 1, key: `Alloc{host="a"}`, mtype: "gauge"}, got[0])
+	assert.Equal(t, walRecord{typ: walSample, ref: 1, sample: sample{t: 2000, v: 2.5}}, got[2])
+
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, size, info.Size())
+}
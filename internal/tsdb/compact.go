@@ -0,0 +1,338 @@
+// Часть модуля tsdb содержит сжатие базы: запись завершенных
+// интервалов головного блока в блоки, слияние блоков и применение
+// политик хранения.
+package tsdb
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/retention"
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// Compact записывает в блоки значения головного блока интервалов,
+// завершенных к now с запасом в половину интервала для опоздавших
+// значений, затем объединяет блоки одного интервала слияния
+func (db *DB) Compact(now time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	blockMs := db.blockDuration.Milliseconds()
+	boundary := alignDown(now.UnixMilli()-blockMs/2, blockMs)
+	if err := db.cutHead(boundary); err != nil {
+		return err
+	}
+	return db.mergeBlocks(blockMs * mergeFactor)
+}
+
+// cutHead записывает значения головного блока раньше boundary в
+// блоки по интервалам блока и заменяет журнал оставшимися значениями;
+// значения удаляются из головного блока после записи блоков, поэтому
+// при сбое они остаются в журнале и сливаются с блоком повторно
+func (db *DB) cutHead(boundary int64) error {
+	min, ok := db.head.minTime()
+	if !ok || min >= boundary {
+		return nil
+	}
+	blockMs := db.blockDuration.Milliseconds()
+	ranges := make(map[int64][]seriesSamples)
+	for key, s := range db.head.series {
+		start := 0
+		for start < len(s.samples) && s.samples[start].t < boundary {
+			minT := alignDown(s.samples[start].t, blockMs)
+			end := start
+			for end < len(s.samples) && s.samples[end].t < minT+blockMs && s.samples[end].t < boundary {
+				end++
+			}
+			ranges[minT] = append(ranges[minT], seriesSamples{key: key, mtype: s.mtype, samples: s.samples[start:end]})
+			start = end
+		}
+	}
+	for minT, series := range ranges {
+		b, err := writeBlock(db.dir, minT, minT+blockMs, series)
+		if err != nil {
+			return err
+		}
+		db.addBlock(b)
+	}
+	db.head.cut(boundary)
+	return db.rewriteWAL()
+}
+
+// rewriteWAL заменяет журнал значениями головного блока
+func (db *DB) rewriteWAL() error {
+	path := filepath.Join(db.dir, walName)
+	if err := db.wal.close(); err != nil {
+		return err
+	}
+	if err := writeWAL(path, db.head); err != nil {
+		return err
+	}
+	var err error
+	db.wal, err = openWAL(path)
+	return err
+}
+
+// mergeBlocks объединяет блоки, начинающиеся в одном интервале
+// длительностью mergeMs, в один блок
+func (db *DB) mergeBlocks(mergeMs int64) error {
+	groups := make(map[int64][]*block)
+	for _, b := range db.blocks {
+		group := alignDown(b.minT, mergeMs)
+		groups[group] = append(groups[group], b)
+	}
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		minT, maxT := group[0].minT, group[0].maxT
+		for _, b := range group {
+			if b.minT < minT {
+				minT = b.minT
+			}
+			if b.maxT > maxT {
+				maxT = b.maxT
+			}
+		}
+		series, err := blocksSamples(group, nil)
+		if err != nil {
+			return err
+		}
+		if err := db.replaceBlocks(group, minT, maxT, series); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blocksSamples возвращает объединенные значения рядов блоков,
+// отсортированных по началу интервала; fn, если задана, изменяет
+// значения ряда перед записью
+func blocksSamples(blocks []*block, fn func(key, mtype string, samples []sample) []sample) ([]seriesSamples, error) {
+	keys := make(map[string]string)
+	for _, b := range blocks {
+		for key, s := range b.series {
+			keys[key] = s.mtype
+		}
+	}
+	series := make([]seriesSamples, 0, len(keys))
+	for key, mtype := range keys {
+		samples := make([]sample, 0)
+		for _, b := range blocks {
+			part, err := b.all(key)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, part...)
+		}
+		samples = mergeSamples(samples)
+		if fn != nil {
+			samples = fn(key, mtype, samples)
+		}
+		if len(samples) > 0 {
+			series = append(series, seriesSamples{key: key, mtype: mtype, samples: samples})
+		}
+	}
+	return series, nil
+}
+
+// replaceBlocks записывает значения series в новый блок вместо
+// блоков old, без значений блоки только удаляются
+func (db *DB) replaceBlocks(old []*block, minT, maxT int64, series []seriesSamples) error {
+	if len(series) > 0 {
+		b, err := writeBlock(db.dir, minT, maxT, series)
+		if err != nil {
+			return err
+		}
+		db.addBlock(b)
+	}
+	removed := make(map[*block]bool, len(old))
+	for _, b := range old {
+		removed[b] = true
+		if err := b.remove(); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	blocks := db.blocks[:0]
+	for _, b := range db.blocks {
+		if !removed[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	db.blocks = blocks
+	return nil
+}
+
+// ApplyRetention применяет политики хранения к блокам: значения
+// рядов старше Raw политики заменяются агрегатами наименьшего
+// интервала политики, время хранения которого еще не истекло,
+// остальные удаляются. Агрегат counter - наибольшая накопленная
+// сумма, gauge - среднее значение. Головной блок не изменяется
+func (db *DB) ApplyRetention(policies retention.Policies, now time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	nowMs := now.UnixMilli()
+	// копия, replaceBlocks изменяет db.blocks
+	blocks := append([]*block(nil), db.blocks...)
+	for _, b := range blocks {
+		changed := false
+		series, err := blocksSamples([]*block{b}, func(key, mtype string, samples []sample) []sample {
+			policy, ok := policies.Match(seriesName(key))
+			if !ok {
+				return samples
+			}
+			out := downsample(samples, policy, mtype, nowMs)
+			if !equalSamples(out, samples) {
+				changed = true
+			}
+			return out
+		})
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		if err := db.replaceBlocks([]*block{b}, b.minT, b.maxT, series); err != nil {
+			return err
+		}
+	}
+	db.reindex()
+	return nil
+}
+
+// downsample возвращает отсортированные по времени значения ряда
+// после применения политики хранения policy на момент nowMs, см.
+// ApplyRetention; значение агрегата получает время начала интервала,
+// поэтому повторное применение политики не изменяет агрегаты
+func downsample(samples []sample, policy config.Retention, mtype string, nowMs int64) []sample {
+	rawFrom := nowMs - policy.Raw.Milliseconds()
+	rollups := append([]config.Rollup(nil), policy.Rollups...)
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i].Resolution < rollups[j].Resolution })
+	// tier возвращает номер агрегата значения со временем t, -1 - значение удаляется
+	tier := func(t int64) int {
+		for i, r := range rollups {
+			if t >= nowMs-r.Keep.Milliseconds() {
+				return i
+			}
+		}
+		return -1
+	}
+	out := make([]sample, 0, len(samples))
+	start := 0
+	for start < len(samples) && samples[start].t < rawFrom {
+		current := tier(samples[start].t)
+		end := start
+		for end < len(samples) && samples[end].t < rawFrom && tier(samples[end].t) == current {
+			end++
+		}
+		if current >= 0 {
+			group := toSamples(samples[start:end])
+			for _, b := range retention.Downsample(group, nil, rollups[current].Resolution) {
+				value := b.Avg()
+				if mtype == "counter" {
+					value = b.Max
+				}
+				out = append(out, sample{t: b.Start.UnixMilli(), v: value})
+			}
+		}
+		start = end
+	}
+	out = append(out, samples[start:]...)
+	// агрегаты соседних групп могут попасть в один интервал
+	return mergeSamples(out)
+}
+
+// equalSamples сравнивает значения рядов
+func equalSamples(a, b []sample) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// reindex перестраивает индекс рядов по блокам и головному блоку
+func (db *DB) reindex() {
+	db.names = make(map[string][]string)
+	db.mtypes = make(map[string]string)
+	for _, b := range db.blocks {
+		for key, s := range b.series {
+			db.index(key, s.mtype)
+		}
+	}
+	for key, s := range db.head.series {
+		db.index(key, s.mtype)
+	}
+}
+
+// lastValue последнее значение ряда и тип метрики
+type lastValue struct {
+	mtype string
+	value float64
+}
+
+// lastValues возвращает последние значения всех рядов по ключу
+func (db *DB) lastValues() (map[string]lastValue, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	out := make(map[string]lastValue, len(db.mtypes))
+	for key, s := range db.head.series {
+		if len(s.samples) > 0 {
+			out[key] = lastValue{mtype: s.mtype, value: s.samples[len(s.samples)-1].v}
+		}
+	}
+	// блоки с более поздним интервалом проверяются первыми, для ряда
+	// читается только последний чанк
+	blocks := append([]*block(nil), db.blocks...)
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].maxT > blocks[j].maxT })
+	for _, b := range blocks {
+		for key, s := range b.series {
+			if _, ok := out[key]; ok || len(s.chunks) == 0 {
+				continue
+			}
+			last := s.chunks[len(s.chunks)-1]
+			samples, err := b.samples(key, last.minT, last.maxT)
+			if err != nil {
+				return nil, err
+			}
+			if len(samples) > 0 {
+				out[key] = lastValue{mtype: s.mtype, value: samples[len(samples)-1].v}
+			}
+		}
+	}
+	return out, nil
+}
+
+// alignDown возвращает начало интервала step, содержащего t
+func alignDown(t, step int64) int64 {
+	if t < 0 {
+		return t - (t%step+step)%step
+	}
+	return t - t%step
+}
+
+// toSamples преобразует значения в значения временного ряда
+func toSamples(samples []sample) []types.Sample {
+	out := make([]types.Sample, 0, len(samples))
+	for _, s := range samples {
+		out = append(out, types.Sample{Timestamp: time.UnixMilli(s.t), Value: s.v})
+	}
+	return out
+}
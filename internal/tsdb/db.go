@@ -0,0 +1,281 @@
+// Модуль tsdb содержит встроенную базу временных рядов на диске.
+// Значения записываются в журнал предзаписи и головной блок в памяти,
+// сжатие периодически переносит завершенные интервалы головного блока
+// в неизменяемые файлы блоков со сжатием Gorilla и объединяет блоки
+// одного интервала слияния. Время значений хранится в миллисекундах.
+// Записи журнала передаются операционной системе сразу, а на диск
+// сбрасываются Sync: хранилище Storage вызывает его каждые
+// WithSyncInterval, поэтому при сбое питания или ядра теряются
+// значения, записанные не ранее этого интервала до сбоя; при падении
+// только процесса сервера значения не теряются.
+package tsdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// Значения параметров по умолчанию
+const (
+	defaultBlockDuration = 2 * time.Hour
+	defaultSyncInterval  = time.Second
+	// mergeFactor блоки объединяются в блоки длительностью
+	// mergeFactor интервалов блока
+	mergeFactor = 12
+)
+
+// ErrClosed база закрыта
+var ErrClosed = errors.New("tsdb: database is closed")
+
+// Option тип функции для настройки DB
+type Option func(db *DB)
+
+// WithBlockDuration задает интервал времени блока, головной блок
+// записывается на диск после завершения интервала
+func WithBlockDuration(d time.Duration) Option {
+	return func(db *DB) {
+		if d >= time.Minute {
+			db.blockDuration = d
+		}
+	}
+}
+
+// WithSyncInterval задает интервал сброса журнала предзаписи на диск,
+// значения за последний интервал могут быть потеряны при сбое
+func WithSyncInterval(d time.Duration) Option {
+	return func(db *DB) {
+		if d > 0 {
+			db.syncInterval = d
+		}
+	}
+}
+
+// DB база временных рядов в каталоге dir, безопасна для
+// конкурентного использования
+type DB struct {
+	dir           string
+	blockDuration time.Duration
+	syncInterval  time.Duration
+	mu            sync.RWMutex
+	head          *head
+	wal           *wal
+	blocks        []*block            // отсортированы по началу интервала
+	names         map[string][]string // индекс ключей рядов по имени метрики
+	mtypes        map[string]string   // тип метрики по ключу ряда
+	closed        bool
+}
+
+// Open открывает базу в каталоге dir: читает индексы блоков и
+// восстанавливает головной блок по журналу предзаписи
+func Open(dir string, opts ...Option) (*DB, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	db := &DB{
+		dir:           dir,
+		blockDuration: defaultBlockDuration,
+		syncInterval:  defaultSyncInterval,
+		head:          newHead(),
+		names:         make(map[string][]string),
+		mtypes:        make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(db)
+	}
+	// временные файлы остаются после сбоя при записи блока или журнала
+	tmps, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if err != nil {
+		return nil, err
+	}
+	for _, tmp := range tmps {
+		if err := os.Remove(tmp); err != nil {
+			return nil, err
+		}
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+blockExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		b, err := openBlock(path)
+		if err != nil {
+			db.closeBlocks()
+			return nil, err
+		}
+		db.addBlock(b)
+	}
+	walPath := filepath.Join(dir, walName)
+	err = replayWAL(walPath, func(rec walRecord) {
+		switch rec.typ {
+		case walSeries:
+			db.head.addSeries(&headSeries{ref: rec.ref, key: rec.key, mtype: rec.mtype})
+			db.index(rec.key, rec.mtype)
+		case walSample:
+			if s, ok := db.head.refs[rec.ref]; ok {
+				s.add(rec.sample)
+			}
+		}
+	})
+	if err != nil {
+		db.closeBlocks()
+		return nil, err
+	}
+	if db.wal, err = openWAL(walPath); err != nil {
+		db.closeBlocks()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Append добавляет значение value ряда key типа mtype во время ts
+func (db *DB) Append(key, mtype string, ts time.Time, value float64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	s, created := db.head.getOrCreate(key, mtype)
+	if created {
+		if err := db.wal.logSeries(s.ref, key, mtype); err != nil {
+			return err
+		}
+		db.index(key, mtype)
+	}
+	smp := sample{t: ts.UnixMilli(), v: value}
+	if err := db.wal.logSample(s.ref, smp); err != nil {
+		return err
+	}
+	s.add(smp)
+	return nil
+}
+
+// Select возвращает значения ряда key в интервале [from, to]
+func (db *DB) Select(key string, from, to time.Time) ([]types.Sample, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	samples, err := db.samples(key, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	return toSamples(samples), nil
+}
+
+// Series возвращает отсортированные ключи рядов метрики name
+func (db *DB) Series(name string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	keys := make([]string, len(db.names[name]))
+	copy(keys, db.names[name])
+	return keys
+}
+
+// Sync сбрасывает журнал предзаписи на диск
+func (db *DB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return db.wal.sync()
+}
+
+// Close сбрасывает журнал на диск и закрывает файлы базы, значения
+// головного блока восстанавливаются по журналу при следующем Open
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	db.closeBlocks()
+	return db.wal.close()
+}
+
+// samples возвращает значения ряда key блоков и головного блока в
+// интервале [from, to] миллисекунд; из значений с одинаковым временем
+// остается значение более позднего блока или головного блока
+func (db *DB) samples(key string, from, to int64) ([]sample, error) {
+	out := make([]sample, 0)
+	for _, b := range db.blocks {
+		part, err := b.samples(key, from, to)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, part...)
+	}
+	if s, ok := db.head.series[key]; ok {
+		for _, smp := range s.samples {
+			if smp.t >= from && smp.t <= to {
+				out = append(out, smp)
+			}
+		}
+	}
+	return mergeSamples(out), nil
+}
+
+// mergeSamples сортирует значения по времени, из значений с
+// одинаковым временем оставляет последнее в samples
+func mergeSamples(samples []sample) []sample {
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].t < samples[j].t })
+	out := samples[:0]
+	for _, s := range samples {
+		if n := len(out); n > 0 && out[n-1].t == s.t {
+			out[n-1] = s
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// index добавляет ряд в индекс по имени метрики
+func (db *DB) index(key, mtype string) {
+	if _, ok := db.mtypes[key]; ok {
+		return
+	}
+	db.mtypes[key] = mtype
+	name := seriesName(key)
+	keys := db.names[name]
+	i := sort.SearchStrings(keys, key)
+	keys = append(keys, "")
+	copy(keys[i+1:], keys[i:])
+	keys[i] = key
+	db.names[name] = keys
+}
+
+// addBlock добавляет блок с сохранением сортировки и индексирует его ряды
+func (db *DB) addBlock(b *block) {
+	i := sort.Search(len(db.blocks), func(i int) bool { return db.blocks[i].minT > b.minT })
+	db.blocks = append(db.blocks, nil)
+	copy(db.blocks[i+1:], db.blocks[i:])
+	db.blocks[i] = b
+	for key, s := range b.series {
+		db.index(key, s.mtype)
+	}
+}
+
+// closeBlocks закрывает файлы блоков
+func (db *DB) closeBlocks() {
+	for _, b := range db.blocks {
+		b.close()
+	}
+}
+
+// seriesName возвращает имя метрики ключа ряда
+func seriesName(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
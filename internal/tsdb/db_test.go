@@ -0,0 +1,168 @@
+package tsdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/config"
+	"github.com/hrapovd1/pmetrics/internal/retention"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// start начало интервала блока по умолчанию для тестов
+var start = time.UnixMilli(alignDown(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli(), defaultBlockDuration.Milliseconds()))
+
+// appendEvery добавляет n значений ряда key с шагом step начиная с from
+func appendEvery(t *testing.T, db *DB, key, mtype string, from time.Time, step time.Duration, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		require.NoError(t, db.Append(key, mtype, from.Add(time.Duration(i)*step), float64(i)))
+	}
+}
+
+func TestDB_reopen(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	require.NoError(t, err)
+	appendEvery(t, db, `Alloc{host="a"}`, "gauge", start, 10*time.Second, 5)
+	// значение с опозданием и повторное значение
+	require.NoError(t, db.Append(`Alloc{host="a"}`, "gauge", start.Add(5*time.Second), 0.5))
+	require.NoError(t, db.Append(`Alloc{host="a"}`, "gauge", start.Add(40*time.Second), 40))
+	require.NoError(t, db.Close())
+	assert.ErrorIs(t, db.Append("Alloc", "gauge", start, 1), ErrClosed)
+
+	db, err = Open(dir)
+	require.NoError(t, err)
+	defer db.Close()
+	got, err := db.Select(`Alloc{host="a"}`, start, start.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []types.Sample{
+		{Timestamp: start, Value: 0},
+		{Timestamp: start.Add(5 * time.Second), Value: 0.5},
+		{Timestamp: start.Add(10 * time.Second), Value: 1},
+		{Timestamp: start.Add(20 * time.Second), Value: 2},
+		{Timestamp: start.Add(30 * time.Second), Value: 3},
+		{Timestamp: start.Add(40 * time.Second), Value: 40},
+	}, got)
+	assert.Equal(t, []string{`Alloc{host="a"}`}, db.Series("Alloc"))
+}
+
+func TestDB_Compact(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, WithBlockDuration(time.Hour))
+	require.NoError(t, err)
+	// 3 часа значений раз в минуту
+	appendEvery(t, db, "PollCount", "counter", start, time.Minute, 180)
+	appendEvery(t, db, `Alloc{host="b"}`, "gauge", start, time.Minute, 180)
+	appendEvery(t, db, `Alloc{host="a"}`, "gauge", start.Add(2*time.Hour), time.Minute, 60)
+
+	// завершены 2 интервала, третий остается в головном блоке
+	require.NoError(t, db.Compact(start.Add(3*time.Hour)))
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+blockExt))
+	require.NoError(t, err)
+	// блоки одного интервала слияния объединены
+	assert.Len(t, paths, 1)
+	assert.Len(t, db.head.series, 3)
+
+	check := func(db *DB) {
+		got, err := db.Select("PollCount", start.Add(119*time.Minute), start.Add(121*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, []types.Sample{
+			{Timestamp: start.Add(119 * time.Minute), Value: 119},
+			{Timestamp: start.Add(120 * time.Minute), Value: 120},
+			{Timestamp: start.Add(121 * time.Minute), Value: 121},
+		}, got)
+		got, err = db.Select(`Alloc{host="b"}`, start, start.Add(3*time.Hour))
+		require.NoError(t, err)
+		assert.Len(t, got, 180)
+		assert.Equal(t, []string{`Alloc{host="a"}`, `Alloc{host="b"}`}, db.Series("Alloc"))
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	// журнал содержит только значения головного блока
+	db, err = Open(dir, WithBlockDuration(time.Hour))
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Len(t, db.head.series["PollCount"].samples, 60)
+	check(db)
+
+	// повторное сжатие после записи оставшегося интервала
+	require.NoError(t, db.Compact(start.Add(4*time.Hour)))
+	assert.Empty(t, db.head.series)
+	check(db)
+}
+
+func TestDB_ApplyRetention(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, WithBlockDuration(time.Hour))
+	require.NoError(t, err)
+	defer db.Close()
+	appendEvery(t, db, "PollCount", "counter", start, time.Minute, 180)
+	appendEvery(t, db, "Alloc", "gauge", start, time.Minute, 180)
+	appendEvery(t, db, "Other", "gauge", start, time.Minute, 180)
+	require.NoError(t, db.Compact(start.Add(4*time.Hour)))
+
+	policies, err := retention.NewPolicies([]config.Retention{
+		{Match: "PollCount", Raw: time.Hour, Rollups: []config.Rollup{{Resolution: 30 * time.Minute, Keep: 3 * time.Hour}}},
+		{Match: "Alloc", Raw: time.Hour},
+	})
+	require.NoError(t, err)
+	now := start.Add(4 * time.Hour)
+	require.NoError(t, db.ApplyRetention(policies, now))
+
+	// counter старше часа заменен наибольшим значением за 30 минут
+	got, err := db.Select("PollCount", start, now)
+	require.NoError(t, err)
+	assert.Equal(t, []types.Sample{
+		{Timestamp: start.Add(60 * time.Minute), Value: 89},
+		{Timestamp: start.Add(90 * time.Minute), Value: 119},
+		{Timestamp: start.Add(120 * time.Minute), Value: 149},
+		{Timestamp: start.Add(150 * time.Minute), Value: 179},
+	}, got)
+	// gauge без агрегатов старше часа удален
+	got, err = db.Select("Alloc", start, now)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	assert.Empty(t, db.Series("Alloc"))
+	// ряд без политики не изменяется
+	got, err = db.Select("Other", start, now)
+	require.NoError(t, err)
+	assert.Len(t, got, 180)
+
+	// повторное применение не изменяет агрегаты
+	require.NoError(t, db.ApplyRetention(policies, now))
+	got, err = db.Select("PollCount", start, now)
+	require.NoError(t, err)
+	assert.Len(t, got, 4)
+}
+
+func Test_downsample(t *testing.T) {
+	policy := config.Retention{
+		Match: "*",
+		Raw:   10 * time.Minute,
+		Rollups: []config.Rollup{
+			{Resolution: 10 * time.Minute, Keep: 20 * time.Minute},
+			{Resolution: 5 * time.Minute, Keep: 15 * time.Minute},
+		},
+	}
+	minute := time.Minute.Milliseconds()
+	samples := make([]sample, 0)
+	for i := int64(0); i < 40; i++ {
+		samples = append(samples, sample{t: i * minute, v: float64(i)})
+	}
+	got := downsample(samples, policy, "gauge", 40*minute)
+	want := []sample{
+		// старше 20 минут удаляются, от 15 до 20 минут - агрегаты по
+		// 10 минут, от 10 до 15 минут - по 5 минут, затем исходные значения
+		{t: 20 * minute, v: 22},
+		{t: 25 * minute, v: 27},
+	}
+	for i := int64(30); i < 40; i++ {
+		want = append(want, sample{t: i * minute, v: float64(i)})
+	}
+	assert.Equal(t, want, got)
+}
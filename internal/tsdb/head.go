@@ -0,0 +1,95 @@
+// Часть модуля tsdb содержит головной блок - последние значения
+// рядов в памяти, еще не записанные в блоки на диске.
+package tsdb
+
+import "sort"
+
+// headSeries ряд головного блока, значения отсортированы по времени
+type headSeries struct {
+	ref     uint64
+	key     string
+	mtype   string
+	samples []sample
+}
+
+// add добавляет значение, значение с тем же временем заменяется
+func (s *headSeries) add(smp sample) {
+	n := len(s.samples)
+	if n == 0 || s.samples[n-1].t < smp.t {
+		s.samples = append(s.samples, smp)
+		return
+	}
+	// значение с опозданием вставляется по времени
+	i := sort.Search(n, func(i int) bool { return s.samples[i].t >= smp.t })
+	if s.samples[i].t == smp.t {
+		s.samples[i] = smp
+		return
+	}
+	s.samples = append(s.samples, sample{})
+	copy(s.samples[i+1:], s.samples[i:])
+	s.samples[i] = smp
+}
+
+// head головной блок
+type head struct {
+	series  map[string]*headSeries
+	refs    map[uint64]*headSeries
+	nextRef uint64
+}
+
+// newHead создает пустой головной блок
+func newHead() *head {
+	return &head{
+		series:  make(map[string]*headSeries),
+		refs:    make(map[uint64]*headSeries),
+		nextRef: 1,
+	}
+}
+
+// getOrCreate возвращает ряд key, created - ряд создан
+func (h *head) getOrCreate(key, mtype string) (*headSeries, bool) {
+	if s, ok := h.series[key]; ok {
+		return s, false
+	}
+	s := &headSeries{ref: h.nextRef, key: key, mtype: mtype}
+	h.nextRef++
+	h.addSeries(s)
+	return s, true
+}
+
+// addSeries добавляет ряд с известной ссылкой, например из журнала
+func (h *head) addSeries(s *headSeries) {
+	h.series[s.key] = s
+	h.refs[s.ref] = s
+	if s.ref >= h.nextRef {
+		h.nextRef = s.ref + 1
+	}
+}
+
+// cut удаляет из головного блока значения рядов раньше before,
+// ряды без значений удаляются
+func (h *head) cut(before int64) {
+	for key, s := range h.series {
+		i := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].t >= before })
+		if i == 0 {
+			continue
+		}
+		s.samples = append([]sample(nil), s.samples[i:]...)
+		if len(s.samples) == 0 {
+			delete(h.series, key)
+			delete(h.refs, s.ref)
+		}
+	}
+}
+
+// minTime возвращает время самого раннего значения, false - значений нет
+func (h *head) minTime() (int64, bool) {
+	var min int64
+	found := false
+	for _, s := range h.series {
+		if len(s.samples) > 0 && (!found || s.samples[0].t < min) {
+			min, found = s.samples[0].t, true
+		}
+	}
+	return min, found
+}
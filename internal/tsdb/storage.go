@@ -0,0 +1,245 @@
+// Часть модуля tsdb содержит хранилище метрик, сохраняющее историю
+// значений counter и gauge во встроенную базу временных рядов.
+package tsdb
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/retention"
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// compactInterval интервал сжатия базы
+const compactInterval = time.Minute
+
+// seriesLocks число блокировок записи рядов
+const seriesLocks = 64
+
+// Storage тип для хранения метрик: текущие значения хранятся в
+// промежуточном хранилище, история значений counter и gauge - в
+// базе временных рядов на диске
+type Storage struct {
+	db       *DB
+	logger   *log.Logger
+	backStor types.Repository
+	// locks блокировки записи рядов по хешу ключа: новое значение
+	// промежуточного хранилища и его запись в базу выполняются под
+	// одной блокировкой, значения ряда попадают в журнал по порядку
+	locks [seriesLocks]sync.Mutex
+}
+
+// NewStorage открывает базу временных рядов в каталоге dir и
+// возвращает хранилище с промежуточным хранилищем backStor
+func NewStorage(dir string, logger *log.Logger, backStor types.Repository, opts ...Option) (*Storage, error) {
+	db, err := Open(dir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{db: db, logger: logger, backStor: backStor}, nil
+}
+
+// Append сохраняет новое значение типа counter с дозаписью к старому,
+// в историю записывается накопленная сумма
+func (ts *Storage) Append(ctx context.Context, key string, value int64) {
+	defer ts.lock(key)()
+	ts.backStor.Append(ctx, key, value)
+	if total, ok := ts.backStor.Get(ctx, key).(int64); ok {
+		ts.append(key, "counter", float64(total))
+	}
+}
+
+// AppendTotal дописывает к значению counter приращение до накопленного
+// значения total, см. storage.MemStorage.AppendTotal
+func (ts *Storage) AppendTotal(ctx context.Context, key string, total int64) int64 {
+	defer ts.lock(key)()
+	delta := ts.backStor.AppendTotal(ctx, key, total)
+	if value, ok := ts.backStor.Get(ctx, key).(int64); ok {
+		ts.append(key, "counter", float64(value))
+	}
+	return delta
+}
+
+// Get возвращает значение метрики переданной через key
+func (ts *Storage) Get(ctx context.Context, key string) interface{} {
+	return ts.backStor.Get(ctx, key)
+}
+
+// GetAll возвращает все метрики
+func (ts *Storage) GetAll(ctx context.Context) map[string]interface{} {
+	return ts.backStor.GetAll(ctx)
+}
+
+// Rewrite перезаписывает значение метрики типа gauge
+func (ts *Storage) Rewrite(ctx context.Context, key string, value float64) {
+	defer ts.lock(key)()
+	ts.backStor.Rewrite(ctx, key, value)
+	if value, ok := ts.backStor.Get(ctx, key).(float64); ok {
+		ts.append(key, "gauge", value)
+	}
+}
+
+// AppendHistogram сохраняет значение типа histogram со сложением с
+// текущим, история histogram не хранится
+func (ts *Storage) AppendHistogram(ctx context.Context, key string, value types.Histogram) {
+	ts.backStor.AppendHistogram(ctx, key, value)
+}
+
+// RewriteSummary перезаписывает значение метрики типа summary,
+// история summary не хранится
+func (ts *Storage) RewriteSummary(ctx context.Context, key string, value types.Summary) {
+	ts.backStor.RewriteSummary(ctx, key, value)
+}
+
+// StoreAll сохраняет все полученные метрики через слайс metrics
+func (ts *Storage) StoreAll(ctx context.Context, metrics *[]types.Metric) {
+	for _, m := range *metrics {
+		key := m.Key()
+		switch m.MType {
+		case "counter":
+			ts.Append(ctx, key, *m.Delta)
+		case "gauge":
+			ts.Rewrite(ctx, key, *m.Value)
+		case "histogram":
+			if m.Histogram != nil && m.Histogram.Validate() == nil {
+				ts.AppendHistogram(ctx, key, *m.Histogram)
+			}
+		case "summary":
+			if m.Summary != nil && m.Summary.Validate() == nil {
+				ts.RewriteSummary(ctx, key, *m.Summary)
+			}
+		}
+	}
+}
+
+// Range возвращает историю значений counter и gauge временного ряда
+// из базы, при ошибке чтения - историю промежуточного хранилища,
+// если оно ее хранит
+func (ts *Storage) Range(ctx context.Context, key string, from, to time.Time) []types.Sample {
+	samples, err := ts.db.Select(key, from, to)
+	if err == nil {
+		return samples
+	}
+	ts.logger.Println(err)
+	stor, ok := ts.backStor.(types.Ranger)
+	if !ok {
+		return nil
+	}
+	return stor.Range(ctx, key, from, to)
+}
+
+// Series возвращает ключи рядов метрики name с метками, см. types.SeriesLister
+func (ts *Storage) Series(ctx context.Context, name string) []string {
+	stor, ok := ts.backStor.(types.SeriesLister)
+	if !ok {
+		return nil
+	}
+	return stor.Series(ctx, name)
+}
+
+// Compact применяет политики хранения к истории базы на момент now
+func (ts *Storage) Compact(ctx context.Context, policies retention.Policies, now time.Time) error {
+	return ts.db.ApplyRetention(policies, now)
+}
+
+// Storing запускается в отдельной go routine для сохранения метрик,
+// при restore сначала восстанавливает значения метрик, см. Restore;
+// до отмены контекста периодически сжимает базу
+func (ts *Storage) Storing(ctx context.Context, w *sync.WaitGroup, logger *log.Logger, interval time.Duration, restore bool) {
+	if restore {
+		if err := ts.Restore(ctx); err != nil {
+			logger.Printf("ts.Restore err: %v", err)
+		}
+	}
+	w.Add(1)
+	go ts.compacting(ctx, w)
+	stor := ts.backStor.(types.Storager)
+	stor.Storing(ctx, w, logger, interval, false)
+}
+
+// Restore восстанавливает значения метрик при запуске: сначала
+// промежуточное хранилище, затем последние значения рядов базы;
+// counter восстанавливается по большему значению, gauge по значению базы
+func (ts *Storage) Restore(ctx context.Context) error {
+	stor := ts.backStor.(types.Storager)
+	if err := stor.Restore(ctx); err != nil {
+		ts.logger.Printf("restore from backing storage: %v", err)
+	}
+	values, err := ts.db.lastValues()
+	if err != nil {
+		return err
+	}
+	for key, last := range values {
+		switch last.mtype {
+		case "counter":
+			current, _ := ts.backStor.Get(ctx, key).(int64)
+			if total := int64(last.value); total > current {
+				ts.backStor.Append(ctx, key, total-current)
+			}
+		case "gauge":
+			ts.backStor.Rewrite(ctx, key, last.value)
+		}
+	}
+	ts.logger.Printf("restored %d series from tsdb", len(values))
+	return nil
+}
+
+// Close закрывает базу и промежуточное хранилище, необходимо
+// запускать в defer
+func (ts *Storage) Close() error {
+	stor := ts.backStor.(types.Storager)
+	defer func() {
+		if err := stor.Close(); err != nil {
+			ts.logger.Print(err)
+		}
+	}()
+	return ts.db.Close()
+}
+
+// Ping проверяет, что журнал базы доступен для записи
+func (ts *Storage) Ping(ctx context.Context) bool {
+	return ts.db.Sync() == nil
+}
+
+// lock блокирует запись ряда key, возвращает функцию разблокировки
+func (ts *Storage) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &ts.locks[h.Sum32()%seriesLocks]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// append записывает значение ряда в базу с текущим временем
+func (ts *Storage) append(key, mtype string, value float64) {
+	if err := ts.db.Append(key, mtype, time.Now(), value); err != nil {
+		ts.logger.Printf("tsdb append %s: %v", key, err)
+	}
+}
+
+// compacting сбрасывает журнал на диск каждые WithSyncInterval и
+// сжимает базу каждые compactInterval до отмены контекста
+func (ts *Storage) compacting(ctx context.Context, w *sync.WaitGroup) {
+	defer w.Done()
+	tick := time.NewTicker(compactInterval)
+	defer tick.Stop()
+	syncTick := time.NewTicker(ts.db.syncInterval)
+	defer syncTick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-syncTick.C:
+			if err := ts.db.Sync(); err != nil {
+				ts.logger.Printf("tsdb sync: %v", err)
+			}
+		case now := <-tick.C:
+			if err := ts.db.Compact(now); err != nil {
+				ts.logger.Printf("tsdb compaction: %v", err)
+			}
+		}
+	}
+}
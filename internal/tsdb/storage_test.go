@@ -0,0 +1,93 @@
+package tsdb
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New(io.Discard, "", 0)
+	dir := t.TempDir()
+	buff := make(map[string]interface{})
+	ts, err := NewStorage(dir, logger, storage.NewMemStorage(storage.WithBuffer(buff)))
+	require.NoError(t, err)
+
+	from := time.Now().Add(-time.Minute)
+	delta, value := int64(5), 1.5
+	ts.StoreAll(ctx, &[]types.Metric{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "Latency", MType: "histogram", Histogram: &types.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}},
+	})
+	ts.Append(ctx, "PollCount", 2)
+	assert.Equal(t, int64(7), ts.Get(ctx, "PollCount"))
+	assert.Equal(t, 1.5, buff["Alloc"])
+	assert.Contains(t, buff, "Latency")
+	assert.True(t, ts.Ping(ctx))
+	// значение другого типа не изменяется и не пишется в историю
+	ts.Append(ctx, "Alloc", 1)
+	ts.Rewrite(ctx, "PollCount", 1)
+	assert.Equal(t, int64(7), ts.Get(ctx, "PollCount"))
+
+	// в историю counter записывается накопленная сумма
+	got := ts.Range(ctx, "PollCount", from, time.Now().Add(time.Minute))
+	require.NotEmpty(t, got)
+	assert.Equal(t, 7.0, got[len(got)-1].Value)
+	assert.Len(t, ts.Range(ctx, "Alloc", from, time.Now().Add(time.Minute)), 1)
+	assert.Empty(t, ts.db.Series("Latency"))
+	require.NoError(t, ts.Close())
+	assert.False(t, ts.Ping(ctx))
+
+	// значения counter и gauge восстанавливаются из базы
+	buff = make(map[string]interface{})
+	ts, err = NewStorage(dir, logger, storage.NewMemStorage(storage.WithBuffer(buff)))
+	require.NoError(t, err)
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	ts.Storing(ctx, &wg, logger, time.Second, true)
+	cancel()
+	wg.Wait()
+	assert.Equal(t, int64(7), buff["PollCount"])
+	assert.Equal(t, 1.5, buff["Alloc"])
+	assert.NotContains(t, buff, "Latency")
+}
+
+func TestStorage_concurrentAppend(t *testing.T) {
+	ctx := context.Background()
+	ts, err := NewStorage(t.TempDir(), log.New(io.Discard, "", 0), storage.NewMemStorage(), WithSyncInterval(time.Millisecond))
+	require.NoError(t, err)
+	defer ts.Close()
+
+	from := time.Now().Add(-time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				ts.Append(ctx, "PollCount", 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// накопленные суммы попадают в историю по порядку, без повторов
+	got := ts.Range(ctx, "PollCount", from, time.Now().Add(time.Minute))
+	require.NotEmpty(t, got)
+	for i := 1; i < len(got); i++ {
+		assert.Greater(t, got[i].Value, got[i-1].Value)
+	}
+	assert.Equal(t, 400.0, got[len(got)-1].Value)
+	assert.Equal(t, time.Millisecond, ts.db.syncInterval)
+}
@@ -0,0 +1,189 @@
+// Часть модуля tsdb содержит журнал предзаписи (WAL) значений
+// головного блока, по которому головной блок восстанавливается
+// после перезапуска.
+package tsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+)
+
+// Типы записей журнала
+const (
+	walSeries byte = 1 // ряд: ссылка, ключ и тип метрики
+	walSample byte = 2 // значение: ссылка на ряд, время и значение
+)
+
+// walName имя файла журнала в каталоге базы
+const walName = "wal"
+
+// walHeaderSize длина и контрольная сумма записи
+const walHeaderSize = 8
+
+// wal журнал предзаписи, каждая запись - длина, CRC32 и данные
+type wal struct {
+	file *os.File
+	buf  *bufio.Writer
+}
+
+// openWAL открывает журнал для дозаписи, поврежденный при сбое
+// конец журнала отбрасывается при чтении replayWAL
+func openWAL(path string) (*wal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{file: file, buf: bufio.NewWriter(file)}, nil
+}
+
+// logSeries добавляет в журнал запись ряда
+func (w *wal) logSeries(ref uint64, key, mtype string) error {
+	rec := []byte{walSeries}
+	rec = binary.AppendUvarint(rec, ref)
+	rec = appendString(rec, key)
+	rec = appendString(rec, mtype)
+	return w.write(rec)
+}
+
+// logSample добавляет в журнал запись значения ряда ref
+func (w *wal) logSample(ref uint64, s sample) error {
+	rec := []byte{walSample}
+	rec = binary.AppendUvarint(rec, ref)
+	rec = binary.AppendVarint(rec, s.t)
+	rec = binary.BigEndian.AppendUint64(rec, math.Float64bits(s.v))
+	return w.write(rec)
+}
+
+// write записывает запись и передает ее операционной системе,
+// на диск записи сбрасывает sync
+func (w *wal) write(rec []byte) error {
+	var header [walHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(rec)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(rec))
+	if _, err := w.buf.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.buf.Write(rec); err != nil {
+		return err
+	}
+	return w.buf.Flush()
+}
+
+// sync сбрасывает журнал на диск
+func (w *wal) sync() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// close сбрасывает журнал на диск и закрывает файл
+func (w *wal) close() error {
+	if err := w.sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// walRecord прочитанная запись журнала
+type walRecord struct {
+	typ    byte
+	ref    uint64
+	key    string
+	mtype  string
+	sample sample
+}
+
+// replayWAL читает записи журнала path и вызывает для них fn,
+// поврежденный или недописанный конец журнала обрезается
+func replayWAL(path string, fn func(rec walRecord)) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var good int64
+	for {
+		rec, n, err := readWALRecord(r)
+		if err != nil {
+			break
+		}
+		good += int64(n)
+		fn(rec)
+	}
+	return file.Truncate(good)
+}
+
+// readWALRecord читает одну запись журнала и возвращает ее длину
+func readWALRecord(r *bufio.Reader) (walRecord, int, error) {
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return walRecord{}, 0, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[:4]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return walRecord{}, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) || len(data) == 0 {
+		return walRecord{}, 0, errCorrupted
+	}
+	rec, err := decodeWALRecord(data)
+	return rec, walHeaderSize + len(data), err
+}
+
+// decodeWALRecord разбирает данные записи журнала
+func decodeWALRecord(data []byte) (walRecord, error) {
+	d := decoder{buf: data[1:]}
+	rec := walRecord{typ: data[0], ref: d.uvarint()}
+	switch rec.typ {
+	case walSeries:
+		rec.key = d.string()
+		rec.mtype = d.string()
+	case walSample:
+		rec.sample.t = d.varint()
+		rec.sample.v = math.Float64frombits(d.uint64())
+	default:
+		return rec, errCorrupted
+	}
+	return rec, d.err
+}
+
+// writeWAL атомарно заменяет журнал path записями рядов и значений
+// головного блока h
+func writeWAL(path string, h *head) error {
+	tmp := path + ".tmp"
+	w, err := openWAL(tmp)
+	if err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		w.close()
+		return err
+	}
+	for _, s := range h.series {
+		if err := w.logSeries(s.ref, s.key, s.mtype); err != nil {
+			w.close()
+			return err
+		}
+		for _, smp := range s.samples {
+			if err := w.logSample(s.ref, smp); err != nil {
+				w.close()
+				return err
+			}
+		}
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,135 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Histogram значение метрики типа histogram, Counts содержит количество
+// значений в каждом бакете с верхними границами Bounds, последний бакет
+// не ограничен сверху (+Inf)
+type Histogram struct {
+	Bounds []float64 `json:"bounds"` // верхние границы бакетов по возрастанию
+	Counts []uint64  `json:"counts"` // количество значений в бакетах, len(Bounds)+1
+	Sum    float64   `json:"sum"`    // сумма значений
+	Count  uint64    `json:"count"`  // количество значений
+}
+
+// Quantile значение квантиля метрики типа summary
+type Quantile struct {
+	Quantile float64 `json:"quantile"` // квантиль от 0 до 1
+	Value    float64 `json:"value"`    // значение квантиля
+}
+
+// Summary значение метрики типа summary
+type Summary struct {
+	Quantiles []Quantile `json:"quantiles"` // квантили по возрастанию
+	Sum       float64    `json:"sum"`       // сумма значений
+	Count     uint64     `json:"count"`     // количество значений
+}
+
+// NewHistogram создает пустую гистограмму с границами бакетов bounds
+func NewHistogram(bounds []float64) Histogram {
+	return Histogram{
+		Bounds: append([]float64(nil), bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe добавляет значение в гистограмму
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.Bounds, value)
+	h.Counts[i]++
+	h.Sum += value
+	h.Count++
+}
+
+// Validate проверяет согласованность границ и количества в бакетах
+func (h Histogram) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("histogram has %d bounds and %d counts, want %d counts", len(h.Bounds), len(h.Counts), len(h.Bounds)+1)
+	}
+	for i := 1; i < len(h.Bounds); i++ {
+		if h.Bounds[i] <= h.Bounds[i-1] {
+			return errors.New("histogram bounds must be in ascending order")
+		}
+	}
+	var count uint64
+	for _, c := range h.Counts {
+		count += c
+	}
+	if count != h.Count {
+		return fmt.Errorf("histogram count %d is not equal to sum of bucket counts %d", h.Count, count)
+	}
+	return nil
+}
+
+// Merge возвращает гистограмму с суммой значений h и other, при
+// различных границах бакетов возвращается копия other и false
+func (h Histogram) Merge(other Histogram) (Histogram, bool) {
+	out := NewHistogram(other.Bounds)
+	copy(out.Counts, other.Counts)
+	out.Sum, out.Count = other.Sum, other.Count
+	if !equalBounds(h.Bounds, other.Bounds) || len(h.Counts) != len(out.Counts) {
+		return out, false
+	}
+	for i, c := range h.Counts {
+		out.Counts[i] += c
+	}
+	out.Sum += h.Sum
+	out.Count += h.Count
+	return out, true
+}
+
+// String возвращает гистограмму в виде count=N sum=S le_B1=C1 ... le_+Inf=CN,
+// количество в бакетах накопительное
+func (h Histogram) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "count=%d sum=%v", h.Count, h.Sum)
+	var total uint64
+	for i, c := range h.Counts {
+		total += c
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(h.Bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(&out, " le_%s=%d", le, total)
+	}
+	return out.String()
+}
+
+// Validate проверяет значения квантилей
+func (s Summary) Validate() error {
+	for _, q := range s.Quantiles {
+		if q.Quantile < 0 || q.Quantile > 1 {
+			return fmt.Errorf("summary quantile %v is out of range [0, 1]", q.Quantile)
+		}
+	}
+	return nil
+}
+
+// String возвращает summary в виде count=N sum=S q_Q1=V1 ...
+func (s Summary) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "count=%d sum=%v", s.Count, s.Sum)
+	for _, q := range s.Quantiles {
+		fmt.Fprintf(&out, " q_%v=%v", q.Quantile, q.Value)
+	}
+	return out.String()
+}
+
+// equalBounds сравнивает границы бакетов
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram_Observe(t *testing.T) {
+	h := NewHistogram([]float64{1, 5})
+	for _, v := range []float64{0.5, 1, 3, 10} {
+		h.Observe(v)
+	}
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+	assert.Equal(t, 14.5, h.Sum)
+	assert.NoError(t, h.Validate())
+	assert.Equal(t, "count=4 sum=14.5 le_1=2 le_5=3 le_+Inf=4", h.String())
+}
+
+func TestHistogram_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hist    Histogram
+		wantErr bool
+	}{
+		{name: "good", hist: Histogram{Bounds: []float64{1}, Counts: []uint64{1, 2}, Count: 3}},
+		{name: "no bounds", hist: Histogram{Counts: []uint64{2}, Count: 2}},
+		{name: "counts length", hist: Histogram{Bounds: []float64{1}, Counts: []uint64{1}, Count: 1}, wantErr: true},
+		{name: "bounds order", hist: Histogram{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}}, wantErr: true},
+		{name: "count", hist: Histogram{Bounds: []float64{1}, Counts: []uint64{1, 2}, Count: 4}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.wantErr {
+				assert.Error(t, test.hist.Validate())
+			} else {
+				assert.NoError(t, test.hist.Validate())
+			}
+		})
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h := Histogram{Bounds: []float64{1}, Counts: []uint64{1, 2}, Sum: 5, Count: 3}
+	merged, ok := h.Merge(Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1})
+	assert.True(t, ok)
+	assert.Equal(t, Histogram{Bounds: []float64{1}, Counts: []uint64{2, 2}, Sum: 5.5, Count: 4}, merged)
+	// исходная гистограмма не меняется
+	assert.Equal(t, []uint64{1, 2}, h.Counts)
+
+	other := Histogram{Bounds: []float64{2}, Counts: []uint64{0, 1}, Sum: 3, Count: 1}
+	merged, ok = h.Merge(other)
+	assert.False(t, ok)
+	assert.Equal(t, other, merged)
+
+	merged, ok = Histogram{}.Merge(other)
+	assert.False(t, ok)
+	assert.Equal(t, other, merged)
+}
+
+func TestSummary(t *testing.T) {
+	s := Summary{Quantiles: []Quantile{{Quantile: 0.5, Value: 2}, {Quantile: 0.99, Value: 7}}, Sum: 20, Count: 8}
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, "count=8 sum=20 q_0.5=2 q_0.99=7", s.String())
+	s.Quantiles = append(s.Quantiles, Quantile{Quantile: 1.5})
+	assert.Error(t, s.Validate())
+}
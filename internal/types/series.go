@@ -0,0 +1,82 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Key возвращает ключ временного ряда метрики в Repository,
+// метрика без меток хранится под своим именем
+func (m Metric) Key() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
+// SeriesKey формирует ключ временного ряда из имени и меток
+// в виде name{key1="value1",key2="value2"}, метки сортируются по имени
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	return name + "{" + LabelsString(labels) + "}"
+}
+
+// LabelsString возвращает метки в виде key1="value1",key2="value2"
+// отсортированными по имени
+func LabelsString(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// ParseSeriesKey разбирает ключ временного ряда на имя и метки,
+// для ключа без меток возвращается пустой набор меток
+func ParseSeriesKey(key string) (string, map[string]string, error) {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key, nil, nil
+	}
+	if !strings.HasSuffix(key, "}") {
+		return key, nil, fmt.Errorf("series key %q: no closing brace", key)
+	}
+	name := key[:start]
+	labels, err := ParseLabels(key[start+1 : len(key)-1])
+	if err != nil {
+		return key, nil, fmt.Errorf("series key %q: %w", key, err)
+	}
+	return name, labels, nil
+}
+
+// ParseLabels разбирает метки в виде key1="value1",key2="value2"
+func ParseLabels(raw string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for raw != "" {
+		sep := strings.IndexByte(raw, '=')
+		if sep <= 0 {
+			return nil, errors.New("bad label name")
+		}
+		name := raw[:sep]
+		quoted, err := strconv.QuotedPrefix(raw[sep+1:])
+		if err != nil {
+			return nil, fmt.Errorf("bad value of label %s", name)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("bad value of label %s", name)
+		}
+		labels[name] = value
+		raw = raw[sep+1+len(quoted):]
+		if raw != "" {
+			if raw[0] != ',' {
+				return nil, fmt.Errorf("want ',' after label %s", name)
+			}
+			raw = raw[1:]
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,54 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesKey(t *testing.T) {
+	assert.Equal(t, "Alloc", SeriesKey("Alloc", nil))
+	assert.Equal(t, `cpu{host="web01",region="eu"}`, SeriesKey("cpu", map[string]string{"region": "eu", "host": "web01"}))
+	assert.Equal(t, `Alloc{agent="a"}`, Metric{ID: "Alloc", Labels: map[string]string{"agent": "a"}}.Key())
+}
+
+func TestParseSeriesKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		metric  string
+		labels  map[string]string
+		wantErr bool
+	}{
+		{name: "no labels", key: "Alloc", metric: "Alloc"},
+		{
+			name:   "labels",
+			key:    `cpu{host="web01",region="eu"}`,
+			metric: "cpu",
+			labels: map[string]string{"host": "web01", "region": "eu"},
+		},
+		{
+			name:   "escaped value",
+			key:    `cpu{path="a,b=\"c\"}"}`,
+			metric: "cpu",
+			labels: map[string]string{"path": `a,b="c"}`},
+		},
+		{name: "no brace", key: `cpu{host="a"`, wantErr: true},
+		{name: "no quotes", key: `cpu{host=a}`, wantErr: true},
+		{name: "no comma", key: `cpu{a="1"b="2"}`, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metric, labels, err := ParseSeriesKey(test.key)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.metric, metric)
+			assert.Equal(t, test.labels, labels)
+			assert.Equal(t, test.key, SeriesKey(metric, labels))
+		})
+	}
+}
@@ -9,16 +9,26 @@ import (
 	"time"
 )
 
-// Префикс в названиях таблиц базы
-const DBtablePrefix = "pmetric_"
+// Таблицы базы
+const (
+	DBSeriesTable  = "metric_series"  // временные ряды
+	DBSamplesTable = "metric_samples" // значения временных рядов
+	DBRollupsTable = "metric_rollups" // агрегаты значений временных рядов
+	// DBtablePrefix префикс таблиц метрик прежней схемы, в которой
+	// каждая метрика хранилась в отдельной таблице
+	DBtablePrefix = "pmetric_"
+)
 
 // Metric тип JSON формата метрики
 type Metric struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
-	Hash  string   `json:"hash,omitempty"`  // значение хеш-функции
+	ID        string            `json:"id"`                  // имя метрики
+	MType     string            `json:"type"`                // параметр, принимающий значение gauge, counter, histogram или summary
+	Delta     *int64            `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64          `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *Histogram        `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Summary   *Summary          `json:"summary,omitempty"`   // значение метрики в случае передачи summary
+	Hash      string            `json:"hash,omitempty"`      // значение хеш-функции
+	Labels    map[string]string `json:"labels,omitempty"`    // метки временного ряда
 }
 
 type EncData struct {
@@ -26,15 +36,67 @@ type EncData struct {
 	Data  string `json:"data1"` // зашифрованные данные
 }
 
-// Repository основной интерфейс хранилища метрик
+// Repository основной интерфейс хранилища метрик, значения хранятся
+// по ключу временного ряда, см. SeriesKey
 type Repository interface {
 	Append(ctx context.Context, key string, value int64)
+	AppendTotal(ctx context.Context, key string, total int64) int64
 	Get(ctx context.Context, key string) interface{}
 	GetAll(ctx context.Context) map[string]interface{}
 	Rewrite(ctx context.Context, key string, value float64)
+	AppendHistogram(ctx context.Context, key string, value Histogram)
+	RewriteSummary(ctx context.Context, key string, value Summary)
 	StoreAll(ctx context.Context, metrics *[]Metric)
 }
 
+// ValueType возвращает тип метрики значения value, хранимого в
+// Repository, для значения неизвестного типа - пустую строку
+func ValueType(value interface{}) string {
+	switch value.(type) {
+	case int64:
+		return "counter"
+	case float64:
+		return "gauge"
+	case Histogram:
+		return "histogram"
+	case Summary:
+		return "summary"
+	default:
+		return ""
+	}
+}
+
+// Sample значение временного ряда в момент времени
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// Ranger интерфейс хранилища, хранящего историю значений временных рядов
+type Ranger interface {
+	Range(ctx context.Context, key string, from, to time.Time) []Sample
+}
+
+// SeriesLister интерфейс хранилища, которое находит ряды метрики по
+// имени без перебора всех рядов: Series возвращает ключи рядов метрики
+// name с метками
+type SeriesLister interface {
+	Series(ctx context.Context, name string) []string
+}
+
+// Renamer интерфейс хранилища, которое переносит значение ряда под
+// другой ключ: Rename переносит значение ряда from в ряд to, если ряд
+// to еще не сохранен, и возвращает true при переносе
+type Renamer interface {
+	Rename(ctx context.Context, from, to string) bool
+}
+
+// Throttler интерфейс хранилища, которое не успевает сохранять
+// значения: Throttle возвращает ошибку, пока запись перегружена
+type Throttler interface {
+	Throttle() error
+}
+
 // Storager вспомогательный интерфейс хранилища метрик
 type Storager interface {
 	Close() error
@@ -43,11 +105,51 @@ type Storager interface {
 	Storing(ctx context.Context, w *sync.WaitGroup, logger *log.Logger, interval time.Duration, restore bool)
 }
 
-// MetricModel модель таблицы для хранения метрики в базе
-type MetricModel struct {
-	Timestamp int64 `gorm:"primaryKey;autoCreateTime"`
-	ID        string
-	Mtype     string
+// SeriesModel модель таблицы временных рядов, ряд определяется
+// именем метрики и метками в формате LabelsString; схема таблиц
+// задается миграциями модуля migrations
+type SeriesModel struct {
+	ID     int64 `gorm:"primaryKey"`
+	Name   string
+	Labels string
+	Mtype  string
+}
+
+// TableName возвращает имя таблицы временных рядов
+func (SeriesModel) TableName() string {
+	return DBSeriesTable
+}
+
+// SampleModel модель таблицы значений временных рядов, время
+// хранится в наносекундах unix времени
+type SampleModel struct {
+	SeriesID  int64 `gorm:"primaryKey;autoIncrement:false"`
+	Timestamp int64 `gorm:"primaryKey;autoIncrement:false"`
 	Value     sql.NullFloat64
 	Delta     sql.NullInt64
+	Data      sql.NullString // histogram или summary в JSON формате
+}
+
+// TableName возвращает имя таблицы значений временных рядов
+func (SampleModel) TableName() string {
+	return DBSamplesTable
+}
+
+// RollupModel модель таблицы агрегатов значений временных рядов за
+// интервал Resolution секунд, начинающийся в Timestamp наносекунд
+// unix времени; Increase - прирост counter за интервал
+type RollupModel struct {
+	SeriesID   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Resolution int64 `gorm:"primaryKey;autoIncrement:false"`
+	Timestamp  int64 `gorm:"primaryKey;autoIncrement:false"`
+	Min        float64
+	Max        float64
+	Sum        float64
+	Count      int64
+	Increase   sql.NullFloat64
+}
+
+// TableName возвращает имя таблицы агрегатов временных рядов
+func (RollupModel) TableName() string {
+	return DBRollupsTable
 }
@@ -0,0 +1,181 @@
+// Часть модуля usecase содержит функции агрегации истории
+// значений временного ряда по окнам времени.
+package usecase
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// DefaultStep размер окна агрегации, если он не задан
+const DefaultStep = time.Minute
+
+// maxPoints ограничивает число окон в ответе
+const maxPoints = 11000
+
+// AggregateQuery параметры запроса агрегации временного ряда
+type AggregateQuery struct {
+	Key        string        // ключ временного ряда
+	Func       string        // avg, min, max, sum, count, last, rate, increase или percentile
+	From       time.Time     // начало интервала, нулевое - за DefaultRange до конца
+	To         time.Time     // конец интервала, нулевое - текущее время
+	Step       time.Duration // размер окна, нулевой - DefaultStep
+	Percentile float64       // процентиль от 0 до 100 для percentile
+}
+
+// Aggregate возвращает значения функции агрегации по окнам [t, t+step)
+// интервала запроса, время значения - начало окна, окна без значений
+// и с бесконечным результатом пропускаются. Функции rate и increase
+// применяются только к counter метрикам
+func Aggregate(ctx context.Context, repo types.Repository, q AggregateQuery) ([]types.Sample, error) {
+	aggr, ok := aggregators[q.Func]
+	if !ok {
+		return nil, errors.New("undefined aggregate function")
+	}
+	if q.Func == "percentile" && (q.Percentile < 0 || q.Percentile > 100 || math.IsNaN(q.Percentile)) {
+		return nil, errors.New("percentile must be between 0 and 100")
+	}
+	key, err := ResolveKey(ctx, repo, q.Key)
+	if err != nil {
+		return nil, err
+	}
+	q.Key = key
+	if q.Func == "rate" || q.Func == "increase" {
+		if _, ok := repo.Get(ctx, q.Key).(int64); !ok {
+			return nil, errors.New(q.Func + " is applicable only to counter metric")
+		}
+	}
+	if q.Step == 0 {
+		q.Step = DefaultStep
+	}
+	if q.Step < 0 {
+		return nil, errors.New("step must be positive")
+	}
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-DefaultRange)
+	}
+	if q.To.Sub(q.From)/q.Step >= maxPoints {
+		return nil, errors.New("too many points, increase step")
+	}
+	samples, err := GetRange(ctx, repo, q.Key, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]types.Sample, 0)
+	var prev *types.Sample
+	i := 0
+	for start := q.From; !start.After(q.To); start = start.Add(q.Step) {
+		end := start.Add(q.Step)
+		window := make([]float64, 0)
+		first := i
+		for ; i < len(samples) && samples[i].Timestamp.Before(end); i++ {
+			window = append(window, samples[i].Value)
+		}
+		if len(window) > 0 {
+			value := aggr(window, prev, q)
+			if !math.IsNaN(value) && !math.IsInf(value, 0) {
+				points = append(points, types.Sample{Timestamp: start, Value: value})
+			}
+		}
+		if i > first {
+			prev = &samples[i-1]
+		}
+	}
+	return points, nil
+}
+
+// aggregator функция агрегации значений окна, prev последнее
+// значение предыдущих окон или nil
+type aggregator func(window []float64, prev *types.Sample, q AggregateQuery) float64
+
+var aggregators = map[string]aggregator{
+	"avg": func(window []float64, _ *types.Sample, _ AggregateQuery) float64 {
+		return sum(window) / float64(len(window))
+	},
+	"min": func(window []float64, _ *types.Sample, _ AggregateQuery) float64 {
+		out := window[0]
+		for _, v := range window[1:] {
+			out = math.Min(out, v)
+		}
+		return out
+	},
+	"max": func(window []float64, _ *types.Sample, _ AggregateQuery) float64 {
+		out := window[0]
+		for _, v := range window[1:] {
+			out = math.Max(out, v)
+		}
+		return out
+	},
+	"sum": func(window []float64, _ *types.Sample, _ AggregateQuery) float64 {
+		return sum(window)
+	},
+	"count": func(window []float64, _ *types.Sample, _ AggregateQuery) float64 {
+		return float64(len(window))
+	},
+	"last": func(window []float64, _ *types.Sample, _ AggregateQuery) float64 {
+		return window[len(window)-1]
+	},
+	"increase": func(window []float64, prev *types.Sample, _ AggregateQuery) float64 {
+		return Increase(increaseBase(window, prev), window)
+	},
+	"rate": func(window []float64, prev *types.Sample, q AggregateQuery) float64 {
+		return Increase(increaseBase(window, prev), window) / q.Step.Seconds()
+	},
+	"percentile": func(window []float64, _ *types.Sample, q AggregateQuery) float64 {
+		return percentile(window, q.Percentile)
+	},
+}
+
+// sum возвращает сумму значений
+func sum(values []float64) float64 {
+	var out float64
+	for _, v := range values {
+		out += v
+	}
+	return out
+}
+
+// increaseBase возвращает значение, относительно которого считается
+// прирост counter в окне: prev или первое значение окна
+func increaseBase(window []float64, prev *types.Sample) float64 {
+	if prev != nil {
+		return prev.Value
+	}
+	return window[0]
+}
+
+// Increase возвращает прирост накопленного значения counter
+// относительно base, уменьшение значения считается сбросом счетчика
+func Increase(base float64, values []float64) float64 {
+	last := base
+	var out float64
+	for _, v := range values {
+		if v < last {
+			out += v
+		} else {
+			out += v - last
+		}
+		last = v
+	}
+	return out
+}
+
+// percentile возвращает процентиль p значений с линейной интерполяцией
+func percentile(values []float64, p float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
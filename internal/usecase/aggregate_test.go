@@ -0,0 +1,144 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// historyStub хранилище с заданной историей значений
+type historyStub struct {
+	*storage.MemStorage
+	samples []types.Sample
+}
+
+func (hs historyStub) Range(ctx context.Context, key string, from, to time.Time) []types.Sample {
+	out := make([]types.Sample, 0)
+	for _, s := range hs.samples {
+		if !s.Timestamp.Before(from) && !s.Timestamp.After(to) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func TestAggregate_agentSeries(t *testing.T) {
+	now := time.Now()
+	repo := historyStub{
+		MemStorage: storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+			`PollCount{agent="a1",host="web01"}`: int64(7),
+			`Alloc{agent="a1",host="web01"}`:     1.5,
+			`Alloc{agent="a2",host="web02"}`:     2.5,
+		})),
+		samples: []types.Sample{{Timestamp: now.Add(-time.Second), Value: 7}},
+	}
+	ctx := context.Background()
+
+	// метрика без меток находит единственный ряд агента
+	points, err := Aggregate(ctx, repo, AggregateQuery{Key: "PollCount", Func: "increase", To: now})
+	require.NoError(t, err)
+	assert.Len(t, points, 1)
+	samples, err := GetRange(ctx, repo, "PollCount", time.Time{}, now)
+	require.NoError(t, err)
+	assert.Len(t, samples, 1)
+
+	// несколько рядов метрики неоднозначны
+	_, err = Aggregate(ctx, repo, AggregateQuery{Key: "Alloc", Func: "avg", To: now})
+	assert.ErrorIs(t, err, ErrAmbiguousKey)
+	_, err = GetForecast(ctx, repo, ForecastQuery{Key: "Alloc", To: now})
+	assert.ErrorIs(t, err, ErrAmbiguousKey)
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	repo := historyStub{
+		MemStorage: storage.NewMemStorage(storage.WithBuffer(map[string]interface{}{
+			"PollCount": int64(7),
+			"Alloc":     float64(4),
+		})),
+		// counter сбрасывается между 40 и 50 секундой
+		samples: []types.Sample{
+			{Timestamp: at(0), Value: 1},
+			{Timestamp: at(10), Value: 3},
+			{Timestamp: at(20), Value: 4},
+			{Timestamp: at(40), Value: 10},
+			{Timestamp: at(50), Value: 2},
+			{Timestamp: at(55), Value: 7},
+		},
+	}
+	ctx := context.Background()
+	query := func(key, fn string) AggregateQuery {
+		return AggregateQuery{Key: key, Func: fn, From: at(0), To: at(59), Step: 30 * time.Second, Percentile: 50}
+	}
+	tests := []struct {
+		fn   string
+		key  string
+		want []float64
+	}{
+		{fn: "avg", key: "Alloc", want: []float64{8.0 / 3, 19.0 / 3}},
+		{fn: "min", key: "Alloc", want: []float64{1, 2}},
+		{fn: "max", key: "Alloc", want: []float64{4, 10}},
+		{fn: "sum", key: "Alloc", want: []float64{8, 19}},
+		{fn: "count", key: "Alloc", want: []float64{3, 3}},
+		{fn: "last", key: "Alloc", want: []float64{4, 7}},
+		{fn: "percentile", key: "Alloc", want: []float64{3, 7}},
+		{fn: "increase", key: "PollCount", want: []float64{3, 13}},
+		{fn: "rate", key: "PollCount", want: []float64{0.1, 13.0 / 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fn, func(t *testing.T) {
+			points, err := Aggregate(ctx, repo, query(tt.key, tt.fn))
+			require.NoError(t, err)
+			require.Len(t, points, len(tt.want))
+			for i, p := range points {
+				assert.Equal(t, at(30*i), p.Timestamp)
+				assert.InDelta(t, tt.want[i], p.Value, 1e-9)
+			}
+		})
+	}
+
+	t.Run("empty windows", func(t *testing.T) {
+		q := query("Alloc", "count")
+		q.Step = 5 * time.Second
+		points, err := Aggregate(ctx, repo, q)
+		require.NoError(t, err)
+		assert.Len(t, points, 6)
+		assert.Equal(t, at(55), points[5].Timestamp)
+	})
+
+	errTests := []struct {
+		name string
+		q    AggregateQuery
+	}{
+		{name: "unknown function", q: query("Alloc", "median")},
+		{name: "rate of gauge", q: query("Alloc", "rate")},
+		{name: "wrong percentile", q: AggregateQuery{Key: "Alloc", Func: "percentile", Percentile: 101}},
+		{name: "negative step", q: AggregateQuery{Key: "Alloc", Func: "avg", Step: -time.Second}},
+		{name: "too many points", q: AggregateQuery{Key: "Alloc", Func: "avg", Step: time.Millisecond}},
+		{name: "from after to", q: AggregateQuery{Key: "Alloc", Func: "avg", From: at(10), To: at(0)}},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Aggregate(ctx, repo, tt.q)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("no history", func(t *testing.T) {
+		_, err := Aggregate(ctx, storage.NewMemStorage(), query("Alloc", "avg"))
+		assert.NoError(t, err)
+		_, err = Aggregate(ctx, noHistoryRepo{storage.NewMemStorage()}, query("Alloc", "avg"))
+		assert.ErrorIs(t, err, ErrNoHistory)
+	})
+}
+
+// noHistoryRepo хранилище без истории значений
+type noHistoryRepo struct {
+	types.Repository
+}
@@ -0,0 +1,367 @@
+// Часть модуля usecase содержит прогноз значений временного ряда
+// по истории линейной регрессией и методами Хольта и Хольта-Винтерса.
+package usecase
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// Методы прогноза
+const (
+	ForecastLinear = "linear" // линейная регрессия методом наименьших квадратов
+	ForecastHolt   = "holt"   // экспоненциальное сглаживание Хольта, с периодом сезонности - Хольта-Винтерса
+)
+
+const (
+	// DefaultHorizon горизонт прогноза, если он не задан
+	DefaultHorizon = 24 * time.Hour
+	// DefaultHoltAlpha коэффициент сглаживания уровня метода Хольта
+	DefaultHoltAlpha = 0.5
+	// DefaultHoltBeta коэффициент сглаживания тренда метода Хольта
+	DefaultHoltBeta = 0.1
+	// DefaultHoltGamma коэффициент сглаживания сезонных поправок
+	// метода Хольта-Винтерса
+	DefaultHoltGamma = 0.1
+	// maxSeasonSlots наибольшее число интервалов периода сезонности
+	maxSeasonSlots = 288
+	// forecastPoints число точек прогноза на горизонте
+	forecastPoints = 24
+)
+
+// Trend модель временного ряда: значение Level в момент At
+// и изменение Slope в секунду; при ненулевом периоде Season к значению
+// добавляется сезонная поправка Seasonal равных интервалов периода,
+// отсчитываемых от момента Origin
+type Trend struct {
+	Level    float64
+	Slope    float64
+	At       time.Time
+	Season   time.Duration
+	Seasonal []float64
+	Origin   time.Time
+}
+
+// ValueAt возвращает прогноз значения в момент t
+func (tr Trend) ValueAt(t time.Time) float64 {
+	value := tr.Level + tr.Slope*t.Sub(tr.At).Seconds()
+	if tr.Season > 0 && len(tr.Seasonal) > 0 {
+		value += tr.Seasonal[tr.slot(t)]
+	}
+	return value
+}
+
+// Crossing возвращает момент, начиная с которого прогноз достигает
+// значения threshold, false - если тренд удаляется от порога; для
+// сезонного тренда - первый такой момент хотя бы одного интервала
+// периода сезонности
+func (tr Trend) Crossing(threshold float64) (time.Time, bool) {
+	if tr.Season <= 0 || len(tr.Seasonal) == 0 {
+		return crossing(tr.Level, tr.Slope, tr.At, threshold)
+	}
+	current := tr.ValueAt(tr.At)
+	if current == threshold {
+		return tr.At, true
+	}
+	above := current > threshold
+	var first time.Time
+	found := false
+	for i, offset := range tr.Seasonal {
+		level := tr.Level + offset
+		var at time.Time
+		if above && level <= threshold || !above && level >= threshold {
+			// значения интервала уже достигли порога, но при удалении
+			// тренда от порога достигают его только до пересечения
+			at = tr.nextSlot(i, tr.At)
+			if above && tr.Slope > 0 || !above && tr.Slope < 0 {
+				seconds := (threshold - level) / tr.Slope
+				if at.Sub(tr.At).Seconds() > seconds {
+					continue
+				}
+			}
+		} else {
+			from, ok := crossing(level, tr.Slope, tr.At, threshold)
+			if !ok {
+				continue
+			}
+			at = tr.nextSlot(i, from)
+		}
+		if !found || at.Before(first) {
+			first, found = at, true
+		}
+	}
+	return first, found
+}
+
+// crossing возвращает момент достижения значения threshold тренда
+// без сезонности
+func crossing(level, slope float64, at time.Time, threshold float64) (time.Time, bool) {
+	if level == threshold {
+		return at, true
+	}
+	if slope == 0 {
+		return time.Time{}, false
+	}
+	seconds := (threshold - level) / slope
+	if seconds < 0 || seconds > math.MaxInt64/float64(time.Second) {
+		return time.Time{}, false
+	}
+	return at.Add(time.Duration(seconds * float64(time.Second))), true
+}
+
+// phase возвращает смещение момента t от начала его периода сезонности
+func (tr Trend) phase(t time.Time) time.Duration {
+	phase := t.Sub(tr.Origin) % tr.Season
+	if phase < 0 {
+		phase += tr.Season
+	}
+	return phase
+}
+
+// slot возвращает номер интервала периода сезонности момента t
+func (tr Trend) slot(t time.Time) int {
+	slot := int(float64(tr.phase(t)) / float64(tr.Season) * float64(len(tr.Seasonal)))
+	if slot >= len(tr.Seasonal) {
+		slot = len(tr.Seasonal) - 1
+	}
+	return slot
+}
+
+// nextSlot возвращает первый не ранее t момент интервала i периода
+// сезонности
+func (tr Trend) nextSlot(i int, t time.Time) time.Time {
+	if tr.slot(t) == i {
+		return t
+	}
+	start := time.Duration(float64(tr.Season) * float64(i) / float64(len(tr.Seasonal)))
+	phase := tr.phase(t)
+	if phase < start {
+		return t.Add(start - phase)
+	}
+	return t.Add(tr.Season - phase + start)
+}
+
+// LinearTrend строит тренд линейной регрессией, момент тренда -
+// время последнего значения
+func LinearTrend(samples []types.Sample) (Trend, error) {
+	if len(samples) < 2 {
+		return Trend{}, errors.New("at least two samples are required")
+	}
+	at := samples[len(samples)-1].Timestamp
+	var meanT, meanV float64
+	for _, s := range samples {
+		meanT += s.Timestamp.Sub(at).Seconds()
+		meanV += s.Value
+	}
+	n := float64(len(samples))
+	meanT, meanV = meanT/n, meanV/n
+	var cov, variance float64
+	for _, s := range samples {
+		dt := s.Timestamp.Sub(at).Seconds() - meanT
+		cov += dt * (s.Value - meanV)
+		variance += dt * dt
+	}
+	if variance == 0 {
+		return Trend{}, errors.New("samples must have different timestamps")
+	}
+	slope := cov / variance
+	return Trend{Level: meanV - slope*meanT, Slope: slope, At: at}, nil
+}
+
+// HoltTrend строит тренд двойным экспоненциальным сглаживанием
+// с учетом неравных интервалов между значениями, alpha сглаживает
+// уровень, beta - тренд
+func HoltTrend(samples []types.Sample, alpha, beta float64) (Trend, error) {
+	if alpha <= 0 || alpha > 1 || beta <= 0 || beta > 1 {
+		return Trend{}, errors.New("smoothing factors must be in (0, 1]")
+	}
+	if len(samples) < 2 {
+		return Trend{}, errors.New("at least two samples are required")
+	}
+	tr := Trend{Level: samples[0].Value, At: samples[0].Timestamp}
+	initialized := false
+	for _, s := range samples[1:] {
+		dt := s.Timestamp.Sub(tr.At).Seconds()
+		if dt <= 0 {
+			continue
+		}
+		if !initialized {
+			tr.Slope = (s.Value - tr.Level) / dt
+			initialized = true
+		}
+		prev := tr.Level
+		tr.Level = alpha*s.Value + (1-alpha)*(tr.Level+tr.Slope*dt)
+		tr.Slope = beta*(tr.Level-prev)/dt + (1-beta)*tr.Slope
+		tr.At = s.Timestamp
+	}
+	if !initialized {
+		return Trend{}, errors.New("samples must have different timestamps")
+	}
+	return tr, nil
+}
+
+// HoltWintersTrend строит сезонный тренд тройным экспоненциальным
+// сглаживанием с периодом сезонности season, gamma сглаживает сезонные
+// поправки; период делится на интервалы по медианному интервалу между
+// значениями, но не более maxSeasonSlots, история должна охватывать не
+// менее двух периодов: по первым двум строятся начальные уровень, тренд
+// и сезонные поправки
+func HoltWintersTrend(samples []types.Sample, alpha, beta, gamma float64, season time.Duration) (Trend, error) {
+	if alpha <= 0 || alpha > 1 || beta <= 0 || beta > 1 || gamma <= 0 || gamma > 1 {
+		return Trend{}, errors.New("smoothing factors must be in (0, 1]")
+	}
+	if season <= 0 {
+		return Trend{}, errors.New("season must be positive")
+	}
+	ordered := make([]types.Sample, 0, len(samples))
+	steps := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		if len(ordered) > 0 {
+			step := s.Timestamp.Sub(ordered[len(ordered)-1].Timestamp)
+			if step <= 0 {
+				continue
+			}
+			steps = append(steps, float64(step))
+		}
+		ordered = append(ordered, s)
+	}
+	if len(ordered) < 2 || ordered[len(ordered)-1].Timestamp.Sub(ordered[0].Timestamp) < 2*season {
+		return Trend{}, errors.New("history must cover at least two seasons")
+	}
+	sort.Float64s(steps)
+	slots := int(math.Round(float64(season) / steps[len(steps)/2]))
+	if slots > maxSeasonSlots {
+		slots = maxSeasonSlots
+	}
+	if slots < 2 {
+		return Trend{}, errors.New("season must cover at least two samples")
+	}
+
+	tr := Trend{Season: season, Seasonal: make([]float64, slots), Origin: ordered[0].Timestamp}
+	// средние значения и время значений первых двух периодов
+	var values, seconds [2]float64
+	var counts [2]int
+	for _, s := range ordered {
+		if n := int(s.Timestamp.Sub(tr.Origin) / season); n < 2 {
+			values[n] += s.Value
+			seconds[n] += s.Timestamp.Sub(tr.Origin).Seconds()
+			counts[n]++
+		}
+	}
+	for n := range counts {
+		if counts[n] == 0 {
+			return Trend{}, errors.New("not enough history for seasonal forecast")
+		}
+		values[n] /= float64(counts[n])
+		seconds[n] /= float64(counts[n])
+	}
+	tr.Level = values[0]
+	tr.Slope = (values[1] - values[0]) / (seconds[1] - seconds[0])
+	tr.At = tr.Origin.Add(time.Duration(seconds[0] * float64(time.Second)))
+	// начальные поправки - средние отклонения от тренда в первом периоде
+	slotCounts := make([]int, slots)
+	rest := ordered
+	for len(rest) > 0 && rest[0].Timestamp.Sub(tr.Origin) < season {
+		i := tr.slot(rest[0].Timestamp)
+		tr.Seasonal[i] += rest[0].Value - tr.Level - tr.Slope*rest[0].Timestamp.Sub(tr.At).Seconds()
+		slotCounts[i]++
+		rest = rest[1:]
+	}
+	for i, n := range slotCounts {
+		if n > 0 {
+			tr.Seasonal[i] /= float64(n)
+		}
+	}
+	for _, s := range rest {
+		dt := s.Timestamp.Sub(tr.At).Seconds()
+		i := tr.slot(s.Timestamp)
+		prev := tr.Level
+		tr.Level = alpha*(s.Value-tr.Seasonal[i]) + (1-alpha)*(tr.Level+tr.Slope*dt)
+		tr.Slope = beta*(tr.Level-prev)/dt + (1-beta)*tr.Slope
+		tr.Seasonal[i] = gamma*(s.Value-tr.Level) + (1-gamma)*tr.Seasonal[i]
+		tr.At = s.Timestamp
+	}
+	return tr, nil
+}
+
+// ForecastQuery параметры запроса прогноза временного ряда
+type ForecastQuery struct {
+	Key       string        // ключ временного ряда
+	Method    string        // linear или holt, пустой - linear
+	From      time.Time     // начало истории, нулевое - за DefaultRange до конца
+	To        time.Time     // конец истории и начало прогноза, нулевое - текущее время
+	Horizon   time.Duration // горизонт прогноза, нулевой - DefaultHorizon
+	Threshold *float64      // порог, момент достижения которого прогнозируется
+	Alpha     float64       // сглаживание уровня для holt, нулевое - DefaultHoltAlpha
+	Beta      float64       // сглаживание тренда для holt, нулевое - DefaultHoltBeta
+	Gamma     float64       // сглаживание сезонных поправок для holt, нулевое - DefaultHoltGamma
+	Season    time.Duration // период сезонности для holt, нулевой - без сезонности
+}
+
+// Forecast результат прогноза
+type Forecast struct {
+	Trend
+	Points   []types.Sample // прогноз на горизонте
+	Crossing *time.Time     // момент достижения порога, nil - порог не достигается
+}
+
+// GetForecast строит тренд по истории временного ряда и возвращает
+// прогноз на горизонте запроса
+func GetForecast(ctx context.Context, repo types.Repository, q ForecastQuery) (Forecast, error) {
+	if q.Horizon == 0 {
+		q.Horizon = DefaultHorizon
+	}
+	if q.Horizon < 0 {
+		return Forecast{}, errors.New("horizon must be positive")
+	}
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	samples, err := GetRange(ctx, repo, q.Key, q.From, q.To)
+	if err != nil {
+		return Forecast{}, err
+	}
+	var tr Trend
+	switch q.Method {
+	case "", ForecastLinear:
+		tr, err = LinearTrend(samples)
+	case ForecastHolt:
+		if q.Alpha == 0 {
+			q.Alpha = DefaultHoltAlpha
+		}
+		if q.Beta == 0 {
+			q.Beta = DefaultHoltBeta
+		}
+		if q.Season == 0 {
+			tr, err = HoltTrend(samples, q.Alpha, q.Beta)
+			break
+		}
+		if q.Gamma == 0 {
+			q.Gamma = DefaultHoltGamma
+		}
+		tr, err = HoltWintersTrend(samples, q.Alpha, q.Beta, q.Gamma, q.Season)
+	default:
+		return Forecast{}, errors.New("undefined forecast method")
+	}
+	if err != nil {
+		return Forecast{}, err
+	}
+
+	out := Forecast{Trend: tr, Points: make([]types.Sample, 0, forecastPoints)}
+	step := q.Horizon / forecastPoints
+	for i := 1; i <= forecastPoints; i++ {
+		at := q.To.Add(time.Duration(i) * step)
+		out.Points = append(out.Points, types.Sample{Timestamp: at, Value: tr.ValueAt(at)})
+	}
+	if q.Threshold != nil {
+		if crossing, ok := tr.Crossing(*q.Threshold); ok {
+			out.Crossing = &crossing
+		}
+	}
+	return out, nil
+}
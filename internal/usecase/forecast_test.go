@@ -0,0 +1,182 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// linearSamples возвращает значения с интервалом в минуту от start
+func linearSamples(start time.Time, values ...float64) []types.Sample {
+	out := make([]types.Sample, 0, len(values))
+	for i, v := range values {
+		out = append(out, types.Sample{Timestamp: start.Add(time.Duration(i) * time.Minute), Value: v})
+	}
+	return out
+}
+
+func TestLinearTrend(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	tr, err := LinearTrend(linearSamples(start, 1000, 900, 800))
+	require.NoError(t, err)
+	assert.InDelta(t, 800, tr.Level, 1e-9)
+	assert.InDelta(t, -100.0/60, tr.Slope, 1e-9)
+	assert.Equal(t, start.Add(2*time.Minute), tr.At)
+	assert.InDelta(t, 700, tr.ValueAt(start.Add(3*time.Minute)), 1e-9)
+
+	crossing, ok := tr.Crossing(0)
+	require.True(t, ok)
+	assert.Equal(t, start.Add(10*time.Minute), crossing)
+	_, ok = tr.Crossing(900)
+	assert.False(t, ok, "threshold is behind the trend")
+
+	// разброс вокруг тренда
+	tr, err = LinearTrend(linearSamples(start, 20, 40, 30))
+	require.NoError(t, err)
+	assert.InDelta(t, 35, tr.Level, 1e-9)
+	assert.InDelta(t, 5.0/60, tr.Slope, 1e-9)
+
+	_, err = LinearTrend(linearSamples(start, 1))
+	assert.Error(t, err)
+	_, err = LinearTrend([]types.Sample{{Timestamp: start, Value: 1}, {Timestamp: start, Value: 2}})
+	assert.Error(t, err)
+}
+
+func TestHoltTrend(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	tr, err := HoltTrend(linearSamples(start, 1000, 900, 800, 700), DefaultHoltAlpha, DefaultHoltBeta)
+	require.NoError(t, err)
+	assert.InDelta(t, 700, tr.Level, 1e-9)
+	assert.InDelta(t, -100.0/60, tr.Slope, 1e-9)
+	assert.Equal(t, start.Add(3*time.Minute), tr.At)
+
+	tr, err = HoltTrend(linearSamples(start, 20, 40, 30), 0.5, 0.1)
+	require.NoError(t, err)
+	assert.InDelta(t, 45, tr.Level, 1e-9)
+	assert.InDelta(t, 18.5/60, tr.Slope, 1e-9)
+
+	_, err = HoltTrend(linearSamples(start, 1, 2), 0, 0.1)
+	assert.Error(t, err)
+	_, err = HoltTrend([]types.Sample{{Timestamp: start, Value: 1}, {Timestamp: start, Value: 2}}, 0.5, 0.5)
+	assert.Error(t, err)
+}
+
+// seasonalSamples возвращает n значений с интервалом в 10 минут от
+// start: сезонные поправки pattern с периодом в час и тренд slope в секунду
+func seasonalSamples(start time.Time, n int, slope float64, pattern ...float64) []types.Sample {
+	out := make([]types.Sample, 0, n)
+	for i := 0; i < n; i++ {
+		at := start.Add(time.Duration(i) * 10 * time.Minute)
+		out = append(out, types.Sample{Timestamp: at, Value: 100 + slope*at.Sub(start).Seconds() + pattern[i%len(pattern)]})
+	}
+	return out
+}
+
+func TestHoltWintersTrend(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	pattern := []float64{0, 10, 20, 10, 0, -40}
+	tr, err := HoltWintersTrend(seasonalSamples(start, 16, 0, pattern...), DefaultHoltAlpha, DefaultHoltBeta, DefaultHoltGamma, time.Hour)
+	require.NoError(t, err)
+	assert.InDelta(t, 100, tr.Level, 1e-9)
+	assert.InDelta(t, 0, tr.Slope, 1e-9)
+	assert.Equal(t, start.Add(150*time.Minute), tr.At)
+	require.Len(t, tr.Seasonal, len(pattern))
+	for i, offset := range pattern {
+		assert.InDelta(t, offset, tr.Seasonal[i], 1e-9)
+	}
+	assert.InDelta(t, 120, tr.ValueAt(start.Add(205*time.Minute)), 1e-9)
+	assert.InDelta(t, 60, tr.ValueAt(start.Add(230*time.Minute)), 1e-9)
+
+	// порог достигается только в третьем интервале периода
+	crossing, ok := tr.Crossing(115)
+	require.True(t, ok)
+	assert.Equal(t, start.Add(200*time.Minute), crossing)
+	crossing, ok = tr.Crossing(70)
+	require.True(t, ok)
+	assert.Equal(t, start.Add(170*time.Minute), crossing)
+	_, ok = tr.Crossing(130)
+	assert.False(t, ok, "threshold is above every season slot")
+
+	// рост с сезонными колебаниями
+	tr, err = HoltWintersTrend(seasonalSamples(start, 48, 0.01, pattern...), DefaultHoltAlpha, DefaultHoltBeta, DefaultHoltGamma, time.Hour)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.01, tr.Slope, 1e-9)
+	next := start.Add(48 * 10 * time.Minute)
+	assert.InDelta(t, 100+0.01*next.Sub(start).Seconds(), tr.ValueAt(next), 1e-6)
+	assert.InDelta(t, 60+0.01*next.Add(50*time.Minute).Sub(start).Seconds(), tr.ValueAt(next.Add(50*time.Minute)), 1e-6)
+
+	_, err = HoltWintersTrend(seasonalSamples(start, 11, 0, pattern...), 0.5, 0.1, 0.1, time.Hour)
+	assert.Error(t, err, "history is shorter than two seasons")
+	_, err = HoltWintersTrend(seasonalSamples(start, 16, 0, pattern...), 0.5, 0.1, 0, time.Hour)
+	assert.Error(t, err)
+	_, err = HoltWintersTrend(seasonalSamples(start, 16, 0, pattern...), 0.5, 0.1, 0.1, 10*time.Minute)
+	assert.Error(t, err, "season is shorter than two samples")
+
+	// во втором периоде нет значений
+	gap := append(linearSamples(start, 1, 2, 3, 4, 5, 6), linearSamples(start.Add(130*time.Minute), 1, 2, 3)...)
+	_, err = HoltWintersTrend(gap, 0.5, 0.1, 0.1, time.Hour)
+	assert.EqualError(t, err, "not enough history for seasonal forecast")
+}
+
+func TestGetForecast(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	repo := historyStub{
+		MemStorage: storage.NewMemStorage(),
+		samples:    linearSamples(start, 1000, 900, 800, 700),
+	}
+	ctx := context.Background()
+	zero := float64(0)
+
+	got, err := GetForecast(ctx, repo, ForecastQuery{
+		Key:       "FreeMemory",
+		From:      start,
+		To:        start.Add(3 * time.Minute),
+		Horizon:   24 * time.Minute,
+		Threshold: &zero,
+	})
+	require.NoError(t, err)
+	require.Len(t, got.Points, forecastPoints)
+	assert.Equal(t, start.Add(4*time.Minute), got.Points[0].Timestamp)
+	assert.InDelta(t, 600, got.Points[0].Value, 1e-9)
+	assert.InDelta(t, -1700, got.Points[forecastPoints-1].Value, 1e-9)
+	require.NotNil(t, got.Crossing)
+	assert.Equal(t, start.Add(10*time.Minute), *got.Crossing)
+
+	got, err = GetForecast(ctx, repo, ForecastQuery{
+		Key:    "FreeMemory",
+		Method: ForecastHolt,
+		From:   start,
+		To:     start.Add(3 * time.Minute),
+	})
+	require.NoError(t, err)
+	assert.InDelta(t, -100.0/60, got.Slope, 1e-9)
+	assert.Nil(t, got.Crossing, "threshold is not set")
+	assert.Equal(t, start.Add(3*time.Minute+DefaultHorizon), got.Points[forecastPoints-1].Timestamp)
+
+	repo.samples = seasonalSamples(start, 16, 0, 0, 10, 20, 10, 0, -40)
+	got, err = GetForecast(ctx, repo, ForecastQuery{
+		Key:       "FreeMemory",
+		Method:    ForecastHolt,
+		From:      start,
+		To:        start.Add(150 * time.Minute),
+		Horizon:   time.Hour,
+		Season:    time.Hour,
+		Threshold: &zero,
+	})
+	require.NoError(t, err)
+	assert.Len(t, got.Seasonal, 6)
+	assert.InDelta(t, 60, got.Points[9].Value, 1e-9)
+	assert.Nil(t, got.Crossing)
+
+	_, err = GetForecast(ctx, repo, ForecastQuery{Key: "FreeMemory", Method: "arima", From: start, To: start.Add(time.Hour)})
+	assert.Error(t, err)
+	_, err = GetForecast(ctx, repo, ForecastQuery{Key: "FreeMemory", From: start, To: start.Add(time.Hour), Horizon: -time.Hour})
+	assert.Error(t, err)
+	_, err = GetForecast(ctx, noHistoryRepo{storage.NewMemStorage()}, ForecastQuery{Key: "FreeMemory"})
+	assert.ErrorIs(t, err, ErrNoHistory)
+}
@@ -9,18 +9,39 @@ import (
 	"github.com/hrapovd1/pmetrics/internal/types"
 )
 
-// Write sign data with hash function here
+// Write sign data with hash function here,
+// labels are signed as part of series key
 func SignData(data *types.Metric, key string) error {
 	h := hmac.New(sha256.New, []byte(key))
 	switch data.MType {
 	case "counter":
-		_, err := h.Write([]byte(fmt.Sprintf("%s:%s:%d", data.ID, data.MType, *data.Delta)))
+		_, err := h.Write([]byte(fmt.Sprintf("%s:%s:%d", data.Key(), data.MType, *data.Delta)))
 		if err != nil {
 			return err
 		}
 		data.Hash = fmt.Sprintf("%x", h.Sum(nil))
 	case "gauge":
-		_, err := h.Write([]byte(fmt.Sprintf("%s:%s:%f", data.ID, data.MType, *data.Value)))
+		_, err := h.Write([]byte(fmt.Sprintf("%s:%s:%f", data.Key(), data.MType, *data.Value)))
+		if err != nil {
+			return err
+		}
+		data.Hash = fmt.Sprintf("%x", h.Sum(nil))
+	case "histogram":
+		if data.Histogram == nil {
+			return errors.New("undefined data.Histogram")
+		}
+		hg := data.Histogram
+		_, err := h.Write([]byte(fmt.Sprintf("%s:%s:%v:%v:%f:%d", data.Key(), data.MType, hg.Bounds, hg.Counts, hg.Sum, hg.Count)))
+		if err != nil {
+			return err
+		}
+		data.Hash = fmt.Sprintf("%x", h.Sum(nil))
+	case "summary":
+		if data.Summary == nil {
+			return errors.New("undefined data.Summary")
+		}
+		sm := data.Summary
+		_, err := h.Write([]byte(fmt.Sprintf("%s:%s:%v:%f:%d", data.Key(), data.MType, sm.Quantiles, sm.Sum, sm.Count)))
 		if err != nil {
 			return err
 		}
@@ -40,3 +61,16 @@ func IsSignEqual(data types.Metric, key string) bool {
 	signLocal := []byte(data.Hash)
 	return hmac.Equal(signRemote, signLocal)
 }
+
+// SignBody возвращает подпись тела запроса целиком, используется
+// для протоколов без подписи отдельных метрик
+func SignBody(body []byte, key string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(body)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// IsBodySignEqual проверяет подпись тела запроса
+func IsBodySignEqual(body []byte, sign string, key string) bool {
+	return hmac.Equal([]byte(sign), []byte(SignBody(body, key)))
+}
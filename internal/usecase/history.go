@@ -0,0 +1,58 @@
+// Часть модуля usecase содержит методы запроса истории
+// значений временных рядов.
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// DefaultRange интервал запроса истории, если его начало не задано
+const DefaultRange = time.Hour
+
+// ErrNoHistory возвращается, если хранилище не хранит историю значений
+var ErrNoHistory = errors.New("storage doesn't keep metrics history")
+
+// GetRange возвращает историю значений временного ряда key в интервале
+// [from, to], нулевое to означает текущее время, нулевое from - to минус
+// DefaultRange; ключ разрешается ResolveKey
+func GetRange(ctx context.Context, repo types.Repository, key string, from, to time.Time) ([]types.Sample, error) {
+	ranger, ok := repo.(types.Ranger)
+	if !ok {
+		return nil, ErrNoHistory
+	}
+	key, err := ResolveKey(ctx, repo, key)
+	if err != nil {
+		return nil, err
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-DefaultRange)
+	}
+	if from.After(to) {
+		return nil, errors.New("range start is after its end")
+	}
+	samples := ranger.Range(ctx, key, from, to)
+	if samples == nil {
+		return nil, ErrNoHistory
+	}
+	return samples, nil
+}
+
+// ParseTime разбирает время в формате RFC3339 или unix время в секундах,
+// пустая строка возвращает нулевое время
+func ParseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,185 @@
+// Часть модуля usecase содержит методы для отдачи метрик
+// в текстовом формате Prometheus.
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hrapovd1/pmetrics/internal/types"
+)
+
+// PromContentType тип содержимого текстового формата Prometheus
+const PromContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PromName приводит имя метрики к допустимому в Prometheus виду,
+// недопустимые символы заменяются на '_'
+func PromName(name string) string {
+	var out strings.Builder
+	for i, ch := range name {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch == '_', ch == ':':
+			out.WriteRune(ch)
+		case ch >= '0' && ch <= '9':
+			if i == 0 {
+				out.WriteRune('_')
+			}
+			out.WriteRune(ch)
+		default:
+			out.WriteRune('_')
+		}
+	}
+	if out.Len() == 0 {
+		return "_"
+	}
+	return out.String()
+}
+
+// WritePromMetrics записывает все метрики из Repository в текстовом
+// формате Prometheus, counter метрики отдаются как counter, gauge как gauge,
+// histogram и summary рядами _bucket, _sum и _count, временные ряды одной метрики группируются под общим # TYPE
+func WritePromMetrics(ctx context.Context, w io.Writer, repo types.Repository) error {
+	all := repo.GetAll(ctx)
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// ряды метрики: тип и строки каждого ряда в формате Prometheus
+	type family struct {
+		mType  string
+		series map[string]string
+	}
+	families := make(map[string]*family)
+	names := make([]string, 0)
+	for _, k := range keys {
+		name, labels, err := types.ParseSeriesKey(k)
+		if err != nil {
+			name, labels = k, nil
+		}
+		name = PromName(name)
+		series := name + promLabels(labels)
+
+		var mType, text string
+		switch val := all[k].(type) {
+		case int64:
+			mType = "counter"
+			text = series + " " + strconv.FormatInt(val, 10) + "\n"
+		case float64:
+			mType = "gauge"
+			text = series + " " + promFloat(val) + "\n"
+		case types.Histogram:
+			mType = "histogram"
+			text = promHistogram(name, labels, val)
+		case types.Summary:
+			mType = "summary"
+			text = promSummary(name, labels, val)
+		default:
+			continue
+		}
+		f, ok := families[name]
+		if !ok {
+			f = &family{mType: mType, series: make(map[string]string)}
+			families[name] = f
+			names = append(names, name)
+		}
+		// после приведения ряды могут совпасть, оставляю первый,
+		// ряды с другим типом под тем же именем пропускаю
+		if _, ok := f.series[series]; ok || f.mType != mType {
+			continue
+		}
+		f.series[series] = text
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := families[name]
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", name, f.mType); err != nil {
+			return err
+		}
+		series := make([]string, 0, len(f.series))
+		for s := range f.series {
+			series = append(series, s)
+		}
+		sort.Strings(series)
+		for _, s := range series {
+			if _, err := io.WriteString(w, f.series[s]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// promHistogram возвращает строки гистограммы с накопительными бакетами
+func promHistogram(name string, labels map[string]string, h types.Histogram) string {
+	var out strings.Builder
+	var total uint64
+	for i, c := range h.Counts {
+		total += c
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = promFloat(h.Bounds[i])
+		}
+		fmt.Fprintf(&out, "%s_bucket%s %d\n", name, promLabels(withLabel(labels, "le", le)), total)
+	}
+	fmt.Fprintf(&out, "%s_sum%s %s\n", name, promLabels(labels), promFloat(h.Sum))
+	fmt.Fprintf(&out, "%s_count%s %d\n", name, promLabels(labels), h.Count)
+	return out.String()
+}
+
+// promSummary возвращает строки summary с квантилями
+func promSummary(name string, labels map[string]string, s types.Summary) string {
+	var out strings.Builder
+	for _, q := range s.Quantiles {
+		fmt.Fprintf(&out, "%s%s %s\n", name, promLabels(withLabel(labels, "quantile", promFloat(q.Quantile))), promFloat(q.Value))
+	}
+	fmt.Fprintf(&out, "%s_sum%s %s\n", name, promLabels(labels), promFloat(s.Sum))
+	fmt.Fprintf(&out, "%s_count%s %d\n", name, promLabels(labels), s.Count)
+	return out.String()
+}
+
+// withLabel возвращает копию меток с дополнительной меткой
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[name] = value
+	return out
+}
+
+// promLabels возвращает метки в формате Prometheus {name="value",...}
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, PromName(k)+`="`+promEscape.Replace(v)+`"`)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// promEscape экранирует значения меток
+var promEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promFloat преобразует float64 в строку формата Prometheus
+func promFloat(val float64) string {
+	switch {
+	case math.IsNaN(val):
+		return "NaN"
+	case math.IsInf(val, 1):
+		return "+Inf"
+	case math.IsInf(val, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/hrapovd1/pmetrics/internal/storage"
+	"github.com/hrapovd1/pmetrics/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Alloc", want: "Alloc"},
+		{name: "cpu.load-1m", want: "cpu_load_1m"},
+		{name: "1value", want: "_1value"},
+		{name: "ns:metric_1", want: "ns:metric_1"},
+		{name: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, PromName(tt.name))
+		})
+	}
+}
+
+func TestWritePromMetrics(t *testing.T) {
+	stor := map[string]interface{}{
+		"PollCount":                    int64(5),
+		"Alloc":                        float64(12.5),
+		"cpu.load":                     float64(0.25),
+		"Broken":                       math.Inf(1),
+		`Alloc{agent="b"}`:             float64(3),
+		`Alloc{agent="a"}`:             float64(2),
+		`req{path="/a\"b",code="200"}`: int64(1),
+	}
+	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
+	var out strings.Builder
+	require.NoError(t, WritePromMetrics(context.Background(), &out, locStorage))
+	want := `# TYPE Alloc gauge
+Alloc 12.5
+Alloc{agent="a"} 2
+Alloc{agent="b"} 3
+# TYPE Broken gauge
+Broken +Inf
+# TYPE PollCount counter
+PollCount 5
+# TYPE cpu_load gauge
+cpu_load 0.25
+# TYPE req counter
+req{code="200",path="/a\"b"} 1
+`
+	assert.Equal(t, want, out.String())
+}
+
+func TestWritePromMetrics_distribution(t *testing.T) {
+	stor := map[string]interface{}{
+		`gc_pause{agent="a"}`: types.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{2, 1, 1}, Sum: 3.5, Count: 4},
+		"rpc":                 types.Summary{Quantiles: []types.Quantile{{Quantile: 0.5, Value: 1.5}}, Sum: 8, Count: 4},
+	}
+	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
+	var out strings.Builder
+	require.NoError(t, WritePromMetrics(context.Background(), &out, locStorage))
+	want := `# TYPE gc_pause histogram
+gc_pause_bucket{agent="a",le="0.1"} 2
+gc_pause_bucket{agent="a",le="1"} 3
+gc_pause_bucket{agent="a",le="+Inf"} 4
+gc_pause_sum{agent="a"} 3.5
+gc_pause_count{agent="a"} 4
+# TYPE rpc summary
+rpc{quantile="0.5"} 1.5
+rpc_sum 8
+rpc_count 4
+`
+	assert.Equal(t, want, out.String())
+}
@@ -13,8 +13,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -30,10 +32,43 @@ const (
 	getMetricName = 3 // Позиция имени метрики в url GET запроса
 )
 
+// ErrTypeMismatch возвращается при записи значения метрики, тип
+// которой отличается от типа значения, сохраненного под тем же ключом
+var ErrTypeMismatch = errors.New("metric type mismatch")
+
+// ErrAmbiguousKey возвращается, если ключу метрики без меток
+// соответствует несколько временных рядов
+var ErrAmbiguousKey = errors.New("ambiguous metric id")
+
+// CheckType проверяет, что под ключом key не сохранено значение
+// метрики другого типа, чем mType
+func CheckType(ctx context.Context, repo types.Repository, key, mType string) error {
+	current := repo.Get(ctx, key)
+	if current == nil {
+		return nil
+	}
+	if stored := types.ValueType(current); stored != mType {
+		return fmt.Errorf("%w: %s is %s, not %s", ErrTypeMismatch, key, stored, mType)
+	}
+	return nil
+}
+
+// Throttle возвращает ошибку хранилища repo, пока оно не успевает
+// сохранять значения, см. types.Throttler
+func Throttle(repo types.Repository) error {
+	if throttler, ok := repo.(types.Throttler); ok {
+		return throttler.Throttle()
+	}
+	return nil
+}
+
 // WriteMetric сохраняет метрику в Repository при получении через
 // url POST запроса.
 func WriteMetric(ctx context.Context, path []string, repo types.Repository) error {
 	metricKey := path[metricName]
+	if err := CheckType(ctx, repo, metricKey, path[metricType]); err != nil {
+		return err
+	}
 	switch path[metricType] {
 	case "gauge":
 		metricValue, err := storage.StrToFloat64(path[metricVal])
@@ -60,17 +95,23 @@ func GetMetric(ctx context.Context, repo types.Repository, path []string) (strin
 	var metricValue string
 	var err error
 
-	if metricType == "gauge" || metricType == "counter" {
-		metricVal := repo.Get(ctx, metric)
+	switch metricType {
+	case "gauge", "counter", "histogram", "summary":
+		var metricVal interface{}
+		metricVal, err = getValue(ctx, repo, metric)
 		switch metricVal := metricVal.(type) {
 		case int64:
 			metricValue = fmt.Sprint(metricVal)
 		case float64:
 			metricValue = fmt.Sprint(metricVal)
+		case types.Histogram:
+			metricValue = metricVal.String()
+		case types.Summary:
+			metricValue = metricVal.String()
 		case nil:
 			metricValue = ""
 		}
-	} else {
+	default:
 		err = errors.New("undefined metric type")
 	}
 	return metricValue, err
@@ -79,12 +120,33 @@ func GetMetric(ctx context.Context, repo types.Repository, path []string) (strin
 // WriteJSONMetric сохраняет метрику в Repository полученную в
 // JSON формате POST запроса.
 func WriteJSONMetric(ctx context.Context, data types.Metric, repo types.Repository) error {
+	if err := CheckType(ctx, repo, data.Key(), data.MType); err != nil {
+		return err
+	}
 	switch data.MType {
 	case "gauge":
-		repo.Rewrite(ctx, data.ID, *data.Value)
+		repo.Rewrite(ctx, data.Key(), *data.Value)
 		return nil
 	case "counter":
-		repo.Append(ctx, data.ID, *data.Delta)
+		repo.Append(ctx, data.Key(), *data.Delta)
+		return nil
+	case "histogram":
+		if data.Histogram == nil {
+			return errors.New("histogram value is missing")
+		}
+		if err := data.Histogram.Validate(); err != nil {
+			return err
+		}
+		repo.AppendHistogram(ctx, data.Key(), *data.Histogram)
+		return nil
+	case "summary":
+		if data.Summary == nil {
+			return errors.New("summary value is missing")
+		}
+		if err := data.Summary.Validate(); err != nil {
+			return err
+		}
+		repo.RewriteSummary(ctx, data.Key(), *data.Summary)
 		return nil
 	default:
 		return errors.New("undefined metric type")
@@ -92,9 +154,54 @@ func WriteJSONMetric(ctx context.Context, data types.Metric, repo types.Reposito
 }
 
 // WriteJSONMetrics сохраняет метрики полученные в JSON формате
-// POST запроса в Repository.
-func WriteJSONMetrics(ctx context.Context, data *[]types.Metric, repo types.Repository) {
-	repo.StoreAll(ctx, data)
+// POST запроса в Repository. Метрики, под ключом которых сохранено
+// значение другого типа, пропускаются, остальные сохраняются; о
+// пропущенных метриках сообщает ошибка ErrTypeMismatch.
+func WriteJSONMetrics(ctx context.Context, data *[]types.Metric, repo types.Repository) error {
+	checked := make([]types.Metric, 0, len(*data))
+	var mismatch error
+	skipped := 0
+	for _, m := range *data {
+		if err := CheckType(ctx, repo, m.Key(), m.MType); err != nil {
+			if mismatch == nil {
+				mismatch = err
+			}
+			skipped++
+			continue
+		}
+		checked = append(checked, m)
+	}
+	repo.StoreAll(ctx, &checked)
+	if mismatch != nil {
+		return fmt.Errorf("%d metrics skipped: %w", skipped, mismatch)
+	}
+	return nil
+}
+
+// WriteCounterTotal сохраняет накопленное значение total counter ряда
+// key от источника, который передает накопленные значения (Prometheus,
+// OTLP): приращение дописывается атомарно, см. Repository.AppendTotal.
+// Counter хранит целые значения, поэтому дробное значение ряда, который
+// еще не сохранен как counter, сохраняется как gauge без округления,
+// а значение ряда, уже сохраненного как counter, округляется.
+// NaN (в том числе отметки устаревания Prometheus) и бесконечные
+// значения пропускаются.
+func WriteCounterTotal(ctx context.Context, repo types.Repository, key string, total float64) {
+	if math.IsNaN(total) || math.IsInf(total, 0) {
+		return
+	}
+	switch repo.Get(ctx, key).(type) {
+	case int64:
+		repo.AppendTotal(ctx, key, int64(math.Round(total)))
+	case nil:
+		if total == math.Trunc(total) && math.Abs(total) < math.MaxInt64 {
+			repo.AppendTotal(ctx, key, int64(total))
+			return
+		}
+		repo.Rewrite(ctx, key, total)
+	case float64:
+		repo.Rewrite(ctx, key, total)
+	}
 }
 
 // GetJSONMetric возвращает метрику из Repository в JSON формате
@@ -104,27 +211,103 @@ func GetJSONMetric(ctx context.Context, repo types.Repository, data *types.Metri
 
 	switch data.MType {
 	case "gauge":
-		val := repo.Get(ctx, data.ID)
-		if val == nil {
+		found, err := getValue(ctx, repo, data.Key())
+		if err != nil {
+			return err
+		}
+		value, ok := found.(float64)
+		if !ok {
 			return errors.New("not found")
 		}
-		value := val.(float64)
 		data.Value = &value
-		err = nil
 	case "counter":
-		val := repo.Get(ctx, data.ID)
-		if val == nil {
+		found, err := getValue(ctx, repo, data.Key())
+		if err != nil {
+			return err
+		}
+		value, ok := found.(int64)
+		if !ok {
 			return errors.New("not found")
 		}
-		value := val.(int64)
 		data.Delta = &value
-		err = nil
+	case "histogram":
+		found, err := getValue(ctx, repo, data.Key())
+		if err != nil {
+			return err
+		}
+		value, ok := found.(types.Histogram)
+		if !ok {
+			return errors.New("not found")
+		}
+		data.Histogram = &value
+	case "summary":
+		found, err := getValue(ctx, repo, data.Key())
+		if err != nil {
+			return err
+		}
+		value, ok := found.(types.Summary)
+		if !ok {
+			return errors.New("not found")
+		}
+		data.Summary = &value
 	default:
 		err = errors.New("undefined metric type")
 	}
 	return err
 }
 
+// ResolveKey возвращает ключ временного ряда, значение и история
+// которого запрашиваются по ключу key. Ключ без меток, под которым
+// значение не сохранено, разрешается в единственный ряд метрики с
+// таким именем, например Alloc в Alloc{agent="a1",host="web01"}:
+// сервер помечает метрики агентов метками агента. При нескольких рядах
+// метрики возвращается ошибка ErrAmbiguousKey со списком рядов.
+func ResolveKey(ctx context.Context, repo types.Repository, key string) (string, error) {
+	if strings.Contains(key, "{") || repo.Get(ctx, key) != nil {
+		return key, nil
+	}
+	series := seriesOf(ctx, repo, key)
+	switch len(series) {
+	case 0:
+		return key, nil
+	case 1:
+		return series[0], nil
+	default:
+		return "", fmt.Errorf("%w: %s matches %s", ErrAmbiguousKey, key, strings.Join(series, ", "))
+	}
+}
+
+// seriesOf возвращает отсортированные ключи рядов метрики name с
+// метками, хранилище без types.SeriesLister перебирает все ряды
+func seriesOf(ctx context.Context, repo types.Repository, name string) []string {
+	var keys []string
+	if lister, ok := repo.(types.SeriesLister); ok {
+		keys = lister.Series(ctx, name)
+	} else {
+		for k := range repo.GetAll(ctx) {
+			keys = append(keys, k)
+		}
+	}
+	series := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if strings.HasPrefix(k, name+"{") {
+			series = append(series, k)
+		}
+	}
+	sort.Strings(series)
+	return series
+}
+
+// getValue возвращает значение временного ряда key из Repository,
+// ключ разрешается ResolveKey
+func getValue(ctx context.Context, repo types.Repository, key string) (interface{}, error) {
+	key, err := ResolveKey(ctx, repo, key)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Get(ctx, key), nil
+}
+
 // GetTableMetrics возвращает все метрики в строчном виде для
 // последующего отображения на html странице.
 func GetTableMetrics(ctx context.Context, repo types.Repository) map[string]string {
@@ -136,6 +319,10 @@ func GetTableMetrics(ctx context.Context, repo types.Repository) map[string]stri
 			outTable[k] = fmt.Sprint(value)
 		case float64:
 			outTable[k] = fmt.Sprint(value)
+		case types.Histogram:
+			outTable[k] = value.String()
+		case types.Summary:
+			outTable[k] = value.String()
 		}
 	}
 	return outTable
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"os"
 	"testing"
@@ -18,6 +19,7 @@ import (
 func TestWriteJSONMetric(t *testing.T) {
 	M1 := int64(5)
 	M2 := float64(-4.65)
+	M1f := float64(5)
 	tests := []struct {
 		name string
 		data types.Metric
@@ -33,6 +35,11 @@ func TestWriteJSONMetric(t *testing.T) {
 			data: types.Metric{ID: "M2", MType: "gauge", Value: &M2},
 			want: "-4.65",
 		},
+		{
+			name: "M2 with labels",
+			data: types.Metric{ID: "M2", MType: "gauge", Value: &M1f, Labels: map[string]string{"agent": "a1"}},
+			want: "5",
+		},
 	}
 	stor := make(map[string]interface{})
 	ctx := context.Background()
@@ -41,7 +48,7 @@ func TestWriteJSONMetric(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			err := WriteJSONMetric(ctx, tt.data, locStorage)
 			require.NoError(t, err)
-			switch result := locStorage.Get(ctx, tt.data.ID).(type) {
+			switch result := locStorage.Get(ctx, tt.data.Key()).(type) {
 			case int64:
 				assert.Equal(t, tt.want, fmt.Sprint(result))
 			case float64:
@@ -51,6 +58,160 @@ func TestWriteJSONMetric(t *testing.T) {
 	}
 }
 
+func TestWriteJSONMetrics_typeMismatch(t *testing.T) {
+	ctx := context.Background()
+	stor := map[string]interface{}{"C1": int64(1), "G1": float64(1)}
+	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
+	delta, value := int64(2), 2.5
+
+	err := WriteJSONMetric(ctx, types.Metric{ID: "C1", MType: "gauge", Value: &value}, locStorage)
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	err = WriteMetric(ctx, []string{"", "update", "counter", "G1", "2"}, locStorage)
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+
+	// метрики другого типа пропускаются, остальные сохраняются
+	err = WriteJSONMetrics(ctx, &[]types.Metric{
+		{ID: "C1", MType: "gauge", Value: &value},
+		{ID: "G1", MType: "counter", Delta: &delta},
+		{ID: "C1", MType: "counter", Delta: &delta},
+	}, locStorage)
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	assert.Equal(t, map[string]interface{}{"C1": int64(3), "G1": float64(1)}, stor)
+}
+
+func TestWriteJSONMetric_distribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    types.Metric
+		wantErr bool
+		want    string
+	}{
+		{
+			name: "histogram",
+			data: types.Metric{ID: "H1", MType: "histogram", Histogram: &types.Histogram{
+				Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 3, Count: 2,
+			}},
+			want: "count=2 sum=3 le_1=1 le_+Inf=2",
+		},
+		{
+			name: "histogram append",
+			data: types.Metric{ID: "H1", MType: "histogram", Histogram: &types.Histogram{
+				Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1,
+			}},
+			want: "count=3 sum=3.5 le_1=2 le_+Inf=3",
+		},
+		{
+			name: "summary",
+			data: types.Metric{ID: "S1", MType: "summary", Summary: &types.Summary{
+				Quantiles: []types.Quantile{{Quantile: 0.5, Value: 1.5}}, Sum: 6, Count: 4,
+			}},
+			want: "count=4 sum=6 q_0.5=1.5",
+		},
+		{
+			name:    "histogram without value",
+			data:    types.Metric{ID: "H2", MType: "histogram"},
+			wantErr: true,
+		},
+		{
+			name: "bad histogram",
+			data: types.Metric{ID: "H2", MType: "histogram", Histogram: &types.Histogram{
+				Bounds: []float64{1}, Counts: []uint64{1}, Count: 1,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "bad summary",
+			data: types.Metric{ID: "S2", MType: "summary", Summary: &types.Summary{
+				Quantiles: []types.Quantile{{Quantile: 2, Value: 1}},
+			}},
+			wantErr: true,
+		},
+	}
+	ctx := context.Background()
+	locStorage := storage.NewMemStorage()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := WriteJSONMetric(ctx, tt.data, locStorage)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, locStorage.Get(ctx, tt.data.ID))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, fmt.Sprint(locStorage.Get(ctx, tt.data.ID)))
+
+			got := types.Metric{ID: tt.data.ID, MType: tt.data.MType}
+			require.NoError(t, GetJSONMetric(ctx, locStorage, &got))
+			value, err := GetMetric(ctx, locStorage, []string{"", "value", tt.data.MType, tt.data.ID})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, value)
+		})
+	}
+}
+
+func TestCheckType(t *testing.T) {
+	ctx := context.Background()
+	stor := map[string]interface{}{"C1": int64(10), "G1": float64(1)}
+	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
+	assert.NoError(t, CheckType(ctx, locStorage, "C1", "counter"))
+	assert.NoError(t, CheckType(ctx, locStorage, "G1", "gauge"))
+	assert.NoError(t, CheckType(ctx, locStorage, "N1", "gauge"))
+	assert.ErrorIs(t, CheckType(ctx, locStorage, "C1", "gauge"), ErrTypeMismatch)
+	assert.ErrorIs(t, CheckType(ctx, locStorage, "G1", "histogram"), ErrTypeMismatch)
+}
+
+func TestWriteCounterTotal(t *testing.T) {
+	ctx := context.Background()
+	stor := map[string]interface{}{"C1": int64(10), "G1": float64(1)}
+	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
+	WriteCounterTotal(ctx, locStorage, "C1", 15)
+	assert.Equal(t, int64(15), stor["C1"])
+	// значение ряда, сохраненного как counter, округляется
+	WriteCounterTotal(ctx, locStorage, "C1", 15.4)
+	assert.Equal(t, int64(15), stor["C1"])
+	// счетчик источника сброшен
+	WriteCounterTotal(ctx, locStorage, "C1", 3)
+	assert.Equal(t, int64(18), stor["C1"])
+	WriteCounterTotal(ctx, locStorage, "C2", 7)
+	assert.Equal(t, int64(7), stor["C2"])
+	// дробное значение нового ряда сохраняется как gauge
+	WriteCounterTotal(ctx, locStorage, "C3", 2.5)
+	assert.Equal(t, 2.5, stor["C3"])
+	WriteCounterTotal(ctx, locStorage, "G1", 7)
+	assert.Equal(t, float64(7), stor["G1"])
+	WriteCounterTotal(ctx, locStorage, "C2", math.NaN())
+	assert.Equal(t, int64(7), stor["C2"])
+}
+
+func TestGetMetric_agentSeries(t *testing.T) {
+	ctx := context.Background()
+	stor := map[string]interface{}{
+		`Alloc{agent="a1",host="web01"}`:     1.5,
+		`PollCount{agent="a1",host="web01"}`: int64(3),
+		`PollCount{agent="a2",host="web02"}`: int64(4),
+	}
+	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
+
+	// метрика без меток находит единственный ряд агента
+	value, err := GetMetric(ctx, locStorage, []string{"", "value", "gauge", "Alloc"})
+	require.NoError(t, err)
+	assert.Equal(t, "1.5", value)
+	data := types.Metric{ID: "Alloc", MType: "gauge"}
+	require.NoError(t, GetJSONMetric(ctx, locStorage, &data))
+	assert.Equal(t, 1.5, *data.Value)
+
+	// несколько рядов метрики неоднозначны
+	_, err = GetMetric(ctx, locStorage, []string{"", "value", "counter", "PollCount"})
+	assert.ErrorIs(t, err, ErrAmbiguousKey)
+	data = types.Metric{ID: "PollCount", MType: "counter"}
+	err = GetJSONMetric(ctx, locStorage, &data)
+	assert.ErrorIs(t, err, ErrAmbiguousKey)
+	assert.Contains(t, err.Error(), `PollCount{agent="a1",host="web01"}, PollCount{agent="a2",host="web02"}`)
+	data = types.Metric{ID: "PollCount", MType: "counter", Labels: map[string]string{"agent": "a2", "host": "web02"}}
+	require.NoError(t, GetJSONMetric(ctx, locStorage, &data))
+	assert.Equal(t, int64(4), *data.Delta)
+}
+
 func TestGetJSONMetric(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -207,11 +368,12 @@ func TestGetTableMetrics(t *testing.T) {
 		want map[string]string
 	}{
 		name: "Check table",
-		want: map[string]string{"M1": "5", "M2": "0"},
+		want: map[string]string{"M1": "5", "M2": "0", "H1": "count=1 sum=2 le_+Inf=1"},
 	}
 	stor := make(map[string]interface{})
 	stor["M1"] = int64(5)
 	stor["M2"] = float64(0)
+	stor["H1"] = types.Histogram{Counts: []uint64{1}, Sum: 2, Count: 1}
 	locStorage := storage.NewMemStorage(storage.WithBuffer(stor))
 	ctx := context.Background()
 	result := GetTableMetrics(ctx, locStorage)
@@ -240,6 +402,25 @@ func TestSignData(t *testing.T) {
 			data: types.Metric{ID: "test2", MType: "gauge", Value: &gauge},
 			want: "de0a02dd05ed708397ab730155bdf233ef415eebe8778c921b1aeff8333570e6",
 		},
+		{
+			name: "gauge value with labels",
+			data: types.Metric{ID: "test2", MType: "gauge", Value: &gauge, Labels: map[string]string{"agent": "a1"}},
+			want: "21ac7a11b817b084ce1d95c1b2d49ce0573e837c68628e3bb59d11e669fcd444",
+		},
+		{
+			name: "histogram value",
+			data: types.Metric{ID: "test3", MType: "histogram", Histogram: &types.Histogram{
+				Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 3, Count: 2,
+			}},
+			want: "522e07bde8b53cc503ac15d28acf2cc79c55010e3cfed0ae49c7ca134215ff24",
+		},
+		{
+			name: "summary value",
+			data: types.Metric{ID: "test4", MType: "summary", Summary: &types.Summary{
+				Quantiles: []types.Quantile{{Quantile: 0.5, Value: 1.5}}, Sum: 6, Count: 4,
+			}},
+			want: "d76208b6bd2686744a62fefd323e0d2e27c518d0b70c3a5db9340b28b9089bf8",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -249,6 +430,14 @@ func TestSignData(t *testing.T) {
 	}
 }
 
+func TestIsBodySignEqual(t *testing.T) {
+	body := []byte("metrics body")
+	sign := SignBody(body, "key")
+	assert.True(t, IsBodySignEqual(body, sign, "key"))
+	assert.False(t, IsBodySignEqual(body, sign, "other key"))
+	assert.False(t, IsBodySignEqual([]byte("other body"), sign, "key"))
+}
+
 func TestIsSignEqual(t *testing.T) {
 	const key = "wersdjfl23.w3"
 	value := int64(34567)